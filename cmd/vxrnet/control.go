@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+
+	"github.com/urfave/cli"
+
+	"github.com/TrilliumIT/vxrouter/pkg/control"
+)
+
+// controlClient returns a client of the control api of the running plugin, on the global
+// --control-addr, on localhost if it has no host, with the --timeout of the subcommand
+func controlClient(ctx *cli.Context) (*control.Client, error) {
+	gctx := ctx.Parent()
+	addr := gctx.String("control-addr")
+	if addr == "" {
+		return nil, cli.NewExitError("the control api is disabled, set --control-addr", 1)
+	}
+	if h, p, err := net.SplitHostPort(addr); err == nil && (h == "" || net.ParseIP(h).IsUnspecified()) {
+		addr = net.JoinHostPort("localhost", p)
+	}
+	return control.NewClient(addr, gctx.String("control-token"), ctx.Duration("timeout")), nil
+}
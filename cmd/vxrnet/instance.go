@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/extipam"
+)
+
+// instance is a set of network and ipam drivers served by this process
+type instance struct {
+	name     string
+	netName  string
+	ipamName string
+	scope    string
+	vniMin   int
+	vniMax   int
+	defaults map[string]string
+}
+
+// parseInstance parses an instance from name[;key=value...]
+// The drivers are named <netName>-<name> and <ipamName>-<name> unless
+// overridden by the networkdriver and ipamdriver keys.
+// scope, vnimin and vnimax configure the instance, any other keys are
+// default options for networks created with the instance's driver
+func parseInstance(s, scope, netName, ipamName string) (*instance, error) {
+	fields := strings.Split(s, ";")
+	in := &instance{
+		name:     strings.TrimSpace(fields[0]),
+		scope:    scope,
+		vniMin:   0,
+		vniMax:   vxlan.MaxVxlanID,
+		defaults: make(map[string]string),
+	}
+	if in.name == "" || strings.ContainsAny(in.name, "/ ") {
+		return nil, fmt.Errorf("invalid instance name %q", in.name)
+	}
+	in.netName = netName + "-" + in.name
+	in.ipamName = ipamName + "-" + in.name
+
+	var err error
+	for _, f := range fields[1:] {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid option %q for instance %v", f, in.name)
+		}
+		k, v := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		switch k {
+		case "scope":
+			in.scope = v
+		case "networkdriver":
+			in.netName = v
+		case "ipamdriver":
+			in.ipamName = v
+		case "vnimin":
+			in.vniMin, err = vxlan.ParseVxlanID(v)
+		case "vnimax":
+			in.vniMax, err = vxlan.ParseVxlanID(v)
+		default:
+			in.defaults[k] = v
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %q for instance %v: %v", f, in.name, err)
+		}
+	}
+
+	if in.netName == in.ipamName {
+		return nil, fmt.Errorf("instance %v network and ipam drivers must have different names", in.name)
+	}
+
+	return in, nil
+}
+
+// instances returns the instances configured by ctx, with the instance wide
+// network option defaults applied
+func instances(ctx *cli.Context) ([]*instance, error) {
+	ns := ctx.String("scope")
+	netName := ctx.String("network-driver-name")
+	ipamName := ctx.String("ipam-driver-name")
+	if netName == "" || ipamName == "" || netName == ipamName {
+		return nil, fmt.Errorf("network and ipam drivers must have different, non-empty names")
+	}
+
+	insts := []*instance{{netName: netName, ipamName: ipamName, scope: ns, vniMax: vxlan.MaxVxlanID}}
+	if specs := ctx.StringSlice("instance"); len(specs) > 0 {
+		insts = nil
+		for _, spec := range specs {
+			in, err := parseInstance(spec, ns, netName, ipamName)
+			if err != nil {
+				return nil, err
+			}
+			insts = append(insts, in)
+		}
+	}
+
+	if err := allocator.Known(ctx.String("ipam-allocator")); err != nil {
+		return nil, fmt.Errorf("invalid allocator: %v", err)
+	}
+	for _, in := range insts {
+		if in.defaults == nil {
+			in.defaults = make(map[string]string)
+		}
+		if _, ok := in.defaults["allocation"]; !ok {
+			in.defaults["allocation"] = ctx.String("ipam-allocator")
+			if ctx.String("external-ipam") != "" && !ctx.IsSet("ipam-allocator") {
+				in.defaults["allocation"] = extipam.AllocatorName
+			}
+		}
+		if _, ok := in.defaults["allocatorurl"]; !ok && ctx.String("ipam-allocator-url") != "" {
+			in.defaults["allocatorurl"] = ctx.String("ipam-allocator-url")
+		}
+		// only when set, so the older VXR_excludefirst/VXR_excludelast variables keep working
+		if _, ok := in.defaults["excludefirst"]; !ok && ctx.IsSet("ipam-exclude-first") {
+			in.defaults["excludefirst"] = strconv.Itoa(ctx.Int("ipam-exclude-first"))
+		}
+		if _, ok := in.defaults["excludelast"]; !ok && ctx.IsSet("ipam-exclude-last") {
+			in.defaults["excludelast"] = strconv.Itoa(ctx.Int("ipam-exclude-last"))
+		}
+		if _, ok := in.defaults["metric"]; !ok && ctx.IsSet("route-metric") {
+			in.defaults["metric"] = strconv.Itoa(ctx.Int("route-metric"))
+		}
+		if _, err := options.Parse(in.defaults); err != nil {
+			return nil, fmt.Errorf("instance %v: %v", in.name, err)
+		}
+	}
+
+	return insts, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/store"
+)
+
+var leaseDBCommand = cli.Command{
+	Name:  "lease-db",
+	Usage: "Check the lease db for corruption, or repair or compact it while the plugin is stopped",
+	Description: "The lease db is the global --lease-db. A lease db in use by the running plugin is not\n" +
+		"   read by the check, it fails to be repaired or compacted. Exits 1 if the check fails.\n" +
+		"   The plugin also compacts it in the background with --lease-compact-interval.",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "repair",
+			Usage: "Rewrite the lease db with the entries which can be read, keeping the damaged db with a .bak suffix",
+		},
+		cli.BoolFlag{
+			Name:  "compact",
+			Usage: "Drop the released leases past the global --lease-retention and compact the lease db",
+		},
+	},
+	Action: leaseDB,
+}
+
+func leaseDB(ctx *cli.Context) error {
+	gctx := ctx.Parent()
+	ldb := gctx.String("lease-db")
+	if ldb == "" {
+		return cli.NewExitError("the lease db is disabled, set --lease-db", 1)
+	}
+	if _, err := os.Stat(ldb); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	switch {
+	case ctx.Bool("repair") && ctx.Bool("compact"):
+		return cli.NewExitError("repair and compact the lease db one at a time", 1)
+	case ctx.Bool("repair"):
+		r, err := store.Repair(ldb)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if r.Reset {
+			fmt.Printf("%v could not be opened, replaced with an empty lease db, kept as %v\n", ldb, r.Backup)
+			return nil
+		}
+		fmt.Printf("%v repaired, %v entries kept, %v dropped, kept as %v before the repair\n", ldb, r.Kept, r.Dropped, r.Backup)
+	case ctx.Bool("compact"):
+		s, err := store.Open(ldb, gctx.Duration("lease-retention"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		n, err := s.Compact()
+		if cerr := s.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Printf("%v compacted, %v released leases dropped\n", ldb, n)
+	default:
+		if err := store.Check(ldb); err != nil {
+			return cli.NewExitError(fmt.Sprintf("%v: %v, run lease-db --repair with the plugin stopped", ldb, err), 1)
+		}
+		fmt.Printf("%v ok\n", ldb)
+	}
+	return nil
+}
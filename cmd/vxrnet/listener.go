@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// unixListener listens on the plugin socket for name in dir, owned by gid with mode
+func unixListener(dir, name string, mode os.FileMode, gid int) (net.Listener, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, name+".sock")
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chown(path, 0, gid)
+	if err == nil {
+		err = os.Chmod(path, mode)
+	}
+	if err != nil {
+		l.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// parseGroup returns the gid of a group name or number
+func parseGroup(g string) (int, error) {
+	if g == "" {
+		return 0, nil
+	}
+	if gid, err := strconv.Atoi(g); err == nil {
+		return gid, nil
+	}
+	grp, err := user.LookupGroup(g)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(grp.Gid)
+}
+
+// parseMode parses an octal file mode
+func parseMode(m string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(m, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid socket mode %v: %v", m, err)
+	}
+	return os.FileMode(mode), nil
+}
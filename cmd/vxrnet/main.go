@@ -0,0 +1,1129 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/activation"
+	gphipam "github.com/docker/go-plugins-helpers/ipam"
+	gphnet "github.com/docker/go-plugins-helpers/network"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/bird"
+	"github.com/TrilliumIT/vxrouter/pkg/bus"
+	"github.com/TrilliumIT/vxrouter/pkg/control"
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+	"github.com/TrilliumIT/vxrouter/pkg/frr"
+	"github.com/TrilliumIT/vxrouter/pkg/gossip"
+	"github.com/TrilliumIT/vxrouter/pkg/hwvtep"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrbgp"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/alloclog"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/extipam"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/kvstore"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/store"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/webhook"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrnet"
+)
+
+const (
+	version         = vxrouter.Version
+	envPrefix       = vxrouter.EnvPrefix
+	shutdownTimeout = 10 * time.Second
+	// leaseExpiryInterval is how often leases with a leasettl are checked
+	leaseExpiryInterval = 5 * time.Second
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "docker-" + vxrnet.DriverName
+	app.Usage = "Docker vxLan Networking"
+	app.Version = version
+
+	app.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:   "debug, d",
+			Usage:  "Enable debugging.",
+			EnvVar: envPrefix + "DEBUG_LOGGING",
+		},
+		cli.StringFlag{
+			Name:   "scope, s",
+			Value:  "local",
+			Usage:  "Scope of the network. local or global.",
+			EnvVar: envPrefix + "NETWORK_SCOPE",
+		},
+		cli.StringFlag{
+			Name:   "network-driver-name",
+			Value:  vxrnet.DriverName,
+			Usage:  "Name of the network driver, as used in docker network create -d",
+			EnvVar: envPrefix + "NETWORK_DRIVER_NAME",
+		},
+		cli.StringFlag{
+			Name:   "ipam-driver-name",
+			Value:  vxripam.DriverName,
+			Usage:  "Name of the ipam driver, as used in docker network create --ipam-driver",
+			EnvVar: envPrefix + "IPAM_DRIVER_NAME",
+		},
+		cli.StringFlag{
+			Name:   "socket-dir",
+			Value:  "/run/docker/plugins",
+			Usage:  "Directory to create the plugin sockets in, when not socket activated",
+			EnvVar: envPrefix + "SOCKET_DIR",
+		},
+		cli.StringFlag{
+			Name:   "socket-mode",
+			Value:  "0660",
+			Usage:  "Octal file mode of the plugin sockets",
+			EnvVar: envPrefix + "SOCKET_MODE",
+		},
+		cli.StringFlag{
+			Name:   "socket-group",
+			Usage:  "Group name or id owning the plugin sockets. Defaults to root",
+			EnvVar: envPrefix + "SOCKET_GROUP",
+		},
+		cli.StringSliceFlag{
+			Name:   "instance",
+			Usage:  "Serve a named driver instance, as name[;key=value...]. Drivers are named <network-driver-name>-<name> and <ipam-driver-name>-<name>, unless set with the networkdriver and ipamdriver keys. Keys scope, vnimin and vnimax configure the instance, others are default network options (eg. vtepdev). May be repeated",
+			EnvVar: envPrefix + "INSTANCES",
+		},
+		cli.DurationFlag{
+			Name:   "prop-timeout, pt",
+			Value:  100 * time.Millisecond,
+			Usage:  "How long to wait for external route propagation",
+			EnvVar: envPrefix + "PROP_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   "resp-timeout, rt",
+			Value:  10 * time.Second,
+			Usage:  "Maximum allowed response milliseconds, to prevent hanging docker daemon",
+			EnvVar: envPrefix + "RESP_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   "reconcile-interval, ri",
+			Value:  30 * time.Second,
+			Usage:  "Interval for running periodic reconcile of routes and containers. 0 to disable",
+			EnvVar: envPrefix + "RECONCILE_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "ipam-allocator, allocation",
+			Value:  "random",
+			Usage:  "Default address allocator, random, sequential, lru, kvstore, external or one compiled in. Per network with --ipam-opt allocation=",
+			EnvVar: envPrefix + "IPAM_ALLOCATOR," + envPrefix + "ALLOCATION",
+		},
+		cli.StringFlag{
+			Name:   "ipam-allocator-url",
+			Usage:  "Default url of the external allocator. Per network with --ipam-opt allocatorurl=",
+			EnvVar: envPrefix + "IPAM_ALLOCATOR_URL",
+		},
+		cli.StringFlag{
+			Name:   "external-ipam",
+			Usage:  "External IPAM to reserve addresses in, netbox or phpipam. Networks use it by default, unless --ipam-allocator is set. Empty to disable",
+			EnvVar: envPrefix + "EXTERNAL_IPAM",
+		},
+		cli.StringFlag{
+			Name:   "external-ipam-url",
+			Usage:  "Url of the external IPAM, the NetBox base url or the phpIPAM api application url, eg. https://ipam/api/vxrouter",
+			EnvVar: envPrefix + "EXTERNAL_IPAM_URL",
+		},
+		cli.StringFlag{
+			Name:   "external-ipam-token",
+			Usage:  "Api token of the external IPAM",
+			EnvVar: envPrefix + "EXTERNAL_IPAM_TOKEN",
+		},
+		cli.StringSliceFlag{
+			Name:   "external-ipam-prefix",
+			Usage:  "Maps a pool to the external IPAM prefix (NetBox) or subnet (phpIPAM) id to reserve its addresses in, as pool=id. Pools not mapped are looked up by cidr. May be repeated",
+			EnvVar: envPrefix + "EXTERNAL_IPAM_PREFIX",
+		},
+		cli.IntFlag{
+			Name:   "ipam-exclude-first",
+			Value:  1,
+			Usage:  "Number of addresses at the start of each pool never to allocate. Per network with --ipam-opt excludefirst=",
+			EnvVar: envPrefix + "IPAM_EXCLUDE_FIRST",
+		},
+		cli.IntFlag{
+			Name:   "ipam-exclude-last",
+			Value:  1,
+			Usage:  "Number of addresses at the end of each pool never to allocate. Per network with --ipam-opt excludelast=",
+			EnvVar: envPrefix + "IPAM_EXCLUDE_LAST",
+		},
+		cli.IntFlag{
+			Name:   "route-metric",
+			Value:  0,
+			Usage:  "Metric of the host routes to containers, 0 for the kernel default. Per network with -o metric=",
+			EnvVar: envPrefix + "ROUTE_METRIC",
+		},
+		cli.DurationFlag{
+			Name:   "release-quarantine",
+			Value:  0,
+			Usage:  "How long to blackhole released addresses so in flight traffic fails fast. 0 to disable",
+			EnvVar: envPrefix + "RELEASE_QUARANTINE",
+		},
+		cli.DurationFlag{
+			Name:   "slow-call",
+			Value:  5 * time.Second,
+			Usage:  "Log a diagnostic snapshot (goroutines, pending netlink operations, route count) of driver calls running longer than this. 0 to disable",
+			EnvVar: envPrefix + "SLOW_CALL",
+		},
+		cli.DurationFlag{
+			Name:   "restart-storm-grace",
+			Value:  30 * time.Second,
+			Usage:  "Keep the route of an address released by a container in a restart loop for this long, and hand it back on its next request. 0 to disable",
+			EnvVar: envPrefix + "RESTART_STORM_GRACE",
+		},
+		cli.DurationFlag{
+			Name:   "idle-teardown",
+			Value:  0,
+			Usage:  "Delete host interfaces of networks with no local endpoints and no traffic for this long. 0 to disable",
+			EnvVar: envPrefix + "IDLE_TEARDOWN",
+		},
+		cli.DurationFlag{
+			Name:   "swarm-peers",
+			Value:  0,
+			Usage:  "Interval for listing the nodes of the docker swarm, whose addresses the vxlans flood unknown and broadcast traffic to. Only on managers, 0 to disable",
+			EnvVar: envPrefix + "SWARM_PEERS",
+		},
+		cli.StringFlag{
+			Name:   "gossip-bind",
+			Usage:  "Udp address (host:port) to gossip with the other hosts on, exchanging the tunnel endpoints of the vxlan ids each serves, which its vxlans flood to. Empty to disable",
+			EnvVar: envPrefix + "GOSSIP_BIND",
+		},
+		cli.StringFlag{
+			Name:   "gossip-advertise",
+			Usage:  "Address (host:port) the other hosts reach this one on for gossip, the bind address if empty",
+			EnvVar: envPrefix + "GOSSIP_ADVERTISE",
+		},
+		cli.StringSliceFlag{
+			Name:   "gossip-join",
+			Usage:  "Addresses (host:port) of other hosts to gossip with first, repeat or comma separate for several",
+			EnvVar: envPrefix + "GOSSIP_JOIN",
+		},
+		cli.StringFlag{
+			Name:   "gossip-key",
+			Usage:  "Shared key authenticating gossip messages, the same on all hosts",
+			EnvVar: envPrefix + "GOSSIP_KEY",
+		},
+		cli.DurationFlag{
+			Name:   "gossip-interval",
+			Value:  5 * time.Second,
+			Usage:  "Interval between gossip heartbeats, hosts silent for 3 intervals are dropped",
+			EnvVar: envPrefix + "GOSSIP_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   "verify-interval",
+			Value:  0,
+			Usage:  "Interval for cross-checking the lease store, routes, docker endpoints and host interfaces, logging the inconsistencies found. 0 to disable",
+			EnvVar: envPrefix + "VERIFY_INTERVAL",
+		},
+		cli.BoolFlag{
+			Name:   "verify-repair",
+			Usage:  "Repair the inconsistencies found by the periodic verify",
+			EnvVar: envPrefix + "VERIFY_REPAIR",
+		},
+		cli.StringFlag{
+			Name:   "mac-oui",
+			Usage:  "OUI prefix (eg. 02:42:ac) of the MACs generated for container endpoints, so overlay traffic can be identified on switches. Empty to let the kernel pick MACs",
+			EnvVar: envPrefix + "MAC_OUI",
+		},
+		cli.BoolFlag{
+			Name:   "prepopulate-neighbors",
+			Usage:  "Program neighbor and forwarding entries for the host routes to containers on other hosts as they are learned, so first packets do not wait for flood and learn. Requires --mac-oui on all hosts",
+			EnvVar: envPrefix + "PREPOPULATE_NEIGHBORS",
+		},
+		cli.BoolTFlag{
+			Name:   "netmgr-hints",
+			Usage:  "Write hints telling a running NetworkManager or systemd-networkd to leave vxrouter interfaces unmanaged",
+			EnvVar: envPrefix + "NETMGR_HINTS",
+		},
+		cli.StringFlag{
+			Name:   "gateway-netns",
+			Usage:  "Network namespace for the host gateway interfaces and container routes, instead of the root namespace. A name under /var/run/netns, created if missing, or the path of a helper container's namespace, which must route for it",
+			EnvVar: envPrefix + "GATEWAY_NETNS",
+		},
+		cli.StringFlag{
+			Name:   "route-audit",
+			Value:  "repair",
+			Usage:  "Action on third party modification of vxrouter routes and gateways. off, log, alert or repair",
+			EnvVar: envPrefix + "ROUTE_AUDIT",
+		},
+		cli.StringSliceFlag{
+			Name:   "fabric",
+			Usage:  "Define an underlay fabric as name:vtepdev[:srcaddr]. Networks are pinned to fabrics with -o fabric=name[,standby...], or placed on the least utilized of them with -o fabricpolicy=balance. May be repeated",
+			EnvVar: envPrefix + "FABRICS",
+		},
+		cli.StringSliceFlag{
+			Name:   "lldp",
+			Usage:  "Underlay interface to discover the connected switch port on with lldp. May be repeated",
+			EnvVar: envPrefix + "LLDP",
+		},
+		cli.StringFlag{
+			Name:   "lease-db",
+			Value:  "/var/lib/vxrouter/leases.db",
+			Usage:  "Database to persist address leases in, to restore their routes after a restart. Empty to disable",
+			EnvVar: envPrefix + "LEASE_DB",
+		},
+		cli.DurationFlag{
+			Name:   "lease-retention",
+			Value:  7 * 24 * time.Hour,
+			Usage:  "Keep released leases in the lease db for this long, as the history of the addresses. 0 to keep none",
+			EnvVar: envPrefix + "LEASE_RETENTION",
+		},
+		cli.DurationFlag{
+			Name:   "lease-compact-interval",
+			Value:  24 * time.Hour,
+			Usage:  "How often to drop the released leases past lease-retention and compact the lease db. 0 to disable",
+			EnvVar: envPrefix + "LEASE_COMPACT_INTERVAL",
+		},
+		cli.DurationFlag{
+			Name:   "lease-restore-grace",
+			Value:  5 * time.Minute,
+			Usage:  "Keep the routes restored from the lease db for this long without a container, while docker starts the containers",
+			EnvVar: envPrefix + "LEASE_RESTORE_GRACE",
+		},
+		cli.DurationFlag{
+			Name:   "lease-ttl",
+			Value:  0,
+			Usage:  "Reclaim leases older than this whose container is gone and whose address is no longer routed. 0 to disable",
+			EnvVar: envPrefix + "LEASE_TTL",
+		},
+		cli.DurationFlag{
+			Name:   "lease-reclaim-interval",
+			Value:  time.Minute,
+			Usage:  "How often to look for stale leases when lease-ttl is set",
+			EnvVar: envPrefix + "LEASE_RECLAIM_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "alloc-log",
+			Usage:  "File to append a json line to for every address request, assignment and release, or journald to log them to the systemd journal. Empty to disable",
+			EnvVar: envPrefix + "ALLOC_LOG",
+		},
+		cli.StringFlag{
+			Name:   "webhook-url",
+			Usage:  "Url to post a json event to for every address assigned to or released from a container, eg. to sync an external IPAM. Empty to disable",
+			EnvVar: envPrefix + "WEBHOOK_URL",
+		},
+		cli.IntFlag{
+			Name:   "webhook-tries",
+			Value:  5,
+			Usage:  "How many times to try posting each webhook event, with exponential backoff",
+			EnvVar: envPrefix + "WEBHOOK_TRIES",
+		},
+		cli.StringFlag{
+			Name:   "bus-url",
+			Usage:  "NATS server or Kafka brokers to publish the endpoints joining and leaving this host to, and to program those of other hosts from, as nats://[user:pass@]host[:port] or kafka://[user:pass@]broker[:port][,broker[:port]...] (tls:// and kafka+tls:// for tls). Empty to disable",
+			EnvVar: envPrefix + "BUS_URL",
+		},
+		cli.StringFlag{
+			Name:   "bus-subject",
+			Value:  bus.DefaultSubject,
+			Usage:  "Subject, or Kafka topic, endpoints are published and consumed on, the same for all hosts",
+			EnvVar: envPrefix + "BUS_SUBJECT",
+		},
+		cli.StringFlag{
+			Name:   "hwvtep",
+			Usage:  "OVSDB server of a hardware VTEP to bind the endpoints of this host in, as tcp:host:port or unix:path, so bare-metal servers behind it share the vxlan ids of containers. Empty to disable",
+			EnvVar: envPrefix + "HWVTEP",
+		},
+		cli.Int64Flag{
+			Name:   "frr-asn",
+			Usage:  "AS number of the bgp router of a local FRRouting to advertise the container routes of this host from, with vtysh. 0 to disable",
+			EnvVar: envPrefix + "FRR_ASN",
+		},
+		cli.StringFlag{
+			Name:   "frr-vtysh",
+			Value:  "vtysh",
+			Usage:  "vtysh binary of the FRRouting set with --frr-asn",
+			EnvVar: envPrefix + "FRR_VTYSH",
+		},
+		cli.Int64Flag{
+			Name:   "bgp-asn",
+			Usage:  "Local AS number of the gobgpd to advertise the endpoints and container routes of this host from as evpn type 2 and type 5 routes, and to import those of other hosts from. 0 to disable",
+			EnvVar: envPrefix + "BGP_ASN",
+		},
+		cli.StringFlag{
+			Name:   "bgp-router-id",
+			Usage:  "IPv4 router id of the gobgpd set with --bgp-asn, also the next hop of routes without a tunnel endpoint address",
+			EnvVar: envPrefix + "BGP_ROUTER_ID",
+		},
+		cli.StringSliceFlag{
+			Name:   "bgp-peer",
+			Usage:  "Peer to add to the gobgpd set with --bgp-asn for the evpn family, as address[;as=asn], in the local AS unless set. May be repeated, none if the peers are configured in gobgpd",
+			EnvVar: envPrefix + "BGP_PEERS",
+		},
+		cli.StringFlag{
+			Name:   "bgp-api",
+			Value:  vxrbgp.DefaultAPI,
+			Usage:  "Grpc api host:port of the gobgpd set with --bgp-asn",
+			EnvVar: envPrefix + "BGP_API",
+		},
+		cli.StringFlag{
+			Name:   "bgp-gobgp",
+			Value:  "gobgp",
+			Usage:  "gobgp cli binary of the gobgpd set with --bgp-asn",
+			EnvVar: envPrefix + "BGP_GOBGP",
+		},
+		cli.DurationFlag{
+			Name:   "bgp-interval",
+			Value:  vxrbgp.DefaultInterval,
+			Usage:  "How often to poll the gobgpd set with --bgp-asn for the routes of other hosts",
+			EnvVar: envPrefix + "BGP_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "bird-include",
+			Usage:  "Include file of a BIRD static protocol to write the IPv4 container routes of this host to, IPv6 ones go to the same file with a 6 before its extension. BIRD is reconfigured on every change. Empty to disable",
+			EnvVar: envPrefix + "BIRD_INCLUDE",
+		},
+		cli.StringFlag{
+			Name:   "bird-socket",
+			Value:  "/run/bird/bird.ctl",
+			Usage:  "Control socket of the BIRD set with --bird-include",
+			EnvVar: envPrefix + "BIRD_SOCKET",
+		},
+		cli.StringFlag{
+			Name:   "kv-store",
+			Usage:  "etcd or consul to lock addresses in before installing their routes, as etcd://host:port[/prefix] or consul://host:port[/prefix] (etcds:// or consuls:// for https). Empty to rely on route propagation only",
+			EnvVar: envPrefix + "KV_STORE",
+		},
+		cli.DurationFlag{
+			Name:   "kv-lock-ttl",
+			Value:  30 * time.Second,
+			Usage:  "How long an address stays locked in the kv store, it must outlast route propagation",
+			EnvVar: envPrefix + "KV_LOCK_TTL",
+		},
+		cli.StringFlag{
+			Name:   "control-addr",
+			Usage:  "Address (host:port) to serve the control api on. Empty to disable",
+			EnvVar: envPrefix + "CONTROL_ADDR",
+		},
+		cli.StringFlag{
+			Name:   "control-token",
+			Usage:  "Bearer token required by the control api, and sent to the seed host",
+			EnvVar: envPrefix + "CONTROL_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "control-tls-cert",
+			Usage:  "Certificate file to serve the control api over https with, along with --control-tls-key",
+			EnvVar: envPrefix + "CONTROL_TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "control-tls-key",
+			Usage:  "Key file of --control-tls-cert",
+			EnvVar: envPrefix + "CONTROL_TLS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "control-tls-ca",
+			Usage:  "CA certificate file to verify the control api of an https seed with, instead of the system roots",
+			EnvVar: envPrefix + "CONTROL_TLS_CA",
+		},
+		cli.StringFlag{
+			Name:   "seed",
+			Usage:  "Control api address (host:port, or https://host:port) of an existing host to fetch network and allocation state from at startup",
+			EnvVar: envPrefix + "SEED",
+		},
+		cli.DurationFlag{
+			Name:   "seed-ttl",
+			Value:  5 * time.Minute,
+			Usage:  "How long addresses allocated on the seed host are reserved while waiting for their routes to propagate",
+			EnvVar: envPrefix + "SEED_TTL",
+		},
+		cli.BoolFlag{
+			Name:   "seed-neighbors",
+			Usage:  "Import the forwarding and neighbor entries known to the seed host at startup, to avoid the flood and learn penalty of a fresh host",
+			EnvVar: envPrefix + "SEED_NEIGHBORS",
+		},
+	}
+	app.Commands = []cli.Command{validateCommand, statusCommand, networksCommand, whoHasCommand, poolsCommand, observeCommand, verifyCommand, planCommand, leaseDBCommand}
+	app.Action = Run
+	err := app.Run(os.Args)
+	if err != nil {
+		log.WithError(err).Fatal("error running app")
+	}
+}
+
+// Run initializes the driver
+func Run(ctx *cli.Context) {
+	initLogging(ctx)
+
+	pt := ctx.Duration("prop-timeout")
+	rt := ctx.Duration("resp-timeout")
+
+	eipam, err := externalIPAM(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("invalid external ipam")
+	}
+
+	insts, err := instances(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("invalid instance")
+	}
+
+	for _, fs := range ctx.StringSlice("fabric") {
+		f, err := host.ParseFabric(fs)
+		if err != nil {
+			log.WithError(err).Fatal("invalid fabric")
+		}
+		host.AddFabric(f)
+	}
+
+	if gn := ctx.String("gateway-netns"); gn != "" {
+		err = gwns.Set(gn)
+		if err != nil {
+			log.WithError(err).Fatal("failed to set up gateway namespace")
+		}
+		log.WithField("ns", gn).Info("host gateway interfaces are in a dedicated namespace")
+	}
+
+	host.SetManagerHints(ctx.BoolT("netmgr-hints"))
+	if nms := host.NetworkManagers(); len(nms) > 0 {
+		log.WithField("managers", nms).Info("network managers are running, interface conflicts are reported in status")
+	}
+
+	err = host.CheckProtos()
+	if err != nil {
+		log.WithError(err).Fatal("invalid route protocols")
+	}
+
+	err = host.RetagLegacyRoutes()
+	if err != nil {
+		log.WithError(err).Warn("failed to retag legacy host routes")
+	}
+
+	err = host.CleanQuarantine()
+	if err != nil {
+		log.WithError(err).Warn("failed to clean up stale blackhole routes")
+	}
+
+	err = host.CleanLeakedRoutes()
+	if err != nil {
+		log.WithError(err).Warn("failed to clean up leaked routes")
+	}
+
+	ap, err := host.ParseAuditPolicy(ctx.String("route-audit"))
+	if err != nil {
+		log.WithError(err).Fatal("invalid route audit policy")
+	}
+
+	var oui net.HardwareAddr
+	if o := ctx.String("mac-oui"); o != "" {
+		oui, err = vxrnet.ParseOUI(o)
+		if err != nil {
+			log.WithError(err).Fatal("invalid mac oui")
+		}
+	}
+	if ctx.Bool("prepopulate-neighbors") && oui == nil {
+		log.Fatal("--prepopulate-neighbors requires --mac-oui")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		if err := host.AuditRoutes(ap, done); err != nil {
+			log.WithError(err).Error("route audit stopped")
+		}
+	}()
+
+	if ctx.Bool("prepopulate-neighbors") {
+		go func() {
+			mac := func(ip net.IP) net.HardwareAddr { return vxrnet.AddressMAC(oui, ip) }
+			if err := host.PrepopulateNeighbors(mac, done); err != nil {
+				log.WithError(err).Error("neighbor prepopulation stopped")
+			}
+		}()
+	}
+
+	if li := ctx.StringSlice("lldp"); len(li) > 0 {
+		go func() {
+			if err := host.DiscoverLLDP(li, done); err != nil {
+				log.WithError(err).Error("lldp discovery stopped")
+			}
+		}()
+	}
+
+	ext := map[string]bool{
+		"control-api":           ctx.String("control-addr") != "",
+		"seed-bootstrap":        ctx.String("seed") != "",
+		"seed-neighbors":        ctx.String("seed") != "" && ctx.Bool("seed-neighbors"),
+		"route-audit":           ap != host.AuditOff,
+		"release-quarantine":    ctx.Duration("release-quarantine") > 0,
+		"instances":             len(ctx.StringSlice("instance")) > 0,
+		"lease-store":           ctx.String("lease-db") != "",
+		"lldp":                  len(ctx.StringSlice("lldp")) > 0,
+		"fabrics":               len(ctx.StringSlice("fabric")) > 0,
+		"idle-teardown":         ctx.Duration("idle-teardown") > 0,
+		"verify":                ctx.Duration("verify-interval") > 0,
+		"swarm-peers":           ctx.Duration("swarm-peers") > 0,
+		"gossip":                ctx.String("gossip-bind") != "",
+		"mac-oui":               ctx.String("mac-oui") != "",
+		"prepopulate-neighbors": ctx.Bool("prepopulate-neighbors"),
+		"kv-store":              ctx.String("kv-store") != "",
+		"lease-ttl":             ctx.String("lease-db") != "" && ctx.Duration("lease-ttl") > 0,
+		"gateway-netns":         ctx.String("gateway-netns") != "",
+		"alloc-log":             ctx.String("alloc-log") != "",
+		"webhook":               ctx.String("webhook-url") != "",
+		"external-ipam":         eipam != nil,
+		"bus":                   ctx.String("bus-url") != "",
+		"hwvtep":                ctx.String("hwvtep") != "",
+		"frr":                   ctx.Int64("frr-asn") != 0,
+		"bird":                  ctx.String("bird-include") != "",
+		"bgp-evpn":              ctx.Int64("bgp-asn") != 0,
+	}
+
+	var leases *store.Store
+	if ldb := ctx.String("lease-db"); ldb != "" {
+		leases, err = store.Open(ldb, ctx.Duration("lease-retention"))
+		if err != nil {
+			log.WithField("lease-db", ldb).WithError(err).Fatal("failed to open lease store")
+		}
+		defer leases.Close() // nolint: errcheck
+		if ci := ctx.Duration("lease-compact-interval"); ci > 0 {
+			go func() {
+				t := time.NewTicker(ci)
+				defer t.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-t.C:
+					}
+					n, err := leases.Compact()
+					if err != nil {
+						log.WithField("lease-db", ldb).WithError(err).Error("failed to compact lease store")
+						continue
+					}
+					log.WithField("lease-db", ldb).WithField("pruned", n).Debug("compacted lease store")
+				}
+			}()
+		}
+	}
+
+	var al *alloclog.Log
+	if alp := ctx.String("alloc-log"); alp != "" {
+		al, err = alloclog.Open(alp)
+		if err != nil {
+			log.WithField("alloc-log", alp).WithError(err).Fatal("failed to open allocation log")
+		}
+		defer al.Close() // nolint: errcheck
+	}
+
+	var wh *webhook.Hook
+	if whu := ctx.String("webhook-url"); whu != "" {
+		wh = webhook.New(whu, ctx.Int("webhook-tries"))
+		defer wh.Close()
+	}
+
+	var mb *bus.Bus
+	if bu := ctx.String("bus-url"); bu != "" {
+		mb, err = bus.New(bu, ctx.String("bus-subject"))
+		if err != nil {
+			log.WithField("bus-url", bu).WithError(err).Fatal("invalid bus")
+		}
+		defer mb.Close()
+	}
+
+	var g *gossip.Gossip
+	if ctx.String("gossip-bind") != "" {
+		if ctx.Duration("swarm-peers") > 0 {
+			log.Fatal("gossip and swarm-peers both set the flood peers, enable only one")
+		}
+		g, err = gossip.New(gossipOptions(ctx))
+		if err != nil {
+			log.WithField("gossip-bind", ctx.String("gossip-bind")).WithError(err).Fatal("failed to start gossip")
+		}
+		defer g.Close()
+	}
+
+	var hv *hwvtep.VTEP
+	if hu := ctx.String("hwvtep"); hu != "" {
+		hv, err = hwvtep.New(hu)
+		if err != nil {
+			log.WithField("hwvtep", hu).WithError(err).Fatal("invalid hardware vtep")
+		}
+		defer hv.Close()
+	}
+
+	if asn := ctx.Int64("frr-asn"); asn != 0 {
+		fr, err := frr.New(ctx.String("frr-vtysh"), asn)
+		if err != nil {
+			log.WithField("frr-asn", asn).WithError(err).Fatal("failed to set up frr")
+		}
+		defer fr.Close()
+		go func() {
+			if err := host.ExportRoutes(fr, done); err != nil {
+				log.WithError(err).Error("route export to frr stopped")
+			}
+		}()
+	}
+
+	var bg *vxrbgp.BGP
+	if asn := ctx.Int64("bgp-asn"); asn != 0 {
+		cfg, err := bgpConfig(ctx)
+		if err == nil {
+			bg, err = vxrbgp.New(cfg)
+		}
+		if err != nil {
+			log.WithField("bgp-asn", asn).WithError(err).Fatal("failed to set up bgp")
+		}
+		defer bg.Close()
+		go func() {
+			if err := host.ExportRoutes(bg, done); err != nil {
+				log.WithError(err).Error("route export to bgp stopped")
+			}
+		}()
+	}
+
+	if bi := ctx.String("bird-include"); bi != "" {
+		be, err := bird.New(bi, ctx.String("bird-socket"))
+		if err != nil {
+			log.WithField("bird-include", bi).WithError(err).Fatal("failed to set up bird export")
+		}
+		defer be.Close()
+		go func() {
+			if err := host.ExportRoutes(be, done); err != nil {
+				log.WithError(err).Error("route export to bird stopped")
+			}
+		}()
+	}
+
+	var kv kvstore.Locker
+	if kvs := ctx.String("kv-store"); kvs != "" {
+		kv, err = kvstore.New(kvs)
+		if err != nil {
+			log.WithField("kv-store", kvs).WithError(err).Fatal("invalid kv store")
+		}
+	}
+
+	cores := []*core.Core{}
+	nhs := []*gphnet.Handler{}
+	ihs := []*gphipam.Handler{}
+	for _, in := range insts {
+		var c *core.Core
+		c, err = core.New(core.Options{
+			NetworkDriverName: in.netName,
+			IpamDriverName:    in.ipamName,
+			Defaults:          in.defaults,
+			PropTime:          pt,
+			RespTime:          rt,
+			Quarantine:        ctx.Duration("release-quarantine"),
+			SlowCall:          ctx.Duration("slow-call"),
+			StormGrace:        ctx.Duration("restart-storm-grace"),
+			RestoreGrace:      ctx.Duration("lease-restore-grace"),
+			KV:                kv,
+			KVTTL:             ctx.Duration("kv-lock-ttl"),
+			Leases:            leases,
+			AllocLog:          al,
+			Webhook:           wh,
+			ExternalIPAM:      eipam,
+			Bus:               mb,
+			HWVTEP:            hv,
+			BGP:               bg,
+			Peers:             gossipPeers(g),
+		})
+		if err != nil {
+			log.WithField("instance", in.name).WithError(err).Fatal("failed to create docker core")
+		}
+		cores = append(cores, c)
+
+		if seed := ctx.String("seed"); seed != "" {
+			var sc *control.Client
+			sc, err = seedClient(ctx, seed, rt)
+			if err == nil {
+				err = bootstrap(c, sc, ctx.Duration("seed-ttl"))
+			}
+			if err != nil {
+				log.WithField("seed", seed).WithError(err).Fatal("failed to bootstrap from seed")
+			}
+		}
+
+		var warm *control.Client
+		if seed := ctx.String("seed"); seed != "" && ctx.Bool("seed-neighbors") {
+			warm, err = seedClient(ctx, seed, rt)
+			if err != nil {
+				log.WithField("seed", seed).WithError(err).Fatal("failed to create seed client")
+			}
+		}
+
+		go func(c *core.Core, ri time.Duration) {
+			if !c.WaitForDocker(done) {
+				return
+			}
+			c.RestoreLeases()
+			c.Reconcile()
+			if warm != nil {
+				warmNeighbors(c, warm)
+			}
+			if ri <= 0 {
+				return
+			}
+			t := time.NewTicker(ri)
+			for {
+				<-t.C
+				c.Reconcile()
+			}
+		}(c, ctx.Duration("reconcile-interval"))
+
+		if idle := ctx.Duration("idle-teardown"); idle > 0 {
+			go func(c *core.Core) {
+				if !c.WaitForDocker(done) {
+					return
+				}
+				t := time.NewTicker(idle / 4)
+				defer t.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-t.C:
+						c.TeardownIdle(idle)
+					}
+				}
+			}(c)
+		}
+
+		if vi := ctx.Duration("verify-interval"); vi > 0 {
+			go verifyLoop(c, vi, ctx.Bool("verify-repair"), done)
+		}
+
+		// the flood peers are shared by all instances, discover from the first only
+		if si := ctx.Duration("swarm-peers"); si > 0 && len(cores) == 1 {
+			go func(c *core.Core) {
+				if !c.WaitForDocker(done) {
+					return
+				}
+				t := time.NewTicker(si)
+				defer t.Stop()
+				for {
+					if err := c.DiscoverSwarmPeers(); err != nil {
+						log.WithError(err).Error("failed to discover swarm peers")
+					}
+					select {
+					case <-done:
+						return
+					case <-t.C:
+					}
+				}
+			}(c)
+		}
+
+		// the lease store is shared by all instances, expire and reclaim from the first only
+		if leases != nil && len(cores) == 1 {
+			go func(c *core.Core) {
+				if !c.WaitForDocker(done) {
+					return
+				}
+				t := time.NewTicker(leaseExpiryInterval)
+				defer t.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-t.C:
+						c.ExpireLeases()
+					}
+				}
+			}(c)
+		}
+		ttl, ri := ctx.Duration("lease-ttl"), ctx.Duration("lease-reclaim-interval")
+		if leases != nil && ttl > 0 && ri > 0 && len(cores) == 1 {
+			go func(c *core.Core) {
+				if !c.WaitForDocker(done) {
+					return
+				}
+				t := time.NewTicker(ri)
+				defer t.Stop()
+				for {
+					select {
+					case <-done:
+						return
+					case <-t.C:
+						c.ReclaimLeases(ttl)
+					}
+				}
+			}(c)
+		}
+
+		var nd *vxrnet.Driver
+		nd, err = vxrnet.NewDriver(vxrnet.Options{Scope: in.scope, VniMin: in.vniMin, VniMax: in.vniMax, MacOUI: oui}, c)
+		if err != nil {
+			log.WithField("driver", c.NetworkDriverName()).WithError(err).Fatal("failed to create driver")
+		}
+		nh := gphnet.NewHandler(nd)
+		handleManifest(nh, "NetworkDriver", ext)
+		nhs = append(nhs, nh)
+
+		var id *vxripam.Driver
+		id, err = vxripam.NewDriver(c)
+		if err != nil {
+			log.WithField("driver", c.IpamDriverName()).WithError(err).Fatal("failed to create driver")
+		}
+		ih := gphipam.NewHandler(id)
+		handleManifest(ih, "IpamDriver", ext)
+		ihs = append(ihs, ih)
+	}
+
+	// each instance tracks the convergence of the routes it allocated
+	g.OnRoute(func(h, dst string, learned time.Time) {
+		for _, c := range cores {
+			c.RouteLearned(h, dst, learned)
+		}
+	})
+
+	// stopped receives the name of each handler as it stops serving
+	stopped := make(chan string)
+	running := 0
+	serve := func(name string, f func() error) {
+		running++
+		go func() {
+			if err := f(); err != nil && err != http.ErrServerClosed {
+				log.WithField("driver", name).WithError(err).Error()
+			}
+			stopped <- name
+		}()
+	}
+
+	listeners, _ := activation.Listeners() // wtf coreos, this funciton never returns errors
+	if len(listeners) == 0 {
+		sd := ctx.String("socket-dir")
+		var sm os.FileMode
+		sm, err = parseMode(ctx.String("socket-mode"))
+		if err != nil {
+			log.WithError(err).Fatal("invalid socket mode")
+		}
+		var sg int
+		sg, err = parseGroup(ctx.String("socket-group"))
+		if err != nil {
+			log.WithError(err).Fatal("invalid socket group")
+		}
+		for i, c := range cores {
+			nh, ih := nhs[i], ihs[i]
+			var nl, il net.Listener
+			nl, err = unixListener(sd, c.NetworkDriverName(), sm, sg)
+			if err != nil {
+				log.WithField("driver", c.NetworkDriverName()).WithError(err).Fatal("failed to listen on plugin socket")
+			}
+			il, err = unixListener(sd, c.IpamDriverName(), sm, sg)
+			if err != nil {
+				log.WithField("driver", c.IpamDriverName()).WithError(err).Fatal("failed to listen on plugin socket")
+			}
+			log.WithField("driver", c.NetworkDriverName()).WithField("listener", nl.Addr().String()).Debug("launching network handler with default listener")
+			serve(c.NetworkDriverName(), func() error { return nh.Serve(nl) })
+			log.WithField("driver", c.IpamDriverName()).WithField("listener", il.Addr().String()).Debug("launching ipam handler with default listener")
+			serve(c.IpamDriverName(), func() error { return ih.Serve(il) })
+		}
+	} else if len(listeners) == 2*len(cores) {
+		for i, c := range cores {
+			nh, ih := nhs[i], ihs[i]
+			nl, il := listeners[2*i], listeners[2*i+1]
+			log.WithField("driver", c.NetworkDriverName()).WithField("listener", nl.Addr().String()).Debug("launching network handler")
+			serve(c.NetworkDriverName(), func() error { return nh.Serve(nl) })
+			log.WithField("driver", c.IpamDriverName()).WithField("listener", il.Addr().String()).Debug("launching ipam handler")
+			serve(c.IpamDriverName(), func() error { return ih.Serve(il) })
+		}
+	} else {
+		log.Fatal("exactly two sockets per instance are required for socket activation")
+	}
+
+	var cs *control.Server
+	if ca := ctx.String("control-addr"); ca != "" {
+		var cl net.Listener
+		cl, err = net.Listen("tcp", ca)
+		if err != nil {
+			log.WithField("control-addr", ca).WithError(err).Fatal("failed to listen for control api")
+		}
+		cs = control.NewServer(ctx.String("control-token"), cores...)
+		log.WithField("listener", cl.Addr().String()).Debug("launching control api")
+		cert, key := ctx.String("control-tls-cert"), ctx.String("control-tls-key")
+		go func() {
+			var err error
+			if cert != "" {
+				err = cs.ServeTLS(cl, cert, key)
+			} else {
+				err = cs.Serve(cl)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Error("control api stopped")
+			}
+		}()
+	}
+
+	// flush the network caches on SIGUSR2, eg. after editing docker's network metadata
+	usr2 := make(chan os.Signal, 1)
+	signal.Notify(usr2, syscall.SIGUSR2)
+	go func() {
+		for range usr2 {
+			for _, cr := range cores {
+				if _, err := cr.FlushCache(); err != nil {
+					log.WithField("driver", cr.NetworkDriverName()).WithError(err).Error("failed to re-enumerate networks")
+				}
+			}
+		}
+	}()
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case name := <-stopped:
+		log.WithField("driver", name).Error("handler stopped, shutting down")
+		running--
+	case <-c:
+	}
+
+	for i, cr := range cores {
+		nhCtx, nhCtxCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer nhCtxCancel()
+		err = nhs[i].Shutdown(nhCtx)
+		if err != nil {
+			log.WithField("driver", cr.NetworkDriverName()).WithError(err).Error("error shutting down driver")
+		}
+
+		ihCtx, ihCtxCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer ihCtxCancel()
+		err = ihs[i].Shutdown(ihCtx)
+		if err != nil {
+			log.WithField("driver", cr.IpamDriverName()).WithError(err).Error("error shutting down driver")
+		}
+	}
+
+	if cs != nil {
+		csCtx, csCtxCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer csCtxCancel()
+		err = cs.Shutdown(csCtx)
+		if err != nil {
+			log.WithError(err).Error("error shutting down control api")
+		}
+	}
+
+	for ; running > 0; running-- {
+		<-stopped
+	}
+
+	fmt.Println()
+	fmt.Println("tetelestai")
+}
+
+// warmNeighbors imports the neighbors known to the seed host. Failing to is not fatal,
+// they are learned from traffic as usual.
+func warmNeighbors(c *core.Core, seed *control.Client) {
+	ns, err := seed.Neighbors()
+	if err != nil {
+		log.WithError(err).Warn("failed to fetch neighbors from seed")
+		return
+	}
+	n, err := c.ImportNeighbors(ns)
+	if err != nil {
+		log.WithError(err).Warn("failed to import neighbors from seed")
+		return
+	}
+	log.WithField("driver", c.NetworkDriverName()).WithField("neighbors", n).Info("imported neighbors from seed")
+}
+
+// bootstrap fetches the state from a seed host and loads it into core
+func bootstrap(c *core.Core, seed *control.Client, ttl time.Duration) error {
+	st, err := seed.State()
+	if err != nil {
+		return err
+	}
+	return c.Bootstrap(st, ttl)
+}
+
+// seedClient returns a client for the control api of the seed host, verifying an https
+// seed against --control-tls-ca if it is set
+func seedClient(ctx *cli.Context, seed string, timeout time.Duration) (*control.Client, error) {
+	sc := control.NewClient(seed, ctx.String("control-token"), timeout)
+	caFile := ctx.String("control-tls-ca")
+	if caFile == "" {
+		return sc, nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in %v", caFile)
+	}
+	sc.SetRootCAs(pool)
+	return sc, nil
+}
+
+// externalIPAM returns the client of the configured external IPAM, or nil if there is none
+func externalIPAM(ctx *cli.Context) (extipam.Client, error) {
+	kind := ctx.String("external-ipam")
+	if kind == "" {
+		return nil, nil
+	}
+	pfx, err := extipam.ParsePrefixes(ctx.StringSlice("external-ipam-prefix"))
+	if err != nil {
+		return nil, err
+	}
+	return extipam.New(kind, ctx.String("external-ipam-url"), ctx.String("external-ipam-token"), pfx)
+}
+
+// initLogging sets up logging from the global flags
+// gossipPeers returns a function listing the hosts of the live gossip members, nil without gossip
+func gossipPeers(g *gossip.Gossip) func() []string {
+	if g == nil {
+		return nil
+	}
+	return func() []string {
+		ret := []string{}
+		for _, m := range g.Members() {
+			ret = append(ret, m.Host)
+		}
+		return ret
+	}
+}
+
+// gossipOptions returns the gossip options of the flags
+func gossipOptions(ctx *cli.Context) gossip.Options {
+	return gossip.Options{
+		Bind:      ctx.String("gossip-bind"),
+		Advertise: ctx.String("gossip-advertise"),
+		Join:      ctx.StringSlice("gossip-join"),
+		Key:       ctx.String("gossip-key"),
+		Interval:  ctx.Duration("gossip-interval"),
+	}
+}
+
+// bgpConfig returns the bgp config of the flags
+func bgpConfig(ctx *cli.Context) (vxrbgp.Config, error) {
+	cfg := vxrbgp.Config{
+		Gobgp:    ctx.String("bgp-gobgp"),
+		API:      ctx.String("bgp-api"),
+		ASN:      ctx.Int64("bgp-asn"),
+		RouterID: net.ParseIP(ctx.String("bgp-router-id")),
+		Interval: ctx.Duration("bgp-interval"),
+	}
+	for _, ps := range ctx.StringSlice("bgp-peer") {
+		p, err := vxrbgp.ParsePeer(ps)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Peers = append(cfg.Peers, p)
+	}
+	return cfg, nil
+}
+
+func initLogging(ctx *cli.Context) {
+	if ctx.Bool("debug") {
+		log.SetLevel(log.DebugLevel)
+	}
+	log.SetFormatter(&log.TextFormatter{
+		ForceColors:      false,
+		DisableColors:    true,
+		DisableTimestamp: false,
+		FullTimestamp:    true,
+	})
+}
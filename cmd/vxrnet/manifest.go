@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	pluginContentType = "application/vnd.docker.plugins.v1.1+json"
+	// docker addresses plugins on unix sockets with this dummy host.
+	// A host specific pattern takes precedence over the helpers' own
+	// /Plugin.Activate handler, which cannot be replaced.
+	activatePattern = "plugin.sock/Plugin.Activate"
+	manifestPath    = "/Plugin.Manifest"
+)
+
+// manifest is the plugin activation response, extended with the optional
+// vxrouter subsystems enabled in this process. docker only reads Implements.
+type manifest struct {
+	Implements []string        `json:"Implements"`
+	Version    string          `json:"Version"`
+	Extensions map[string]bool `json:"Extensions"`
+}
+
+type handleFuncer interface {
+	HandleFunc(path string, fn func(w http.ResponseWriter, r *http.Request))
+}
+
+// handleManifest serves the manifest on activation and on manifestPath
+func handleManifest(h handleFuncer, implements string, ext map[string]bool) {
+	m := &manifest{
+		Implements: []string{implements},
+		Version:    version,
+		Extensions: ext,
+	}
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", pluginContentType)
+		if err := json.NewEncoder(w).Encode(m); err != nil {
+			log.WithError(err).Error("failed to encode manifest")
+		}
+	}
+	h.HandleFunc(activatePattern, fn)
+	h.HandleFunc(manifestPath, fn)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+)
+
+var networksCommand = cli.Command{
+	Name:  "networks",
+	Usage: "Show the networks of the running plugin and the addresses allocated in their subnets, from its control api",
+	Description: "An address is allocated once it has a host route, on this or another host. The plugin\n" +
+		"   is reached on the global --control-addr, on localhost if it has no host, with the global\n" +
+		"   --control-token.",
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: 10 * time.Second,
+			Usage: "How long to wait for the control api",
+		},
+		outputFlag,
+	},
+	Action: showNetworks,
+}
+
+// networkSummary is a network in the output of networks
+type networkSummary struct {
+	Name    string           `json:"name"`
+	ID      string           `json:"id"`
+	Driver  string           `json:"driver"`
+	Scope   string           `json:"scope"`
+	Subnets []*subnetSummary `json:"subnets"`
+}
+
+type subnetSummary struct {
+	Subnet    string `json:"subnet"`
+	Allocated int    `json:"allocated"`
+}
+
+// summarizeNetworks counts the allocations of st in each subnet of its networks
+func summarizeNetworks(st *core.State) []*networkSummary {
+	var allocs []net.IP
+	for _, a := range st.Allocations {
+		if ip := net.ParseIP(a); ip != nil {
+			allocs = append(allocs, ip)
+		}
+	}
+
+	ret := []*networkSummary{}
+	for _, nr := range st.Networks {
+		ns := &networkSummary{Name: nr.Name, ID: nr.ID, Driver: nr.Driver, Scope: nr.Scope, Subnets: []*subnetSummary{}}
+		for _, c := range nr.IPAM.Config {
+			_, sn, err := net.ParseCIDR(c.Subnet)
+			if err != nil {
+				continue
+			}
+			ss := &subnetSummary{Subnet: sn.String()}
+			for _, ip := range allocs {
+				if sn.Contains(ip) {
+					ss.Allocated++
+				}
+			}
+			ns.Subnets = append(ns.Subnets, ss)
+		}
+		ret = append(ret, ns)
+	}
+	return ret
+}
+
+func showNetworks(ctx *cli.Context) error {
+	if err := checkOutput(ctx); err != nil {
+		return err
+	}
+	c, err := controlClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	st, err := c.State()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	nss := summarizeNetworks(st)
+
+	return output(ctx, nss, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NETWORK\tID\tDRIVER\tSCOPE\tSUBNET\tALLOCATED")
+		for _, ns := range nss {
+			id := ns.ID
+			if len(id) > 12 {
+				id = id[:12]
+			}
+			if len(ns.Subnets) == 0 {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t-\t-\n", ns.Name, id, ns.Driver, ns.Scope)
+			}
+			for _, ss := range ns.Subnets {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", ns.Name, id, ns.Driver, ns.Scope, ss.Subnet, ss.Allocated)
+			}
+		}
+		return tw.Flush()
+	})
+}
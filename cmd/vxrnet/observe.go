@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/control"
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+)
+
+var observeCommand = cli.Command{
+	Name:  "observe",
+	Usage: "Serve the control api read only from kernel and docker state, without plugin sockets or changing anything",
+	Description: "For monitoring hosts and audits. The state, status, pools, topology, neighbors and\n" +
+		"   budget endpoints are served on the global --control-addr, for the networks of the\n" +
+		"   global --instance or driver names. No routes, neighbors or interfaces are changed,\n" +
+		"   the lease store is not opened and pools can not be frozen. With --gateway-netns the\n" +
+		"   existing namespace is read, it is not created.",
+	Action: observe,
+}
+
+func observe(ctx *cli.Context) error {
+	gctx := ctx.Parent()
+	initLogging(gctx)
+	host.SetReadOnly()
+
+	ca := gctx.String("control-addr")
+	if ca == "" {
+		return cli.NewExitError("the control api is disabled, set --control-addr", 1)
+	}
+	insts, err := instances(gctx)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if gn := gctx.String("gateway-netns"); gn != "" {
+		err = gwns.Observe(gn)
+		if err != nil {
+			return cli.NewExitError("failed to open gateway namespace: "+err.Error(), 1)
+		}
+	}
+
+	cores := []*core.Core{}
+	for _, in := range insts {
+		var c *core.Core
+		c, err = core.New(core.Options{
+			NetworkDriverName: in.netName,
+			IpamDriverName:    in.ipamName,
+			Defaults:          in.defaults,
+			PropTime:          gctx.Duration("prop-timeout"),
+			RespTime:          gctx.Duration("resp-timeout"),
+		})
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		cores = append(cores, c)
+	}
+
+	cl, err := net.Listen("tcp", ca)
+	if err != nil {
+		return cli.NewExitError("failed to listen for control api: "+err.Error(), 1)
+	}
+	cs := control.NewServer(gctx.String("control-token"), cores...)
+	cs.SetReadOnly()
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- cs.Serve(cl)
+	}()
+	log.WithField("listener", cl.Addr().String()).Info("observing, serving the control api read only")
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-stopped:
+		if err != nil && err != http.ErrServerClosed {
+			return cli.NewExitError("control api stopped: "+err.Error(), 1)
+		}
+		return nil
+	case <-sig:
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return cs.Shutdown(sctx)
+}
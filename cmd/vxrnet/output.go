@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// outputFlag selects the output format of the admin subcommands
+var outputFlag = cli.StringFlag{
+	Name:  "output, o",
+	Value: "table",
+	Usage: "Output format, table, json or yaml",
+}
+
+// output writes v to stdout in the format of the --output flag, using table for the table format
+func output(ctx *cli.Context, v interface{}, table func(w io.Writer) error) error {
+	switch f := strings.ToLower(ctx.String("output")); f {
+	case "table", "":
+		return table(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "yaml":
+		return writeYAML(os.Stdout, v)
+	default:
+		return cli.NewExitError(fmt.Sprintf("unknown output format %q, must be table, json or yaml", f), 1)
+	}
+}
+
+// checkOutput fails early on an unknown --output format, before anything is done
+func checkOutput(ctx *cli.Context) error {
+	switch strings.ToLower(ctx.String("output")) {
+	case "table", "", "json", "yaml":
+		return nil
+	}
+	return cli.NewExitError(fmt.Sprintf("unknown output format %q, must be table, json or yaml", ctx.String("output")), 1)
+}
+
+// writeYAML writes v as yaml, through its json encoding so the field names and omissions match
+func writeYAML(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var t interface{}
+	if err = dec.Decode(&t); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	yamlValue(&buf, t, 0, false)
+	if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// yamlValue writes a decoded json value at indent. inline is set when the value follows a key or dash on the same line.
+func yamlValue(b *bytes.Buffer, v interface{}, indent int, inline bool) {
+	pad := strings.Repeat("  ", indent)
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if inline {
+			b.WriteByte('\n')
+		}
+		for _, k := range keys {
+			b.WriteString(pad + yamlScalar(k) + ":")
+			yamlValue(b, t[k], indent+1, true)
+		}
+	case []interface{}:
+		if len(t) == 0 {
+			if inline {
+				b.WriteByte(' ')
+			}
+			b.WriteString("[]\n")
+			return
+		}
+		if inline {
+			b.WriteByte('\n')
+		}
+		for _, e := range t {
+			b.WriteString(pad + "-")
+			if m, ok := e.(map[string]interface{}); ok && len(m) > 0 {
+				// the first key of a map follows the dash
+				keys := make([]string, 0, len(m))
+				for k := range m {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for i, k := range keys {
+					if i == 0 {
+						b.WriteString(" ")
+					} else {
+						b.WriteString(pad + "  ")
+					}
+					b.WriteString(yamlScalar(k) + ":")
+					yamlValue(b, m[k], indent+2, true)
+				}
+				continue
+			}
+			yamlValue(b, e, indent+1, true)
+		}
+	default:
+		if inline {
+			b.WriteByte(' ')
+		}
+		b.WriteString(yamlScalar(t) + "\n")
+	}
+}
+
+// yamlScalar formats a json scalar, quoting strings yaml would otherwise read as another type
+func yamlScalar(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(t)
+	case json.Number:
+		return t.String()
+	case string:
+		if t == "" || strings.ContainsAny(t, ":#{}[],&*!|>'\"%@`\n\t") || strings.TrimSpace(t) != t ||
+			strings.HasPrefix(t, "-") || strings.HasPrefix(t, "?") {
+			return strconv.Quote(t)
+		}
+		switch strings.ToLower(t) {
+		case "true", "false", "yes", "no", "on", "off", "null", "~", "y", "n", ".inf", ".nan", "<<", "=":
+			return strconv.Quote(t)
+		}
+		// numbers, also yaml 1.1 integers in other bases or with underscores, eg. 0x10 and 1_000
+		if _, err := strconv.ParseFloat(t, 64); err == nil {
+			return strconv.Quote(t)
+		}
+		if _, err := strconv.ParseInt(t, 0, 64); err == nil {
+			return strconv.Quote(t)
+		}
+		return t
+	}
+	return fmt.Sprint(v)
+}
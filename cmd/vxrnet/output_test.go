@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestYAMLScalar(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{json.Number("1e3"), "1e3"},
+		{"plain", "plain"},
+		{"10.1.2.0/24", "10.1.2.0/24"},
+		{"", `""`},
+		// strings yaml would read as booleans, null or numbers
+		{"yes", `"yes"`},
+		{"No", `"No"`},
+		{"on", `"on"`},
+		{"~", `"~"`},
+		{"1e3", `"1e3"`},
+		{"10", `"10"`},
+		{"0x10", `"0x10"`},
+		{"1_000", `"1_000"`},
+		{".inf", `".inf"`},
+		// strings with yaml syntax
+		{":", `":"`},
+		{"fd00::1", `"fd00::1"`},
+		{"a: b", `"a: b"`},
+		{"-", `"-"`},
+		{"-1", `"-1"`},
+		{"- item", `"- item"`},
+		{"? key", `"? key"`},
+		{"#comment", `"#comment"`},
+		{" padded", `" padded"`},
+		{"two\nlines", `"two\nlines"`},
+		{`say "hi"`, `"say \"hi\""`},
+	}
+	for _, tt := range tests {
+		if got := yamlScalar(tt.v); got != tt.want {
+			t.Errorf("yamlScalar(%#v) = %v, want %v", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"scalar", "yes", "\"yes\"\n"},
+		{"empty list", []string{}, "[]\n"},
+		{"empty map", map[string]interface{}{"m": map[string]int{}}, "m: {}\n"},
+		{"map", map[string]interface{}{"b": 1, "a": "x", "c": []string{}}, "a: x\nb: 1\nc: []\n"},
+		{"list of maps", []map[string]interface{}{{"pool": "10.1.2.0/24", "free": "-", "tags": []string{"a", "1e3"}}, {"pool": ":"}},
+			"- free: \"-\"\n  pool: 10.1.2.0/24\n  tags:\n    - a\n    - \"1e3\"\n- pool: \":\"\n"},
+		{"nested", map[string]interface{}{"net": map[string]interface{}{"pools": []interface{}{map[string]int{"size": 256}}}},
+			"net:\n  pools:\n    - size: 256\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := writeYAML(&b, tt.v); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
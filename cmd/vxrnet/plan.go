@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+var planCommand = cli.Command{
+	Name:      "plan",
+	Usage:     "Show what allocating an address on a network would do, without changing anything",
+	ArgsUsage: "NETWORK [ADDRESS]",
+	Description: "Reports the address which would be allocated on the network, by name or id, or whether\n" +
+		"   the requested address is available, and the host interfaces, gateways and routes which\n" +
+		"   would be created, from the control api on the global --control-addr, on localhost if it\n" +
+		"   has no host, with the global --control-token. With a random allocator the address is\n" +
+		"   only an example.",
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: 10 * time.Second,
+			Usage: "How long to wait for the control api",
+		},
+		outputFlag,
+	},
+	Action: plan,
+}
+
+func plan(ctx *cli.Context) error {
+	if err := checkOutput(ctx); err != nil {
+		return err
+	}
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return cli.NewExitError("usage: plan NETWORK [ADDRESS]", 1)
+	}
+	c, err := controlClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	p, err := c.Plan(ctx.Args().Get(0), ctx.Args().Get(1))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	failed := 0
+	for _, pa := range p.Addresses {
+		if pa.Error != "" {
+			failed++
+		}
+	}
+	err = output(ctx, p, func(w io.Writer) error {
+		fmt.Fprintf(w, "network %v (%v) of driver %v\n", p.Network, p.NetworkID, p.Driver)
+		fmt.Fprintf(w, "interfaces to create: %v\n", listOrNone(p.Interfaces))
+		fmt.Fprintf(w, "gateways to add: %v\n\n", listOrNone(p.Gateways))
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "SUBNET\tADDRESS\tEXACT\tROUTES\tERROR")
+		for _, pa := range p.Addresses {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", pa.Subnet, pa.Address, pa.Exact, listOrNone(pa.Routes), pa.Error)
+		}
+		return tw.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("no address would be allocated in %v subnets", failed), 1)
+	}
+	return nil
+}
+
+func listOrNone(l []string) string {
+	if len(l) == 0 {
+		return "none"
+	}
+	return strings.Join(l, ", ")
+}
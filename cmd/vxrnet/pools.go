@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+var poolsCommand = cli.Command{
+	Name:  "pools",
+	Usage: "Show the address utilization of each pool of the running plugin, from its control api",
+	Description: "The plugin is reached on the global --control-addr, on localhost if it has no host,\n" +
+		"   with the global --control-token.",
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: 10 * time.Second,
+			Usage: "How long to wait for the control api",
+		},
+		outputFlag,
+	},
+	Action: showPools,
+}
+
+func showPools(ctx *cli.Context) error {
+	if err := checkOutput(ctx); err != nil {
+		return err
+	}
+	c, err := controlClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	pus, err := c.Pools()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	return output(ctx, pus, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NETWORK\tPOOL\tTOTAL\tALLOCATED\tLEASED\tEXCLUDED\tFREE")
+		for _, pu := range pus {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%.1f%%\n", pu.Network, pu.Pool, pu.Total, pu.Allocated, pu.Leased, pu.Excluded, pu.PercentFree)
+		}
+		return tw.Flush()
+	})
+}
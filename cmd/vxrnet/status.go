@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+var statusCommand = cli.Command{
+	Name:  "status",
+	Usage: "Show the capacity of each pool of the running plugin, from its control api",
+	Description: "Reports the vxlan id, size, excluded, reserved, allocated and free addresses of each\n" +
+		"   pool, whether it is frozen and the network managers conflicting with it. The plugin is\n" +
+		"   reached on the global --control-addr, on localhost if it has no host, with the global\n" +
+		"   --control-token.",
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: 10 * time.Second,
+			Usage: "How long to wait for the control api",
+		},
+		outputFlag,
+	},
+	Action: showStatus,
+}
+
+func showStatus(ctx *cli.Context) error {
+	if err := checkOutput(ctx); err != nil {
+		return err
+	}
+	c, err := controlClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	st, err := c.Status()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	return output(ctx, st, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NETWORK\tPOOL\tVXLANID\tSIZE\tEXCLUDED\tRESERVED\tALLOCATED\tFREE\tUSED\tFROZEN\tCONFLICTS")
+		for _, ps := range st {
+			frozen := "-"
+			if ps.FrozenSince != nil {
+				frozen = ps.FrozenSince.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%.1f%%\t%v\t%v\n", ps.Network, ps.Pool, ps.VxlanID, ps.Size,
+				ps.Excluded, ps.Reserved, ps.Allocated, ps.Free, ps.Utilization*100, frozen, listOrNone(ps.Conflicts))
+		}
+		return tw.Flush()
+	})
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/urfave/cli"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/bus"
+	"github.com/TrilliumIT/vxrouter/pkg/control"
+	"github.com/TrilliumIT/vxrouter/pkg/frr"
+	"github.com/TrilliumIT/vxrouter/pkg/gossip"
+	"github.com/TrilliumIT/vxrouter/pkg/hwvtep"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrbgp"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/kvstore"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/store"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrnet"
+)
+
+// validateTimeout bounds each connectivity check of validate-config
+const validateTimeout = 5 * time.Second
+
+var validateCommand = cli.Command{
+	Name:      "validate-config",
+	Usage:     "Check the configuration and connectivity to docker and the seed host, without starting the plugin",
+	ArgsUsage: "[env-file]",
+	Description: "The configuration is taken from the global flags and environment. If an env-file of\n" +
+		"   VXR_ variable assignments is given, eg. a systemd EnvironmentFile, it is read\n" +
+		"   instead of the global flags. Exits non-zero if anything is invalid.",
+	Flags:  []cli.Flag{outputFlag},
+	Action: validateConfig,
+}
+
+func validateConfig(ctx *cli.Context) error {
+	if err := checkOutput(ctx); err != nil {
+		return err
+	}
+	gctx := ctx.Parent()
+	if f := ctx.Args().First(); f != "" {
+		err := loadEnvFile(f)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		// re-parse the flags, so the variables from the file take effect
+		app := cli.NewApp()
+		app.Flags = ctx.App.Flags
+		app.Action = func(c *cli.Context) error {
+			gctx = c
+			return nil
+		}
+		err = app.Run(os.Args[:1])
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	errs := checkConfig(gctx)
+	res := &validation{Valid: len(errs) == 0, Errors: []string{}}
+	for _, err := range errs {
+		res.Errors = append(res.Errors, err.Error())
+	}
+	err := output(ctx, res, func(w io.Writer) error {
+		for _, err := range errs {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+		if res.Valid {
+			fmt.Fprintln(w, "configuration is valid")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !res.Valid {
+		return cli.NewExitError("configuration is invalid", 1)
+	}
+	return nil
+}
+
+// validation is the result of validate-config
+type validation struct {
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors"`
+}
+
+// loadEnvFile sets the environment variables assigned in path, one NAME=value per line
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(l, "export "), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%v:%v: expected NAME=value", path, n)
+		}
+		err = os.Setenv(strings.TrimSpace(kv[0]), strings.Trim(strings.TrimSpace(kv[1]), `"'`))
+		if err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// checkConfig returns everything wrong with the configuration in ctx
+func checkConfig(ctx *cli.Context) []error {
+	errs := []error{}
+	check := func(what string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v: %v", what, err))
+		}
+	}
+
+	insts, err := instances(ctx)
+	check("instances", err)
+	names := make(map[string]bool)
+	for _, in := range insts {
+		for _, n := range []string{in.netName, in.ipamName} {
+			if names[n] {
+				check("instances", fmt.Errorf("driver name %v is used more than once", n))
+			}
+			names[n] = true
+		}
+		if in.vniMin > in.vniMax {
+			check("instances", fmt.Errorf("instance %v vnimin %v is above vnimax %v", in.name, in.vniMin, in.vniMax))
+		}
+	}
+
+	for _, fs := range ctx.StringSlice("fabric") {
+		_, err = host.ParseFabric(fs)
+		check("fabric", err)
+	}
+	for _, li := range ctx.StringSlice("lldp") {
+		_, err = net.InterfaceByName(li)
+		check("lldp", err)
+	}
+
+	_, err = host.ParseAuditPolicy(ctx.String("route-audit"))
+	check("route-audit", err)
+	check("route protocols", host.CheckProtos())
+	if o := ctx.String("mac-oui"); o != "" {
+		_, err = vxrnet.ParseOUI(o)
+		check("mac-oui", err)
+	}
+	if ctx.Bool("prepopulate-neighbors") && ctx.String("mac-oui") == "" {
+		check("prepopulate-neighbors", fmt.Errorf("requires --mac-oui"))
+	}
+	// a named gateway namespace is created on start, a path must already exist
+	if gn := ctx.String("gateway-netns"); strings.Contains(gn, "/") {
+		_, err = os.Stat(gn)
+		check("gateway-netns", err)
+	}
+	_, err = parseMode(ctx.String("socket-mode"))
+	check("socket-mode", err)
+	_, err = parseGroup(ctx.String("socket-group"))
+	check("socket-group", err)
+
+	// only read an existing lease store, opening a missing one would create its directory
+	if ldb := ctx.String("lease-db"); ldb != "" {
+		if _, err = os.Stat(ldb); err == nil {
+			check("lease-db", store.Check(ldb))
+		} else if !os.IsNotExist(err) {
+			check("lease-db", err)
+		}
+	}
+
+	if kvs := ctx.String("kv-store"); kvs != "" {
+		_, err = kvstore.New(kvs)
+		check("kv-store", err)
+	}
+
+	_, err = externalIPAM(ctx)
+	check("external-ipam", err)
+
+	if bu := ctx.String("bus-url"); bu != "" {
+		_, err = bus.ParseURL(bu)
+		check("bus-url", err)
+	}
+
+	if ctx.String("gossip-bind") != "" {
+		check("gossip", gossip.CheckOptions(gossipOptions(ctx)))
+		if ctx.Duration("swarm-peers") > 0 {
+			check("gossip", fmt.Errorf("gossip and swarm-peers both set the flood peers, enable only one"))
+		}
+	}
+
+	if hu := ctx.String("hwvtep"); hu != "" {
+		_, _, err = hwvtep.ParseURL(hu)
+		check("hwvtep", err)
+	}
+	if asn := ctx.Int64("frr-asn"); asn != 0 {
+		check("frr-asn", frr.Check(ctx.String("frr-vtysh"), asn))
+	}
+	if ctx.Int64("bgp-asn") != 0 {
+		cfg, err := bgpConfig(ctx)
+		if err == nil {
+			err = vxrbgp.Check(cfg)
+		}
+		check("bgp-asn", err)
+	}
+
+	if whu := ctx.String("webhook-url"); whu != "" {
+		var u *url.URL
+		u, err = url.Parse(whu)
+		if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+			err = fmt.Errorf("%q is not an http or https url", whu)
+		}
+		check("webhook-url", err)
+	}
+
+	if ca := ctx.String("control-addr"); ca != "" {
+		_, err = net.ResolveTCPAddr("tcp", ca)
+		check("control-addr", err)
+	}
+	if (ctx.String("control-tls-cert") == "") != (ctx.String("control-tls-key") == "") {
+		check("control-tls-cert", fmt.Errorf("--control-tls-cert and --control-tls-key must be set together"))
+	}
+
+	dc, err := client.NewEnvClient()
+	if err == nil {
+		dctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+		_, err = dc.Ping(dctx)
+		cancel()
+	}
+	check("docker", err)
+
+	if seed := ctx.String("seed"); seed != "" {
+		var sc *control.Client
+		sc, err = seedClient(ctx, seed, validateTimeout)
+		if err == nil {
+			_, err = sc.State()
+		}
+		check("seed", err)
+	}
+
+	return errs
+}
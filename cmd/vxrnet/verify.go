@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+)
+
+var verifyCommand = cli.Command{
+	Name:  "verify",
+	Usage: "Cross-check the lease store, kernel routes, docker endpoints and host interfaces of the running plugin",
+	Description: "Reports missing routes, orphan routes, orphan leases and orphan interfaces, from the\n" +
+		"   control api on the global --control-addr, on localhost if it has no host, with the\n" +
+		"   global --control-token. Exits 1 if any inconsistency is found and not repaired.\n" +
+		"   The plugin can also verify in the background with --verify-interval.",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "repair",
+			Usage: "Repair the inconsistencies found",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: time.Minute,
+			Usage: "How long to wait for the control api",
+		},
+		outputFlag,
+	},
+	Action: verify,
+}
+
+func verify(ctx *cli.Context) error {
+	if err := checkOutput(ctx); err != nil {
+		return err
+	}
+	c, err := controlClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	reps, err := c.Verify(ctx.Bool("repair"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	unrepaired := 0
+	for _, rep := range reps {
+		for _, f := range rep.Findings {
+			if !f.Repaired {
+				unrepaired++
+			}
+		}
+	}
+	err = output(ctx, reps, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "DRIVER\tCATEGORY\tNETWORK\tADDRESS\tSTATUS")
+		for _, rep := range reps {
+			for _, f := range rep.Findings {
+				fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", rep.Driver, f.Category, f.Network, f.Address, findingStatus(f))
+			}
+		}
+		return tw.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	if unrepaired > 0 {
+		return cli.NewExitError(fmt.Sprintf("%v inconsistencies not repaired", unrepaired), 1)
+	}
+	return nil
+}
+
+func findingStatus(f *core.Finding) string {
+	switch {
+	case f.Repaired:
+		return "repaired"
+	case f.Error != "":
+		return "failed: " + f.Error
+	}
+	return "found"
+}
+
+// verifyLoop verifies c every interval until done, logging the findings
+func verifyLoop(c *core.Core, interval time.Duration, repair bool, done <-chan struct{}) {
+	if !c.WaitForDocker(done) {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		rep, err := c.Verify(repair)
+		if err != nil {
+			log.WithField("driver", c.NetworkDriverName()).WithError(err).Warn("failed to verify")
+			continue
+		}
+		for _, f := range rep.Findings {
+			log := log.WithField("driver", rep.Driver).WithField("category", f.Category).
+				WithField("network", f.Network).WithField("address", f.Address)
+			switch {
+			case f.Repaired:
+				log.Info("repaired inconsistency")
+			case f.Error != "":
+				log.WithField("error", f.Error).Error("failed to repair inconsistency")
+			default:
+				log.Warn("found inconsistency")
+			}
+		}
+	}
+}
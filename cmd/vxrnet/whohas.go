@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+)
+
+var whoHasCommand = cli.Command{
+	Name:      "who-has",
+	Usage:     "Show which network, container and vtep an address belongs to, from the control api",
+	ArgsUsage: "ADDRESS",
+	Description: "Reports the networks with a subnet containing the address, whether it is allocated, the\n" +
+		"   local container it is attached to and the neighbors known for it, with their mac, vtep\n" +
+		"   and vni. The plugin is reached on the global --control-addr, on localhost if it has no\n" +
+		"   host, with the global --control-token. Exits 1 if the address is in no network.",
+	Flags: []cli.Flag{
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: 10 * time.Second,
+			Usage: "How long to wait for the control api",
+		},
+		outputFlag,
+	},
+	Action: whoHas,
+}
+
+// owner is a network containing the address looked up by who-has
+type owner struct {
+	Network   string `json:"network"`
+	NetworkID string `json:"network_id"`
+	Subnet    string `json:"subnet"`
+	// Allocated is set if the address has a host route, on this or another host
+	Allocated bool `json:"allocated"`
+	// Container is the local container with the address, if any
+	Container string        `json:"container,omitempty"`
+	Neighbors []neigh.Entry `json:"neighbors"`
+}
+
+// findOwners returns the networks of st containing ip, with the neighbors of ns on ip. The
+// neighbors are known by host interface, not network, so overlapping subnets share them.
+func findOwners(ip net.IP, st *core.State, ns []neigh.Entry) []*owner {
+	ret := []*owner{}
+	for _, nr := range st.Networks {
+		for _, c := range nr.IPAM.Config {
+			_, sn, err := net.ParseCIDR(c.Subnet)
+			if err != nil || !sn.Contains(ip) {
+				continue
+			}
+			o := &owner{Network: nr.Name, NetworkID: nr.ID, Subnet: sn.String(), Neighbors: []neigh.Entry{}}
+			for _, a := range st.Allocations {
+				if ip.Equal(net.ParseIP(a)) {
+					o.Allocated = true
+				}
+			}
+			for id, er := range nr.Containers {
+				for _, a := range []string{er.IPv4Address, er.IPv6Address} {
+					if eip, _, err := net.ParseCIDR(a); err == nil && ip.Equal(eip) {
+						o.Container = er.Name
+						if o.Container == "" {
+							o.Container = id
+						}
+					}
+				}
+			}
+			for _, n := range ns {
+				if ip.Equal(net.ParseIP(n.IP)) {
+					o.Neighbors = append(o.Neighbors, n)
+				}
+			}
+			ret = append(ret, o)
+		}
+	}
+	return ret
+}
+
+func whoHas(ctx *cli.Context) error {
+	if err := checkOutput(ctx); err != nil {
+		return err
+	}
+	if ctx.NArg() != 1 {
+		return cli.NewExitError("usage: who-has ADDRESS", 1)
+	}
+	ip := net.ParseIP(ctx.Args().Get(0))
+	if ip == nil {
+		return cli.NewExitError(fmt.Sprintf("invalid address %q", ctx.Args().Get(0)), 1)
+	}
+	c, err := controlClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	st, err := c.State()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	ns, err := c.Neighbors()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	owners := findOwners(ip, st, ns)
+
+	err = output(ctx, owners, func(w io.Writer) error {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "NETWORK\tSUBNET\tALLOCATED\tCONTAINER\tMAC\tVTEP\tVNI")
+		for _, o := range owners {
+			ctr := o.Container
+			if ctr == "" {
+				ctr = "-"
+			}
+			var macs, vteps, vnis []string
+			for _, n := range o.Neighbors {
+				macs = append(macs, n.MAC)
+				vteps = append(vteps, n.VTEP)
+				vnis = append(vnis, fmt.Sprint(n.VNI))
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", o.Network, o.Subnet, o.Allocated, ctr,
+				dashIfEmpty(macs), dashIfEmpty(vteps), dashIfEmpty(vnis))
+		}
+		return tw.Flush()
+	})
+	if err != nil {
+		return err
+	}
+	if len(owners) == 0 {
+		return cli.NewExitError(fmt.Sprintf("%v is in no network", ip), 1)
+	}
+	return nil
+}
+
+func dashIfEmpty(l []string) string {
+	if len(l) == 0 {
+		return "-"
+	}
+	return strings.Join(l, ", ")
+}
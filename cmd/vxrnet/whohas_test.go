@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+)
+
+func testState() *core.State {
+	return &core.State{
+		Networks: []types.NetworkResource{
+			{
+				Name: "net1", ID: "abc", Driver: "vxrNet", Scope: "local",
+				IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.1.2.0/24"}, {Subnet: "fd00:1::/64"}}},
+				Containers: map[string]types.EndpointResource{
+					"c1": {Name: "web", IPv4Address: "10.1.2.5/24", IPv6Address: "fd00:1::5/64"},
+				},
+			},
+			{Name: "net2", ID: "def", IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.1.3.0/24"}}}},
+		},
+		Allocations: []string{"10.1.2.5", "10.1.2.6", "10.1.3.9", "fd00:1::5"},
+	}
+}
+
+func TestSummarizeNetworks(t *testing.T) {
+	nss := summarizeNetworks(testState())
+	want := map[string]int{"10.1.2.0/24": 2, "fd00:1::/64": 1, "10.1.3.0/24": 1}
+	n := 0
+	for _, ns := range nss {
+		for _, ss := range ns.Subnets {
+			n++
+			if ss.Allocated != want[ss.Subnet] {
+				t.Errorf("%v of %v has %v allocated, want %v", ss.Subnet, ns.Name, ss.Allocated, want[ss.Subnet])
+			}
+		}
+	}
+	if len(nss) != 2 || n != len(want) {
+		t.Errorf("got %v networks with %v subnets, want 2 with %v", len(nss), n, len(want))
+	}
+}
+
+func TestFindOwners(t *testing.T) {
+	ns := []neigh.Entry{
+		{Network: "vxrBr-42", VNI: 42, IP: "10.1.2.6", MAC: "02:00:00:00:00:06", VTEP: "192.168.0.2"},
+		{Network: "vxrBr-42", VNI: 42, IP: "10.1.2.7", MAC: "02:00:00:00:00:07", VTEP: "192.168.0.3"},
+	}
+	tests := []struct {
+		addr      string
+		network   string
+		allocated bool
+		container string
+		neighbors int
+	}{
+		{"10.1.2.5", "net1", true, "web", 0},
+		{"fd00:1::5", "net1", true, "web", 0},
+		{"10.1.2.6", "net1", true, "", 1},
+		{"10.1.2.8", "net1", false, "", 0},
+		{"10.1.3.9", "net2", true, "", 0},
+		{"10.1.4.1", "", false, "", 0},
+	}
+	for _, tt := range tests {
+		owners := findOwners(net.ParseIP(tt.addr), testState(), ns)
+		if tt.network == "" {
+			if len(owners) != 0 {
+				t.Errorf("%v found in %v networks, want none", tt.addr, len(owners))
+			}
+			continue
+		}
+		if len(owners) != 1 {
+			t.Errorf("%v found in %v networks, want 1", tt.addr, len(owners))
+			continue
+		}
+		o := owners[0]
+		if o.Network != tt.network || o.Allocated != tt.allocated || o.Container != tt.container || len(o.Neighbors) != tt.neighbors {
+			t.Errorf("%v found as %+v, want network %v, allocated %v, container %q and %v neighbors",
+				tt.addr, o, tt.network, tt.allocated, tt.container, tt.neighbors)
+		}
+	}
+}
@@ -11,5 +11,14 @@ const (
 	NetworkDriver           = "vxrNet"
 	IpamDriver              = "vxrIpam"
 	DefaultReqAddrSleepTime = 100 * time.Millisecond
-	DefaultRouteProto       = 192
+	DefaultProbeTime        = 200 * time.Millisecond
+	DefaultCanaryTimeout    = time.Second
+	DefaultMaxSelectTries   = 64
+	DefaultMinFreeRatio     = 0.01
+	DefaultRouteProto       = 240
+	DefaultSummaryProto     = 241
+	DefaultDelegateProto    = 242
+	DefaultBGPProto         = 243
+	DefaultQuarantineProto  = 244
+	DefaultFabricSample     = 10 * time.Second
 )
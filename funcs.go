@@ -9,11 +9,10 @@ import (
 )
 
 func getEnvOpt(val, opt string) string { //nolint: unparam
-	e := os.Getenv(val)
-	if e == "" {
-		e = opt
+	if opt != "" {
+		return opt
 	}
-	return e
+	return os.Getenv(val)
 }
 
 // GetEnvIntWithDefault gets value, prioritizing first opt, if it is not empty, then the environment variable specified by val, and lastly the default.
@@ -43,3 +42,17 @@ func GetEnvDurWithDefault(val, opt string, def time.Duration) time.Duration { //
 	}
 	return ei
 }
+
+// GetEnvFloatWithDefault gets value, prioritizing first opt, if it is not empty, then the environment variable specified by val, and lastly the default.
+func GetEnvFloatWithDefault(val, opt string, def float64) float64 { //nolint: unparam
+	e := getEnvOpt(val, opt)
+	if e == "" {
+		return def
+	}
+	ef, err := strconv.ParseFloat(e, 64)
+	if err != nil {
+		log.WithField("string", e).WithError(err).Warnf("failed to convert string to float, using default")
+		return def
+	}
+	return ef
+}
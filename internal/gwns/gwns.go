@@ -0,0 +1,238 @@
+// Package gwns places the host gateway interfaces, the vxlans and host macvlans,
+// and the routes to containers in a dedicated network namespace instead of the
+// host root namespace. A helper container or routing daemon joined to that
+// namespace is then responsible for connecting it to the rest of the network.
+package gwns
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"github.com/vishvananda/netns"
+)
+
+// nsRunDir is where named network namespaces are bound, as by iproute2
+const nsRunDir = "/var/run/netns"
+
+var (
+	ns     = netns.None()
+	root   = netns.None()
+	handle = &netlink.Handle{}
+	rootH  = &netlink.Handle{}
+)
+
+// forwarding are the sysctls set in the namespace for it to route for containers
+var forwarding = map[string]string{
+	"net.ipv4.ip_forward":              "1",
+	"net.ipv6.conf.all.forwarding":     "1",
+	"net.ipv6.conf.default.forwarding": "1",
+}
+
+// Set places the gateway interfaces in the network namespace nsName, either the name of a
+// namespace under /var/run/netns, which is created if it does not exist, or the path of a
+// namespace, eg. /var/run/docker/netns/<sandbox> of a helper container.
+// It must be called before any interfaces are created, from the root namespace.
+func Set(nsName string) error {
+	log := log.WithField("Func", "gwns.Set()").WithField("ns", nsName)
+	log.Debug()
+
+	path := nsName
+	if !strings.Contains(nsName, "/") {
+		path = filepath.Join(nsRunDir, nsName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			err = create(path)
+			if err != nil {
+				log.WithError(err).Error("failed to create namespace")
+				return err
+			}
+			log.Info("created gateway namespace")
+		}
+	}
+
+	err := open(nsName, path)
+	if err != nil {
+		return err
+	}
+	return Do(func() error {
+		for k, v := range forwarding {
+			p := filepath.Join("/proc/sys", strings.Replace(k, ".", "/", -1))
+			if err := ioutil.WriteFile(p, []byte(v), 0644); err != nil {
+				return fmt.Errorf("failed to set %v: %v", k, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Observe reads the gateway interfaces from the existing network namespace nsName, as given to Set,
+// without creating it or changing its settings
+func Observe(nsName string) error {
+	path := nsName
+	if !strings.Contains(nsName, "/") {
+		path = filepath.Join(nsRunDir, nsName)
+	}
+	return open(nsName, path)
+}
+
+// open opens the namespace at path as the gateway namespace
+func open(nsName, path string) error {
+	n, err := netns.GetFromPath(path)
+	if err != nil {
+		return err
+	}
+	r, err := netns.Get()
+	if err != nil {
+		n.Close() // nolint: errcheck
+		return err
+	}
+	if n.Equal(r) {
+		n.Close() // nolint: errcheck
+		r.Close() // nolint: errcheck
+		return fmt.Errorf("%v is the root namespace", nsName)
+	}
+	h, err := netlink.NewHandleAt(n)
+	if err != nil {
+		n.Close() // nolint: errcheck
+		r.Close() // nolint: errcheck
+		return err
+	}
+
+	ns, root, handle = n, r, h
+	return nil
+}
+
+// create creates a network namespace bound at path, the way ip netns add does
+func create(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE|os.O_EXCL, 0444)
+	if err != nil {
+		return err
+	}
+	f.Close() // nolint: errcheck
+
+	err = DoIn(netns.None(), func() error {
+		n, err := netns.New()
+		if err != nil {
+			return err
+		}
+		defer n.Close() // nolint: errcheck
+		src := fmt.Sprintf("/proc/self/task/%d/ns/net", syscall.Gettid())
+		return syscall.Mount(src, path, "none", syscall.MS_BIND, "")
+	})
+	if err != nil {
+		os.Remove(path) // nolint: errcheck
+	}
+	return err
+}
+
+// Enabled reports whether the gateway interfaces are in a dedicated namespace
+func Enabled() bool {
+	return ns.IsOpen()
+}
+
+// Handle returns a netlink handle in the gateway namespace
+func Handle() *netlink.Handle {
+	return handle
+}
+
+// Root returns a netlink handle in the root namespace, where the underlay devices are
+func Root() *netlink.Handle {
+	return rootH
+}
+
+// NewHandle returns a new netlink handle in the gateway namespace
+func NewHandle() (*netlink.Handle, error) {
+	if !Enabled() {
+		return netlink.NewHandle()
+	}
+	return netlink.NewHandleAt(ns)
+}
+
+// RouteSubscribe subscribes to route updates in the gateway namespace
+func RouteSubscribe(ch chan<- netlink.RouteUpdate, done <-chan struct{}) error {
+	if !Enabled() {
+		return netlink.RouteSubscribe(ch, done)
+	}
+	return netlink.RouteSubscribeAt(ns, ch, done)
+}
+
+// Subscribe opens a netlink socket in the gateway namespace subscribed to the rtnetlink groups,
+// for the messages themselves, eg. for the port id of the process causing a change
+func Subscribe(groups ...uint) (*nl.NetlinkSocket, error) {
+	if !Enabled() {
+		return nl.Subscribe(syscall.NETLINK_ROUTE, groups...)
+	}
+	return nl.SubscribeAt(ns, netns.None(), syscall.NETLINK_ROUTE, groups...)
+}
+
+// MoveIn moves link from the root namespace into the gateway namespace
+func MoveIn(link netlink.Link) error {
+	if !Enabled() {
+		return nil
+	}
+	return rootH.LinkSetNsFd(link, int(ns))
+}
+
+// MoveOut moves link from the gateway namespace to the root namespace, where docker expects
+// container interfaces to be
+func MoveOut(link netlink.Link) error {
+	if !Enabled() {
+		return nil
+	}
+	return handle.LinkSetNsFd(link, int(root))
+}
+
+// Do runs f with the calling thread in the gateway namespace, for operations
+// without a namespace aware api, eg. raw sockets and /proc/sys
+func Do(f func() error) error {
+	if !Enabled() {
+		return f()
+	}
+	return DoIn(ns, f)
+}
+
+// DoIn runs f with the calling thread locked in the namespace target, or in its own if target
+// is not open. f may move the thread to another namespace, eg. with netns.New. The thread
+// is returned to its namespace after f, if that fails it exits with the goroutine.
+func DoIn(target netns.NsHandle, f func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		return err
+	}
+	defer origin.Close() // nolint: errcheck
+
+	defer func() {
+		if cur, err := netns.Get(); err == nil {
+			back := cur.Equal(origin)
+			cur.Close() // nolint: errcheck
+			if back {
+				return
+			}
+		}
+		if err := netns.Set(origin); err != nil {
+			log.WithError(err).Error("failed to return to original namespace")
+			// the thread is stuck in the namespace, keep it locked so it exits with the goroutine
+			runtime.LockOSThread()
+		}
+	}()
+	if target.IsOpen() {
+		if err = netns.Set(target); err != nil {
+			return err
+		}
+	}
+	return f()
+}
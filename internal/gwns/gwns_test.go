@@ -0,0 +1,32 @@
+package gwns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vishvananda/netns"
+)
+
+func TestDoInOwnNamespace(t *testing.T) {
+	origin, err := netns.Get()
+	if err != nil {
+		t.Skipf("no network namespace: %v", err)
+	}
+	defer origin.Close() // nolint: errcheck
+
+	ferr := errors.New("f failed")
+	err = DoIn(netns.None(), func() error {
+		cur, err := netns.Get()
+		if err != nil {
+			return err
+		}
+		defer cur.Close() // nolint: errcheck
+		if !cur.Equal(origin) {
+			t.Error("f did not run in the namespace of the caller")
+		}
+		return ferr
+	})
+	if err != ferr {
+		t.Errorf("got %v, want the error of f", err)
+	}
+}
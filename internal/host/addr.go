@@ -0,0 +1,210 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// SelectOpts constrains address selection on a host interface
+type SelectOpts struct {
+	// Subnet is the subnet of the network. If nil, it is taken from the gateway address on the host interface
+	Subnet *net.IPNet
+	// PropTime is how long to wait for a route to propagate before checking for duplicates
+	PropTime time.Duration
+	// RespTime is the maximum time to spend selecting an address
+	RespTime time.Duration
+	// Context, if set, bounds selection to its deadline, eg. that of the docker request
+	Context context.Context
+	// ExcludeFirst and ExcludeLast are the number of addresses at the start and end of the subnet never to select
+	ExcludeFirst, ExcludeLast int
+	// Gateway, if set, is never selected
+	Gateway net.IP
+	// Exclude are ranges of the subnet which are never selected
+	Exclude []IPRange
+	// Reserved, if set, reports addresses that are in use on another host
+	Reserved func(net.IP) bool
+	// Block, if set, is a sub-block of the subnet random addresses are preferentially selected from
+	Block *net.IPNet
+	// BlockOnly, if set, fails random selection when Block is full instead of falling back to the whole subnet
+	BlockOnly bool
+	// Range, if set, is the sub-pool (docker's --ip-range) of the subnet unrequested addresses are selected from.
+	// The subnet is still used for the mask and exclusions.
+	Range *net.IPNet
+	// Allocator picks the candidates tried when no address is requested, random if nil
+	Allocator allocator.Allocator
+	// DAD, if set, probes selected addresses for PropTime before installing their route,
+	// requested addresses are always probed
+	DAD bool
+	// Claim, if set, locks an address with a coordinator before its route is installed,
+	// returning false if another host holds it
+	Claim func(net.IP) (bool, error)
+	// Unclaim, if set, releases an address locked by Claim which is not selected
+	Unclaim func(net.IP)
+	// Preferred, if set, is tried before any other address when none is requested, eg. one
+	// derived from the endpoint MAC. If it is excluded or in use, another is selected.
+	Preferred net.IP
+	// Conflicts decides what is done when an address is found in use by another node, RetryConflicts if nil
+	Conflicts ConflictPolicy
+	// Evicted, if set, is called when an address is taken over from another node
+	Evicted func(*Conflict)
+	// Metric is the metric of the host route installed, 0 for the kernel default
+	Metric int
+}
+
+// IPToInt returns ip as an integer, of 4 bytes for an ipv4 address
+func IPToInt(ip net.IP) *big.Int {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return new(big.Int).SetBytes(ip)
+}
+
+func intToIP(i *big.Int, ipLen int) net.IP {
+	b := i.Bytes()
+	ip := make(net.IP, ipLen)
+	copy(ip[ipLen-len(b):], b)
+	return ip
+}
+
+// SubnetSize returns the number of addresses in n
+func SubnetSize(n *net.IPNet) *big.Int {
+	ones, bits := n.Mask.Size()
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+}
+
+// NthAddress returns the address n after the base address of sn,
+// or nil if it is outside of sn
+func NthAddress(sn *net.IPNet, n int) net.IP {
+	if n < 0 || SubnetSize(sn).Cmp(big.NewInt(int64(n))) <= 0 {
+		return nil
+	}
+	base := sn.IP.Mask(sn.Mask)
+	return intToIP(new(big.Int).Add(IPToInt(base), big.NewInt(int64(n))), len(base))
+}
+
+// EUI64Address returns the address of sn with the modified EUI-64 interface identifier
+// of mac (rfc 4291), or nil if sn is not an ipv6 subnet of /64 or larger
+func EUI64Address(sn *net.IPNet, mac net.HardwareAddr) net.IP {
+	ones, bits := sn.Mask.Size()
+	if bits != 128 || ones > 64 || len(mac) != 6 {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, sn.IP.Mask(sn.Mask).To16())
+	ip[8] = mac[0] ^ 0x02
+	ip[9], ip[10] = mac[1], mac[2]
+	ip[11], ip[12] = 0xff, 0xfe
+	ip[13], ip[14], ip[15] = mac[3], mac[4], mac[5]
+	return ip
+}
+
+// HashAddress deterministically selects an address of sn based on a hash of key
+func HashAddress(sn *net.IPNet, key []byte) net.IP {
+	h := fnv.New64a()
+	h.Write(key) // nolint: errcheck
+	n := new(big.Int).Mod(new(big.Int).SetUint64(h.Sum64()), SubnetSize(sn))
+	base := sn.IP.Mask(sn.Mask)
+	return intToIP(n.Add(n, IPToInt(base)), len(base))
+}
+
+// SubBlock deterministically selects a sub-block of sn containing size addresses,
+// based on a hash of key. size is rounded up to a power of two.
+// nil is returned if the block would not be smaller than sn.
+func SubBlock(sn *net.IPNet, size int, key string) *net.IPNet {
+	return subBlockAt(sn, size, key, 0)
+}
+
+// subBlockBits returns the number of address bits of a sub-block of size addresses,
+// or 0 if it would not be smaller than sn
+func subBlockBits(sn *net.IPNet, size int) int {
+	ones, bits := sn.Mask.Size()
+	bl := 0
+	for 1<<uint(bl) < size {
+		bl++
+	}
+	if bl < 1 || bits-bl <= ones {
+		return 0
+	}
+	return bl
+}
+
+// subBlockAt selects the sub-block probe blocks after the one SubBlock selects, wrapping
+// around at the end of sn
+func subBlockAt(sn *net.IPNet, size int, key string, probe int) *net.IPNet {
+	ones, bits := sn.Mask.Size()
+	bl := subBlockBits(sn, size)
+	if bl == 0 {
+		return nil
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(key)) // nolint: errcheck
+	nBlocks := new(big.Int).Lsh(big.NewInt(1), uint(bits-bl-ones))
+	idx := new(big.Int).SetUint64(h.Sum64())
+	idx.Add(idx, big.NewInt(int64(probe)))
+	idx.Mod(idx, nBlocks)
+
+	base := IPToInt(sn.IP.Mask(sn.Mask))
+	base.Add(base, idx.Lsh(idx, uint(bl)))
+
+	return &net.IPNet{
+		IP:   intToIP(base, len(sn.Mask)),
+		Mask: net.CIDRMask(bits-bl, bits),
+	}
+}
+
+// maxBlockProbes bounds the sub-blocks probeBlock tries
+const maxBlockProbes = 256
+
+// probeBlock returns the first sub-block of sn from the one SubBlock selects on which
+// is not taken. A Conflict error is returned if all those probed are taken.
+func probeBlock(sn *net.IPNet, size int, key string, taken func(*net.IPNet) (bool, error)) (*net.IPNet, error) {
+	ones, bits := sn.Mask.Size()
+	bl := subBlockBits(sn, size)
+	if bl == 0 {
+		return nil, fmt.Errorf("a block of %v addresses is not smaller than %v", size, sn)
+	}
+	n := maxBlockProbes
+	if bits-bl-ones < 8 {
+		n = 1 << uint(bits-bl-ones)
+	}
+	for i := 0; i < n; i++ {
+		block := subBlockAt(sn, size, key, i)
+		t, err := taken(block)
+		if err != nil {
+			return nil, err
+		}
+		if !t {
+			return block, nil
+		}
+	}
+	return nil, vxrerrors.Conflict("all %v probed blocks of %v addresses in %v are in use by other hosts", n, size, sn)
+}
+
+// blockExclusions translates subnet exclusions of xf first and xl last addresses
+// into the number of addresses to exclude at the start and end of block
+func blockExclusions(sn, block *net.IPNet, xf, xl int) (int, int) {
+	snStart := IPToInt(sn.IP.Mask(sn.Mask))
+	snEnd := new(big.Int).Add(snStart, SubnetSize(sn))
+	bStart := IPToInt(block.IP.Mask(block.Mask))
+	bEnd := new(big.Int).Add(bStart, SubnetSize(block))
+
+	bxf := new(big.Int).Sub(big.NewInt(int64(xf)), new(big.Int).Sub(bStart, snStart))
+	bxl := new(big.Int).Sub(big.NewInt(int64(xl)), new(big.Int).Sub(snEnd, bEnd))
+
+	f, l := 0, 0
+	if bxf.Sign() > 0 {
+		f = int(bxf.Int64())
+	}
+	if bxl.Sign() > 0 {
+		l = int(bxl.Int64())
+	}
+	return f, l
+}
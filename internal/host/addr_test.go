@@ -0,0 +1,66 @@
+package host
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+func TestProbeBlock(t *testing.T) {
+	sn := cidr("10.1.0.0/22")
+	first := SubBlock(sn, 256, "host1")
+
+	free := func(*net.IPNet) (bool, error) { return false, nil }
+	b, err := probeBlock(sn, 256, "host1", free)
+	if err != nil || b.String() != first.String() {
+		t.Errorf("free block is %v (%v), want %v", b, err, first)
+	}
+
+	// another host hashed to the same block
+	taken := map[string]bool{first.String(): true}
+	b, err = probeBlock(sn, 256, "host1", func(b *net.IPNet) (bool, error) { return taken[b.String()], nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.String() == first.String() || !sn.Contains(b.IP) {
+		t.Errorf("probed block is %v, want another block of %v", b, sn)
+	}
+	if ones, _ := b.Mask.Size(); ones != 24 {
+		t.Errorf("probed block is %v, want a /24", b)
+	}
+
+	// the probes wrap around the subnet, each of the 4 blocks is tried once
+	tried := map[string]bool{}
+	_, err = probeBlock(sn, 256, "host1", func(b *net.IPNet) (bool, error) {
+		tried[b.String()] = true
+		return true, nil
+	})
+	if !errors.Is(err, vxrerrors.ErrConflict) {
+		t.Errorf("error with all blocks taken is %v, want a conflict", err)
+	}
+	if len(tried) != 4 {
+		t.Errorf("tried blocks %v, want all 4", tried)
+	}
+
+	if _, err = probeBlock(sn, 1024, "host1", free); err == nil {
+		t.Error("selected a block as large as the subnet")
+	}
+}
+
+func TestIPToInt(t *testing.T) {
+	// ipv4 addresses are 4 bytes, whether parsed in 16
+	if i := IPToInt(net.ParseIP("10.0.1.2")); i.Int64() != 10<<24|1<<8|2 {
+		t.Errorf("10.0.1.2 is %v", i)
+	}
+	if i := IPToInt(net.ParseIP("::1:2")); i.Int64() != 1<<16|2 {
+		t.Errorf("::1:2 is %v", i)
+	}
+	if s := SubnetSize(cidr("10.1.0.0/22")); s.Int64() != 1024 {
+		t.Errorf("size of a /22 is %v", s)
+	}
+	if s := SubnetSize(cidr("fd00::/64")); s.String() != "18446744073709551616" {
+		t.Errorf("size of a /64 is %v", s)
+	}
+}
@@ -0,0 +1,124 @@
+package host
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The raw socket and netlink code builds structs by hand, these check their layout and byte
+// order on the architecture the tests are built for, eg. GOARCH=arm64, arm or 386.
+
+func TestPacketMreqSize(t *testing.T) {
+	// struct packet_mreq is 16 bytes on every architecture
+	if s := unsafe.Sizeof(packetMreq{}); s != 16 {
+		t.Fatalf("packetMreq is %v bytes, want 16", s)
+	}
+}
+
+func TestHtons(t *testing.T) {
+	for _, v := range []uint16{ethPARP, ethPIPv6, ethPLLDP} {
+		h := htons(v)
+		b := (*[2]byte)(unsafe.Pointer(&h))
+		if binary.BigEndian.Uint16(b[:]) != v {
+			t.Errorf("htons(%#x) is %#x in memory, not network order", v, b)
+		}
+	}
+}
+
+func nlMessage(typ uint16, pid uint32, data ...[]byte) syscall.NetlinkMessage {
+	m := syscall.NetlinkMessage{Header: syscall.NlMsghdr{Type: typ, Pid: pid}}
+	for _, d := range data {
+		m.Data = append(m.Data, d...)
+	}
+	return m
+}
+
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	nl.NativeEndian().PutUint32(b, v)
+	return b
+}
+
+func TestParseRouteUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  *net.IPNet
+		gw   net.IP
+	}{
+		{"v4", &net.IPNet{IP: net.ParseIP("10.1.2.3").To4(), Mask: net.CIDRMask(32, 32)}, nil},
+		{"v6", &net.IPNet{IP: net.ParseIP("fd00::5"), Mask: net.CIDRMask(128, 128)}, nil},
+		{"v6 via", &net.IPNet{IP: net.ParseIP("fd00:1::"), Mask: net.CIDRMask(64, 128)}, net.ParseIP("fd00::5")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := nl.NewRtMsg()
+			msg.Family = syscall.AF_INET6
+			if tt.dst.IP.To4() != nil {
+				msg.Family = syscall.AF_INET
+			}
+			ones, _ := tt.dst.Mask.Size()
+			msg.Dst_len = uint8(ones)
+			msg.Protocol = 201
+			data := [][]byte{
+				msg.Serialize(),
+				nl.NewRtAttr(syscall.RTA_DST, tt.dst.IP).Serialize(),
+				nl.NewRtAttr(syscall.RTA_OIF, u32(7)).Serialize(),
+				nl.NewRtAttr(syscall.RTA_PRIORITY, u32(1024)).Serialize(),
+			}
+			if tt.gw != nil {
+				data = append(data, nl.NewRtAttr(syscall.RTA_GATEWAY, tt.gw).Serialize())
+			}
+
+			u, ok := parseUpdate(nlMessage(syscall.RTM_DELROUTE, 4242, data...))
+			if !ok {
+				t.Fatal("route update not parsed")
+			}
+			if u.typ != syscall.RTM_DELROUTE || u.by != 4242 {
+				t.Errorf("type %v by %v, want %v by 4242", u.typ, u.by, syscall.RTM_DELROUTE)
+			}
+			if u.route.Dst.String() != tt.dst.String() {
+				t.Errorf("dst %v, want %v", u.route.Dst, tt.dst)
+			}
+			if u.route.LinkIndex != 7 || u.route.Priority != 1024 || u.route.Protocol != 201 {
+				t.Errorf("link %v priority %v protocol %v, want 7 1024 201", u.route.LinkIndex, u.route.Priority, u.route.Protocol)
+			}
+			if !u.route.Gw.Equal(tt.gw) {
+				t.Errorf("gw %v, want %v", u.route.Gw, tt.gw)
+			}
+		})
+	}
+}
+
+func TestParseAddrUpdate(t *testing.T) {
+	msg := nl.NewIfAddrmsg(syscall.AF_INET)
+	msg.Prefixlen = 24
+	msg.Index = 9
+	ip := net.ParseIP("10.1.2.1").To4()
+	u, ok := parseUpdate(nlMessage(syscall.RTM_DELADDR, 0,
+		msg.Serialize(),
+		nl.NewRtAttr(syscall.IFA_ADDRESS, ip).Serialize(),
+		nl.NewRtAttr(syscall.IFA_LOCAL, ip).Serialize(),
+	))
+	if !ok {
+		t.Fatal("address update not parsed")
+	}
+	if u.linkIndex != 9 || u.addr.String() != "10.1.2.1/24" {
+		t.Errorf("link %v addr %v, want 9 10.1.2.1/24", u.linkIndex, u.addr)
+	}
+	if sender(u.by) != "kernel" {
+		t.Errorf("sender %v, want kernel", sender(u.by))
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	for _, typ := range []uint16{syscall.RTM_NEWROUTE, syscall.RTM_NEWADDR} {
+		if _, ok := parseUpdate(nlMessage(typ, 0, []byte{1, 2})); ok {
+			t.Errorf("truncated message of type %v parsed", typ)
+		}
+	}
+}
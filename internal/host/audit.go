@@ -0,0 +1,219 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/macvlan"
+)
+
+// AuditPolicy is the action taken when a third party modifies a vxrouter route or gateway
+type AuditPolicy int
+
+const (
+	// AuditOff disables the route audit
+	AuditOff AuditPolicy = iota
+	// AuditLog logs modifications as warnings
+	AuditLog
+	// AuditAlert logs modifications as errors, tagged for alerting
+	AuditAlert
+	// AuditRepair logs modifications and restores the route or gateway
+	AuditRepair
+)
+
+// expected deletions are forgotten after this long
+const expectedDelTimeout = 10 * time.Second
+
+var (
+	expectedDels  = make(map[string]time.Time)
+	expectedDelsL sync.Mutex
+)
+
+// ParseAuditPolicy parses an audit policy from off, log, alert or repair
+func ParseAuditPolicy(s string) (AuditPolicy, error) {
+	switch strings.ToLower(s) {
+	case "off", "":
+		return AuditOff, nil
+	case "log":
+		return AuditLog, nil
+	case "alert":
+		return AuditAlert, nil
+	case "repair":
+		return AuditRepair, nil
+	}
+	return AuditOff, fmt.Errorf("unknown route audit policy %v", s)
+}
+
+func (p AuditPolicy) String() string {
+	return [...]string{"off", "log", "alert", "repair"}[p]
+}
+
+// expectRouteDel records that vxrouter is about to delete the route to ip
+// so that the audit does not report it
+func expectRouteDel(ip net.IP) {
+	expectDel(ip.String())
+}
+
+// expectGatewayDel records that vxrouter is about to remove the gateway ip from a host macvlan
+func expectGatewayDel(ip net.IP) {
+	expectDel("gateway " + ip.String())
+}
+
+func expectDel(k string) {
+	expectedDelsL.Lock()
+	defer expectedDelsL.Unlock()
+	expectedDels[k] = time.Now().Add(expectedDelTimeout)
+}
+
+func wasExpectedDel(k string) bool {
+	expectedDelsL.Lock()
+	defer expectedDelsL.Unlock()
+	exp, ok := expectedDels[k]
+	delete(expectedDels, k)
+	return ok && time.Now().Before(exp)
+}
+
+// AuditRoutes watches for third party modifications to vxrouter host routes,
+// deletions or replacements by routes from another protocol, and removals of the
+// gateways of host macvlans, and handles them according to policy until done is
+// closed. Each is logged with the process which made it, when it can be found.
+func AuditRoutes(policy AuditPolicy, done <-chan struct{}) error {
+	if policy == AuditOff {
+		return nil
+	}
+	log := log.WithField("Func", "AuditRoutes()").WithField("policy", policy.String())
+	log.Debug()
+
+	ruc := make(chan rtUpdate)
+	err := subscribeRoutes(ruc, done)
+	if err != nil {
+		log.WithError(err).Error("failed to subscribe to route updates")
+		return err
+	}
+
+	known := make(map[string]netlink.Route)
+	routes, err := nlh.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Protocol: routeProto}, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return err
+	}
+	for _, r := range routes {
+		if r.Type == syscall.RTN_BLACKHOLE {
+			continue
+		}
+		known[r.Dst.IP.String()] = r
+	}
+
+	for ru := range ruc {
+		if ru.typ == syscall.RTM_DELADDR {
+			auditGateway(policy, ru)
+			continue
+		}
+		if ru.route.Dst == nil || ru.route.Type == syscall.RTN_BLACKHOLE {
+			continue
+		}
+		dst := ru.route.Dst.IP.String()
+		switch ru.typ {
+		case syscall.RTM_NEWROUTE:
+			if ru.route.Protocol == routeProto {
+				known[dst] = ru.route
+				continue
+			}
+			r, ok := known[dst]
+			if !ok {
+				continue
+			}
+			if n, _ := VxroutesTo(ru.route.Dst.IP); n > 0 {
+				// a parallel route, likely a duplicate allocation, this is handled by address selection
+				continue
+			}
+			delete(known, dst)
+			auditAnomaly(policy, r, ru.route, ru.by, "vxrouter route replaced by another protocol")
+		case syscall.RTM_DELROUTE:
+			if ru.route.Protocol != routeProto {
+				continue
+			}
+			delete(known, dst)
+			if wasExpectedDel(dst) {
+				continue
+			}
+			if _, err = nlh.LinkByIndex(ru.route.LinkIndex); err != nil {
+				// interface was torn down, routes went with it
+				continue
+			}
+			auditAnomaly(policy, ru.route, ru.route, ru.by, "vxrouter route deleted by a third party")
+		}
+	}
+
+	return nil
+}
+
+// auditGateway handles the removal of an address from a link, if it is the gateway of a host macvlan
+func auditGateway(policy AuditPolicy, ru rtUpdate) {
+	if ru.addr.IP.IsLinkLocalUnicast() || wasExpectedDel("gateway "+ru.addr.IP.String()) {
+		return
+	}
+	link, err := nlh.LinkByIndex(ru.linkIndex)
+	if err != nil || !isHostMacvlan(link) {
+		// not ours, or the interface was torn down and its addresses went with it
+		return
+	}
+	log := log.WithField("Func", "auditGateway()").
+		WithField("gateway", ru.addr.String()).
+		WithField("link", link.Attrs().Name).
+		WithField("by", sender(ru.by))
+
+	msg := "vxrouter gateway removed by a third party"
+	switch policy {
+	case AuditLog:
+		log.Warn(msg)
+	case AuditAlert:
+		log.WithField("alert", true).Error(msg)
+	case AuditRepair:
+		log.Warn(msg + ", repairing")
+		if readOnly {
+			log.Warn("read only, not repairing gateway")
+			return
+		}
+		mvl, err := macvlan.FromLinkIndex(ru.linkIndex)
+		if err == nil {
+			err = mvl.AddAddress(ru.addr)
+		}
+		if err != nil && err != syscall.EEXIST {
+			log.WithError(err).Error("failed to repair gateway")
+		}
+	}
+}
+
+func auditAnomaly(policy AuditPolicy, ours, theirs netlink.Route, by uint32, msg string) {
+	log := log.WithField("Func", "auditAnomaly()").
+		WithField("dst", ours.Dst.String()).
+		WithField("link_index", ours.LinkIndex).
+		WithField("protocol", theirs.Protocol).
+		WithField("by", sender(by))
+
+	switch policy {
+	case AuditLog:
+		log.Warn(msg)
+	case AuditAlert:
+		log.WithField("alert", true).Error(msg)
+	case AuditRepair:
+		log.Warn(msg + ", repairing")
+		r := &netlink.Route{
+			LinkIndex: ours.LinkIndex,
+			Dst:       ours.Dst,
+			Protocol:  routeProto,
+			Priority:  ours.Priority,
+		}
+		if err := nlh.RouteReplace(r); err != nil {
+			log.WithError(err).Error("failed to repair route")
+		}
+	}
+}
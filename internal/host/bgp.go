@@ -0,0 +1,91 @@
+package host
+
+import (
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter"
+)
+
+// bgpProto tags the routes installed from the prefixes other hosts advertise over bgp evpn
+var bgpProto = vxrouter.GetEnvIntWithDefault(vxrouter.EnvPrefix+"BGP_PROTO", "", vxrouter.DefaultBGPProto)
+
+// LinkVTEP returns the vxlan id and local tunnel endpoint of the datapath the link dev is on,
+// eg. the host macvlan the route to a container goes through
+func LinkVTEP(dev string) (int, net.IP, error) {
+	link, err := nlh.LinkByName(dev)
+	if err != nil {
+		return 0, nil, err
+	}
+	dp, err := datapathFromLinkIndex(link.Attrs().ParentIndex)
+	if err != nil {
+		return 0, nil, err
+	}
+	return dp.VTEP()
+}
+
+// SetImportedRoutes installs a route to each prefix through the tunnel endpoint of the host
+// advertising it, and removes those installed before for prefixes no longer advertised.
+// Routes to prefixes already routed otherwise, eg. to a local container, are not installed.
+func SetImportedRoutes(routes map[string]net.IP) error {
+	log := log.WithField("Func", "SetImportedRoutes()")
+	log.WithField("routes", len(routes)).Debug()
+
+	have, err := nlh.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Protocol: bgpProto}, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return err
+	}
+	add, del := diffImported(have, routes)
+	for i := range del {
+		r := del[i]
+		if err = nlh.RouteDel(&r); err != nil && err != syscall.ESRCH {
+			log.WithField("dst", r.Dst).WithError(err).Error("failed to delete withdrawn route")
+		}
+	}
+	for _, r := range add {
+		err = nlh.RouteAdd(r)
+		if err == syscall.EEXIST {
+			log.WithField("dst", r.Dst).Debug("prefix is already routed")
+			continue
+		}
+		if err != nil {
+			log.WithField("dst", r.Dst).WithField("gw", r.Gw).WithError(err).Error("failed to add advertised route")
+		}
+	}
+	return nil
+}
+
+// diffImported returns the routes to add for the advertised prefixes, and those installed to delete,
+// the routes to prefixes not advertised anymore or advertised through another tunnel endpoint
+func diffImported(have []netlink.Route, routes map[string]net.IP) ([]*netlink.Route, []netlink.Route) {
+	add := []*netlink.Route{}
+	del := []netlink.Route{}
+	kept := make(map[string]bool)
+	for _, r := range have {
+		if r.Dst == nil {
+			continue
+		}
+		k := r.Dst.String()
+		if gw, ok := routes[k]; ok && gw.Equal(r.Gw) && !kept[k] {
+			kept[k] = true
+			continue
+		}
+		del = append(del, r)
+	}
+	for k, gw := range routes {
+		_, dst, err := net.ParseCIDR(k)
+		if err != nil || kept[dst.String()] {
+			continue
+		}
+		// the route can not go through a tunnel endpoint of the other family
+		if gw == nil || (dst.IP.To4() == nil) != (gw.To4() == nil) {
+			continue
+		}
+		add = append(add, &netlink.Route{Dst: dst, Gw: gw, Protocol: bgpProto})
+	}
+	return add, del
+}
@@ -0,0 +1,52 @@
+package host
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestDiffImported(t *testing.T) {
+	route := func(dst, gw string) netlink.Route {
+		_, n, _ := net.ParseCIDR(dst) // nolint: errcheck
+		return netlink.Route{Dst: n, Gw: net.ParseIP(gw), Protocol: bgpProto}
+	}
+	have := []netlink.Route{
+		route("10.1.0.5/32", "10.0.0.2"),
+		route("10.1.0.6/32", "10.0.0.2"),
+		route("10.1.0.7/32", "10.0.0.2"),
+	}
+	routes := map[string]net.IP{
+		"10.1.0.5/32":   net.ParseIP("10.0.0.2"),
+		"10.1.0.6/32":   net.ParseIP("10.0.0.3"),
+		"10.1.0.8/32":   net.ParseIP("10.0.0.3"),
+		"fd00:1::8/128": net.ParseIP("10.0.0.3"),
+		"fd00:1::9/128": net.ParseIP("fd00::3"),
+	}
+	add, del := diffImported(have, routes)
+
+	dels := map[string]bool{}
+	for _, r := range del {
+		dels[r.Dst.String()] = true
+	}
+	if len(del) != 2 || !dels["10.1.0.6/32"] || !dels["10.1.0.7/32"] {
+		t.Errorf("deleting %v, want the moved and the withdrawn prefix", del)
+	}
+	adds := map[string]string{}
+	for _, r := range add {
+		adds[r.Dst.String()] = r.Gw.String()
+		if r.Protocol != bgpProto {
+			t.Errorf("adding %v with protocol %v", r.Dst, r.Protocol)
+		}
+	}
+	want := map[string]string{"10.1.0.6/32": "10.0.0.3", "10.1.0.8/32": "10.0.0.3", "fd00:1::9/128": "fd00::3"}
+	if len(adds) != len(want) {
+		t.Errorf("adding %v, want %v", adds, want)
+	}
+	for k, gw := range want {
+		if adds[k] != gw {
+			t.Errorf("adding %v via %v, want %v", k, adds[k], gw)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package host
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+const (
+	icmpEchoRequest   = 8
+	icmpEchoReply     = 0
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+
+	// canaryInterval is how often the echo request is resent while waiting for a reply
+	canaryInterval = 200 * time.Millisecond
+)
+
+// Canary checks that an endpoint with address ip reaches gw over the vxlan, before the
+// address is handed to a container. A temporary macvlan with ip is added to a throwaway
+// network namespace, and gw is pinged from it until it answers or the canary timeout expires.
+func (hi *Interface) Canary(ip *net.IPNet, gw net.IP) error {
+	hi.log.WithField("Func", "Canary()").WithField("ip", ip.String()).WithField("gw", gw.String()).Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := "cnry_" + hex.EncodeToString(suffix)
+	mvl, err := hi.dp.CreateMacvlan(name)
+	if err != nil {
+		return fmt.Errorf("failed to create canary interface: %v", err)
+	}
+
+	ns, err := newNs()
+	if err != nil {
+		mvl.Delete() // nolint: errcheck
+		return fmt.Errorf("failed to create canary namespace: %v", err)
+	}
+	// the kernel deletes the macvlan with the namespace once it is no longer referenced
+	defer ns.Close() // nolint: errcheck
+
+	link, err := gwns.Handle().LinkByName(name)
+	if err == nil {
+		err = gwns.Handle().LinkSetNsFd(link, int(ns))
+	}
+	if err != nil {
+		mvl.Delete() // nolint: errcheck
+		return fmt.Errorf("failed to move canary interface: %v", err)
+	}
+
+	h, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		return err
+	}
+	defer h.Delete()
+	link, err = h.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	defer h.LinkDel(link) // nolint: errcheck
+	err = h.AddrAdd(link, &netlink.Addr{IPNet: ip, Flags: syscall.IFA_F_NODAD})
+	if err != nil {
+		return fmt.Errorf("failed to add canary address: %v", err)
+	}
+	err = h.LinkSetUp(link)
+	if err != nil {
+		return err
+	}
+
+	fd, err := socketAt(ns, gw)
+	if err != nil {
+		return fmt.Errorf("failed to open canary socket: %v", err)
+	}
+	defer syscall.Close(fd) // nolint: errcheck
+
+	return ping(fd, gw, canaryTimeout)
+}
+
+// newNs creates an anonymous network namespace, leaving the calling thread where it was
+func newNs() (netns.NsHandle, error) {
+	ns := netns.None()
+	err := gwns.DoIn(netns.None(), func() (err error) {
+		ns, err = netns.New()
+		return err
+	})
+	return ns, err
+}
+
+// socketAt opens a raw icmp socket for the family of dst in the namespace ns.
+// A socket stays in the namespace it was opened in.
+func socketAt(ns netns.NsHandle, dst net.IP) (int, error) {
+	fd := -1
+	err := gwns.DoIn(ns, func() (err error) {
+		if dst.To4() != nil {
+			fd, err = syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_ICMP)
+		} else {
+			fd, err = syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, syscall.IPPROTO_ICMPV6)
+		}
+		return err
+	})
+	return fd, err
+}
+
+// ping sends icmp echo requests to dst on the raw socket fd until one is answered or timeout expires
+func ping(fd int, dst net.IP, timeout time.Duration) error {
+	id := uint16(os.Getpid())
+	seq := make([]byte, 2)
+	if _, err := rand.Read(seq); err != nil {
+		return err
+	}
+
+	var sa syscall.Sockaddr
+	req := make([]byte, 8)
+	binary.BigEndian.PutUint16(req[4:6], id)
+	copy(req[6:8], seq)
+	if ip4 := dst.To4(); ip4 != nil {
+		s := &syscall.SockaddrInet4{}
+		copy(s.Addr[:], ip4)
+		sa = s
+		req[0] = icmpEchoRequest
+		binary.BigEndian.PutUint16(req[2:4], icmpChecksum(req))
+	} else {
+		s := &syscall.SockaddrInet6{}
+		copy(s.Addr[:], dst.To16())
+		sa = s
+		// the kernel fills in the checksum of icmpv6 raw sockets
+		req[0] = icmpv6EchoRequest
+	}
+
+	tv := syscall.NsecToTimeval(probeReadTO.Nanoseconds())
+	err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	stop := time.Now().Add(timeout)
+	var sent time.Time
+	for time.Now().Before(stop) {
+		if time.Since(sent) >= canaryInterval {
+			err = syscall.Sendto(fd, req, 0, sa)
+			if err != nil && err != syscall.EHOSTUNREACH {
+				return err
+			}
+			sent = time.Now()
+		}
+		n, from, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if isEchoReply(buf[:n], from, dst, req) {
+			return nil
+		}
+	}
+	return fmt.Errorf("no echo reply from %v after %v", dst, timeout)
+}
+
+// isEchoReply reports whether b, received from from, answers the echo request req to dst.
+// ipv4 raw sockets receive the ip header, ipv6 ones do not.
+func isEchoReply(b []byte, from syscall.Sockaddr, dst net.IP, req []byte) bool {
+	switch f := from.(type) {
+	case *syscall.SockaddrInet4:
+		if !net.IP(f.Addr[:]).Equal(dst) || len(b) < 20 {
+			return false
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < ihl+8 || b[ihl] != icmpEchoReply {
+			return false
+		}
+		b = b[ihl:]
+	case *syscall.SockaddrInet6:
+		if !net.IP(f.Addr[:]).Equal(dst) || len(b) < 8 || b[0] != icmpv6EchoReply {
+			return false
+		}
+	default:
+		return false
+	}
+	return binary.BigEndian.Uint16(b[4:6]) == binary.BigEndian.Uint16(req[4:6]) &&
+		binary.BigEndian.Uint16(b[6:8]) == binary.BigEndian.Uint16(req[6:8])
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
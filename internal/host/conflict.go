@@ -0,0 +1,110 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ConflictKind is how an address being selected was found in use by another node
+type ConflictKind string
+
+// Conflict kinds
+const (
+	// ConflictRouted is a requested address already routed by another host
+	ConflictRouted ConflictKind = "routed by another host"
+	// ConflictLocked is an address locked by another host with the coordinator
+	ConflictLocked ConflictKind = "locked by another host"
+	// ConflictProbed is an address answering duplicate address detection
+	ConflictProbed ConflictKind = "answering on the segment"
+	// ConflictRace is an address another host installed a route to at the same time
+	ConflictRace ConflictKind = "routed by another host at the same time"
+)
+
+// Conflict is an address found in use by another node while selecting it
+type Conflict struct {
+	IP        net.IP
+	Requested bool
+	Kind      ConflictKind
+}
+
+// ConflictAction is what is done about a conflict
+type ConflictAction int
+
+// Conflict actions
+const (
+	// ConflictFail fails the allocation
+	ConflictFail ConflictAction = iota
+	// ConflictRetry tries another address, or waits for a requested address to be released
+	ConflictRetry
+	// ConflictEvict takes the address over from the node holding it, which is taken to be stale.
+	// Locks can not be taken over, an eviction of one fails the allocation.
+	ConflictEvict
+)
+
+// ConflictPolicy decides what is done about conflicts
+type ConflictPolicy interface {
+	Resolve(c *Conflict) ConflictAction
+}
+
+// RetryConflicts is the default policy. Selected addresses in conflict are replaced by
+// others, a requested address is waited for while it is routed and fails otherwise.
+type RetryConflicts struct{}
+
+// Resolve implements ConflictPolicy
+func (RetryConflicts) Resolve(c *Conflict) ConflictAction {
+	if !c.Requested || c.Kind == ConflictRouted {
+		return ConflictRetry
+	}
+	return ConflictFail
+}
+
+// FailConflicts fails the allocation on any conflict
+type FailConflicts struct{}
+
+// Resolve implements ConflictPolicy
+func (FailConflicts) Resolve(c *Conflict) ConflictAction {
+	return ConflictFail
+}
+
+// EvictConflicts takes requested addresses over from the nodes holding them, eg. stale peers
+// whose routes were not withdrawn. Selected addresses in conflict are replaced by others.
+type EvictConflicts struct{}
+
+// Resolve implements ConflictPolicy
+func (EvictConflicts) Resolve(c *Conflict) ConflictAction {
+	if !c.Requested {
+		return ConflictRetry
+	}
+	return ConflictEvict
+}
+
+// ConflictPolicyFor returns the conflict policy name, retry, fail or evict
+func ConflictPolicyFor(name string) (ConflictPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "retry":
+		return RetryConflicts{}, nil
+	case "fail":
+		return FailConflicts{}, nil
+	case "evict":
+		return EvictConflicts{}, nil
+	}
+	return nil, fmt.Errorf("unknown conflict policy %q, must be retry, fail or evict", name)
+}
+
+// resolveConflict returns what is done about the conflict of ip, calling Evicted before it is taken over
+func resolveConflict(opts *SelectOpts, ip net.IP, requested bool, kind ConflictKind) ConflictAction {
+	p := opts.Conflicts
+	if p == nil {
+		p = RetryConflicts{}
+	}
+	c := &Conflict{IP: ip, Requested: requested, Kind: kind}
+	a := p.Resolve(c)
+	if a == ConflictEvict && kind == ConflictLocked {
+		a = ConflictFail
+	}
+	if a == ConflictEvict && opts.Evicted != nil {
+		opts.Evicted(c)
+	}
+	return a
+}
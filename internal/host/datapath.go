@@ -0,0 +1,184 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+	"github.com/TrilliumIT/vxrouter/internal/macvlan"
+	"github.com/TrilliumIT/vxrouter/internal/ovs"
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+const datapathOpt = "datapath"
+
+// datapath is the layer 2 segment of a network on this host, tunneled to the other hosts, which
+// the host macvlan and the container macvlans are created on. It is a kernel vxlan, or an Open
+// vSwitch bridge with vxlan ports.
+type datapath interface {
+	Name() string
+	GetIndex() int
+	VTEP() (int, net.IP, error)
+	CreateMacvlan(name string) (*macvlan.Macvlan, error)
+	DeleteMacvlan(name string) error
+	GetSlaveDevices() ([]netlink.Link, error)
+	Delete() error
+}
+
+// ovsDatapath reports whether a network with opts is bridged by Open vSwitch
+func ovsDatapath(opts map[string]string) bool {
+	return strings.EqualFold(optOrEnv(opts, datapathOpt), "ovs")
+}
+
+// newDatapath creates the datapath of a network with opts, or gets it if it exists
+func newDatapath(name string, opts map[string]string) (datapath, error) {
+	if ovsDatapath(opts) {
+		if gwns.Enabled() {
+			return nil, fmt.Errorf("the ovs datapath can not be used with a gateway namespace")
+		}
+		br, err := ovs.New(name, opts)
+		if err != nil {
+			return nil, err
+		}
+		return br, nil
+	}
+	vxl, err := vxlan.New(name, opts)
+	if err == syscall.EOPNOTSUPP || err == syscall.EAFNOSUPPORT {
+		err = vxrerrors.KernelUnsupported(err, "failed to create vxlan %v", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vxl, nil
+}
+
+// datapathFromLinkIndex returns the datapath of the link with index li, a vxlan or the internal port of a bridge
+func datapathFromLinkIndex(li int) (datapath, error) {
+	vxl, err := vxlan.FromLinkIndex(li)
+	if err == nil {
+		return vxl, nil
+	}
+	br, berr := ovs.FromLinkIndex(li)
+	if berr != nil {
+		return nil, fmt.Errorf("link %v is neither a vxlan, %v, nor an ovs bridge, %v", li, err, berr)
+	}
+	return br, nil
+}
+
+// CheckDatapath checks that the datapath of a network with opts is available on this host
+func CheckDatapath(opts map[string]string) error {
+	if !ovsDatapath(opts) {
+		return nil
+	}
+	if gwns.Enabled() {
+		return fmt.Errorf("the ovs datapath can not be used with a gateway namespace")
+	}
+	if _, err := ovs.TunnelSpec(opts); err != nil {
+		return err
+	}
+	return ovs.CheckVersion()
+}
+
+// remoteVTEPs returns the tunnel endpoint each remote MAC of the datapath is behind
+func (hi *Interface) remoteVTEPs() (map[string]net.IP, error) {
+	if br, ok := hi.dp.(*ovs.Bridge); ok {
+		return br.Remotes()
+	}
+	fdb, err := nlh.NeighList(hi.dp.GetIndex(), syscall.AF_BRIDGE)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]net.IP)
+	for _, f := range fdb {
+		if f.HardwareAddr != nil && f.IP != nil && !f.IP.IsUnspecified() {
+			ret[f.HardwareAddr.String()] = f.IP
+		}
+	}
+	return ret, nil
+}
+
+// addRemote points mac at the tunnel endpoint vtep in the datapath, as if it had been learned
+func (hi *Interface) addRemote(mac net.HardwareAddr, vtep net.IP) error {
+	if br, ok := hi.dp.(*ovs.Bridge); ok {
+		return br.AddRemote(mac, vtep)
+	}
+	return nlh.NeighAppend(&netlink.Neigh{
+		LinkIndex:    hi.dp.GetIndex(),
+		Family:       syscall.AF_BRIDGE,
+		State:        netlink.NUD_REACHABLE,
+		Flags:        netlink.NTF_SELF,
+		IP:           vtep,
+		HardwareAddr: mac,
+	})
+}
+
+// delRemote removes the entries of mac from the datapath, only those to vtep unless it is nil
+func (hi *Interface) delRemote(mac net.HardwareAddr, vtep net.IP) error {
+	if br, ok := hi.dp.(*ovs.Bridge); ok {
+		return br.DelRemote(mac, vtep)
+	}
+	fdb, err := nlh.NeighList(hi.dp.GetIndex(), syscall.AF_BRIDGE)
+	if err != nil {
+		return err
+	}
+	for i := range fdb {
+		f := fdb[i]
+		if f.HardwareAddr.String() != mac.String() || (vtep != nil && !f.IP.Equal(vtep)) {
+			continue
+		}
+		if err = nlh.NeighDel(&f); err != nil && err != syscall.ENOENT {
+			return err
+		}
+	}
+	return nil
+}
+
+// bridgePeers returns the flood peers of a bridge with the local tunnel endpoint, without those
+// of this host, and only those of the family of the local endpoint if it is set
+func bridgePeers(peers []net.IP, local net.IP, self func(net.IP) bool) []net.IP {
+	ret := []net.IP{}
+	for _, p := range peers {
+		if local != nil && ((p.To4() == nil) != (local.To4() == nil) || p.Equal(local)) {
+			continue
+		}
+		if self(p) {
+			continue
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// localAddress reports whether ip is an address of this host
+func localAddress(ip net.IP) bool {
+	addrs, err := gwns.Root().AddrList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncBridgePeers updates the vxlan ports of a bridge to the flood peers of its vxlan id
+func syncBridgePeers(br *ovs.Bridge) error {
+	vni, local, err := br.VTEP()
+	if err != nil {
+		return err
+	}
+	peers, set := vniFloodPeers(vni)
+	if !set {
+		return nil
+	}
+	log.WithField("bridge", br.Name()).WithField("Func", "syncBridgePeers()").Debug()
+	return br.SetPeers(bridgePeers(peers, local, localAddress))
+}
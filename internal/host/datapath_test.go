@@ -0,0 +1,34 @@
+package host
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestBridgePeers(t *testing.T) {
+	v4a, v4b, v6 := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("fd00::1")
+	self := func(ip net.IP) bool { return ip.Equal(v4b) }
+	none := func(net.IP) bool { return false }
+	tests := []struct {
+		name  string
+		peers []net.IP
+		local net.IP
+		self  func(net.IP) bool
+		want  []net.IP
+	}{
+		{"no local endpoint", []net.IP{v4a, v6}, nil, none, []net.IP{v4a, v6}},
+		{"family of the local endpoint", []net.IP{v4a, v6}, net.ParseIP("10.0.0.9"), none, []net.IP{v4a}},
+		{"ipv6 local endpoint", []net.IP{v4a, v6}, net.ParseIP("fd00::9"), none, []net.IP{v6}},
+		{"local endpoint", []net.IP{v4a, v4b}, v4a, none, []net.IP{v4b}},
+		{"address of this host", []net.IP{v4a, v4b}, nil, self, []net.IP{v4a}},
+		{"none", nil, nil, none, []net.IP{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bridgePeers(tt.peers, tt.local, tt.self); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package host
+
+import (
+	"net"
+
+	"github.com/TrilliumIT/iputil"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+var delegateProto = vxrouter.GetEnvIntWithDefault(vxrouter.EnvPrefix+"DELEGATE_PROTO", "", vxrouter.DefaultDelegateProto)
+
+// DelegatePrefix selects a free prefix of length plen in sn, and routes it via the container
+// address via, for the container to route on to its own pods or VMs. A prefix is free if it
+// holds no route, or part of one, and neither via nor the gateway. If prev is in sn it is
+// tried first, eg. the prefix a restarting container was delegated before.
+// Like addresses, the prefix is checked again after the route propagation time, so another
+// host delegating it at the same time is noticed.
+func (hi *Interface) DelegatePrefix(sn *net.IPNet, plen int, via net.IP, prev *net.IPNet, opts *SelectOpts) (*net.IPNet, error) {
+	log := hi.log.WithField("Func", "DelegatePrefix()").WithField("via", via.String())
+	log.Debug()
+
+	ones, bits := sn.Mask.Size()
+	if plen <= ones || plen > bits {
+		return nil, vxrerrors.Exhausted("a /%v can not be delegated from %v", plen, sn)
+	}
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	for tries := 0; maxSelectTries <= 0 || tries < maxSelectTries; tries++ {
+		if opts.Context != nil && opts.Context.Err() != nil {
+			return nil, vxrerrors.Timeout("response deadline expired before delegating a prefix")
+		}
+
+		var pfx *net.IPNet
+		if tries == 0 && prev != nil && sn.Contains(prev.IP) {
+			pfx = prev
+		} else {
+			ip := iputil.RandAddrWithExclude(sn, 0, 0)
+			pfx = &net.IPNet{IP: ip.Mask(net.CIDRMask(plen, bits)), Mask: net.CIDRMask(plen, bits)}
+		}
+		if pfx.Contains(via) || (opts.Gateway != nil && pfx.Contains(opts.Gateway)) {
+			continue
+		}
+
+		free, err := prefixFree(pfx)
+		if err != nil {
+			return nil, err
+		}
+		if !free {
+			continue
+		}
+
+		r := &netlink.Route{
+			LinkIndex: hi.mvl.GetIndex(),
+			Dst:       pfx,
+			Gw:        via,
+			Protocol:  delegateProto,
+		}
+		err = nlh.RouteAdd(r)
+		if err != nil {
+			log.WithField("prefix", pfx.String()).WithError(err).Error("failed to add prefix route")
+			return nil, err
+		}
+		if !sleep(opts.Context, opts.PropTime) {
+			nlh.RouteDel(r) // nolint: errcheck
+			return nil, vxrerrors.Timeout("response deadline expired while delegating a prefix")
+		}
+		n, err := numRoutesTo(pfx)
+		if err != nil {
+			nlh.RouteDel(r) // nolint: errcheck
+			return nil, err
+		}
+		if n == 1 {
+			log.WithField("prefix", pfx.String()).Info("delegated prefix")
+			return pfx, nil
+		}
+		if n < 1 {
+			log.WithField("prefix", pfx.String()).Debug("prefix route doesn't exist after it was added")
+			continue
+		}
+		log.WithField("prefix", pfx.String()).Info("someone else delegated the prefix first")
+		if err = nlh.RouteDel(r); err != nil {
+			return nil, err
+		}
+	}
+	return nil, vxrerrors.Exhausted("no free /%v found in %v after %v tries", plen, sn, maxSelectTries)
+}
+
+// RestorePrefix re-adds the route of a prefix delegated to via, unless there is already a route to it.
+// Returns true if the route was added.
+func (hi *Interface) RestorePrefix(pfx *net.IPNet, via net.IP) (bool, error) {
+	hi.log.WithField("Func", "RestorePrefix()").WithField("prefix", pfx.String()).Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	n, err := numRoutesTo(pfx)
+	if err != nil || n > 0 {
+		return false, err
+	}
+	err = nlh.RouteAdd(&netlink.Route{
+		LinkIndex: hi.mvl.GetIndex(),
+		Dst:       pfx,
+		Gw:        via,
+		Protocol:  delegateProto,
+	})
+	return err == nil, err
+}
+
+// DelDelegatedPrefixes deletes the routes of the prefixes delegated to via
+func (hi *Interface) DelDelegatedPrefixes(via net.IP) error {
+	log := hi.log.WithField("Func", "DelDelegatedPrefixes()").WithField("via", via.String())
+	log.Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	routes, err := nlh.RouteListFiltered(family(via), &netlink.Route{Protocol: delegateProto}, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return err
+	}
+	for i := range routes {
+		if !routes[i].Gw.Equal(via) {
+			continue
+		}
+		err = nlh.RouteDel(&routes[i])
+		if err != nil {
+			log.WithField("prefix", routes[i].Dst.String()).WithError(err).Error("failed to delete prefix route")
+			return err
+		}
+	}
+	return nil
+}
+
+// Delegated reports whether ip is within a prefix delegated to a container, on this or another host,
+// that is within a route of sn more specific than sn but for less than a single address
+func Delegated(sn *net.IPNet, ip net.IP) (bool, error) {
+	ones, bits := sn.Mask.Size()
+	routes, err := nlh.RouteList(nil, family(ip))
+	if err != nil {
+		return false, err
+	}
+	for _, r := range routes {
+		if r.Dst == nil || r.Gw == nil || !r.Dst.Contains(ip) {
+			continue
+		}
+		if rones, _ := r.Dst.Mask.Size(); rones > ones && rones < bits {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// prefixFree reports whether no route is to pfx, within it, or to a part of it
+func prefixFree(pfx *net.IPNet) (bool, error) {
+	routes, err := nlh.RouteList(nil, family(pfx.IP))
+	if err != nil {
+		return false, err
+	}
+	pones, _ := pfx.Mask.Size()
+	for _, r := range routes {
+		if r.Dst == nil {
+			continue
+		}
+		rones, _ := r.Dst.Mask.Size()
+		// routes covering the whole prefix, eg. the subnet, do not use it
+		if rones < pones {
+			continue
+		}
+		if pfx.Contains(r.Dst.IP) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
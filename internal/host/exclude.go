@@ -0,0 +1,129 @@
+package host
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+)
+
+// IPRange is an inclusive range of addresses
+type IPRange struct {
+	First, Last net.IP
+}
+
+// ParseRanges parses a comma separated list of addresses, cidrs and first-last ranges,
+// eg. 10.1.2.0/28,10.1.2.200-10.1.2.220
+func ParseRanges(s string) ([]IPRange, error) {
+	ret := []IPRange{}
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		r, err := parseRange(f)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+func parseRange(s string) (IPRange, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return IPRange{}, err
+		}
+		size := SubnetSize(n)
+		last := intToIP(new(big.Int).Add(IPToInt(n.IP), size.Sub(size, big.NewInt(1))), len(n.IP))
+		return IPRange{n.IP, last}, nil
+	}
+
+	fl := strings.SplitN(s, "-", 2)
+	first := net.ParseIP(strings.TrimSpace(fl[0]))
+	last := first
+	if len(fl) == 2 {
+		last = net.ParseIP(strings.TrimSpace(fl[1]))
+	}
+	if first == nil || last == nil {
+		return IPRange{}, fmt.Errorf("invalid address range %q", s)
+	}
+	if (first.To4() == nil) != (last.To4() == nil) {
+		return IPRange{}, fmt.Errorf("address range %q mixes address families", s)
+	}
+	if IPToInt(first).Cmp(IPToInt(last)) > 0 {
+		return IPRange{}, fmt.Errorf("address range %q ends before it starts", s)
+	}
+	return IPRange{first, last}, nil
+}
+
+// Contains reports whether ip is within the range
+func (r IPRange) Contains(ip net.IP) bool {
+	if (ip.To4() == nil) != (r.First.To4() == nil) {
+		return false
+	}
+	i := IPToInt(ip)
+	return i.Cmp(IPToInt(r.First)) >= 0 && i.Cmp(IPToInt(r.Last)) <= 0
+}
+
+func inRanges(ip net.IP, ranges []IPRange) bool {
+	for _, r := range ranges {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExcludedCount returns the number of addresses of sn never selected: the first xf and last xl,
+// the gateway and the exclusion ranges, counting addresses excluded more than once only once
+func ExcludedCount(sn *net.IPNet, xf, xl int, gateway net.IP, ranges []IPRange) *big.Int {
+	start := IPToInt(sn.IP.Mask(sn.Mask))
+	size := SubnetSize(sn)
+	end := new(big.Int).Add(start, size)
+
+	// half open intervals of excluded addresses
+	type ival struct{ s, e *big.Int }
+	ivals := []ival{
+		{start, new(big.Int).Add(start, big.NewInt(int64(xf)))},
+		{new(big.Int).Sub(end, big.NewInt(int64(xl))), end},
+	}
+	if gateway != nil && sn.Contains(gateway) {
+		g := IPToInt(gateway)
+		ivals = append(ivals, ival{g, new(big.Int).Add(g, big.NewInt(1))})
+	}
+	for _, r := range ranges {
+		if (r.First.To4() == nil) != (sn.IP.To4() == nil) {
+			continue
+		}
+		ivals = append(ivals, ival{IPToInt(r.First), new(big.Int).Add(IPToInt(r.Last), big.NewInt(1))})
+	}
+
+	// clip to the subnet, then merge
+	for i := range ivals {
+		if ivals[i].s.Cmp(start) < 0 {
+			ivals[i].s = start
+		}
+		if ivals[i].e.Cmp(end) > 0 {
+			ivals[i].e = end
+		}
+	}
+	sort.Slice(ivals, func(i, j int) bool { return ivals[i].s.Cmp(ivals[j].s) < 0 })
+
+	n := new(big.Int)
+	cur := new(big.Int).Set(start)
+	for _, iv := range ivals {
+		s := iv.s
+		if s.Cmp(cur) < 0 {
+			s = cur
+		}
+		if iv.e.Cmp(s) > 0 {
+			n.Add(n, new(big.Int).Sub(iv.e, s))
+			cur = iv.e
+		}
+	}
+	return n
+}
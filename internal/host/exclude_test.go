@@ -0,0 +1,108 @@
+package host
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"10.1.2.5", []string{"10.1.2.5-10.1.2.5"}, false},
+		{"10.1.2.0/28", []string{"10.1.2.0-10.1.2.15"}, false},
+		{"10.1.2.7/28", []string{"10.1.2.0-10.1.2.15"}, false},
+		{" 10.1.2.200 - 10.1.2.220 ,", []string{"10.1.2.200-10.1.2.220"}, false},
+		{"10.1.2.0/30,10.1.2.9-10.1.2.10", []string{"10.1.2.0-10.1.2.3", "10.1.2.9-10.1.2.10"}, false},
+		{"fd00::/126", []string{"fd00::-fd00::3"}, false},
+		{"fd00::a-fd00::f", []string{"fd00::a-fd00::f"}, false},
+		{"10.1.2.300", nil, true},
+		{"10.1.2.0/33", nil, true},
+		{"10.1.2.9-", nil, true},
+		{"10.1.2.9-10.1.2.1", nil, true},
+		{"10.1.2.1-fd00::1", nil, true},
+	}
+	for _, tt := range tests {
+		rs, err := ParseRanges(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRanges(%q) error is %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if len(rs) != len(tt.want) {
+			t.Errorf("ParseRanges(%q) = %v, want %v", tt.s, rs, tt.want)
+			continue
+		}
+		for i, r := range rs {
+			if got := r.First.String() + "-" + r.Last.String(); got != tt.want[i] {
+				t.Errorf("ParseRanges(%q)[%v] = %v, want %v", tt.s, i, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestIPRangeContains(t *testing.T) {
+	r := IPRange{net.ParseIP("10.1.2.10"), net.ParseIP("10.1.2.20")}
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.9", false},
+		{"10.1.2.10", true},
+		{"10.1.2.15", true},
+		{"10.1.2.20", true},
+		{"10.1.2.21", false},
+		// the same low bits in the other family
+		{"::a01:20f", false},
+	}
+	for _, tt := range tests {
+		if got := r.Contains(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%v contains %v is %v, want %v", r, tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestExcludedCount(t *testing.T) {
+	ranges := func(s string) []IPRange {
+		rs, err := ParseRanges(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	tests := []struct {
+		name    string
+		sn      string
+		xf, xl  int
+		gateway string
+		ranges  string
+		want    int64
+	}{
+		{"nothing", "10.1.2.0/24", 0, 0, "", "", 0},
+		{"first and last", "10.1.2.0/24", 1, 1, "", "", 2},
+		{"gateway", "10.1.2.0/24", 1, 1, "10.1.2.1", "", 3},
+		{"gateway excluded first", "10.1.2.0/24", 2, 1, "10.1.2.1", "", 3},
+		{"gateway outside", "10.1.2.0/24", 1, 1, "10.1.3.1", "", 2},
+		{"range", "10.1.2.0/24", 1, 1, "10.1.2.1", "10.1.2.200-10.1.2.220", 24},
+		{"overlapping ranges", "10.1.2.0/24", 0, 0, "", "10.1.2.10-10.1.2.20,10.1.2.15-10.1.2.30,10.1.2.12", 21},
+		{"range over the first", "10.1.2.0/24", 4, 0, "", "10.1.2.0/29", 8},
+		{"range clipped", "10.1.2.0/24", 0, 0, "", "10.1.1.250-10.1.2.4,10.1.2.250-10.1.3.10", 11},
+		{"range outside", "10.1.2.0/24", 0, 0, "", "10.1.3.0/24", 0},
+		{"other family", "10.1.2.0/24", 0, 0, "", "::/0", 0},
+		{"whole subnet", "10.1.2.0/24", 1, 1, "10.1.2.1", "10.1.2.0/24", 256},
+		{"first and last overlap", "10.1.2.0/30", 3, 3, "", "", 4},
+		{"ipv6", "fd00::/64", 1, 1, "fd00::1", "fd00::100-fd00::1ff", 259},
+	}
+	for _, tt := range tests {
+		var gw net.IP
+		if tt.gateway != "" {
+			gw = net.ParseIP(tt.gateway)
+		}
+		got := ExcludedCount(cidr(tt.sn), tt.xf, tt.xl, gw, ranges(tt.ranges))
+		if !got.IsInt64() || got.Int64() != tt.want {
+			t.Errorf("%v: excluded %v of %v, want %v", tt.name, got, tt.sn, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package host
+
+import (
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// RouteExporter advertises the routes to the containers of this host outside of the kernel,
+// eg. to a routing daemon. Calls must not block, they are made from the route watch.
+type RouteExporter interface {
+	// Export advertises the route to dst, through the link named dev
+	Export(dst *net.IPNet, dev string)
+	// Withdraw withdraws the route to dst
+	Withdraw(dst *net.IPNet)
+}
+
+// exportedProto reports whether routes of protocol p are exported: container host
+// routes, host block summary routes and prefixes delegated to containers
+func exportedProto(p int) bool {
+	return p == routeProto || p == summaryProto || p == delegateProto
+}
+
+// ExportRoutes passes the container routes of this host to e, those present when it starts
+// and those added or deleted since, until done is closed. Quarantine blackholes are not exported.
+func ExportRoutes(e RouteExporter, done <-chan struct{}) error {
+	log := log.WithField("Func", "ExportRoutes()")
+	log.Debug()
+
+	ruc := make(chan netlink.RouteUpdate)
+	err := gwns.RouteSubscribe(ruc, done)
+	if err != nil {
+		log.WithError(err).Error("failed to subscribe to route updates")
+		return err
+	}
+
+	// the links with a route to each destination, it is withdrawn with the last one
+	known := make(map[string]map[int]bool)
+	add := func(r netlink.Route) {
+		k := r.Dst.String()
+		if known[k] == nil {
+			known[k] = make(map[int]bool)
+			var dev string
+			if link, err := nlh.LinkByIndex(r.LinkIndex); err == nil {
+				dev = link.Attrs().Name
+			}
+			e.Export(r.Dst, dev)
+		}
+		known[k][r.LinkIndex] = true
+	}
+
+	routes, err := ownRoutes()
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return err
+	}
+	for _, r := range routes {
+		add(r)
+	}
+
+	for ru := range ruc {
+		if ru.Dst == nil || ru.Route.Type == syscall.RTN_BLACKHOLE || !exportedProto(ru.Protocol) {
+			continue
+		}
+		switch ru.Type {
+		case syscall.RTM_NEWROUTE:
+			add(ru.Route)
+		case syscall.RTM_DELROUTE:
+			k := ru.Dst.String()
+			if known[k] == nil {
+				continue
+			}
+			delete(known[k], ru.LinkIndex)
+			if len(known[k]) == 0 {
+				delete(known, k)
+				e.Withdraw(ru.Dst)
+			}
+		}
+	}
+
+	return nil
+}
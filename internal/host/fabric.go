@@ -0,0 +1,145 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// fabricOpt is the network option pinning a network to one or more fabrics
+const fabricOpt = "fabric"
+
+// Fabric is an underlay network, reached through a vtep device and source address
+type Fabric struct {
+	Name    string
+	VtepDev string
+	SrcAddr net.IP
+}
+
+var (
+	fabrics  = make(map[string]*Fabric)
+	fabricsL sync.RWMutex
+)
+
+// ParseFabric parses a fabric from name:vtepdev[:srcaddr]
+func ParseFabric(s string) (*Fabric, error) {
+	p := strings.SplitN(s, ":", 3)
+	if len(p) < 2 || p[0] == "" || p[1] == "" {
+		return nil, fmt.Errorf("invalid fabric %q, must be name:vtepdev[:srcaddr]", s)
+	}
+	f := &Fabric{Name: p[0], VtepDev: p[1]}
+	if len(p) == 3 {
+		f.SrcAddr = net.ParseIP(p[2])
+		if f.SrcAddr == nil {
+			return nil, fmt.Errorf("invalid fabric %v source address %q", f.Name, p[2])
+		}
+	}
+	return f, nil
+}
+
+// AddFabric makes a fabric available to networks with the fabric option
+func AddFabric(f *Fabric) {
+	fabricsL.Lock()
+	defer fabricsL.Unlock()
+	fabrics[f.Name] = f
+}
+
+func getFabric(name string) (*Fabric, error) {
+	fabricsL.RLock()
+	defer fabricsL.RUnlock()
+	f, ok := fabrics[name]
+	if !ok {
+		return nil, vxrerrors.NotFound("unknown fabric %v", name)
+	}
+	return f, nil
+}
+
+// CheckFabricOption checks that every fabric in a fabric network option exists
+func CheckFabricOption(spec string) error {
+	for _, n := range strings.Split(spec, ",") {
+		if _, err := getFabric(strings.TrimSpace(n)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *Fabric) up() bool {
+	link, err := nlh.LinkByName(f.VtepDev)
+	return err == nil && link.Attrs().OperState != netlink.OperDown && link.Attrs().Flags&net.FlagUp != 0
+}
+
+// fabricOptions resolves the fabric option of the vxlan name into vtepdev and
+// srcaddr options. The fabric option is a comma separated list in order of
+// preference. A vxlan which already exists stays on its fabric, otherwise the
+// first fabric with its vtep device up is used, or with the balance fabric policy
+// the one with the least utilized vtep device.
+func fabricOptions(name string, opts map[string]string) (map[string]string, error) {
+	spec := strings.TrimSpace(opts[fabricOpt])
+	if spec == "" {
+		return opts, nil
+	}
+	log := log.WithField("Interface", name).WithField("Func", "fabricOptions()")
+	log.Debug()
+
+	fs := []*Fabric{}
+	for _, n := range strings.Split(spec, ",") {
+		f, err := getFabric(strings.TrimSpace(n))
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+
+	var sel *Fabric
+	if link, err := nlh.LinkByName(name); err == nil {
+		if vxl, ok := link.(*netlink.Vxlan); ok {
+			for _, f := range fs {
+				if dev, err := nlh.LinkByName(f.VtepDev); err == nil && dev.Attrs().Index == vxl.VtepDevIndex {
+					sel = f
+					break
+				}
+			}
+		}
+	}
+	if sel != nil && !sel.up() {
+		for _, f := range fs {
+			if f != sel && f.up() {
+				log.WithField("fabric", sel.Name).WithField("standby", f.Name).Warn("active fabric is down, standby will be used once the interface is recreated")
+				break
+			}
+		}
+	}
+	if sel == nil && strings.EqualFold(optOrEnv(opts, fabricPolicyOpt), "balance") {
+		sel = leastUtilized(fs)
+	}
+	if sel == nil {
+		for _, f := range fs {
+			if f.up() {
+				sel = f
+				break
+			}
+		}
+	}
+	if sel == nil {
+		sel = fs[0]
+		log.WithField("fabric", sel.Name).Warn("no fabric is up, using the first")
+	}
+
+	ret := make(map[string]string, len(opts)+2)
+	for k, v := range opts {
+		ret[k] = v
+	}
+	ret["vtepdev"] = sel.VtepDev
+	if sel.SrcAddr != nil {
+		ret["srcaddr"] = sel.SrcAddr.String()
+	}
+	log.WithField("fabric", sel.Name).Debug("selected fabric")
+	return ret, nil
+}
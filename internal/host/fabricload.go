@@ -0,0 +1,157 @@
+package host
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter"
+)
+
+// fabricPolicyOpt is the network option selecting how a fabric is picked among those up,
+// order for the first in the fabric option, balance for the least utilized
+const fabricPolicyOpt = "fabricpolicy"
+
+const (
+	// fabricLoadWeight is the weight of the latest sample in the smoothed rate of a fabric
+	fabricLoadWeight = 0.5
+	// defaultLinkSpeed is the speed assumed for vtep devices which do not report one, in Mbit/s
+	defaultLinkSpeed = 1000
+)
+
+// fabricSampleInterval is how often the traffic of the vtep devices of fabrics is sampled
+var fabricSampleInterval = vxrouter.GetEnvDurWithDefault(vxrouter.EnvPrefix+"FABRIC_SAMPLE", "", vxrouter.DefaultFabricSample)
+
+// fabricLoad is the traffic of a vtep device, as its counter when last sampled and smoothed rate
+type fabricLoad struct {
+	bytes uint64
+	at    time.Time
+	rate  float64
+}
+
+var (
+	fabricLoads   = make(map[string]*fabricLoad)
+	fabricLoadsL  sync.Mutex
+	fabricSampler sync.Once
+)
+
+// startFabricSampler samples the fabrics now and then periodically, from the first balanced selection on
+func startFabricSampler() {
+	fabricSampler.Do(func() {
+		sampleFabrics()
+		go func() {
+			t := time.NewTicker(fabricSampleInterval)
+			defer t.Stop()
+			for range t.C {
+				sampleFabrics()
+			}
+		}()
+	})
+}
+
+// sampleFabrics updates the smoothed rate of the vtep device of each fabric
+func sampleFabrics() {
+	fabricsL.RLock()
+	devs := make(map[string]bool, len(fabrics))
+	for _, f := range fabrics {
+		devs[f.VtepDev] = true
+	}
+	fabricsL.RUnlock()
+
+	now := time.Now()
+	fabricLoadsL.Lock()
+	defer fabricLoadsL.Unlock()
+	for dev := range devs {
+		link, err := nlh.LinkByName(dev)
+		if err != nil || link.Attrs().Statistics == nil {
+			continue
+		}
+		st := link.Attrs().Statistics
+		b := st.RxBytes + st.TxBytes
+		l, ok := fabricLoads[dev]
+		if !ok || b < l.bytes {
+			fabricLoads[dev] = &fabricLoad{bytes: b, at: now}
+			continue
+		}
+		if d := now.Sub(l.at).Seconds(); d > 0 {
+			r := float64(b-l.bytes) / d
+			l.rate = fabricLoadWeight*r + (1-fabricLoadWeight)*l.rate
+		}
+		l.bytes, l.at = b, now
+	}
+}
+
+// utilization returns the smoothed traffic of the vtep device of the fabric, as a ratio of its link speed
+func (f *Fabric) utilization() float64 {
+	fabricLoadsL.Lock()
+	l, ok := fabricLoads[f.VtepDev]
+	var rate float64
+	if ok {
+		rate = l.rate
+	}
+	fabricLoadsL.Unlock()
+	return rate * 8 / (float64(linkSpeed(f.VtepDev)) * 1e6)
+}
+
+// linkSpeed returns the speed of a device in Mbit/s, as reported by its driver
+func linkSpeed(dev string) int {
+	b, err := ioutil.ReadFile(filepath.Join("/sys/class/net", dev, "speed"))
+	if err != nil {
+		return defaultLinkSpeed
+	}
+	s, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || s <= 0 {
+		return defaultLinkSpeed
+	}
+	return s
+}
+
+// vxlansOn returns the number of vxlan interfaces on each vtep device index
+func vxlansOn() map[int]int {
+	ret := make(map[int]int)
+	links, err := nlh.LinkList()
+	if err != nil {
+		return ret
+	}
+	for _, link := range links {
+		if vxl, ok := link.(*netlink.Vxlan); ok {
+			ret[vxl.VtepDevIndex]++
+		}
+	}
+	return ret
+}
+
+// leastUtilized returns the fabric which is up with the least utilized vtep device, those
+// with fewer vxlans first when equally utilized, eg. before traffic has been sampled.
+// It returns nil if no fabric is up.
+func leastUtilized(fs []*Fabric) *Fabric {
+	startFabricSampler()
+	counts := vxlansOn()
+
+	var sel *Fabric
+	var selU float64
+	var selN int
+	for _, f := range fs {
+		if !f.up() {
+			continue
+		}
+		u := f.utilization()
+		n := 0
+		if dev, err := nlh.LinkByName(f.VtepDev); err == nil {
+			n = counts[dev.Attrs().Index]
+		}
+		if sel == nil || u < selU || (u == selU && n < selN) {
+			sel, selU, selN = f, u, n
+		}
+	}
+	if sel != nil {
+		log.WithField("fabric", sel.Name).WithField("utilization", selU).WithField("vxlans", selN).Debug("least utilized fabric")
+	}
+	return sel
+}
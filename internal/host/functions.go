@@ -0,0 +1,119 @@
+package host
+
+import (
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+func getIPNets(address net.IP, subnet *net.IPNet) (*net.IPNet, *net.IPNet) {
+	sna := &net.IPNet{
+		IP:   address,
+		Mask: address.DefaultMask(),
+	}
+
+	//address in big subnet
+	if subnet != nil {
+		sna.Mask = subnet.Mask
+	}
+
+	if sna.Mask == nil {
+		sna.Mask = net.CIDRMask(128, 128)
+	}
+
+	_, ml := sna.Mask.Size()
+	a := &net.IPNet{
+		IP:   address,
+		Mask: net.CIDRMask(ml, ml),
+	}
+
+	return sna, a
+}
+
+// family returns the netlink address family of ip. Route and neighbor
+// queries for an address are made against the table of its own family.
+func family(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+func numRoutesTo(ipnet *net.IPNet) (int, error) {
+	routes, err := nlh.RouteListFiltered(family(ipnet.IP), &netlink.Route{Dst: ipnet}, netlink.RT_FILTER_DST)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return -1, err
+	}
+	return len(routes), nil
+}
+
+// VxroutesTo return sthe number of vxrouter routes to a specific IP
+func VxroutesTo(ip net.IP) (int, error) {
+	_, a := getIPNets(ip, nil)
+	routes, err := nlh.RouteListFiltered(family(ip), &netlink.Route{Dst: a, Protocol: routeProto}, netlink.RT_FILTER_DST|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return -1, err
+	}
+	return countRoutes(routes), nil
+}
+
+// countRoutes returns the number of routes which are not blackholes, eg. of quarantined addresses
+func countRoutes(routes []netlink.Route) int {
+	n := 0
+	for _, r := range routes {
+		if r.Type != syscall.RTN_BLACKHOLE {
+			n++
+		}
+	}
+	return n
+}
+
+// AllVxRoutes returns a list of IPNets which there are vxrouer routes to
+func AllVxRoutes() ([]*net.IPNet, error) {
+	ret := []*net.IPNet{}
+	routes, err := nlh.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Protocol: routeProto}, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return ret, err
+	}
+
+	for _, r := range routes {
+		if r.Type == syscall.RTN_BLACKHOLE {
+			continue
+		}
+		ret = append(ret, r.Dst)
+	}
+	return ret, nil
+}
+
+// HostRoutesIn returns all host routes (/32 or /128) within subnet, regardless of protocol
+func HostRoutesIn(subnet *net.IPNet) ([]*net.IPNet, error) {
+	ret := []*net.IPNet{}
+	routes, err := nlh.RouteList(nil, family(subnet.IP))
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return ret, err
+	}
+	return append(ret, hostRoutesIn(routes, subnet)...), nil
+}
+
+// hostRoutesIn returns the destinations of the host routes within subnet. Routes of the other
+// family are skipped, eg. an ipv4-mapped ipv6 route, which an ipv4 subnet would contain.
+func hostRoutesIn(routes []netlink.Route, subnet *net.IPNet) []*net.IPNet {
+	_, snBits := subnet.Mask.Size()
+	var ret []*net.IPNet
+	for _, r := range routes {
+		if r.Dst == nil || !subnet.Contains(r.Dst.IP) {
+			continue
+		}
+		if ones, bits := r.Dst.Mask.Size(); ones != bits || bits != snBits {
+			continue
+		}
+		ret = append(ret, r.Dst)
+	}
+	return ret
+}
@@ -0,0 +1,148 @@
+package host
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func cidr(s string) *net.IPNet {
+	ip, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	// routes from the kernel carry the address of their own family
+	if ip4 := ip.To4(); ip4 != nil && len(n.Mask) == net.IPv4len {
+		n.IP = ip4
+	}
+	return n
+}
+
+func TestFamily(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want int
+	}{
+		{"10.1.2.3", netlink.FAMILY_V4},
+		{"fd00::1", netlink.FAMILY_V6},
+		// a v4-mapped address is an ipv4 address to the kernel, its routes are in the ipv4 table
+		{"::ffff:10.1.2.3", netlink.FAMILY_V4},
+		{"::1", netlink.FAMILY_V6},
+	}
+	for _, tt := range tests {
+		if f := family(net.ParseIP(tt.ip)); f != tt.want {
+			t.Errorf("family of %v is %v, want %v", tt.ip, f, tt.want)
+		}
+	}
+}
+
+func TestGetIPNets(t *testing.T) {
+	tests := []struct {
+		ip       string
+		subnet   string
+		inSubnet string
+		host     string
+	}{
+		{"10.1.2.3", "10.1.2.0/24", "10.1.2.3/24", "10.1.2.3/32"},
+		{"fd00::5", "fd00::/64", "fd00::5/64", "fd00::5/128"},
+		{"10.1.2.3", "", "10.1.2.3/8", "10.1.2.3/32"},
+		{"fd00::5", "", "fd00::5/128", "fd00::5/128"},
+		{"::ffff:10.1.2.3", "", "10.1.2.3/8", "10.1.2.3/32"},
+	}
+	for _, tt := range tests {
+		var sn *net.IPNet
+		if tt.subnet != "" {
+			sn = cidr(tt.subnet)
+		}
+		in, host := getIPNets(net.ParseIP(tt.ip), sn)
+		if in.String() != tt.inSubnet || host.String() != tt.host {
+			t.Errorf("%v in %v is %v and %v, want %v and %v", tt.ip, tt.subnet, in, host, tt.inSubnet, tt.host)
+		}
+	}
+}
+
+func TestHostRoutesIn(t *testing.T) {
+	routes := []netlink.Route{
+		{Dst: nil},
+		{Dst: cidr("10.1.2.3/32")},
+		{Dst: cidr("10.1.2.0/24")},
+		{Dst: cidr("10.1.3.4/32")},
+		{Dst: cidr("fd00::5/128")},
+		{Dst: cidr("fd00::/64")},
+		{Dst: cidr("fd01::5/128")},
+		// a v4-mapped ipv6 host route, contained by the ipv4 pool by net.IPNet
+		{Dst: &net.IPNet{IP: net.ParseIP("::ffff:10.1.2.9"), Mask: net.CIDRMask(128, 128)}},
+		// and another, both in the ipv6 pool of v4-mapped addresses below
+		{Dst: &net.IPNet{IP: net.ParseIP("::ffff:10.1.2.10"), Mask: net.CIDRMask(128, 128)}},
+	}
+	tests := []struct {
+		pool *net.IPNet
+		want []string
+	}{
+		{cidr("10.1.2.0/24"), []string{"10.1.2.3"}},
+		{cidr("10.1.0.0/16"), []string{"10.1.2.3", "10.1.3.4"}},
+		{cidr("fd00::/64"), []string{"fd00::5"}},
+		{cidr("fd00::/15"), []string{"fd00::5", "fd01::5"}},
+		{&net.IPNet{IP: net.ParseIP("::ffff:10.1.2.0"), Mask: net.CIDRMask(120, 128)}, []string{"::ffff:10.1.2.9", "::ffff:10.1.2.10"}},
+		{cidr("192.168.0.0/24"), nil},
+	}
+	for _, tt := range tests {
+		got := hostRoutesIn(routes, tt.pool)
+		if len(got) != len(tt.want) {
+			t.Errorf("host routes in %v are %v, want %v", tt.pool, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !got[i].IP.Equal(net.ParseIP(tt.want[i])) {
+				t.Errorf("host routes in %v are %v, want %v", tt.pool, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCountRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []netlink.Route
+		want   int
+	}{
+		{"none", nil, 0},
+		{"v4", []netlink.Route{{Dst: cidr("10.1.2.3/32")}}, 1},
+		{"v6 and quarantined v4", []netlink.Route{{Dst: cidr("fd00::5/128")}, {Dst: cidr("10.1.2.3/32"), Type: syscall.RTN_BLACKHOLE}}, 1},
+		{"quarantined", []netlink.Route{{Dst: cidr("fd00::5/128"), Type: syscall.RTN_BLACKHOLE}}, 0},
+		{"two hosts", []netlink.Route{{Dst: cidr("fd00::5/128"), LinkIndex: 3}, {Dst: cidr("fd00::5/128"), Gw: net.ParseIP("fd00::1")}}, 2},
+	}
+	for _, tt := range tests {
+		if n := countRoutes(tt.routes); n != tt.want {
+			t.Errorf("%v: counted %v routes, want %v", tt.name, n, tt.want)
+		}
+	}
+}
+
+func TestGatewaySubnet(t *testing.T) {
+	tests := []struct {
+		name  string
+		addrs []string
+		want  string
+	}{
+		{"ipv4", []string{"10.1.2.1/24"}, "10.1.2.0/24"},
+		{"ipv6 only beside link local", []string{"fe80::1/64", "fd00:1::1/64"}, "fd00:1::/64"},
+		{"dual stack", []string{"fe80::1/64", "10.1.2.1/24", "fd00:1::1/64"}, "10.1.2.0/24"},
+		{"link local only", []string{"fe80::1/64"}, "<nil>"},
+		{"none", nil, "<nil>"},
+	}
+	for _, tt := range tests {
+		var addrs []*net.IPNet
+		for _, a := range tt.addrs {
+			ip, n, _ := net.ParseCIDR(a)
+			n.IP = ip
+			addrs = append(addrs, n)
+		}
+		if sn := gatewaySubnet(addrs); sn.String() != tt.want {
+			t.Errorf("%v: subnet is %v, want %v", tt.name, sn, tt.want)
+		}
+	}
+}
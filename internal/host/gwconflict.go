@@ -0,0 +1,100 @@
+package host
+
+import (
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// gatewayConflictOpt is the network option selecting what is done when the gateway address is
+// answered on the segment by another node as the host interface claims it: off, warn, fail or takeover
+const gatewayConflictOpt = "gatewayconflict"
+
+// gatewayConflict returns the gateway conflict action of a network, off unless it is set
+func gatewayConflict(opts map[string]string) string {
+	a := strings.ToLower(optOrEnv(opts, gatewayConflictOpt))
+	if a == "" {
+		return "off"
+	}
+	return a
+}
+
+// checkGateway asks for gateway on the segment before it is added to the host macvlan. Answers
+// from other hosts of this plugin, the tunnel endpoints of the flood peers holding the anycast
+// gateway, are expected and skipped. Returns true if the gateway is to be taken over, announced
+// once it is added. Caller must hold the interface lock.
+func (hi *Interface) checkGateway(gateway *net.IPNet, opts map[string]string) (bool, error) {
+	action := gatewayConflict(opts)
+	if action == "off" || hi.mvl.HasAddress(gateway) {
+		return false, nil
+	}
+	log := hi.log.WithField("Func", "checkGateway()").WithField("gateway", gateway.IP.String())
+	log.Debug()
+
+	mac, err := probeAddress(hi.mvl.GetIndex(), gateway.IP, probeTime, hi.peerMAC)
+	if err != nil {
+		log.WithError(err).Warn("failed to probe for gateway")
+		return false, nil
+	}
+	if mac == nil {
+		return false, nil
+	}
+
+	log = log.WithField("mac", mac.String())
+	switch action {
+	case "fail":
+		err = vxrerrors.Conflict("gateway %v is in use by %v on the segment", gateway.IP, mac)
+		log.WithError(err).Error()
+		return false, err
+	case "takeover":
+		log.Warn("gateway is in use by another node on the segment, taking it over")
+		return true, nil
+	}
+	log.Warn("gateway is in use by another node on the segment")
+	return false, nil
+}
+
+// announceGateway has the nodes on the segment send traffic for the gateway to the host macvlan
+func (hi *Interface) announceGateway(gateway *net.IPNet) {
+	err := announceAddress(hi.mvl.GetIndex(), gateway.IP)
+	if err != nil {
+		hi.log.WithField("gateway", gateway.IP.String()).WithError(err).Warn("failed to announce gateway")
+	}
+}
+
+// peerMAC reports whether mac is behind the tunnel endpoint of a flood peer on the vxlan, a host
+// of this plugin. Without flood peers, eg. with a multicast group, no address is known to be a peer.
+func (hi *Interface) peerMAC(mac net.HardwareAddr) bool {
+	link, err := nlh.LinkByIndex(hi.dp.GetIndex())
+	if err != nil {
+		return false
+	}
+	vxl, ok := link.(*netlink.Vxlan)
+	if !ok {
+		return false
+	}
+	peers, _ := vxlanFloodPeers(vxl)
+	if len(peers) == 0 {
+		return false
+	}
+
+	fdb, err := nlh.NeighList(vxl.Index, syscall.AF_BRIDGE)
+	if err != nil {
+		return false
+	}
+	for _, f := range fdb {
+		if f.IP == nil || f.HardwareAddr.String() != mac.String() {
+			continue
+		}
+		for _, p := range peers {
+			if p.Equal(f.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
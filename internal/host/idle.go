@@ -0,0 +1,46 @@
+package host
+
+import (
+	"sync"
+	"time"
+)
+
+// idleSample is the traffic counter of a host interface when it last changed
+type idleSample struct {
+	packets uint64
+	since   time.Time
+}
+
+var (
+	idleSamples  = make(map[string]*idleSample)
+	idleSamplesL sync.Mutex
+)
+
+// Idle returns how long the vxlan of the host interface has passed no traffic.
+// Traffic is sampled on each call, so the first call for an interface returns 0.
+func (hi *Interface) Idle() (time.Duration, error) {
+	link, err := nlh.LinkByIndex(hi.dp.GetIndex())
+	if err != nil {
+		return 0, err
+	}
+	var packets uint64
+	if st := link.Attrs().Statistics; st != nil {
+		packets = st.RxPackets + st.TxPackets
+	}
+
+	idleSamplesL.Lock()
+	defer idleSamplesL.Unlock()
+	s, ok := idleSamples[hi.name]
+	if !ok || s.packets != packets {
+		idleSamples[hi.name] = &idleSample{packets: packets, since: time.Now()}
+		return 0, nil
+	}
+	return time.Since(s.since), nil
+}
+
+// forgetIdle drops the traffic sample of a deleted host interface
+func forgetIdle(name string) {
+	idleSamplesL.Lock()
+	defer idleSamplesL.Unlock()
+	delete(idleSamples, name)
+}
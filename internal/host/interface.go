@@ -0,0 +1,1044 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/iputil"
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/internal/macvlan"
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+const (
+	gatewayAddTries = 3
+	gatewayAddRetry = 50 * time.Millisecond
+)
+
+var (
+	routeProto       = vxrouter.GetEnvIntWithDefault(vxrouter.EnvPrefix+"ROUTE_PROTO", "", vxrouter.DefaultRouteProto)
+	summaryProto     = vxrouter.GetEnvIntWithDefault(vxrouter.EnvPrefix+"SUMMARY_PROTO", "", vxrouter.DefaultSummaryProto)
+	reqAddrSleepTime = vxrouter.GetEnvDurWithDefault(vxrouter.EnvPrefix+"REQ_ADDR_SLEEP", "", vxrouter.DefaultReqAddrSleepTime)
+	probeTime        = vxrouter.GetEnvDurWithDefault(vxrouter.EnvPrefix+"PROBE_TIME", "", vxrouter.DefaultProbeTime)
+	canaryTimeout    = vxrouter.GetEnvDurWithDefault(vxrouter.EnvPrefix+"CANARY_TIMEOUT", "", vxrouter.DefaultCanaryTimeout)
+)
+
+// Interface holds the datapath and a host macvlan interface used for the gateway interface on a container network
+type Interface struct {
+	name string
+	dp   datapath
+	mvl  *macvlan.Macvlan
+	log  *log.Entry
+	l    *hiLock
+}
+
+// Name returns the name of the host interface
+func (hi *Interface) Name() string {
+	return hi.name
+}
+
+// GetOrCreateInterface creates required host interfaces if they don't exist, or gets them if they already do
+// The gateways, one per address family of a dual-stack network, are claimed on the host macvlan.
+// They are anycast addresses, every host with containers on the network holds them.
+func GetOrCreateInterface(name string, gateways []*net.IPNet, opts map[string]string) (*Interface, error) {
+	hi, _ := getInterface(name)
+	hi.log = log.WithField("Interface", name)
+	log := hi.log.WithField("Func", "GetOrCreateInterface()")
+	log.Debug()
+
+	if hi.dp != nil && hi.mvl != nil && hi.hasGateways(gateways) {
+		hintUnmanaged(hi.dp.Name())
+		return hi, nil
+	}
+
+	vxlName, err := vxlanName(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	hi.l.lock()
+	defer hi.l.unlock()
+	hi, _ = getInterface(name)
+	hi.log = log.WithField("Interface", name)
+
+	created := hi.dp == nil
+	if hi.dp == nil {
+		opts, err = fabricOptions(vxlName, opts)
+		if err != nil {
+			log.WithError(err).Debug("failed to select fabric")
+			return nil, err
+		}
+		opts, err = parentOptions(vxlName, opts)
+		if err != nil {
+			log.WithError(err).Debug("failed to get parent interface")
+			return nil, err
+		}
+		opts = nestedOptions(vxlName, opts)
+		opts = mtuOptions(vxlName, opts)
+		opts = l3Options(opts)
+		hi.dp, err = newDatapath(vxlName, opts)
+		if err != nil {
+			log.WithError(err).Debug("failed to create datapath")
+			return nil, err
+		}
+	}
+
+	if hi.mvl == nil {
+		hi.mvl, err = hi.dp.CreateMacvlan("hmvl_" + name)
+		if err == nil && l3(opts) {
+			err = hi.enableProxyARP()
+		}
+		if err != nil {
+			err2 := hi.UnsafeDelete()
+			if err2 != nil {
+				log.WithError(err).WithError(err2).Debug("failed to delete vxlan")
+				return nil, err2
+			}
+			return nil, err
+		}
+	}
+
+	for _, gateway := range gateways {
+		var takeover bool
+		takeover, err = hi.checkGateway(gateway, opts)
+		if err == nil {
+			err = hi.addGateway(gateway)
+		}
+		if err == nil {
+			if takeover {
+				hi.announceGateway(gateway)
+			}
+			continue
+		}
+		log.WithError(err).Error("failed to add gateway to host interface")
+		if !created {
+			// the interface may be in use by containers, leave it alone
+			return nil, err
+		}
+		//implicitly deletes macvlan
+		err2 := hi.UnsafeDelete()
+		if err2 != nil {
+			log.WithError(err).WithError(err2).Debug("failed to delete vxlan")
+			return nil, err2
+		}
+		return nil, err
+	}
+
+	if created {
+		if err = hi.addFloodPeers(); err != nil {
+			log.WithError(err).Error("failed to add flood peers")
+		}
+	}
+
+	hintUnmanaged(vxlName)
+	return hi, nil
+}
+
+// HasGateway returns whether the gateway is on the host macvlan
+func (hi *Interface) HasGateway(gateway *net.IPNet) bool {
+	return hi.mvl != nil && hi.mvl.HasAddress(gateway)
+}
+
+func (hi *Interface) hasGateways(gateways []*net.IPNet) bool {
+	for _, gw := range gateways {
+		if !hi.mvl.HasAddress(gw) {
+			return false
+		}
+	}
+	return true
+}
+
+// addGateway adds the gateway address to the host macvlan, and verifies it is present.
+// An address added concurrently, eg. by another process, shows up as EEXIST and is
+// accepted once verified. Caller must hold the interface lock.
+func (hi *Interface) addGateway(gateway *net.IPNet) error {
+	log := hi.log.WithField("Func", "addGateway()").WithField("gateway", gateway.String())
+	log.Debug()
+
+	var err error
+	for i := 0; i < gatewayAddTries; i++ {
+		if hi.mvl.HasAddress(gateway) {
+			return nil
+		}
+
+		err = hi.mvl.AddAddress(gateway)
+		if err == nil || err == syscall.EEXIST {
+			if hi.mvl.HasAddress(gateway) {
+				return nil
+			}
+			addrs, _ := hi.mvl.GetAddresses() // nolint: errcheck
+			err = fmt.Errorf("gateway %v not present on %v after adding it, addresses are %v", gateway, "hmvl_"+hi.name, addrs)
+		}
+		log.WithError(err).WithField("try", i+1).Warn("failed to add gateway, retrying")
+		time.Sleep(gatewayAddRetry)
+	}
+
+	return err
+}
+
+// GetInterface gets host interfaces by name
+func GetInterface(name string) (*Interface, error) {
+	log := log.WithField("Interface", name).WithField("Func", "GetInterface()")
+	log.Debug()
+	hi, err := getInterface(name)
+	if err != nil {
+		log.WithError(err).Debug()
+		return nil, err
+	}
+	return hi, err
+}
+
+func getInterface(name string) (*Interface, error) {
+	hi := &Interface{
+		name: name,
+		log:  log.WithField("Interface", name),
+		l:    getHl(name),
+	}
+	log := hi.log.WithField("Func", "getInterface()")
+	log.Debug()
+
+	vxl, err := vxlan.FromName(name)
+	if err != nil {
+		// the datapath of a network sharing its vxlan id, or bridged by open vswitch, is found through the host macvlan
+		mvl, merr := macvlan.FromName("hmvl_" + name)
+		if merr != nil {
+			log.WithError(err).Debug("failed to get vxlan interface")
+			return hi, err
+		}
+		dp, derr := datapathFromLinkIndex(mvl.GetParentIndex())
+		if derr != nil {
+			log.WithError(derr).Debug("failed to get datapath of host macvlan")
+			return hi, derr
+		}
+		hi.dp, hi.mvl = dp, mvl
+		return hi, nil
+	}
+	hi.dp = vxl
+
+	hi.mvl, err = macvlan.FromName("hmvl_" + name)
+	if err != nil {
+		log.WithError(err).Debug("failed to get macvlan interface")
+	}
+
+	return hi, err
+}
+
+// CreateMacvlan creates container macvlan interfaces
+func (hi *Interface) CreateMacvlan(name string) error {
+	log := hi.log.WithField("Func", "CreateMacvlan()")
+	log.Debug()
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	mvl, err := hi.dp.CreateMacvlan(name)
+	if err != nil {
+		return err
+	}
+
+	// docker moves container interfaces from the root namespace
+	err = mvl.MoveOut()
+	if err != nil {
+		log.WithError(err).Error("failed to move macvlan out of gateway namespace")
+		mvl.Delete() // nolint: errcheck
+	}
+	return err
+}
+
+// DeleteMacvlan deletes a container macvlan interface
+func (hi *Interface) DeleteMacvlan(name string) error {
+	log := hi.log.WithField("Func", "DeleteMacvlan()")
+	log.Debug()
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	return hi.dp.DeleteMacvlan(name)
+}
+
+// Delete deletes the host interface, only if there are no additional slave devices attached to the vxlan, and no other vxrnet routes via the hostmacvlan
+func (hi *Interface) Delete() error {
+	hi.l.lock()
+	defer hi.l.unlock()
+	return hi.UnsafeDelete()
+}
+
+// Lock locks the host interface for modificiations. Intended for callers using UnsafeDelete
+func (hi *Interface) Lock() {
+	hi.l.lock()
+}
+
+// Unlock unlocks the host interface for modificiations. Intended for callers using UnsafeDelete
+func (hi *Interface) Unlock() {
+	hi.l.unlock()
+}
+
+// UnsafeDelete deletes the host interface, only if there are no additional slave devices attached to the vxlan, and no other vxrnet routes via the hostmacvlan
+// this function assumes no containers are running on this macvlan, as slaves in other namespaces will not show up
+// Caller is responsible for locking/unlocking the host interface before calling delete
+func (hi *Interface) UnsafeDelete() error {
+	log := hi.log.WithField("Func", "Delete()")
+	log.Debug()
+
+	used, err := hi.inUse()
+	if err != nil || used {
+		return err
+	}
+
+	// the lock is kept, dropping it here would let a caller already waiting on it
+	// race with one getting a fresh lock for the same name
+
+	forgetIdle(hi.name)
+
+	// a vxlan shared with other networks is kept for them
+	others, err := hi.otherNetworks()
+	if err != nil {
+		return err
+	}
+	if others > 0 {
+		log.WithField("networks", others).Debug("vxlan is shared with other networks, deleting only the host macvlan")
+		return hi.mvl.Delete()
+	}
+	parent := hi.vtepDevIndex()
+	if err = hi.dp.Delete(); err != nil {
+		return err
+	}
+	releaseParent(parent)
+	return nil
+}
+
+// inUse reports whether containers are still attached to the vxlan, or routed via the host macvlan
+func (hi *Interface) inUse() (bool, error) {
+	// if there are any other slaves, it is in use
+	slaves, err := hi.dp.GetSlaveDevices()
+	if err != nil {
+		hi.log.WithError(err).Debug("failed to get slaves from vxlan")
+		return false, err
+	}
+	for _, slave := range slaves {
+		if slave.Attrs().Index == hi.mvl.GetIndex() || isHostMacvlan(slave) {
+			continue
+		}
+		hi.log.Debug("other slave devices still exist on this vxlan")
+		return true, nil
+	}
+
+	// if there are any other routes, it is in use
+	routes, err := nlh.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{LinkIndex: hi.mvl.GetIndex(), Protocol: routeProto}, netlink.RT_FILTER_OIF|netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		hi.log.WithError(err).Error("failed to get routes")
+		return false, err
+	}
+	for _, r := range routes {
+		hi.log.WithField("r.Dst", r.Dst.String()).Debug("other routes found on this device")
+		return true, nil
+	}
+	return false, nil
+}
+
+// ReleaseGateways removes the anycast gateway addresses from the host macvlan once the last
+// container has left, even if the interface itself is kept. They are claimed again by
+// GetOrCreateInterface when the next container joins.
+func (hi *Interface) ReleaseGateways() error {
+	log := hi.log.WithField("Func", "ReleaseGateways()")
+	log.Debug()
+
+	hi.l.lock()
+	defer hi.l.unlock()
+
+	if hi.mvl == nil {
+		return nil
+	}
+	used, err := hi.inUse()
+	if err != nil || used {
+		return err
+	}
+
+	gws, err := hi.mvl.GetAddresses()
+	if err != nil {
+		return err
+	}
+	for _, gw := range gws {
+		if gw.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		expectGatewayDel(gw.IP)
+		err = hi.mvl.DelAddress(gw)
+		if err != nil && err != syscall.EADDRNOTAVAIL {
+			return err
+		}
+		log.WithField("gateway", gw.String()).Info("released gateway, no containers are left on this host")
+	}
+	return nil
+}
+
+func (hi *Interface) getSubnet() (*net.IPNet, error) {
+	log := hi.log.WithField("Func", "getSubnet()")
+	log.Debug()
+
+	gws, err := hi.mvl.GetAddresses()
+	if err != nil {
+		return nil, err
+	}
+	if sn := gatewaySubnet(gws); sn != nil {
+		return sn, nil
+	}
+
+	return nil, vxrerrors.NotFound("did not find any addresses on the macvlan")
+}
+
+// gatewaySubnet returns the subnet of the first gateway among the addresses of a host macvlan, or nil
+func gatewaySubnet(addrs []*net.IPNet) *net.IPNet {
+	for _, gw := range addrs {
+		// the kernel adds a link local address to the macvlan, the gateway of an IPv6 only network is beside it
+		if gw.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return &net.IPNet{IP: iputil.FirstAddr(gw), Mask: gw.Mask}
+	}
+	return nil
+}
+
+// SelectAddress returns an available IP or the requested IP (if available) or an error on timeout
+func (hi *Interface) SelectAddress(reqAddress net.IP, opts *SelectOpts) (*net.IPNet, error) {
+	log := hi.log.WithField("Func", "SelectAddress()")
+	log.Debug()
+
+	if reqAddress != nil {
+		log = log.WithField("reqAddress", reqAddress.String())
+	}
+	log.Debug("SelectAddress()")
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	var ip *net.IPNet
+	var err error
+
+	// candidates reserved elsewhere, eg. in an external IPAM, are released once selection ends,
+	// so those rejected are not offered again meanwhile
+	var rejected []net.IP
+	defer func() {
+		for _, r := range rejected {
+			ReleaseCandidate(opts.Allocator, r)
+		}
+	}()
+
+	var sleepTime time.Duration
+	if reqAddress != nil {
+		sleepTime = reqAddrSleepTime
+	}
+
+	// try the preferred block once for each address in it, then fall back to the range or whole subnet
+	block, inRange := opts.Block, false
+	if block == nil && opts.Range != nil {
+		block, inRange = opts.Range, true
+	}
+	var blockTries int64
+	if block != nil {
+		blockTries = maxTries(block)
+	}
+
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.RespTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.RespTime)
+		defer cancel()
+	}
+
+	// refuse rather than spin on a nearly full subnet, blocks and ranges are exhausted by their tries
+	if reqAddress == nil && opts.Subnet != nil && !opts.BlockOnly && opts.Range == nil {
+		u, ok, uerr := utilization(opts)
+		if uerr != nil {
+			log.WithError(uerr).Warn("failed to sample subnet utilization")
+		}
+		if ok && (u.Used >= u.Available || u.Free() < minFreeRatio) {
+			err = vxrerrors.Exhausted("pool %v is exhausted, %v of %v addresses are in use", opts.Subnet, u.Used, u.Available)
+			log.WithError(err).Error()
+			return nil, err
+		}
+	}
+
+	// the preferred address is tried on the first try only
+	var pref net.IP
+	if reqAddress == nil && preferable(opts) {
+		pref = opts.Preferred
+	}
+
+	tries, subnetTries := 0, 0
+	for ctx.Err() == nil {
+		tries++
+		if block != nil && blockTries <= 0 {
+			if opts.BlockOnly || inRange {
+				err = vxrerrors.Exhausted("block %v appears full", block)
+				log.WithError(err).Error()
+				return nil, err
+			}
+			if opts.Range != nil {
+				log.WithField("block", block.String()).Warn("preferred block appears full, selecting from the ip range")
+				block, inRange = opts.Range, true
+				blockTries = maxTries(block)
+			} else {
+				log.WithField("block", block.String()).Warn("preferred block appears full, selecting from the whole subnet")
+				block = nil
+			}
+		}
+		blockTries--
+		if reqAddress == nil && block == nil {
+			subnetTries++
+			if maxSelectTries > 0 && subnetTries > maxSelectTries {
+				err = vxrerrors.Exhausted("no free address found in %v after %v tries", opts.Subnet, maxSelectTries)
+				log.WithError(err).Error()
+				return nil, err
+			}
+		}
+		ip, err = hi.selectAddress(ctx, reqAddress, pref, opts, block, &rejected)
+		pref = nil
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			break
+		}
+		if err != nil {
+			log.WithError(err).Error("failed to select address")
+			return nil, err
+		}
+		if ip != nil {
+			break
+		}
+		if reqAddress == nil {
+			sleepTime = backoff(tries)
+		}
+		sleep(ctx, sleepTime)
+	}
+
+	// the requested address was found routed on every try, rather than the deadline interrupting a try
+	if ip == nil && reqAddress != nil && err == nil && tries > 0 {
+		err = vxrerrors.Conflict("requested address %v is still routed after %v", reqAddress, opts.RespTime)
+		log.WithError(err).Error()
+		return nil, err
+	}
+
+	if ip == nil {
+		err = vxrerrors.Timeout("response deadline expired while waiting for address")
+		log.WithError(err).Error()
+		return nil, err
+	}
+
+	return ip, nil
+}
+
+// preferable reports whether the preferred address of opts may be selected at all
+func preferable(opts *SelectOpts) bool {
+	p := opts.Preferred
+	switch {
+	case p == nil, opts.Subnet == nil, !opts.Subnet.Contains(p), excluded(p, opts):
+		return false
+	case opts.BlockOnly && opts.Block != nil && !opts.Block.Contains(p):
+		return false
+	case opts.Range != nil && !opts.Range.Contains(p):
+		return false
+	}
+	return true
+}
+
+// selectAddress returns an available random IP on this network, or the requested IP
+// if it's available. This function may return (nil, nil) if it selects an unavailable address
+// the intention is for the caller to continue calling in a loop until an address is returned
+// this way the caller can implement their own timeout logic.
+// Candidates reserved elsewhere by the allocator which are not selected are added to rejected.
+func (hi *Interface) selectAddress(ctx context.Context, reqAddress, pref net.IP, opts *SelectOpts, block *net.IPNet, rejected *[]net.IP) (ipn *net.IPNet, err error) {
+	log := hi.log.WithField("Func", "selectAddress()")
+	log.Debug()
+
+	sn := opts.Subnet
+	if sn == nil {
+		sn, err = hi.getSubnet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	addrInSubnet, addrOnly := getIPNets(reqAddress, sn)
+
+	if reqAddress != nil && !sn.Contains(reqAddress) {
+		return nil, fmt.Errorf("requested address was not in this host interface's subnet")
+	}
+
+	// an explicitly requested address may be reused during it's quarantine
+	if reqAddress != nil && isQuarantined(reqAddress) {
+		err = Unquarantine(reqAddress)
+		if err != nil {
+			log.WithError(err).Error("failed to remove quarantine")
+			return nil, err
+		}
+	}
+
+	// keep looking for a random address until one is found
+	if reqAddress == nil {
+		switch {
+		case pref != nil:
+			addrOnly.IP = pref
+		case block != nil:
+			bxf, bxl := blockExclusions(sn, block, opts.ExcludeFirst, opts.ExcludeLast)
+			addrOnly.IP, err = candidate(ctx, opts.Allocator, block, bxf, bxl)
+		default:
+			addrOnly.IP, err = candidate(ctx, opts.Allocator, sn, opts.ExcludeFirst, opts.ExcludeLast)
+		}
+		if err != nil {
+			log.WithError(err).Error("allocator failed to pick a candidate")
+			return nil, err
+		}
+		addrInSubnet.IP = addrOnly.IP
+		defer func(ip net.IP) {
+			if ipn == nil {
+				*rejected = append(*rejected, ip)
+			}
+		}(addrOnly.IP)
+	}
+
+	if opts.Gateway != nil && opts.Gateway.Equal(addrOnly.IP) {
+		if reqAddress != nil {
+			return nil, vxrerrors.Conflict("requested address is the network gateway")
+		}
+		return nil, nil
+	}
+
+	// exclusions only apply to selected addresses, an operator may still request one explicitly
+	if reqAddress == nil && inRanges(addrOnly.IP, opts.Exclude) {
+		return nil, nil
+	}
+
+	if opts.Reserved != nil && opts.Reserved(addrOnly.IP) {
+		if reqAddress != nil {
+			return nil, vxrerrors.Conflict("requested address is reserved by another host")
+		}
+		return nil, nil
+	}
+	numRoutes, err := numRoutesTo(addrOnly)
+	if err != nil {
+		log.WithError(err).Errorf("failed to count routes")
+		return nil, err
+	}
+	// a selected address already routed is merely in use, only a requested one is in conflict
+	evicted := false
+	if numRoutes > 0 {
+		if reqAddress == nil {
+			return nil, nil
+		}
+		switch resolveConflict(opts, addrOnly.IP, true, ConflictRouted) {
+		case ConflictFail:
+			return nil, vxrerrors.Conflict("requested address %v is %v", addrOnly.IP, ConflictRouted)
+		case ConflictEvict:
+			log.WithField("ip", addrOnly.IP).Warn("taking over requested address routed by another host")
+			evicted = true
+		default:
+			return nil, nil
+		}
+	}
+
+	log = log.WithField("ip", addrOnly.String())
+
+	if opts.Claim != nil {
+		var ok bool
+		ok, err = opts.Claim(addrOnly.IP)
+		if err != nil {
+			log.WithError(err).Error("failed to lock address with the coordinator")
+			return nil, err
+		}
+		if !ok {
+			if resolveConflict(opts, addrOnly.IP, reqAddress != nil, ConflictLocked) != ConflictRetry {
+				return nil, vxrerrors.Conflict("address %v is %v", addrOnly.IP, ConflictLocked)
+			}
+			log.Debug("address is locked by another host")
+			return nil, nil
+		}
+		if opts.Unclaim != nil {
+			defer func(ip net.IP) {
+				if ipn == nil {
+					opts.Unclaim(ip)
+				}
+			}(addrOnly.IP)
+		}
+	}
+
+	// an explicitly requested address may be configured statically somewhere on the segment
+	// without a route, ask for it before claiming it. With duplicate address detection selected
+	// addresses are asked for too, for the route propagation time, so another host selecting
+	// the same address at the same time is noticed before either installs its route.
+	if reqAddress != nil || opts.DAD {
+		pt := probeTime
+		if reqAddress == nil {
+			pt = opts.PropTime
+		}
+		var mac net.HardwareAddr
+		mac, err = probeAddress(hi.mvl.GetIndex(), addrOnly.IP, pt, nil)
+		if err != nil {
+			log.WithError(err).Warn("failed to probe for address, relying on routes only")
+		}
+		if mac != nil && !evicted {
+			switch resolveConflict(opts, addrOnly.IP, reqAddress != nil, ConflictProbed) {
+			case ConflictFail:
+				return nil, vxrerrors.Conflict("address %v is in use by %v", addrOnly.IP, mac)
+			case ConflictEvict:
+				log.WithField("mac", mac.String()).Warn("taking over address from another node")
+				evicted = true
+			default:
+				log.WithField("mac", mac.String()).Info("selected address is claimed by another node")
+				return nil, nil
+			}
+		}
+		if reqAddress == nil && !evicted {
+			numRoutes, err = numRoutesTo(addrOnly)
+			if err != nil {
+				log.WithError(err).Errorf("failed to count routes")
+				return nil, err
+			}
+			if numRoutes > 0 {
+				log.Info("route to selected address appeared while probing")
+				return nil, nil
+			}
+		}
+	}
+
+	// routes to the address from elsewhere are watched for while its route propagates
+	w := watchRoutes()
+	defer w.close()
+
+	// add host route to routing table
+	log.Debug("adding route to")
+	err = nlh.RouteAdd(&netlink.Route{
+		LinkIndex: hi.mvl.GetIndex(),
+		Dst:       addrOnly,
+		Protocol:  routeProto,
+		Priority:  opts.Metric,
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to add route")
+		return nil, err
+	}
+
+	//wait for at least estimated route propagation time, or until a route from elsewhere appears
+	remote, ok := hi.propagate(ctx, w, addrOnly, opts.PropTime)
+	if !ok {
+		log.Debug("deadline expired while waiting for route propagation")
+		if err = hi.DelRoute(addrOnly.IP); err != nil {
+			log.WithError(err).Error("failed to delete route")
+		}
+		return nil, ctx.Err()
+	}
+
+	//check that we are still the only route
+	numRoutes, err = numRoutesTo(addrOnly)
+	if err != nil {
+		log.WithError(err).Error("failed to count routes")
+		return nil, err
+	}
+	if remote != nil && numRoutes == 1 {
+		// the one route left is either ours, the other host backed off first, or theirs
+		var ours int
+		ours, err = VxroutesTo(addrOnly.IP)
+		if err != nil {
+			return nil, err
+		}
+		if ours < 1 {
+			log.WithField("remote", remote.String()).Info("route was replaced by one from elsewhere")
+			return nil, nil
+		}
+	}
+
+	if numRoutes < 1 {
+		// The route either wasn't successfully added, or was removed,
+		// possibly because of a race with reconcile()
+		// let the outer loop try again
+		log.Debug("route doesn't exist after it was added")
+		return nil, nil
+	}
+
+	if numRoutes == 1 || evicted {
+		return addrInSubnet, nil
+	}
+
+	if remote != nil {
+		log = log.WithField("remote", remote.String())
+	}
+	log.Info("someone else grabbed ip first")
+	action := resolveConflict(opts, addrOnly.IP, reqAddress != nil, ConflictRace)
+	if action == ConflictEvict {
+		return addrInSubnet, nil
+	}
+
+	err = hi.DelRoute(addrOnly.IP)
+	if err != nil {
+		log.WithError(err).Error("failed to delete dup route")
+		return nil, err
+	}
+
+	if action == ConflictFail {
+		if remote != nil {
+			return nil, vxrerrors.Conflict("address %v is %v, %v", addrOnly.IP, ConflictRace, remote)
+		}
+		return nil, vxrerrors.Conflict("address %v is %v", addrOnly.IP, ConflictRace)
+	}
+
+	return nil, nil
+}
+
+// DelRoute deletes the /32 or /128 to the passed address
+func (hi *Interface) DelRoute(ip net.IP) error {
+	log := hi.log.WithField("Func", "DelRoute()")
+	log.Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	_, addrOnly := getIPNets(ip, nil)
+
+	expectRouteDel(ip)
+	return nlh.RouteDel(&netlink.Route{
+		LinkIndex: hi.mvl.GetIndex(),
+		Dst:       addrOnly,
+		Protocol:  routeProto,
+	})
+}
+
+// RestoreRoute adds the host route to ip, unless there is already a route to it.
+// Returns true if the route was added.
+func (hi *Interface) RestoreRoute(ip net.IP, metric int) (bool, error) {
+	log := hi.log.WithField("Func", "RestoreRoute()").WithField("ip", ip.String())
+	log.Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	_, addrOnly := getIPNets(ip, nil)
+	n, err := numRoutesTo(addrOnly)
+	if err != nil || n > 0 {
+		return false, err
+	}
+
+	err = nlh.RouteAdd(&netlink.Route{
+		LinkIndex: hi.mvl.GetIndex(),
+		Dst:       addrOnly,
+		Protocol:  routeProto,
+		Priority:  metric,
+	})
+	return err == nil, err
+}
+
+// DelNeigh deletes the neighbor entry for ip on the host macvlan, and the
+// forwarding entry on the vxlan for the hardware address it resolved to
+func (hi *Interface) DelNeigh(ip net.IP) error {
+	log := hi.log.WithField("Func", "DelNeigh()").WithField("ip", ip.String())
+	log.Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	neighs, err := nlh.NeighList(hi.mvl.GetIndex(), family(ip))
+	if err != nil {
+		log.WithError(err).Error("failed to list neighbors")
+		return err
+	}
+
+	var macs []net.HardwareAddr
+	for i := range neighs {
+		n := neighs[i]
+		if !n.IP.Equal(ip) {
+			continue
+		}
+		if n.HardwareAddr != nil {
+			macs = append(macs, n.HardwareAddr)
+		}
+		if err = nlh.NeighDel(&n); err != nil && err != syscall.ENOENT {
+			log.WithError(err).Error("failed to delete neighbor")
+			return err
+		}
+	}
+	if len(macs) == 0 {
+		return nil
+	}
+
+	for _, mac := range macs {
+		if err = hi.delRemote(mac, nil); err != nil {
+			log.WithError(err).WithField("mac", mac.String()).Error("failed to delete forwarding entry")
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ClaimHostBlock returns the block of size addresses of sn this host allocates from, and
+// adds a route to it via the host interface, tagged with the summary protocol, for the
+// routing daemon to advertise in place of the host routes within it. The block is the one
+// already routed via the host interface, or the first from the one SubBlock selects for key
+// which is not routed from elsewhere, as another host whose key hashes to the same block
+// has selected it.
+func (hi *Interface) ClaimHostBlock(sn *net.IPNet, size int, key string) (*net.IPNet, error) {
+	log := hi.log.WithField("Func", "ClaimHostBlock()").WithField("subnet", sn.String())
+	log.Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	block, err := SummaryBlockIn(sn, size, hi.mvl.GetIndex())
+	if err != nil || block != nil {
+		return block, err
+	}
+
+	block, err = probeBlock(sn, size, key, func(b *net.IPNet) (bool, error) {
+		n, err := numRoutesTo(b)
+		if err == nil && n > 0 {
+			log.WithField("block", b.String()).Debug("host block is routed elsewhere, probing the next")
+		}
+		return n > 0, err
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to select a host block")
+		return nil, err
+	}
+
+	err = nlh.RouteAdd(&netlink.Route{
+		LinkIndex: hi.mvl.GetIndex(),
+		Dst:       block,
+		Protocol:  summaryProto,
+	})
+	if err != nil {
+		log.WithField("block", block.String()).WithError(err).Error("failed to add host block route")
+		return nil, err
+	}
+	return block, nil
+}
+
+// SummaryBlockIn returns the block of size addresses of sn routed via the link with index
+// linkIndex, or any link if it is 0, tagged with the summary protocol. nil is returned if
+// there is none.
+func SummaryBlockIn(sn *net.IPNet, size, linkIndex int) (*net.IPNet, error) {
+	routes, err := nlh.RouteListFiltered(family(sn.IP), &netlink.Route{Protocol: summaryProto}, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return nil, err
+	}
+	bl := subBlockBits(sn, size)
+	for _, r := range routes {
+		if r.Dst == nil || (linkIndex != 0 && r.LinkIndex != linkIndex) {
+			continue
+		}
+		ones, bits := r.Dst.Mask.Size()
+		if bits-ones == bl && sn.Contains(r.Dst.IP) {
+			return r.Dst, nil
+		}
+	}
+	return nil, nil
+}
+
+// GetInterfaceFromDestinationAddress gets an interface from a host route destination
+func GetInterfaceFromDestinationAddress(address net.IP) (*Interface, error) {
+	routes, err := nlh.RouteGet(address)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range routes {
+		if r.Gw != nil {
+			continue
+		}
+
+		var m *macvlan.Macvlan
+		m, err = macvlan.FromLinkIndex(r.LinkIndex)
+		if err != nil {
+			continue
+		}
+
+		var dp datapath
+		dp, err = datapathFromLinkIndex(m.GetParentIndex())
+		if err != nil {
+			continue
+		}
+
+		return getInterfaceFromDevices(dp, m), nil
+	}
+
+	return nil, vxrerrors.NotFound("interface not found")
+}
+
+func getInterfaceFromDevices(dp datapath, mvl *macvlan.Macvlan) *Interface {
+	// the network is named by the host macvlan, the datapath may be shared
+	name := strings.TrimPrefix(mvl.Name(), "hmvl_")
+	return &Interface{
+		name: name,
+		dp:   dp,
+		mvl:  mvl,
+		log:  log.WithField("Interface", name),
+		l:    getHl(name),
+	}
+}
+
+// sleep waits for d, or until ctx is done. It returns false if ctx is done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backoff returns a jittered wait before retrying a random selection after tries collisions,
+// growing exponentially up to the requested address retry time
+func backoff(tries int) time.Duration {
+	b := reqAddrSleepTime
+	if tries < 20 && time.Millisecond<<uint(tries) < b {
+		b = time.Millisecond << uint(tries)
+	}
+	if b <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(b)))
+}
+
+// candidate returns the next address to try in n from a, excluding the first xf and last xl addresses.
+// Allocators reserving their candidates elsewhere are passed ctx.
+func candidate(ctx context.Context, a allocator.Allocator, n *net.IPNet, xf, xl int) (net.IP, error) {
+	if a == nil {
+		a = allocator.Random{}
+	}
+	if r, ok := a.(allocator.Reserver); ok {
+		return r.Reserve(ctx, n, xf, xl)
+	}
+	return a.Candidate(n, xf, xl)
+}
+
+// ReleaseCandidate releases ip, if a reserved it elsewhere as a candidate, eg. in an external IPAM.
+// It is called for candidates which are not handed out.
+func ReleaseCandidate(a allocator.Allocator, ip net.IP) {
+	r, ok := a.(allocator.Reserver)
+	if !ok || !r.Reserved(ip) {
+		return
+	}
+	if err := r.Release(context.Background(), ip); err != nil {
+		log.WithField("ip", ip).WithError(err).Error("failed to release candidate")
+	}
+}
+
+// maxTries is the number of addresses in block, capped for blocks too large to exhaust, eg. ipv6 ranges
+func maxTries(block *net.IPNet) int64 {
+	n := SubnetSize(block)
+	if !n.IsInt64() {
+		return math.MaxInt64
+	}
+	return n.Int64()
+}
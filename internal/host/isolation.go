@@ -0,0 +1,90 @@
+package host
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os/exec"
+	"strings"
+)
+
+// isolationPriority is the priority of the isolation chains, before the default filter chains
+const isolationPriority = -10
+
+// Isolate installs the nftables policy of a network sharing its vxlan id with others in the
+// container namespace at nsPath. Ip and arp traffic to the subnets of the network from outside
+// of them is dropped, unless it is routed through the gateway, the host macvlan of the network.
+// Containers of the other networks on the vxlan can then reach the container only through
+// their gateways, as if the networks did not share it.
+func (hi *Interface) Isolate(nsPath string, subnets []*net.IPNet) error {
+	log := hi.log.WithField("Func", "Isolate()").WithField("ns", nsPath)
+	log.Debug()
+
+	hi.l.rlock()
+	gw, err := hi.mvl.HardwareAddr()
+	hi.l.runlock()
+	if err != nil {
+		return err
+	}
+
+	rs := isolationRuleset(isolationTable(hi.name), gw, subnets)
+	err = inNs(nsPath, func() error {
+		cmd := exec.Command("nft", "-f", "-")
+		cmd.Stdin = strings.NewReader(rs)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%v: %v", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to install isolation policy")
+		return fmt.Errorf("failed to install isolation policy for %v: %v", hi.name, err)
+	}
+	return nil
+}
+
+// isolationTable returns the name of the nftables tables of a network, unique per network
+// as a container may be connected to several
+func isolationTable(network string) string {
+	h := fnv.New32a()
+	h.Write([]byte(network)) // nolint: errcheck
+	return fmt.Sprintf("vxrouter_%08x", h.Sum32())
+}
+
+// isolationRuleset returns the nft script replacing the isolation tables of a network
+func isolationRuleset(table string, gw net.HardwareAddr, subnets []*net.IPNet) string {
+	var v4, v6 []string
+	for _, sn := range subnets {
+		if sn.IP.To4() != nil {
+			v4 = append(v4, sn.String())
+		} else {
+			v6 = append(v6, sn.String())
+		}
+	}
+
+	var b strings.Builder
+	// declaring then deleting the tables makes replacing them idempotent
+	fmt.Fprintf(&b, "table inet %v {}\ndelete table inet %v\n", table, table)
+	fmt.Fprintf(&b, "table inet %v {\n\tchain input {\n\t\ttype filter hook input priority %v; policy accept;\n", table, isolationPriority)
+	if len(v4) > 0 {
+		s := "{ " + strings.Join(v4, ", ") + " }"
+		fmt.Fprintf(&b, "\t\tip daddr %v ip saddr != %v ether saddr != %v drop\n", s, s, gw)
+	}
+	if len(v6) > 0 {
+		s := "{ " + strings.Join(v6, ", ") + " }"
+		fmt.Fprintf(&b, "\t\ticmpv6 type { nd-neighbor-solicit, nd-neighbor-advert } accept\n")
+		fmt.Fprintf(&b, "\t\tip6 daddr %v ip6 saddr != %v ether saddr != %v drop\n", s, s, gw)
+	}
+	b.WriteString("\t}\n}\n")
+
+	if len(v4) > 0 {
+		s := "{ " + strings.Join(v4, ", ") + " }"
+		fmt.Fprintf(&b, "table arp %v {}\ndelete table arp %v\n", table, table)
+		fmt.Fprintf(&b, "table arp %v {\n\tchain input {\n\t\ttype filter hook input priority %v; policy accept;\n", table, isolationPriority)
+		fmt.Fprintf(&b, "\t\tarp daddr ip %v arp saddr ip != %v drop\n\t}\n}\n", s, s)
+	}
+	return b.String()
+}
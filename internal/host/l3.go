@@ -0,0 +1,67 @@
+package host
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// l3Opt is the network option disabling bridge learning and flooding on the vxlan. The host
+// macvlan answers ARP for the addresses on other hosts instead, from the host routes to them.
+const l3Opt = "l3"
+
+func l3(opts map[string]string) bool {
+	return strings.EqualFold(optOrEnv(opts, l3Opt), "on")
+}
+
+// l3Options disables learning on the vxlan of a layer 3 only network
+func l3Options(opts map[string]string) map[string]string {
+	if !l3(opts) || optOrEnv(opts, "learning") != "" {
+		return opts
+	}
+	ret := make(map[string]string, len(opts)+1)
+	for k, v := range opts {
+		ret[k] = v
+	}
+	ret["learning"] = "false"
+	return ret
+}
+
+func proxyARPPath(name string) string {
+	return filepath.Join("/proc/sys/net/ipv4/conf", name, "proxy_arp")
+}
+
+// enableProxyARP has the host macvlan answer ARP for the addresses routed through other
+// interfaces, those of containers on other hosts
+func (hi *Interface) enableProxyARP() error {
+	return gwns.Do(func() error {
+		return ioutil.WriteFile(proxyARPPath(hi.mvl.Name()), []byte("1"), 0644)
+	})
+}
+
+// isL3 reports whether vxl is of a layer 3 only network, a host macvlan on it answering ARP
+func isL3(vxl *netlink.Vxlan) bool {
+	links, err := nlh.LinkList()
+	if err != nil {
+		return false
+	}
+	for _, link := range links {
+		if link.Attrs().ParentIndex != vxl.Index || !isHostMacvlan(link) {
+			continue
+		}
+		var v []byte
+		err = gwns.Do(func() error {
+			var err error
+			v, err = ioutil.ReadFile(proxyARPPath(link.Attrs().Name))
+			return err
+		})
+		if err == nil && strings.TrimSpace(string(v)) == "1" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package host
+
+import (
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// learnedHostRoute reports whether r is a host route learned from another host, eg. through
+// a routing daemon, not one of ours
+func learnedHostRoute(r netlink.Route) bool {
+	if r.Dst == nil || r.Type != syscall.RTN_UNICAST || exportedProto(r.Protocol) {
+		return false
+	}
+	ones, bits := r.Dst.Mask.Size()
+	return ones == bits
+}
+
+// WatchLearnedRoutes calls learned with the destination of each host route learned from
+// another host as it is added, until done is closed. Routes present when it starts are not
+// passed, their age is unknown.
+func WatchLearnedRoutes(learned func(dst *net.IPNet), done <-chan struct{}) error {
+	log := log.WithField("Func", "WatchLearnedRoutes()")
+	log.Debug()
+
+	ruc := make(chan netlink.RouteUpdate)
+	err := gwns.RouteSubscribe(ruc, done)
+	if err != nil {
+		log.WithError(err).Error("failed to subscribe to route updates")
+		return err
+	}
+	for ru := range ruc {
+		if ru.Type == syscall.RTM_NEWROUTE && learnedHostRoute(ru.Route) {
+			learned(ru.Dst)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package host
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ethPLLDP   = 0x88cc
+	lldpReadTO = time.Second
+
+	lldpTLVEnd        = 0
+	lldpTLVChassisID  = 1
+	lldpTLVPortID     = 2
+	lldpTLVTTL        = 3
+	lldpTLVPortDesc   = 4
+	lldpTLVSystemName = 5
+
+	lldpSubtypeMAC     = 4
+	lldpPortSubtypeMAC = 3
+)
+
+var lldpMulticast = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// LLDPNeighbor is the switch port an underlay interface is connected to
+type LLDPNeighbor struct {
+	Interface       string    `json:"interface"`
+	ChassisID       string    `json:"chassis_id"`
+	PortID          string    `json:"port_id"`
+	PortDescription string    `json:"port_description,omitempty"`
+	SystemName      string    `json:"system_name,omitempty"`
+	Expires         time.Time `json:"expires"`
+}
+
+func (n *LLDPNeighbor) same(o *LLDPNeighbor) bool {
+	return o != nil && n.ChassisID == o.ChassisID && n.PortID == o.PortID
+}
+
+var (
+	lldpNeighbors  = make(map[string]*LLDPNeighbor)
+	lldpNeighborsL sync.Mutex
+)
+
+// LLDPNeighbors returns the current, unexpired lldp neighbors of the underlay interfaces
+func LLDPNeighbors() []LLDPNeighbor {
+	lldpNeighborsL.Lock()
+	defer lldpNeighborsL.Unlock()
+	ret := []LLDPNeighbor{}
+	for _, n := range lldpNeighbors {
+		if time.Now().Before(n.Expires) {
+			ret = append(ret, *n)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Interface < ret[j].Interface })
+	return ret
+}
+
+// DiscoverLLDP listens for lldp frames on each of ifaces, recording and logging
+// the switch and port each is connected to, until done is closed
+func DiscoverLLDP(ifaces []string, done <-chan struct{}) error {
+	fds := []int{}
+	for _, name := range ifaces {
+		fd, err := lldpSocket(name)
+		if err != nil {
+			for _, fd := range fds {
+				syscall.Close(fd) // nolint: errcheck
+			}
+			return fmt.Errorf("failed to listen for lldp on %v: %v", name, err)
+		}
+		fds = append(fds, fd)
+	}
+
+	var wg sync.WaitGroup
+	for i, name := range ifaces {
+		wg.Add(1)
+		go func(fd int, name string) {
+			defer wg.Done()
+			defer syscall.Close(fd) // nolint: errcheck
+			lldpListen(fd, name, done)
+		}(fds[i], name)
+	}
+	wg.Wait()
+	return nil
+}
+
+func htons(i uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, i)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
+
+// packetMreq is struct packet_mreq from linux/if_packet.h
+type packetMreq struct {
+	ifindex int32
+	typ     uint16
+	alen    uint16
+	address [8]byte
+}
+
+func lldpSocket(name string) (int, error) {
+	link, err := net.InterfaceByName(name)
+	if err != nil {
+		return -1, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(ethPLLDP)))
+	if err != nil {
+		return -1, err
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(ethPLLDP), Ifindex: link.Index})
+	if err != nil {
+		syscall.Close(fd) // nolint: errcheck
+		return -1, err
+	}
+
+	// nics commonly filter the lldp multicast address unless asked for it
+	mr := packetMreq{ifindex: int32(link.Index), typ: syscall.PACKET_MR_MULTICAST, alen: uint16(len(lldpMulticast))}
+	copy(mr.address[:], lldpMulticast)
+	mrb := (*[unsafe.Sizeof(mr)]byte)(unsafe.Pointer(&mr))[:]
+	err = syscall.SetsockoptString(fd, syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP, string(mrb))
+	if err != nil {
+		syscall.Close(fd) // nolint: errcheck
+		return -1, err
+	}
+
+	tv := syscall.NsecToTimeval(lldpReadTO.Nanoseconds())
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	if err != nil {
+		syscall.Close(fd) // nolint: errcheck
+		return -1, err
+	}
+
+	return fd, nil
+}
+
+func lldpListen(fd int, name string, done <-chan struct{}) {
+	log := log.WithField("Func", "lldpListen()").WithField("interface", name)
+	log.Debug()
+
+	buf := make([]byte, 1514)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			log.WithError(err).Error("failed to read lldp frame")
+			return
+		}
+
+		nb, err := parseLLDP(buf[:n])
+		if err != nil {
+			log.WithError(err).Debug("ignoring invalid lldp frame")
+			continue
+		}
+		nb.Interface = name
+
+		lldpNeighborsL.Lock()
+		old := lldpNeighbors[name]
+		lldpNeighbors[name] = nb
+		lldpNeighborsL.Unlock()
+
+		if !nb.same(old) {
+			log.WithField("chassis", nb.ChassisID).
+				WithField("port", nb.PortID).
+				WithField("system", nb.SystemName).
+				Info("underlay neighbor discovered")
+		}
+	}
+}
+
+// parseLLDP parses an lldp ethernet frame
+func parseLLDP(frame []byte) (*LLDPNeighbor, error) {
+	if len(frame) < 14 || binary.BigEndian.Uint16(frame[12:14]) != ethPLLDP {
+		return nil, fmt.Errorf("not an lldp frame")
+	}
+
+	nb := &LLDPNeighbor{}
+	b := frame[14:]
+	for len(b) >= 2 {
+		h := binary.BigEndian.Uint16(b[0:2])
+		t, l := int(h>>9), int(h&0x1ff)
+		if len(b) < 2+l {
+			return nil, fmt.Errorf("truncated tlv")
+		}
+		v := b[2 : 2+l]
+		b = b[2+l:]
+
+		switch t {
+		case lldpTLVEnd:
+			b = nil
+		case lldpTLVChassisID, lldpTLVPortID:
+			if l < 2 {
+				return nil, fmt.Errorf("short id tlv")
+			}
+			id := string(v[1:])
+			if (t == lldpTLVChassisID && v[0] == lldpSubtypeMAC) || (t == lldpTLVPortID && v[0] == lldpPortSubtypeMAC) {
+				id = net.HardwareAddr(v[1:]).String()
+			}
+			if t == lldpTLVChassisID {
+				nb.ChassisID = id
+			} else {
+				nb.PortID = id
+			}
+		case lldpTLVTTL:
+			if l < 2 {
+				return nil, fmt.Errorf("short ttl tlv")
+			}
+			nb.Expires = time.Now().Add(time.Duration(binary.BigEndian.Uint16(v)) * time.Second)
+		case lldpTLVPortDesc:
+			nb.PortDescription = string(v)
+		case lldpTLVSystemName:
+			nb.SystemName = string(v)
+		}
+	}
+
+	if nb.ChassisID == "" || nb.PortID == "" {
+		return nil, fmt.Errorf("missing chassis or port id")
+	}
+	return nb, nil
+}
@@ -7,12 +7,10 @@ import (
 
 var (
 	getHlc chan *getHlReq
-	delHlc chan string
 )
 
 func init() {
 	getHlc = make(chan *getHlReq)
-	delHlc = make(chan string)
 	go hiLockLoop()
 }
 
@@ -65,16 +63,11 @@ type getHlReq struct {
 
 func hiLockLoop() {
 	hlCache := make(map[string]*hiLock)
-	for {
-		select {
-		case gh := <-getHlc:
-			if _, ok := hlCache[gh.s]; !ok {
-				hlCache[gh.s] = &hiLock{}
-			}
-			gh.rc <- hlCache[gh.s]
-		case s := <-delHlc:
-			delete(hlCache, s)
+	for gh := range getHlc {
+		if _, ok := hlCache[gh.s]; !ok {
+			hlCache[gh.s] = &hiLock{}
 		}
+		gh.rc <- hlCache[gh.s]
 	}
 }
 
@@ -83,7 +76,3 @@ func getHl(s string) *hiLock {
 	getHlc <- &getHlReq{s, rc}
 	return <-rc
 }
-
-func delHl(s string) {
-	delHlc <- s
-}
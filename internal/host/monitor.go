@@ -0,0 +1,140 @@
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// rtUpdate is a route or address change, with the netlink port id of the process which made it
+type rtUpdate struct {
+	typ   uint16
+	route netlink.Route
+	// addr and linkIndex are set for address changes
+	addr      *net.IPNet
+	linkIndex int
+	by        uint32
+}
+
+// subscribeTimeout is how long a receive on the subscription waits before checking whether it is done
+const subscribeTimeout = time.Second
+
+// subscribeRoutes passes the route and address changes to ch until done is closed. Unlike
+// RouteSubscribe it keeps the port id of the sender of each request, the process id of most
+// tools, which the kernel sets on the notifications.
+func subscribeRoutes(ch chan<- rtUpdate, done <-chan struct{}) error {
+	s, err := gwns.Subscribe(syscall.RTNLGRP_IPV4_ROUTE, syscall.RTNLGRP_IPV6_ROUTE, syscall.RTNLGRP_IPV4_IFADDR, syscall.RTNLGRP_IPV6_IFADDR)
+	if err != nil {
+		return err
+	}
+	// closing the socket does not unblock a receive in progress, it times out to check done
+	tv := unix.NsecToTimeval(subscribeTimeout.Nanoseconds())
+	err = s.SetReceiveTimeout(&tv)
+	if err != nil {
+		s.Close()
+		return err
+	}
+	go func() {
+		defer close(ch)
+		defer s.Close()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			msgs, _, err := s.Receive()
+			if err == syscall.EAGAIN || err == syscall.EINTR {
+				continue
+			}
+			if err != nil {
+				return
+			}
+			for _, m := range msgs {
+				u, ok := parseUpdate(m)
+				if !ok {
+					continue
+				}
+				select {
+				case ch <- u:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// parseUpdate parses the attributes of a route or address notification used by the audit
+func parseUpdate(m syscall.NetlinkMessage) (rtUpdate, bool) {
+	u := rtUpdate{typ: m.Header.Type, by: m.Header.Pid}
+	native := nl.NativeEndian()
+	switch m.Header.Type {
+	case syscall.RTM_NEWROUTE, syscall.RTM_DELROUTE:
+		if len(m.Data) < syscall.SizeofRtMsg {
+			return u, false
+		}
+		msg := nl.DeserializeRtMsg(m.Data)
+		u.route = netlink.Route{Protocol: int(msg.Protocol), Type: int(msg.Type), Table: int(msg.Table), Scope: netlink.Scope(msg.Scope)}
+		attrs, err := nl.ParseRouteAttr(m.Data[msg.Len():])
+		if err != nil {
+			return u, false
+		}
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.RTA_DST:
+				u.route.Dst = &net.IPNet{IP: append(net.IP{}, a.Value...), Mask: net.CIDRMask(int(msg.Dst_len), 8*len(a.Value))}
+			case syscall.RTA_GATEWAY:
+				u.route.Gw = append(net.IP{}, a.Value...)
+			case syscall.RTA_OIF:
+				u.route.LinkIndex = int(native.Uint32(a.Value[0:4]))
+			case syscall.RTA_PRIORITY:
+				u.route.Priority = int(native.Uint32(a.Value[0:4]))
+			case syscall.RTA_TABLE:
+				u.route.Table = int(native.Uint32(a.Value[0:4]))
+			}
+		}
+		return u, true
+	case syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+		if len(m.Data) < syscall.SizeofIfAddrmsg {
+			return u, false
+		}
+		msg := nl.DeserializeIfAddrmsg(m.Data)
+		u.linkIndex = int(msg.Index)
+		attrs, err := nl.ParseRouteAttr(m.Data[msg.Len():])
+		if err != nil {
+			return u, false
+		}
+		for _, a := range attrs {
+			// IFA_LOCAL is the address of an ipv4 point to point link, IFA_ADDRESS its peer
+			if a.Attr.Type == syscall.IFA_LOCAL || (a.Attr.Type == syscall.IFA_ADDRESS && u.addr == nil) {
+				u.addr = &net.IPNet{IP: append(net.IP{}, a.Value...), Mask: net.CIDRMask(int(msg.Prefixlen), 8*len(a.Value))}
+			}
+		}
+		return u, u.addr != nil
+	}
+	return u, false
+}
+
+// sender describes the process with the netlink port id, the kernel for 0. A port id is the
+// process id of the first netlink socket of a process, later ones are not found.
+func sender(portid uint32) string {
+	if portid == 0 {
+		return "kernel"
+	}
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", portid))
+	if err != nil {
+		return fmt.Sprintf("netlink port %d", portid)
+	}
+	return fmt.Sprintf("%v (pid %d)", strings.TrimSpace(string(comm)), portid)
+}
@@ -0,0 +1,29 @@
+package host
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscribeRoutesDone(t *testing.T) {
+	ch := make(chan rtUpdate)
+	done := make(chan struct{})
+	if err := subscribeRoutes(ch, done); err != nil {
+		t.Skipf("can not subscribe to route changes: %v", err)
+	}
+	// let the subscription block in a receive, without route changes it ends on its timeout
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+
+	timeout := time.After(3 * subscribeTimeout)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("subscription did not end after done was closed")
+		}
+	}
+}
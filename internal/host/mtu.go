@@ -0,0 +1,86 @@
+package host
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// mtuOpt is the network option setting the mtu of the vxlan, and so of the host and container macvlans
+const mtuOpt = "mtu"
+
+// underlayLimit returns the largest mtu a vxlan with opts can have, that of its
+// underlay device less the encapsulation overhead
+func underlayLimit(opts map[string]string) (int, error) {
+	ul, v6, err := underlayLink(opts)
+	if err != nil {
+		return 0, err
+	}
+	overhead := encapOverhead4
+	if v6 {
+		overhead = encapOverhead6
+	}
+	return ul.Attrs().MTU - overhead, nil
+}
+
+// mtuOptions resolves the mtu option of the new vxlan name into the vxlanmtu option. Without
+// either, the mtu is derived from the underlay device, as the kernel only does so for a vxlan
+// given its vtep device. An explicit vxlanmtu takes precedence.
+func mtuOptions(name string, opts map[string]string) map[string]string {
+	if optOrEnv(opts, "vxlanmtu") != "" {
+		return opts
+	}
+	if _, err := gwns.Handle().LinkByName(name); err == nil {
+		// an existing vxlan keeps its settings
+		return opts
+	}
+	log := log.WithField("Interface", name).WithField("Func", "mtuOptions()")
+	log.Debug()
+
+	mtu := optOrEnv(opts, mtuOpt)
+	if mtu == "" {
+		limit, err := underlayLimit(opts)
+		if err != nil || limit <= 0 {
+			log.WithError(err).Debug("failed to derive the mtu from the underlay, leaving it to the kernel")
+			return opts
+		}
+		mtu = strconv.Itoa(limit)
+		log.WithField("mtu", mtu).Debug("derived mtu from the underlay")
+	}
+
+	ret := make(map[string]string, len(opts)+1)
+	for k, v := range opts {
+		ret[k] = v
+	}
+	ret["vxlanmtu"] = mtu
+	return ret
+}
+
+// CheckMTU checks that the mtu network option, if set, fits within the underlay of the network.
+// An underlay which can not be found is not an error, it may only exist once the network is used.
+func CheckMTU(opts map[string]string) error {
+	v := opts[mtuOpt]
+	if v == "" {
+		return nil
+	}
+	mtu, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid mtu %q: %v", v, err)
+	}
+	opts, err = fabricOptions("", opts)
+	if err != nil {
+		return err
+	}
+	limit, err := underlayLimit(opts)
+	if err != nil {
+		log.WithError(err).Debug("failed to find underlay device, not checking the mtu")
+		return nil
+	}
+	if mtu > limit {
+		return fmt.Errorf("mtu %v exceeds %v, the mtu of the underlay less the vxlan overhead", mtu, limit)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/macvlan"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+)
+
+// Neighbors returns the known neighbors of the host interface, both remote ones
+// learned from traffic and local ones, which are given this host's tunnel endpoint
+func (hi *Interface) Neighbors() ([]neigh.Entry, error) {
+	log := hi.log.WithField("Func", "Neighbors()")
+	log.Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	vni, local, err := hi.dp.VTEP()
+	if err != nil {
+		return nil, err
+	}
+
+	vteps, err := hi.remoteVTEPs()
+	if err != nil {
+		log.WithError(err).Error("failed to list forwarding entries")
+		return nil, err
+	}
+
+	neighs, err := nlh.NeighList(hi.mvl.GetIndex(), netlink.FAMILY_ALL)
+	if err != nil {
+		log.WithError(err).Error("failed to list neighbors")
+		return nil, err
+	}
+	ret := []neigh.Entry{}
+	for _, n := range neighs {
+		if n.HardwareAddr == nil || n.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED|netlink.NUD_NOARP) != 0 {
+			continue
+		}
+		vtep, ok := vteps[n.HardwareAddr.String()]
+		if !ok {
+			vtep = local
+		}
+		if vtep == nil {
+			continue
+		}
+		ret = append(ret, neigh.Entry{
+			Network: hi.name,
+			VNI:     vni,
+			IP:      n.IP.String(),
+			MAC:     n.HardwareAddr.String(),
+			VTEP:    vtep.String(),
+		})
+	}
+	return ret, nil
+}
+
+// ImportNeighbor programs a remote endpoint into the forwarding and neighbor
+// tables, so traffic to it does not wait for flood and learn. The entries are
+// not permanent, the kernel ages and revalidates them as if they were learned.
+// Entries of this host, or of another vxlan id, are not imported.
+func (hi *Interface) ImportNeighbor(e neigh.Entry) (bool, error) {
+	log := hi.log.WithField("Func", "ImportNeighbor()").WithField("ip", e.IP)
+	log.Debug()
+
+	ip := net.ParseIP(e.IP)
+	vtep := net.ParseIP(e.VTEP)
+	mac, err := net.ParseMAC(e.MAC)
+	if ip == nil || vtep == nil || err != nil {
+		return false, fmt.Errorf("invalid neighbor entry %+v", e)
+	}
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	vni, local, err := hi.dp.VTEP()
+	if err != nil {
+		return false, err
+	}
+	if vni != e.VNI || vtep.Equal(local) {
+		return false, nil
+	}
+
+	err = hi.addRemote(mac, vtep)
+	if err != nil {
+		log.WithError(err).Error("failed to add forwarding entry")
+		return false, err
+	}
+
+	err = nlh.NeighSet(&netlink.Neigh{
+		LinkIndex:    hi.mvl.GetIndex(),
+		Family:       family(ip),
+		State:        netlink.NUD_STALE,
+		IP:           ip,
+		HardwareAddr: mac,
+	})
+	if err != nil {
+		log.WithError(err).Error("failed to add neighbor entry")
+		return false, err
+	}
+	return true, nil
+}
+
+// LocalEntries returns the entries of a local container macvlan for its addresses,
+// with this host's tunnel endpoint, for other hosts to import. mac is the MAC docker
+// sets on the endpoint, if nil the macvlan keeps the one the kernel gave it.
+func (hi *Interface) LocalEntries(mvlName string, mac net.HardwareAddr, ips []net.IP) ([]neigh.Entry, error) {
+	log := hi.log.WithField("Func", "LocalEntries()").WithField("macvlan", mvlName)
+	log.Debug()
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	if mac == nil {
+		mvl, err := macvlan.FromName(mvlName)
+		if err != nil {
+			return nil, err
+		}
+		mac, err = mvl.HardwareAddr()
+		if err != nil {
+			return nil, err
+		}
+	}
+	vni, local, err := hi.dp.VTEP()
+	if err != nil {
+		return nil, err
+	}
+	if local == nil {
+		return nil, fmt.Errorf("no tunnel endpoint address on %v", hi.name)
+	}
+
+	ret := []neigh.Entry{}
+	for _, ip := range ips {
+		ret = append(ret, neigh.Entry{
+			Network: hi.name,
+			VNI:     vni,
+			IP:      ip.String(),
+			MAC:     mac.String(),
+			VTEP:    local.String(),
+		})
+	}
+	return ret, nil
+}
+
+// ForgetNeighbor removes the neighbor and forwarding entries of a remote endpoint which
+// left its host. They are only removed while they still point to its MAC and tunnel
+// endpoint, the address may already have been taken by another endpoint.
+func (hi *Interface) ForgetNeighbor(e neigh.Entry) error {
+	log := hi.log.WithField("Func", "ForgetNeighbor()").WithField("ip", e.IP)
+	log.Debug()
+
+	ip := net.ParseIP(e.IP)
+	vtep := net.ParseIP(e.VTEP)
+	mac, err := net.ParseMAC(e.MAC)
+	if ip == nil || vtep == nil || err != nil {
+		return fmt.Errorf("invalid neighbor entry %+v", e)
+	}
+
+	hi.l.rlock()
+	defer hi.l.runlock()
+
+	neighs, err := nlh.NeighList(hi.mvl.GetIndex(), family(ip))
+	if err != nil {
+		log.WithError(err).Error("failed to list neighbors")
+		return err
+	}
+	for i := range neighs {
+		n := neighs[i]
+		if !n.IP.Equal(ip) || n.HardwareAddr.String() != mac.String() {
+			continue
+		}
+		if err = nlh.NeighDel(&n); err != nil && err != syscall.ENOENT {
+			log.WithError(err).Error("failed to delete neighbor")
+			return err
+		}
+	}
+
+	err = hi.delRemote(mac, vtep)
+	if err != nil {
+		log.WithError(err).Error("failed to delete forwarding entry")
+	}
+	return err
+}
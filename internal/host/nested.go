@@ -0,0 +1,159 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// nestedOpt is the network option disabling nested overlay detection, auto or off
+const nestedOpt = "nested"
+
+// the vxlan encapsulation overhead on an ipv4 and ipv6 underlay,
+// the outer ip, udp and vxlan headers and the inner ethernet header
+const (
+	encapOverhead4 = 50
+	encapOverhead6 = 70
+	// underlayMTU is the mtu an underlay which is not itself an overlay is expected to have at least
+	underlayMTU = 1500
+)
+
+// overlayTypes are the link types of tunnel devices, which an underlay of this type is an overlay itself
+var overlayTypes = map[string]bool{
+	"vxlan":     true,
+	"geneve":    true,
+	"gretap":    true,
+	"ip6gretap": true,
+	"ipip":      true,
+	"gre":       true,
+	"ip6tnl":    true,
+	"wireguard": true,
+}
+
+// nestedOptions detects an underlay which is an overlay itself, eg. a vxlan, or the nic of a
+// vm on another overlay, recognised by its mtu below 1500. The mtu of the new vxlan name is
+// then reduced to the underlay mtu less the encapsulation overhead, and udp checksums are
+// enabled, as the offloads of the outer overlay do not cover a nested tunnel.
+// Options set explicitly are kept, with a warning if they will drop packets.
+func nestedOptions(name string, opts map[string]string) map[string]string {
+	if opts[nestedOpt] == "off" {
+		return opts
+	}
+	if _, err := gwns.Handle().LinkByName(name); err == nil {
+		// an existing vxlan keeps its settings
+		return opts
+	}
+	log := log.WithField("Interface", name).WithField("Func", "nestedOptions()")
+	log.Debug()
+
+	ul, v6, err := underlayLink(opts)
+	if err != nil {
+		log.WithError(err).Debug("failed to find underlay device, not checking for a nested overlay")
+		return opts
+	}
+	mtu := ul.Attrs().MTU
+	var reason string
+	switch {
+	case overlayTypes[ul.Type()]:
+		reason = fmt.Sprintf("underlay %v is a %v device", ul.Attrs().Name, ul.Type())
+	case mtu > 0 && mtu < underlayMTU:
+		reason = fmt.Sprintf("underlay %v has an mtu of %v, below %v, it is likely an overlay itself", ul.Attrs().Name, mtu, underlayMTU)
+	default:
+		return opts
+	}
+
+	overhead := encapOverhead4
+	if v6 {
+		overhead = encapOverhead6
+	}
+	want := mtu - overhead
+	log = log.WithField("underlay", ul.Attrs().Name).WithField("underlay_mtu", mtu).WithField("overhead", overhead)
+
+	ret := make(map[string]string, len(opts)+2)
+	for k, v := range opts {
+		ret[k] = v
+	}
+
+	set := optOrEnv(opts, "vxlanmtu")
+	if set == "" {
+		set = optOrEnv(opts, mtuOpt)
+	}
+	if set != "" {
+		if m, err := strconv.Atoi(set); err == nil && m > want {
+			log.WithField("vxlanmtu", m).Warnf("nested overlay detected, %v. The configured mtu %v exceeds the underlay mtu %v less the %v byte vxlan overhead, packets larger than %v will be silently dropped. Set mtu=%v",
+				reason, m, mtu, overhead, want, want)
+		}
+	} else {
+		ret["vxlanmtu"] = strconv.Itoa(want)
+		log.Warnf("nested overlay detected, %v. Setting the vxlan mtu to %v, the underlay mtu %v less the %v byte vxlan overhead. Containers see an mtu of %v, set mtu to override",
+			reason, want, mtu, overhead, want)
+	}
+
+	if optOrEnv(opts, "udpcsum") == "" {
+		ret["udpcsum"] = "true"
+		log.Info("enabling udp checksums on the nested vxlan")
+	}
+	return ret
+}
+
+// underlayLink returns the device the vxlan sends on, the vtepdev option, else the device
+// of the source address, else that of the default route, and if the underlay is ipv6
+func underlayLink(opts map[string]string) (netlink.Link, bool, error) {
+	src := net.ParseIP(optOrEnv(opts, "srcaddr"))
+	grp := net.ParseIP(optOrEnv(opts, "group"))
+	v6 := (src != nil && src.To4() == nil) || (grp != nil && grp.To4() == nil)
+
+	if dev := optOrEnv(opts, "vtepdev"); dev != "" {
+		l, err := gwns.Root().LinkByName(dev)
+		return l, v6, err
+	}
+
+	fam := netlink.FAMILY_V4
+	if v6 {
+		fam = netlink.FAMILY_V6
+	}
+	if src != nil {
+		links, err := gwns.Root().LinkList()
+		if err != nil {
+			return nil, v6, err
+		}
+		for _, l := range links {
+			addrs, err := gwns.Root().AddrList(l, fam)
+			if err != nil {
+				continue
+			}
+			for _, a := range addrs {
+				if a.IP.Equal(src) {
+					return l, v6, nil
+				}
+			}
+		}
+	}
+
+	routes, err := gwns.Root().RouteList(nil, fam)
+	if err != nil {
+		return nil, v6, err
+	}
+	for _, r := range routes {
+		if r.Dst == nil && r.LinkIndex > 0 {
+			l, err := gwns.Root().LinkByIndex(r.LinkIndex)
+			return l, v6, err
+		}
+	}
+	return nil, v6, fmt.Errorf("no default route")
+}
+
+// optOrEnv returns a vxlan option, else its VXR_ environment variable, as the vxlan package does
+func optOrEnv(opts map[string]string, k string) string {
+	if v, ok := opts[k]; ok {
+		return v
+	}
+	return os.Getenv(vxrouter.EnvPrefix + k)
+}
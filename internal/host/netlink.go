@@ -0,0 +1,249 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// nlPoolSize is the number of idle netlink handles kept for reuse
+const nlPoolSize = 8
+
+// nlPool hands out long lived netlink handles, so each call does not open
+// and close its own netlink socket. A handle is only used by one caller at a time.
+type nlPool struct {
+	idle chan *netlink.Handle
+
+	// inflight are the operations using a handle, for diagnosing hangs
+	inflightL sync.Mutex
+	inflight  map[*netlink.Handle]nlOp
+}
+
+type nlOp struct {
+	name  string
+	start time.Time
+}
+
+var nlh = &nlPool{
+	idle:     make(chan *netlink.Handle, nlPoolSize),
+	inflight: make(map[*netlink.Handle]nlOp),
+}
+
+// readOnly refuses the netlink operations which change kernel state, see SetReadOnly
+var readOnly bool
+
+// writeOps are the netlink operations refused in read only mode
+var writeOps = map[string]bool{
+	"RouteAdd":     true,
+	"RouteDel":     true,
+	"RouteReplace": true,
+	"NeighDel":     true,
+	"NeighAppend":  true,
+	"NeighSet":     true,
+}
+
+// SetReadOnly refuses all later route and neighbor changes, for observing a host without
+// touching it. It must be called before any other function of the package.
+func SetReadOnly() {
+	readOnly = true
+}
+
+func (p *nlPool) get(op string) (*netlink.Handle, error) {
+	if readOnly && writeOps[op] {
+		return nil, fmt.Errorf("netlink %v refused, running read only", op)
+	}
+	var h *netlink.Handle
+	select {
+	case h = <-p.idle:
+	default:
+		var err error
+		h, err = gwns.NewHandle()
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.inflightL.Lock()
+	p.inflight[h] = nlOp{op, time.Now()}
+	p.inflightL.Unlock()
+	return h, nil
+}
+
+// put returns h to the pool, unless err shows its socket may be out of sync
+// (ENOBUFS drops messages, EINTR can leave a reply unread), then it is recreated on demand
+func (p *nlPool) put(h *netlink.Handle, err error) {
+	p.inflightL.Lock()
+	delete(p.inflight, h)
+	p.inflightL.Unlock()
+
+	if err == syscall.ENOBUFS || err == syscall.EINTR {
+		log.WithError(err).Debug("discarding netlink handle")
+		h.Delete()
+		return
+	}
+	select {
+	case p.idle <- h:
+	default:
+		h.Delete()
+	}
+}
+
+func (p *nlPool) RouteAdd(route *netlink.Route) error {
+	h, err := p.get("RouteAdd")
+	if err != nil {
+		return err
+	}
+	err = h.RouteAdd(route)
+	p.put(h, err)
+	return err
+}
+
+func (p *nlPool) RouteDel(route *netlink.Route) error {
+	h, err := p.get("RouteDel")
+	if err != nil {
+		return err
+	}
+	err = h.RouteDel(route)
+	p.put(h, err)
+	return err
+}
+
+func (p *nlPool) RouteReplace(route *netlink.Route) error {
+	h, err := p.get("RouteReplace")
+	if err != nil {
+		return err
+	}
+	err = h.RouteReplace(route)
+	p.put(h, err)
+	return err
+}
+
+func (p *nlPool) RouteList(link netlink.Link, family int) ([]netlink.Route, error) {
+	h, err := p.get("RouteList")
+	if err != nil {
+		return nil, err
+	}
+	routes, err := h.RouteList(link, family)
+	p.put(h, err)
+	return routes, err
+}
+
+func (p *nlPool) RouteListFiltered(family int, filter *netlink.Route, filterMask uint64) ([]netlink.Route, error) {
+	h, err := p.get("RouteListFiltered")
+	if err != nil {
+		return nil, err
+	}
+	routes, err := h.RouteListFiltered(family, filter, filterMask)
+	p.put(h, err)
+	return routes, err
+}
+
+func (p *nlPool) RouteGet(destination net.IP) ([]netlink.Route, error) {
+	h, err := p.get("RouteGet")
+	if err != nil {
+		return nil, err
+	}
+	routes, err := h.RouteGet(destination)
+	p.put(h, err)
+	return routes, err
+}
+
+func (p *nlPool) NeighList(linkIndex, family int) ([]netlink.Neigh, error) {
+	h, err := p.get("NeighList")
+	if err != nil {
+		return nil, err
+	}
+	neighs, err := h.NeighList(linkIndex, family)
+	p.put(h, err)
+	return neighs, err
+}
+
+func (p *nlPool) NeighDel(neigh *netlink.Neigh) error {
+	h, err := p.get("NeighDel")
+	if err != nil {
+		return err
+	}
+	err = h.NeighDel(neigh)
+	p.put(h, err)
+	return err
+}
+
+func (p *nlPool) NeighAppend(neigh *netlink.Neigh) error {
+	h, err := p.get("NeighAppend")
+	if err != nil {
+		return err
+	}
+	err = h.NeighAppend(neigh)
+	p.put(h, err)
+	return err
+}
+
+func (p *nlPool) NeighSet(neigh *netlink.Neigh) error {
+	h, err := p.get("NeighSet")
+	if err != nil {
+		return err
+	}
+	err = h.NeighSet(neigh)
+	p.put(h, err)
+	return err
+}
+
+func (p *nlPool) LinkByName(name string) (netlink.Link, error) {
+	h, err := p.get("LinkByName")
+	if err != nil {
+		return nil, err
+	}
+	link, err := h.LinkByName(name)
+	p.put(h, err)
+	return link, err
+}
+
+func (p *nlPool) LinkByIndex(index int) (netlink.Link, error) {
+	h, err := p.get("LinkByIndex")
+	if err != nil {
+		return nil, err
+	}
+	link, err := h.LinkByIndex(index)
+	p.put(h, err)
+	return link, err
+}
+
+func (p *nlPool) LinkList() ([]netlink.Link, error) {
+	h, err := p.get("LinkList")
+	if err != nil {
+		return nil, err
+	}
+	links, err := h.LinkList()
+	p.put(h, err)
+	return links, err
+}
+
+// PendingNetlinkOps returns the netlink operations currently in progress, longest running first
+func PendingNetlinkOps() []string {
+	nlh.inflightL.Lock()
+	ops := make([]nlOp, 0, len(nlh.inflight))
+	for _, op := range nlh.inflight {
+		ops = append(ops, op)
+	}
+	nlh.inflightL.Unlock()
+
+	sort.Slice(ops, func(i, j int) bool { return ops[i].start.Before(ops[j].start) })
+	ret := make([]string, 0, len(ops))
+	for _, op := range ops {
+		ret = append(ret, fmt.Sprintf("%v for %v", op.name, time.Since(op.start).Round(time.Millisecond)))
+	}
+	return ret
+}
+
+// RouteCount returns the number of routes in the main tables of both families
+func RouteCount() (int, error) {
+	routes, err := nlh.RouteList(nil, netlink.FAMILY_ALL)
+	return len(routes), err
+}
@@ -0,0 +1,173 @@
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// Network managers which may take over interfaces created by vxrouter
+const (
+	NetworkManager = "NetworkManager"
+	Networkd       = "systemd-networkd"
+)
+
+// runtime state and configuration paths of the network managers
+var (
+	nmRunDir       = "/run/NetworkManager"
+	nmDevicesDir   = "/run/NetworkManager/devices"
+	nmHintFile     = "/run/NetworkManager/conf.d/90-vxrouter.conf"
+	networkdRunDir = "/run/systemd/netif"
+	networkdLinks  = "/run/systemd/netif/links"
+	networkdHint   = "/run/systemd/network/10-vxrouter.network"
+)
+
+// hinted are the names of the vxlan interfaces written to the unmanaged hints.
+// Macvlans are covered by their name prefixes.
+var hinted = struct {
+	sync.Mutex
+	enabled bool
+	names   map[string]bool
+}{enabled: true, names: make(map[string]bool)}
+
+// SetManagerHints enables or disables writing unmanaged interface hints for network managers
+func SetManagerHints(enabled bool) {
+	hinted.Lock()
+	defer hinted.Unlock()
+	hinted.enabled = enabled
+}
+
+// NetworkManagers returns the network managers running on this host
+func NetworkManagers() []string {
+	ret := []string{}
+	if _, err := os.Stat(nmRunDir); err == nil {
+		ret = append(ret, NetworkManager)
+	}
+	if _, err := os.Stat(networkdRunDir); err == nil {
+		ret = append(ret, Networkd)
+	}
+	return ret
+}
+
+// hintUnmanaged adds the vxlan interface name to the unmanaged hints of the running network
+// managers, so they leave it and the host and container macvlans alone. The hints are written
+// to their runtime configuration, and take effect when the manager next reloads it.
+func hintUnmanaged(name string) {
+	hinted.Lock()
+	defer hinted.Unlock()
+	if !hinted.enabled || hinted.names[name] {
+		return
+	}
+	hinted.names[name] = true
+
+	names := []string{}
+	for n := range hinted.names {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, m := range NetworkManagers() {
+		var err error
+		switch m {
+		case NetworkManager:
+			specs := []string{"interface-name:hmvl_*", "interface-name:cmvl_*"}
+			for _, n := range names {
+				specs = append(specs, "interface-name:"+n)
+			}
+			err = writeHint(nmHintFile, "# written by vxrouter\n[keyfile]\nunmanaged-devices="+strings.Join(specs, ";")+"\n")
+		case Networkd:
+			err = writeHint(networkdHint, "# written by vxrouter\n[Match]\nName=hmvl_* cmvl_* "+strings.Join(names, " ")+"\n\n[Link]\nUnmanaged=yes\n")
+		}
+		if err != nil {
+			log.WithField("manager", m).WithError(err).Warn("failed to write unmanaged interface hints")
+		}
+	}
+}
+
+func writeHint(path, content string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// ManagerConflicts returns the ways network managers are interfering with the host interface:
+// managing the vxlan or host macvlan, which they may reconfigure or flush at any time,
+// or managing the underlay device the vxlan is bound to, which may change its address.
+func (hi *Interface) ManagerConflicts() []string {
+	ret := []string{}
+	links := map[string]string{"vxlan": hi.dp.Name(), "host macvlan": "hmvl_" + hi.name}
+	if l, err := nlh.LinkByName(hi.dp.Name()); err == nil {
+		if vx, ok := l.(*netlink.Vxlan); ok && vx.VtepDevIndex != 0 {
+			if dev, err := gwns.Root().LinkByIndex(vx.VtepDevIndex); err == nil {
+				links["underlay"] = dev.Attrs().Name
+			}
+		}
+	}
+	// network managers only see the root namespace
+	if gwns.Enabled() {
+		delete(links, "vxlan")
+		delete(links, "host macvlan")
+	}
+
+	for role, name := range links {
+		l, err := gwns.Root().LinkByName(name)
+		if err != nil {
+			continue
+		}
+		for _, m := range managersOf(l.Attrs().Index) {
+			ret = append(ret, fmt.Sprintf("%v %v is managed by %v", role, name, m))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// managersOf returns the network managers actively managing the link at index
+func managersOf(index int) []string {
+	ret := []string{}
+	i := strconv.Itoa(index)
+
+	// NetworkManager keeps a state file for each device, with managed=true if it manages it
+	if b, err := ioutil.ReadFile(filepath.Join(nmDevicesDir, i)); err == nil {
+		if stateValue(string(b), "managed") == "true" {
+			ret = append(ret, NetworkManager)
+		}
+	}
+
+	// networkd keeps a state file for each link, with its admin state
+	if b, err := ioutil.ReadFile(filepath.Join(networkdLinks, i)); err == nil {
+		switch stateValue(string(b), "ADMIN_STATE") {
+		case "configuring", "configured", "failed":
+			ret = append(ret, Networkd)
+		}
+	}
+	return ret
+}
+
+// stateValue returns the value of key in a key=value state file
+func stateValue(s, key string) string {
+	for _, l := range strings.Split(s, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(l), "=", 2)
+		if len(kv) == 2 && kv[0] == key {
+			return kv[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// parentOpt is the network option selecting the underlay interface of the vxlan
+const parentOpt = "parent"
+
+// parentAlias marks the vlan sub-interfaces created for the parent option, which
+// are deleted with the last vxlan on them
+const parentAlias = "vxrouter-parent"
+
+// ParseParent parses a parent option, an interface name, or dev.vlan for a vlan
+// sub-interface which is created if it does not exist, returning the vlan id or 0
+func ParseParent(s string) (string, int, error) {
+	if s == "" || len(s) > 15 || strings.ContainsAny(s, "/: \t") {
+		return "", 0, fmt.Errorf("invalid parent interface %q", s)
+	}
+	i := strings.LastIndex(s, ".")
+	if i < 0 {
+		return s, 0, nil
+	}
+	vid, err := strconv.Atoi(s[i+1:])
+	if err != nil || i == 0 || vid < 1 || vid > 4094 {
+		return "", 0, fmt.Errorf("invalid parent interface %q, a vlan sub-interface must be dev.vlan with a vlan id from 1 to 4094", s)
+	}
+	return s[:i], vid, nil
+}
+
+// parentOptions resolves the parent option of the vxlan name into vtepdev, which
+// it overrides, and unless set srcaddr options. A vlan sub-interface parent is
+// created on its device if it does not exist.
+func parentOptions(name string, opts map[string]string) (map[string]string, error) {
+	parent := strings.TrimSpace(opts[parentOpt])
+	if parent == "" {
+		return opts, nil
+	}
+	log := log.WithField("Interface", name).WithField("Func", "parentOptions()").WithField("parent", parent)
+	log.Debug()
+
+	dev, vid, err := ParseParent(parent)
+	if err != nil {
+		return nil, err
+	}
+	link, err := gwns.Root().LinkByName(parent)
+	if err != nil {
+		if vid == 0 {
+			return nil, vxrerrors.NotFound("parent interface %v not found", parent)
+		}
+		link, err = createParentVlan(parent, dev, vid)
+		if err != nil {
+			return nil, err
+		}
+		log.WithField("vlan", vid).Info("created vlan sub-interface for parent")
+	}
+
+	ret := make(map[string]string, len(opts)+2)
+	for k, v := range opts {
+		ret[k] = v
+	}
+	ret["vtepdev"] = parent
+	if optOrEnv(opts, "srcaddr") != "" {
+		return ret, nil
+	}
+
+	grp := net.ParseIP(optOrEnv(opts, "group"))
+	fam := netlink.FAMILY_V4
+	if grp != nil && grp.To4() == nil {
+		fam = netlink.FAMILY_V6
+	}
+	addrs, err := gwns.Root().AddrList(link, fam)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if a.IP.IsGlobalUnicast() {
+			ret["srcaddr"] = a.IP.String()
+			return ret, nil
+		}
+	}
+	log.Debug("parent interface has no address, the kernel selects the source address")
+	return ret, nil
+}
+
+// createParentVlan creates and brings up the vlan sub-interface name of vid on dev
+func createParentVlan(name, dev string, vid int) (netlink.Link, error) {
+	base, err := gwns.Root().LinkByName(dev)
+	if err != nil {
+		return nil, vxrerrors.NotFound("parent interface %v of vlan sub-interface %v not found", dev, name)
+	}
+	vl := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: base.Attrs().Index},
+		VlanId:    vid,
+	}
+	if err = gwns.Root().LinkAdd(vl); err != nil {
+		return nil, err
+	}
+	link, err := gwns.Root().LinkByName(name)
+	if err == nil {
+		err = gwns.Root().LinkSetAlias(link, parentAlias)
+	}
+	if err == nil {
+		err = gwns.Root().LinkSetUp(link)
+	}
+	if err != nil {
+		gwns.Root().LinkDel(vl) // nolint: errcheck
+		return nil, err
+	}
+	return link, nil
+}
+
+// releaseParent deletes the vlan sub-interface at index if it was created for the
+// parent option and no vxlan uses it anymore
+func releaseParent(index int) {
+	if index == 0 {
+		return
+	}
+	link, err := gwns.Root().LinkByIndex(index)
+	if err != nil || link.Type() != "vlan" || link.Attrs().Alias != parentAlias {
+		return
+	}
+	log := log.WithField("parent", link.Attrs().Name).WithField("Func", "releaseParent()")
+
+	hs := []*netlink.Handle{gwns.Root()}
+	if gwns.Handle() != gwns.Root() {
+		hs = append(hs, gwns.Handle())
+	}
+	for _, h := range hs {
+		links, err := h.LinkList()
+		if err != nil {
+			log.WithError(err).Debug("failed to list links")
+			return
+		}
+		for _, l := range links {
+			if vxl, ok := l.(*netlink.Vxlan); ok && vxl.VtepDevIndex == index {
+				return
+			}
+		}
+	}
+	if err = gwns.Root().LinkDel(link); err != nil {
+		log.WithError(err).Error("failed to delete vlan sub-interface of parent")
+		return
+	}
+	log.Info("deleted vlan sub-interface of parent")
+}
+
+// vtepDevIndex returns the index of the vtep device of the vxlan, 0 if it has none
+func (hi *Interface) vtepDevIndex() int {
+	link, err := nlh.LinkByIndex(hi.dp.GetIndex())
+	if err != nil {
+		return 0
+	}
+	if vxl, ok := link.(*netlink.Vxlan); ok {
+		return vxl.VtepDevIndex
+	}
+	return 0
+}
@@ -0,0 +1,255 @@
+package host
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/ovs"
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+)
+
+// floodMAC is the MAC of the forwarding entries unknown and broadcast traffic is flooded to
+var floodMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+
+var (
+	floodPeers    []net.IP
+	floodPeersSet bool
+	// floodPeersByVNI, if set, are the peers of each vxlan id, instead of floodPeers for all
+	floodPeersByVNI map[int][]net.IP
+	floodPeersL     sync.RWMutex
+)
+
+// SetFloodPeers sets the tunnel endpoints of the other hosts, which unknown and broadcast
+// traffic of every vxlan is flooded to with unicast forwarding entries, and updates the
+// vxlans of this host. Until it is first called the flood entries of vxlans are left alone.
+func SetFloodPeers(peers []net.IP) error {
+	ps := sortedIPs(peers)
+
+	floodPeersL.Lock()
+	changed := !floodPeersSet || floodPeersByVNI != nil || !ipsEqual(floodPeers, ps)
+	floodPeers, floodPeersByVNI, floodPeersSet = ps, nil, true
+	floodPeersL.Unlock()
+	if !changed {
+		return nil
+	}
+	log.WithField("peers", ps).Info("flood peers changed")
+	return syncAllFloodPeers()
+}
+
+// SetVNIFloodPeers is SetFloodPeers with the peers of each vxlan id, those serving it.
+// A vxlan with an id without peers floods to none.
+func SetVNIFloodPeers(peers map[int][]net.IP) error {
+	byVNI := make(map[int][]net.IP, len(peers))
+	for vni, ps := range peers {
+		byVNI[vni] = sortedIPs(ps)
+	}
+
+	floodPeersL.Lock()
+	changed := !floodPeersSet || floodPeersByVNI == nil || len(byVNI) != len(floodPeersByVNI)
+	for vni, ps := range byVNI {
+		if !changed && !ipsEqual(floodPeersByVNI[vni], ps) {
+			changed = true
+		}
+	}
+	floodPeers, floodPeersByVNI, floodPeersSet = nil, byVNI, true
+	floodPeersL.Unlock()
+	if !changed {
+		return nil
+	}
+	log.WithField("peers", byVNI).Info("flood peers changed")
+	return syncAllFloodPeers()
+}
+
+// syncAllFloodPeers updates the flood entries of all vxlans of this plugin, and the vxlan ports of its bridges
+func syncAllFloodPeers() error {
+	vxls, err := vxrouterVxlans()
+	if err != nil {
+		return err
+	}
+	for _, vxl := range vxls {
+		if err = syncFloodPeers(vxl); err != nil {
+			log.WithField("vxlan", vxl.Name).WithError(err).Error("failed to update flood peers")
+		}
+	}
+	brs, err := ovs.Bridges()
+	if err != nil {
+		return err
+	}
+	for _, br := range brs {
+		if err = syncBridgePeers(br); err != nil {
+			log.WithField("bridge", br.Name()).WithError(err).Error("failed to update flood peers")
+		}
+	}
+	return nil
+}
+
+// vxrouterVxlans returns the vxlans of this plugin, those with a host macvlan
+func vxrouterVxlans() ([]*netlink.Vxlan, error) {
+	links, err := nlh.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	vxrouter := make(map[int]bool)
+	for _, link := range links {
+		if mvl, ok := link.(*netlink.Macvlan); ok && isHostMacvlan(mvl) {
+			vxrouter[mvl.ParentIndex] = true
+		}
+	}
+	ret := []*netlink.Vxlan{}
+	for _, link := range links {
+		if vxl, ok := link.(*netlink.Vxlan); ok && vxrouter[vxl.Index] {
+			ret = append(ret, vxl)
+		}
+	}
+	return ret, nil
+}
+
+// LocalVTEPs returns the local tunnel endpoint of each vxlan id served by this host
+func LocalVTEPs() (map[int]net.IP, error) {
+	vxls, err := vxrouterVxlans()
+	if err != nil {
+		return nil, err
+	}
+	dps := []datapath{}
+	for _, nl := range vxls {
+		vxl, err := vxlan.FromLink(nl)
+		if err != nil {
+			continue
+		}
+		dps = append(dps, vxl)
+	}
+	brs, err := ovs.Bridges()
+	if err != nil {
+		return nil, err
+	}
+	for _, br := range brs {
+		dps = append(dps, br)
+	}
+	ret := make(map[int]net.IP, len(dps))
+	for _, dp := range dps {
+		vni, local, err := dp.VTEP()
+		if err != nil || local == nil {
+			continue
+		}
+		ret[vni] = local
+	}
+	return ret, nil
+}
+
+func sortedIPs(ips []net.IP) []net.IP {
+	ps := make([]net.IP, len(ips))
+	copy(ps, ips)
+	sort.Slice(ps, func(i, j int) bool { return ps[i].String() < ps[j].String() })
+	return ps
+}
+
+func ipsEqual(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// vxlanFloodPeers returns the flood peers of vxl, and whether they were set
+func vxlanFloodPeers(vxl *netlink.Vxlan) ([]net.IP, bool) {
+	return vniFloodPeers(vxl.VxlanId)
+}
+
+// vniFloodPeers returns the flood peers of the vxlan id vni, and whether they were set
+func vniFloodPeers(vni int) ([]net.IP, bool) {
+	floodPeersL.RLock()
+	defer floodPeersL.RUnlock()
+	if floodPeersByVNI != nil {
+		return floodPeersByVNI[vni], floodPeersSet
+	}
+	return floodPeers, floodPeersSet
+}
+
+// syncFloodPeers adds the flood entries of vxl missing for the peers, and removes those of
+// peers which left. The entry of the group or default remote of the vxlan is kept, as are
+// entries to peers of the other address family. The vxlan of a layer 3 only network floods
+// to none.
+func syncFloodPeers(vxl *netlink.Vxlan) error {
+	peers, set := vxlanFloodPeers(vxl)
+	if !set {
+		return nil
+	}
+	if isL3(vxl) {
+		peers = nil
+	}
+	log := log.WithField("vxlan", vxl.Name).WithField("Func", "syncFloodPeers()")
+	log.Debug()
+
+	v6 := vxl.Group != nil && vxl.Group.To4() == nil || vxl.SrcAddr != nil && vxl.SrcAddr.To4() == nil
+	want := make(map[string]net.IP)
+	for _, p := range peers {
+		if (p.To4() == nil) == v6 && !p.Equal(vxl.SrcAddr) {
+			want[p.String()] = p
+		}
+	}
+
+	fdb, err := nlh.NeighList(vxl.Index, syscall.AF_BRIDGE)
+	if err != nil {
+		return err
+	}
+	for i := range fdb {
+		f := fdb[i]
+		if f.HardwareAddr.String() != floodMAC.String() || f.IP == nil || f.IP.Equal(vxl.Group) {
+			continue
+		}
+		if _, ok := want[f.IP.String()]; ok {
+			delete(want, f.IP.String())
+			continue
+		}
+		if (f.IP.To4() == nil) != v6 {
+			continue
+		}
+		if err = nlh.NeighDel(&f); err != nil && err != syscall.ENOENT {
+			return err
+		}
+		log.WithField("peer", f.IP).Debug("removed flood peer")
+	}
+
+	for _, p := range want {
+		err = nlh.NeighAppend(&netlink.Neigh{
+			LinkIndex:    vxl.Index,
+			Family:       syscall.AF_BRIDGE,
+			Flags:        netlink.NTF_SELF,
+			State:        netlink.NUD_PERMANENT | netlink.NUD_NOARP,
+			HardwareAddr: floodMAC,
+			IP:           p,
+		})
+		if err != nil && err != syscall.EEXIST {
+			return err
+		}
+		log.WithField("peer", p).Debug("added flood peer")
+	}
+	return nil
+}
+
+// addFloodPeers adds the flood entries of the peers to the vxlan of a new host interface,
+// or the vxlan ports to them to its bridge
+func (hi *Interface) addFloodPeers() error {
+	if br, ok := hi.dp.(*ovs.Bridge); ok {
+		return syncBridgePeers(br)
+	}
+	link, err := nlh.LinkByIndex(hi.dp.GetIndex())
+	if err != nil {
+		return err
+	}
+	vxl, ok := link.(*netlink.Vxlan)
+	if !ok {
+		return nil
+	}
+	return syncFloodPeers(vxl)
+}
@@ -0,0 +1,89 @@
+package host
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+)
+
+// PlanInterfaces returns the links GetOrCreateInterface would create for the network name,
+// without creating them
+func PlanInterfaces(name string, opts map[string]string) ([]string, error) {
+	vxlName, err := vxlanName(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	if parent := strings.TrimSpace(opts[parentOpt]); parent != "" {
+		_, vid, err := ParseParent(parent)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = gwns.Root().LinkByName(parent); err != nil && vid > 0 {
+			ret = append(ret, parent)
+		}
+	}
+	if _, err = nlh.LinkByName(vxlName); err != nil {
+		ret = append(ret, vxlName)
+	}
+	if _, err = nlh.LinkByName("hmvl_" + name); err != nil {
+		ret = append(ret, "hmvl_"+name)
+	}
+	return ret, nil
+}
+
+// PeekAddresses returns the next count candidates SelectAddress would try for an
+// unrequested address, from the block of opts if set, without claiming any
+func PeekAddresses(opts *SelectOpts, count int) ([]net.IP, error) {
+	p, ok := opts.Allocator.(allocator.Peeker)
+	if !ok {
+		return nil, fmt.Errorf("the allocator can not preview its candidates")
+	}
+	block := opts.Block
+	if block == nil {
+		block = opts.Range
+	}
+	if block == nil {
+		return p.Peek(opts.Subnet, opts.ExcludeFirst, opts.ExcludeLast, count)
+	}
+	bxf, bxl := blockExclusions(opts.Subnet, block, opts.ExcludeFirst, opts.ExcludeLast)
+	return p.Peek(block, bxf, bxl, count)
+}
+
+// CheckAddress returns why SelectAddress would not select ip, or an empty string if it
+// would, without claiming it. Duplicate address detection and claims are not checked.
+func CheckAddress(ip net.IP, requested bool, opts *SelectOpts) (string, error) {
+	if !opts.Subnet.Contains(ip) {
+		return "not in the subnet", nil
+	}
+	if opts.Gateway != nil && opts.Gateway.Equal(ip) {
+		return "the network gateway", nil
+	}
+	if !requested && inRanges(ip, opts.Exclude) {
+		return "excluded", nil
+	}
+	if opts.Reserved != nil && opts.Reserved(ip) {
+		return "reserved by another host", nil
+	}
+	_, a := getIPNets(ip, opts.Subnet)
+	n, err := numRoutesTo(a)
+	if err != nil {
+		return "", err
+	}
+	if n > 0 {
+		if requested && isQuarantined(ip) {
+			return "", nil
+		}
+		return "routed", nil
+	}
+	return "", nil
+}
+
+// RouteExists returns whether there is a route to dst
+func RouteExists(dst *net.IPNet) (bool, error) {
+	n, err := numRoutesTo(dst)
+	return n > 0, err
+}
@@ -0,0 +1,165 @@
+package host
+
+import (
+	"net"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+	"github.com/TrilliumIT/vxrouter/internal/macvlan"
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// PrepopulateNeighbors watches for host routes to containers on other hosts, in the subnet
+// of a host interface, and imports a neighbor and forwarding entry for each, so the first
+// packets to them do not wait for flood and learn. The entries are removed with the route.
+// mac returns the MAC of the endpoint with an address, nil if it can not be known. The
+// owning host's tunnel endpoint is taken to be the nexthop of the route, as when hosts
+// route to each other over the underlay. It runs until done is closed.
+func PrepopulateNeighbors(mac func(net.IP) net.HardwareAddr, done <-chan struct{}) error {
+	log := log.WithField("Func", "PrepopulateNeighbors()")
+	log.Debug()
+
+	ruc := make(chan netlink.RouteUpdate)
+	err := gwns.RouteSubscribe(ruc, done)
+	if err != nil {
+		log.WithError(err).Error("failed to subscribe to route updates")
+		return err
+	}
+
+	known := make(map[string]neigh.Entry)
+	routes, err := nlh.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Type: syscall.RTN_UNICAST}, netlink.RT_FILTER_TYPE)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return err
+	}
+	for _, r := range routes {
+		prepopulate(known, r, mac)
+	}
+
+	for ru := range ruc {
+		switch ru.Type {
+		case syscall.RTM_NEWROUTE:
+			prepopulate(known, ru.Route, mac)
+		case syscall.RTM_DELROUTE:
+			unpopulate(known, ru.Route)
+		}
+	}
+
+	return nil
+}
+
+// remoteHostRoute reports whether r is an IPv4 host route through a nexthop, not one of ours
+func remoteHostRoute(r netlink.Route) bool {
+	if r.Dst == nil || r.Gw == nil || r.Protocol == routeProto || r.Type != syscall.RTN_UNICAST {
+		return false
+	}
+	ones, bits := r.Dst.Mask.Size()
+	return bits == 32 && ones == 32
+}
+
+func prepopulate(known map[string]neigh.Entry, r netlink.Route, mac func(net.IP) net.HardwareAddr) {
+	if !remoteHostRoute(r) {
+		return
+	}
+	m := mac(r.Dst.IP)
+	if m == nil {
+		return
+	}
+	hi, err := interfaceForSubnetOf(r.Dst.IP)
+	if err != nil {
+		return
+	}
+	log := hi.log.WithField("Func", "prepopulate()").WithField("ip", r.Dst.IP).WithField("gw", r.Gw)
+
+	vni, _, err := hi.dp.VTEP()
+	if err != nil {
+		log.WithError(err).Debug("failed to get vxlan id")
+		return
+	}
+	e := neigh.Entry{
+		Network: hi.name,
+		VNI:     vni,
+		IP:      r.Dst.IP.String(),
+		MAC:     m.String(),
+		VTEP:    r.Gw.String(),
+	}
+	old, ok := known[e.IP]
+	if ok && old == e {
+		return
+	}
+	if ok {
+		// the endpoint moved to another host
+		forgetPrepopulated(old)
+		delete(known, e.IP)
+	}
+
+	imported, err := hi.ImportNeighbor(e)
+	if err != nil {
+		log.WithError(err).Warn("failed to prepopulate neighbor")
+		return
+	}
+	if imported {
+		log.WithField("mac", e.MAC).Debug("prepopulated neighbor")
+		known[e.IP] = e
+	}
+}
+
+func unpopulate(known map[string]neigh.Entry, r netlink.Route) {
+	if !remoteHostRoute(r) {
+		return
+	}
+	e, ok := known[r.Dst.IP.String()]
+	if !ok || e.VTEP != r.Gw.String() {
+		return
+	}
+	delete(known, e.IP)
+	forgetPrepopulated(e)
+}
+
+func forgetPrepopulated(e neigh.Entry) {
+	hi, err := getInterface(e.Network)
+	if err != nil {
+		// the interface was torn down, the entries went with it
+		return
+	}
+	if err = hi.ForgetNeighbor(e); err != nil {
+		hi.log.WithField("Func", "forgetPrepopulated()").WithField("ip", e.IP).WithError(err).Warn("failed to remove prepopulated neighbor")
+	}
+}
+
+// interfaceForSubnetOf returns the host interface with a gateway in the subnet of ip
+func interfaceForSubnetOf(ip net.IP) (*Interface, error) {
+	links, err := nlh.LinkList()
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		if !isHostMacvlan(link) {
+			continue
+		}
+		m, err := macvlan.FromLinkIndex(link.Attrs().Index)
+		if err != nil {
+			continue
+		}
+		gws, err := m.GetAddresses()
+		if err != nil {
+			continue
+		}
+		for _, gw := range gws {
+			if !gw.Contains(ip) {
+				continue
+			}
+			v, err := vxlan.FromLinkIndex(m.GetParentIndex())
+			if err != nil {
+				return nil, err
+			}
+			return getInterfaceFromDevices(v, m), nil
+		}
+	}
+	return nil, vxrerrors.NotFound("no host interface in the subnet of %v", ip)
+}
@@ -0,0 +1,288 @@
+package host
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+const (
+	ethPARP  = 0x0806
+	ethPIPv6 = 0x86dd
+
+	arpRequest = 1
+	arpReply   = 2
+
+	icmpv6NeighSol = 135
+	icmpv6NeighAdv = 136
+
+	ndFlagRouter   = 0x80
+	ndFlagOverride = 0x20
+	ndOptTargetLL  = 2
+
+	probeReadTO = 50 * time.Millisecond
+
+	// announceCount is how many times an address taken over is announced, a single frame may be lost
+	announceCount    = 3
+	announceInterval = 100 * time.Millisecond
+)
+
+var ethBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// probeAddress sends an arp probe (rfc 5227) or ipv6 duplicate address detection
+// neighbor solicitation (rfc 4862) for ip out of the interface at ifindex, and
+// waits up to timeout for another node to claim it. Claims from hardware addresses
+// ignore returns true for are skipped, ignore may be nil.
+// It returns the hardware address of the node using ip, or nil if none answered.
+func probeAddress(ifindex int, ip net.IP, timeout time.Duration, ignore func(net.HardwareAddr) bool) (mac net.HardwareAddr, err error) {
+	// the host macvlan may be in the gateway namespace, the socket must be opened there
+	err = gwns.Do(func() error {
+		mac, err = probe(ifindex, ip, timeout, ignore)
+		return err
+	})
+	return mac, err
+}
+
+func probe(ifindex int, ip net.IP, timeout time.Duration, ignore func(net.HardwareAddr) bool) (net.HardwareAddr, error) {
+	link, err := net.InterfaceByIndex(ifindex)
+	if err != nil {
+		return nil, err
+	}
+
+	proto := uint16(ethPARP)
+	var frame []byte
+	if ip4 := ip.To4(); ip4 != nil {
+		frame = arpProbe(link.HardwareAddr, ip4)
+	} else {
+		proto = ethPIPv6
+		frame = ndProbe(link.HardwareAddr, ip.To16())
+	}
+
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(proto)))
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd) // nolint: errcheck
+
+	sa := &syscall.SockaddrLinklayer{Protocol: htons(proto), Ifindex: ifindex}
+	err = syscall.Bind(fd, sa)
+	if err != nil {
+		return nil, err
+	}
+
+	tv := syscall.NsecToTimeval(probeReadTO.Nanoseconds())
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Sendto(fd, frame, 0, sa)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1514)
+	stop := time.Now().Add(timeout)
+	for time.Now().Before(stop) {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var mac net.HardwareAddr
+		if proto == ethPARP {
+			mac = arpClaim(buf[:n], ip.To4(), link.HardwareAddr)
+		} else {
+			mac = ndClaim(buf[:n], ip.To16(), link.HardwareAddr)
+		}
+		if mac != nil && (ignore == nil || !ignore(mac)) {
+			return mac, nil
+		}
+	}
+	return nil, nil
+}
+
+// announceAddress sends gratuitous arp (rfc 5227) or an unsolicited neighbor advertisement
+// overriding cached entries (rfc 4861) for ip out of the interface at ifindex, so the other
+// nodes on the segment send traffic for ip to it
+func announceAddress(ifindex int, ip net.IP) error {
+	return gwns.Do(func() error {
+		link, err := net.InterfaceByIndex(ifindex)
+		if err != nil {
+			return err
+		}
+
+		proto := uint16(ethPARP)
+		var frame []byte
+		if ip4 := ip.To4(); ip4 != nil {
+			frame = arpAnnounce(link.HardwareAddr, ip4)
+		} else {
+			proto = ethPIPv6
+			frame = ndAnnounce(link.HardwareAddr, ip.To16())
+		}
+
+		fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, int(htons(proto)))
+		if err != nil {
+			return err
+		}
+		defer syscall.Close(fd) // nolint: errcheck
+
+		sa := &syscall.SockaddrLinklayer{Protocol: htons(proto), Ifindex: ifindex}
+		for i := 0; i < announceCount; i++ {
+			if i > 0 {
+				time.Sleep(announceInterval)
+			}
+			if err = syscall.Sendto(fd, frame, 0, sa); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func ethHeader(dst, src net.HardwareAddr, proto uint16) []byte {
+	b := make([]byte, 14)
+	copy(b[0:6], dst)
+	copy(b[6:12], src)
+	binary.BigEndian.PutUint16(b[12:14], proto)
+	return b
+}
+
+// arpProbe builds an arp request for ip with an unspecified sender address
+func arpProbe(src net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, 28)
+	binary.BigEndian.PutUint16(b[0:2], 1) // ethernet
+	binary.BigEndian.PutUint16(b[2:4], 0x0800)
+	b[4], b[5] = 6, 4
+	binary.BigEndian.PutUint16(b[6:8], arpRequest)
+	copy(b[8:14], src)
+	copy(b[24:28], ip)
+	return append(ethHeader(ethBroadcast, src, ethPARP), b...)
+}
+
+// arpAnnounce builds a gratuitous arp request, with ip as both the sender and target address
+func arpAnnounce(src net.HardwareAddr, ip net.IP) []byte {
+	frame := arpProbe(src, ip)
+	copy(frame[14+14:14+18], ip)
+	return frame
+}
+
+// arpClaim returns the sender of an arp reply or request from ip, other than self.
+// A probe for ip from another node is a claim too, it is selecting ip at the same time.
+func arpClaim(frame []byte, ip net.IP, self net.HardwareAddr) net.HardwareAddr {
+	if len(frame) < 42 || binary.BigEndian.Uint16(frame[12:14]) != ethPARP {
+		return nil
+	}
+	b := frame[14:]
+	op := binary.BigEndian.Uint16(b[6:8])
+	if op != arpReply && op != arpRequest {
+		return nil
+	}
+	sha := net.HardwareAddr(b[8:14])
+	if bytes.Equal(sha, self) {
+		return nil
+	}
+	probe := op == arpRequest && net.IP(b[14:18]).Equal(net.IPv4zero.To4()) && net.IP(b[24:28]).Equal(ip)
+	if !net.IP(b[14:18]).Equal(ip) && !probe {
+		return nil
+	}
+	return append(net.HardwareAddr{}, sha...)
+}
+
+// ndProbe builds a duplicate address detection neighbor solicitation for ip,
+// sent from the unspecified address to the solicited-node multicast group of ip
+func ndProbe(src net.HardwareAddr, ip net.IP) []byte {
+	dst := net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0xff, ip[13], ip[14], ip[15]}
+	dmac := net.HardwareAddr{0x33, 0x33, 0xff, ip[13], ip[14], ip[15]}
+
+	icmp := make([]byte, 24)
+	icmp[0] = icmpv6NeighSol
+	copy(icmp[8:24], ip)
+
+	hdr := make([]byte, 40)
+	hdr[0] = 6 << 4
+	binary.BigEndian.PutUint16(hdr[4:6], uint16(len(icmp)))
+	hdr[6] = syscall.IPPROTO_ICMPV6
+	hdr[7] = 255
+	copy(hdr[8:24], net.IPv6unspecified)
+	copy(hdr[24:40], dst)
+
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(hdr[8:24], hdr[24:40], icmp))
+
+	frame := ethHeader(dmac, src, ethPIPv6)
+	frame = append(frame, hdr...)
+	return append(frame, icmp...)
+}
+
+// ndAnnounce builds an unsolicited neighbor advertisement for ip to the all-nodes group, from
+// ip as a router with the override flag and its link-layer address
+func ndAnnounce(src net.HardwareAddr, ip net.IP) []byte {
+	dst := net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01}
+	dmac := net.HardwareAddr{0x33, 0x33, 0, 0, 0, 0x01}
+
+	icmp := make([]byte, 32)
+	icmp[0] = icmpv6NeighAdv
+	icmp[4] = ndFlagRouter | ndFlagOverride
+	copy(icmp[8:24], ip)
+	icmp[24], icmp[25] = ndOptTargetLL, 1
+	copy(icmp[26:32], src)
+
+	hdr := make([]byte, 40)
+	hdr[0] = 6 << 4
+	binary.BigEndian.PutUint16(hdr[4:6], uint16(len(icmp)))
+	hdr[6] = syscall.IPPROTO_ICMPV6
+	hdr[7] = 255
+	copy(hdr[8:24], ip)
+	copy(hdr[24:40], dst)
+
+	binary.BigEndian.PutUint16(icmp[2:4], icmpv6Checksum(hdr[8:24], hdr[24:40], icmp))
+
+	frame := ethHeader(dmac, src, ethPIPv6)
+	frame = append(frame, hdr...)
+	return append(frame, icmp...)
+}
+
+// ndClaim returns the source of a neighbor advertisement for ip, other than self.
+// A duplicate address detection solicitation for ip from another node is a claim too.
+func ndClaim(frame []byte, ip net.IP, self net.HardwareAddr) net.HardwareAddr {
+	if len(frame) < 14+40+24 || binary.BigEndian.Uint16(frame[12:14]) != ethPIPv6 {
+		return nil
+	}
+	hdr, icmp := frame[14:54], frame[54:]
+	dad := icmp[0] == icmpv6NeighSol && net.IP(hdr[8:24]).Equal(net.IPv6unspecified)
+	if hdr[6] != syscall.IPPROTO_ICMPV6 || (icmp[0] != icmpv6NeighAdv && !dad) {
+		return nil
+	}
+	smac := net.HardwareAddr(frame[6:12])
+	if !net.IP(icmp[8:24]).Equal(ip) || bytes.Equal(smac, self) {
+		return nil
+	}
+	return append(net.HardwareAddr{}, smac...)
+}
+
+func icmpv6Checksum(src, dst net.IP, icmp []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src)
+	add(dst)
+	sum += uint32(len(icmp))
+	sum += syscall.IPPROTO_ICMPV6
+	add(icmp)
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,82 @@
+package host
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+var (
+	selfMAC  = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	otherMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+)
+
+func TestARPProbeClaim(t *testing.T) {
+	ip := net.ParseIP("10.1.2.3").To4()
+
+	probe := arpProbe(otherMAC, ip)
+	if len(probe) != 42 {
+		t.Fatalf("arp probe is %v bytes, want 42", len(probe))
+	}
+	// a probe of another node for the same address is a claim
+	if mac := arpClaim(probe, ip, selfMAC); mac.String() != otherMAC.String() {
+		t.Errorf("claim by %v, want %v", mac, otherMAC)
+	}
+	// our own probe is not
+	if mac := arpClaim(arpProbe(selfMAC, ip), ip, selfMAC); mac != nil {
+		t.Errorf("own probe claimed by %v", mac)
+	}
+	// nor a probe for another address
+	if mac := arpClaim(probe, net.ParseIP("10.1.2.4").To4(), selfMAC); mac != nil {
+		t.Errorf("probe for another address claimed by %v", mac)
+	}
+
+	announce := arpAnnounce(otherMAC, ip)
+	if !net.IP(announce[28:32]).Equal(ip) || !net.IP(announce[38:42]).Equal(ip) {
+		t.Errorf("gratuitous arp sender %v target %v, want %v", net.IP(announce[28:32]), net.IP(announce[38:42]), ip)
+	}
+	if mac := arpClaim(announce, ip, selfMAC); mac.String() != otherMAC.String() {
+		t.Errorf("gratuitous arp claimed by %v, want %v", mac, otherMAC)
+	}
+}
+
+// validChecksum checks the icmpv6 checksum of an ethernet frame with an ipv6 packet
+func validChecksum(frame []byte) bool {
+	hdr, icmp := frame[14:54], append([]byte{}, frame[54:]...)
+	sum := binary.BigEndian.Uint16(icmp[2:4])
+	icmp[2], icmp[3] = 0, 0
+	return icmpv6Checksum(hdr[8:24], hdr[24:40], icmp) == sum
+}
+
+func TestNDProbeClaim(t *testing.T) {
+	ip := net.ParseIP("fd00::1:2")
+
+	probe := ndProbe(otherMAC, ip)
+	if !validChecksum(probe) {
+		t.Error("invalid neighbor solicitation checksum")
+	}
+	if probe[54] != icmpv6NeighSol || probe[14+6] != syscall.IPPROTO_ICMPV6 {
+		t.Errorf("type %v next header %v, want a neighbor solicitation", probe[54], probe[14+6])
+	}
+	if mac := ndClaim(probe, ip, selfMAC); mac.String() != otherMAC.String() {
+		t.Errorf("claim by %v, want %v", mac, otherMAC)
+	}
+	if mac := ndClaim(ndProbe(selfMAC, ip), ip, selfMAC); mac != nil {
+		t.Errorf("own probe claimed by %v", mac)
+	}
+
+	announce := ndAnnounce(otherMAC, ip)
+	if !validChecksum(announce) {
+		t.Error("invalid neighbor advertisement checksum")
+	}
+	if announce[54] != icmpv6NeighAdv || announce[54+4] != ndFlagRouter|ndFlagOverride {
+		t.Errorf("type %v flags %#x, want an overriding router advertisement", announce[54], announce[54+4])
+	}
+	if net.HardwareAddr(announce[54+26:54+32]).String() != otherMAC.String() {
+		t.Errorf("target link-layer address %v, want %v", net.HardwareAddr(announce[54+26:54+32]), otherMAC)
+	}
+	if mac := ndClaim(announce, ip, selfMAC); mac.String() != otherMAC.String() {
+		t.Errorf("advertisement claimed by %v, want %v", mac, otherMAC)
+	}
+}
@@ -0,0 +1,98 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// remoteRoute is a route to an address being selected, from another host or source than the
+// host interface, seen while the route of the address was propagating
+type remoteRoute struct {
+	gw       net.IP
+	dev      string
+	protocol int
+	// after is how long after the route of the address was added it was seen
+	after time.Duration
+}
+
+func (r *remoteRoute) String() string {
+	s := "route"
+	if r.gw != nil {
+		s += " via " + r.gw.String()
+	}
+	if r.dev != "" {
+		s += " dev " + r.dev
+	}
+	return fmt.Sprintf("%v proto %v, seen %v after ours", s, r.protocol, r.after.Round(time.Millisecond))
+}
+
+// routeWatch watches the routes to an address while its route propagates
+type routeWatch struct {
+	ruc  chan netlink.RouteUpdate
+	done chan struct{}
+	// start is when the watch started, right before the route was added
+	start time.Time
+}
+
+// watchRoutes subscribes to route updates, before the route to an address is added, so no
+// route to it from elsewhere is missed. It returns nil if it can not subscribe.
+func watchRoutes() *routeWatch {
+	w := &routeWatch{ruc: make(chan netlink.RouteUpdate), done: make(chan struct{}), start: time.Now()}
+	if err := gwns.RouteSubscribe(w.ruc, w.done); err != nil {
+		return nil
+	}
+	return w
+}
+
+// close stops the watch. The updates still queued are drained, so the subscription can end.
+func (w *routeWatch) close() {
+	if w == nil {
+		return
+	}
+	close(w.done)
+	go func() {
+		for range w.ruc {
+		}
+	}()
+}
+
+// propagate waits d for the route to dst through the host macvlan to propagate. It returns
+// early with the first route to dst added from elsewhere meanwhile, or nil after d. False is
+// returned if ctx expired first. Without a watch it only waits.
+func (hi *Interface) propagate(ctx context.Context, w *routeWatch, dst *net.IPNet, d time.Duration) (*remoteRoute, bool) {
+	if w == nil {
+		return nil, sleep(ctx, d)
+	}
+	t := time.NewTimer(time.Until(w.start.Add(d)))
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-t.C:
+			return nil, true
+		case ru, ok := <-w.ruc:
+			if !ok {
+				return nil, sleep(ctx, time.Until(w.start.Add(d)))
+			}
+			if ru.Type != syscall.RTM_NEWROUTE || ru.Dst == nil || ru.Dst.String() != dst.String() {
+				continue
+			}
+			if ru.LinkIndex == hi.mvl.GetIndex() && ru.Protocol == routeProto {
+				continue
+			}
+			r := &remoteRoute{gw: ru.Gw, protocol: ru.Protocol, after: time.Since(w.start)}
+			if link, err := nlh.LinkByIndex(ru.LinkIndex); err == nil {
+				r.dev = link.Attrs().Name
+			}
+			return r, true
+		}
+	}
+}
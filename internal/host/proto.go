@@ -0,0 +1,194 @@
+package host
+
+import (
+	"fmt"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter"
+)
+
+// knownProtos are the route protocols of the kernel, iproute2's rt_protos and FRR's zebra
+var knownProtos = map[int]string{
+	8:   "gated",
+	9:   "ra",
+	10:  "mrt",
+	11:  "zebra",
+	12:  "bird",
+	13:  "dnrouted",
+	14:  "xorp",
+	15:  "ntk",
+	16:  "dhcp",
+	17:  "mrouted",
+	18:  "keepalived",
+	42:  "babel",
+	99:  "openr",
+	186: "bgp",
+	187: "isis",
+	188: "ospf",
+	189: "rip",
+	190: "frr",
+	191: "frr",
+	192: "eigrp",
+	193: "ldp",
+	194: "sharp",
+	195: "pbr",
+	196: "frr static",
+	197: "openfabric",
+	198: "srte",
+}
+
+// legacyRouteProto is the route protocol host routes were tagged with by released versions
+// before they had a protocol of their own. It is that of EIGRP, and only accepted for host routes.
+const legacyRouteProto = 192
+
+// routeProtoEnv is the environment variable setting the route protocol of host routes
+var routeProtoEnv = vxrouter.EnvPrefix + "ROUTE_PROTO"
+
+// CheckProtos checks the route protocols vxrouter tags its routes with. They must be distinct,
+// above the protocols of the kernel and of static routes, and not those of known routing daemons,
+// every route tagged with them is taken as vxrouter's own, and may be deleted. Host routes may
+// keep the legacy route protocol.
+func CheckProtos() error {
+	return checkProtos(map[string]int{
+		routeProtoEnv:                           routeProto,
+		vxrouter.EnvPrefix + "SUMMARY_PROTO":    summaryProto,
+		vxrouter.EnvPrefix + "DELEGATE_PROTO":   delegateProto,
+		vxrouter.EnvPrefix + "BGP_PROTO":        bgpProto,
+		vxrouter.EnvPrefix + "QUARANTINE_PROTO": quarantineProto,
+	})
+}
+
+func checkProtos(protos map[string]int) error {
+	seen := make(map[int]string)
+	for env, p := range protos {
+		if p <= syscall.RTPROT_STATIC || p > 255 {
+			return fmt.Errorf("%v %v is not a free route protocol, it must be between %v and 255", env, p, syscall.RTPROT_STATIC+1)
+		}
+		if d, ok := knownProtos[p]; ok && (env != routeProtoEnv || p != legacyRouteProto) {
+			return fmt.Errorf("%v %v is the route protocol of %v", env, p, d)
+		}
+		if o, ok := seen[p]; ok {
+			return fmt.Errorf("%v and %v are both %v", o, env, p)
+		}
+		seen[p] = env
+	}
+	return nil
+}
+
+// ownRoutes returns the routes tagged with the route protocols of vxrouter
+func ownRoutes() ([]netlink.Route, error) {
+	ret := []netlink.Route{}
+	for _, p := range []int{routeProto, summaryProto, delegateProto} {
+		routes, err := nlh.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Protocol: p}, netlink.RT_FILTER_PROTOCOL)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range routes {
+			if r.Dst != nil {
+				ret = append(ret, r)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// leaked reports why r, one of vxrouter's routes, was left behind, or "" if it was not. Routes
+// go through host macvlans, and prefixes are delegated to addresses with a host route.
+func leaked(r netlink.Route) string {
+	link, err := nlh.LinkByIndex(r.LinkIndex)
+	if err != nil || !isHostMacvlan(link) {
+		return "not through a host interface"
+	}
+	if r.Protocol != delegateProto || r.Gw == nil {
+		return ""
+	}
+	n, err := VxroutesTo(r.Gw)
+	if err == nil && n == 0 {
+		return "delegated to an address without a host route"
+	}
+	return ""
+}
+
+// RetagLegacyRoutes tags the host routes left by a released version with the legacy route
+// protocol with the current one, so they are reconciled and deleted like those added since.
+// Only routes through host macvlans are retagged, they are replaced in place, so the
+// containers behind them stay reachable.
+func RetagLegacyRoutes() error {
+	log := log.WithField("Func", "RetagLegacyRoutes()")
+	log.Debug()
+
+	if routeProto == legacyRouteProto {
+		return nil
+	}
+	routes, err := nlh.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Protocol: legacyRouteProto}, netlink.RT_FILTER_PROTOCOL)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return err
+	}
+
+	for _, r := range legacyHostRoutes(routes, func(i int) bool {
+		link, err := nlh.LinkByIndex(i)
+		return err == nil && isHostMacvlan(link)
+	}) {
+		r.Protocol = routeProto
+		log := log.WithField("dst", r.Dst.String())
+		err = nlh.RouteReplace(&r)
+		if err != nil {
+			log.WithError(err).Error("failed to retag legacy host route")
+			continue
+		}
+		log.Info("retagged legacy host route")
+	}
+	return nil
+}
+
+// legacyHostRoutes returns the host routes of routes which go through a host macvlan,
+// as reported by hostMacvlan for their link index
+func legacyHostRoutes(routes []netlink.Route, hostMacvlan func(int) bool) []netlink.Route {
+	ret := []netlink.Route{}
+	for _, r := range routes {
+		if r.Dst == nil || r.Gw != nil || r.Type == syscall.RTN_BLACKHOLE {
+			continue
+		}
+		if ones, bits := r.Dst.Mask.Size(); ones != bits {
+			continue
+		}
+		if hostMacvlan(r.LinkIndex) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// CleanLeakedRoutes removes vxrouter routes left behind by a previous run. Only routes tagged
+// with the route protocols of vxrouter are considered, routes managed by the operator or a
+// routing daemon are never deleted.
+func CleanLeakedRoutes() error {
+	log := log.WithField("Func", "CleanLeakedRoutes()")
+	log.Debug()
+
+	routes, err := ownRoutes()
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return err
+	}
+
+	for _, r := range routes {
+		why := leaked(r)
+		if why == "" {
+			continue
+		}
+		log := log.WithField("dst", r.Dst.String()).WithField("proto", r.Protocol).WithField("reason", why)
+		log.Info("removing leaked route")
+		r := r
+		err = nlh.RouteDel(&r)
+		if err != nil && err != syscall.ESRCH {
+			log.WithError(err).Error("failed to remove leaked route")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package host
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter"
+)
+
+func TestDefaultProtos(t *testing.T) {
+	err := checkProtos(map[string]int{
+		"route":      vxrouter.DefaultRouteProto,
+		"summary":    vxrouter.DefaultSummaryProto,
+		"delegate":   vxrouter.DefaultDelegateProto,
+		"bgp":        vxrouter.DefaultBGPProto,
+		"quarantine": vxrouter.DefaultQuarantineProto,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBlackholeRouteProto(t *testing.T) {
+	r := blackholeRoute(net.ParseIP("10.1.2.5"))
+	if r.Protocol != quarantineProto || r.Protocol == routeProto {
+		t.Errorf("blackhole protocol is %v, want %v apart from the host routes' %v", r.Protocol, quarantineProto, routeProto)
+	}
+}
+
+func TestCheckProtos(t *testing.T) {
+	tests := []struct {
+		name   string
+		protos map[string]int
+	}{
+		{"kernel", map[string]int{"route": 2}},
+		{"static", map[string]int{"route": 4}},
+		{"above 255", map[string]int{"route": 256}},
+		{"bird", map[string]int{"route": 12}},
+		{"babel", map[string]int{"route": 42}},
+		{"bgp", map[string]int{"route": 186}},
+		{"eigrp", map[string]int{"route": 192}},
+		{"frr ldp", map[string]int{"summary": 193}},
+		{"frr sharp", map[string]int{"delegate": 194}},
+		{"frr static", map[string]int{"route": 196}},
+		{"duplicate", map[string]int{"route": 240, "summary": 240}},
+	}
+	for _, tt := range tests {
+		if err := checkProtos(tt.protos); err == nil {
+			t.Errorf("%v: %v accepted", tt.name, tt.protos)
+		}
+	}
+	if err := checkProtos(map[string]int{"route": 5, "summary": 200, "delegate": 255}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLegacyRouteProto(t *testing.T) {
+	if err := checkProtos(map[string]int{routeProtoEnv: legacyRouteProto, "summary": 241}); err != nil {
+		t.Errorf("legacy host route protocol refused: %v", err)
+	}
+	if err := checkProtos(map[string]int{"summary": legacyRouteProto}); err == nil {
+		t.Error("legacy route protocol accepted for summaries")
+	}
+}
+
+func TestLegacyHostRoutes(t *testing.T) {
+	hmvl := func(i int) bool { return i == 5 }
+	routes := []netlink.Route{
+		{LinkIndex: 5, Dst: cidr("10.1.2.5/32")},
+		{LinkIndex: 5, Dst: cidr("fd00:1::5/128")},
+		// an eigrp route, not through a host interface
+		{LinkIndex: 2, Dst: cidr("10.1.3.5/32")},
+		{LinkIndex: 5, Dst: cidr("10.1.4.0/24")},
+		{LinkIndex: 5, Dst: cidr("10.1.2.6/32"), Gw: net.ParseIP("10.1.2.1")},
+		{Dst: cidr("10.1.2.7/32"), Type: syscall.RTN_BLACKHOLE},
+		{LinkIndex: 5},
+	}
+	got := legacyHostRoutes(routes, hmvl)
+	if len(got) != 2 || got[0].Dst.String() != "10.1.2.5/32" || got[1].Dst.String() != "fd00:1::5/128" {
+		t.Errorf("legacy host routes are %v, want 10.1.2.5/32 and fd00:1::5/128", got)
+	}
+}
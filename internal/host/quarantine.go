@@ -0,0 +1,109 @@
+package host
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter"
+)
+
+var (
+	quarantined  = make(map[string]*time.Timer)
+	quarantinedL sync.Mutex
+	// quarantineProto tags blackholes apart from the host routes, so a routing daemon
+	// redistributing those does not announce quarantined addresses as taken
+	quarantineProto = vxrouter.GetEnvIntWithDefault(vxrouter.EnvPrefix+"QUARANTINE_PROTO", "", vxrouter.DefaultQuarantineProto)
+)
+
+func blackholeRoute(ip net.IP) *netlink.Route {
+	_, a := getIPNets(ip, nil)
+	return &netlink.Route{
+		Dst:      a,
+		Type:     syscall.RTN_BLACKHOLE,
+		Protocol: quarantineProto,
+	}
+}
+
+// Quarantine installs a blackhole route to a just released address for d,
+// so in flight traffic to it is dropped here instead of leaking to the gateway.
+// The address will not be randomly selected again until the route is removed.
+func Quarantine(ip net.IP, d time.Duration) error {
+	log := log.WithField("Func", "Quarantine()").WithField("ip", ip.String())
+	log.Debug()
+
+	quarantinedL.Lock()
+	defer quarantinedL.Unlock()
+
+	if t, ok := quarantined[ip.String()]; ok {
+		t.Reset(d)
+		return nil
+	}
+
+	err := nlh.RouteAdd(blackholeRoute(ip))
+	if err != nil {
+		log.WithError(err).Error("failed to add blackhole route")
+		return err
+	}
+
+	quarantined[ip.String()] = time.AfterFunc(d, func() {
+		if err := Unquarantine(ip); err != nil {
+			log.WithError(err).Error("failed to remove blackhole route")
+		}
+	})
+
+	return nil
+}
+
+// Unquarantine removes the blackhole route to ip, if there is one
+func Unquarantine(ip net.IP) error {
+	quarantinedL.Lock()
+	defer quarantinedL.Unlock()
+
+	t, ok := quarantined[ip.String()]
+	if !ok {
+		return nil
+	}
+	t.Stop()
+	delete(quarantined, ip.String())
+
+	log.WithField("Func", "Unquarantine()").WithField("ip", ip.String()).Debug()
+	return nlh.RouteDel(blackholeRoute(ip))
+}
+
+func isQuarantined(ip net.IP) bool {
+	quarantinedL.Lock()
+	defer quarantinedL.Unlock()
+	_, ok := quarantined[ip.String()]
+	return ok
+}
+
+// CleanQuarantine removes vxrouter blackhole routes left behind by a previous run
+func CleanQuarantine() error {
+	log := log.WithField("Func", "CleanQuarantine()")
+	log.Debug()
+
+	routes, err := nlh.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Protocol: quarantineProto, Type: syscall.RTN_BLACKHOLE}, netlink.RT_FILTER_PROTOCOL|netlink.RT_FILTER_TYPE)
+	if err != nil {
+		log.WithError(err).Error("failed to get routes")
+		return err
+	}
+
+	for _, r := range routes {
+		if r.Dst == nil || isQuarantined(r.Dst.IP) {
+			continue
+		}
+		log.WithField("dst", r.Dst.String()).Debug("removing stale blackhole route")
+		r := r
+		err = nlh.RouteDel(&r)
+		if err != nil {
+			log.WithError(err).Error("failed to remove stale blackhole route")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package host
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+)
+
+// sharedVNIOpt is the network option putting a network on a vxlan shared by all networks
+// with its vxlan id and the option on. Each keeps its own host macvlan and gateway.
+const sharedVNIOpt = "sharevni"
+
+// SharedVxlanName returns the name of the vxlan shared by the networks with vxlan id vni
+func SharedVxlanName(vni int) string {
+	return "vxr" + strconv.Itoa(vni)
+}
+
+// vxlanName returns the name of the vxlan of the network name, named after the network
+// unless it shares its vxlan id
+func vxlanName(name string, opts map[string]string) (string, error) {
+	if !strings.EqualFold(optOrEnv(opts, sharedVNIOpt), "on") {
+		return name, nil
+	}
+	vni, err := vxlan.ParseVxlanID(opts["vxlanid"])
+	if err != nil {
+		return "", err
+	}
+	return SharedVxlanName(vni), nil
+}
+
+// isHostMacvlan reports whether link is the host macvlan of a network
+func isHostMacvlan(link netlink.Link) bool {
+	return strings.HasPrefix(link.Attrs().Name, "hmvl_")
+}
+
+// otherNetworks returns the number of other networks with a host macvlan on the vxlan of the interface
+func (hi *Interface) otherNetworks() (int, error) {
+	slaves, err := hi.dp.GetSlaveDevices()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, slave := range slaves {
+		if slave.Attrs().Index != hi.mvl.GetIndex() && isHostMacvlan(slave) {
+			n++
+		}
+	}
+	return n, nil
+}
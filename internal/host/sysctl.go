@@ -0,0 +1,73 @@
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netns"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+)
+
+// ParseSysctls parses a comma separated list of key=value network sysctls,
+// eg. net.ipv6.conf.all.accept_ra=0,net.ipv4.conf.all.arp_notify=1
+func ParseSysctls(s string) (map[string]string, error) {
+	ret := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 {
+			return nil, fmt.Errorf("invalid sysctl %q, must be key=value", kv)
+		}
+		k := strings.TrimSpace(p[0])
+		if !strings.HasPrefix(k, "net.") || strings.Contains(k, "..") || strings.Contains(k, "/") {
+			return nil, fmt.Errorf("invalid sysctl %q, only net.* sysctls may be set", k)
+		}
+		ret[k] = strings.TrimSpace(p[1])
+	}
+	return ret, nil
+}
+
+// SetNsSysctls sets sysctls in the network namespace at nsPath
+// Only namespace wide sysctls (eg. conf.all, conf.default) can be set before
+// the container interface has been moved into the namespace.
+func SetNsSysctls(nsPath string, sysctls map[string]string) error {
+	log := log.WithField("Func", "SetNsSysctls()").WithField("ns", nsPath)
+	log.Debug()
+
+	if len(sysctls) == 0 {
+		return nil
+	}
+
+	return inNs(nsPath, func() error {
+		for k, v := range sysctls {
+			p := filepath.Join("/proc/sys", strings.Replace(k, ".", "/", -1))
+			err := ioutil.WriteFile(p, []byte(v), 0644)
+			if err != nil {
+				log.WithField("sysctl", k).WithError(err).Error("failed to set sysctl")
+				return fmt.Errorf("failed to set %v: %v", k, err)
+			}
+		}
+		return nil
+	})
+}
+
+// inNs runs f on a thread in the network namespace at nsPath. Processes started by f run in it too.
+func inNs(nsPath string, f func() error) error {
+	log := log.WithField("Func", "inNs()").WithField("ns", nsPath)
+
+	ns, err := netns.GetFromPath(nsPath)
+	if err != nil {
+		log.WithError(err).Error("failed to open namespace")
+		return err
+	}
+	defer ns.Close() // nolint: errcheck
+
+	return gwns.DoIn(ns, f)
+}
@@ -0,0 +1,95 @@
+package host
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TrilliumIT/vxrouter"
+)
+
+var (
+	maxSelectTries = vxrouter.GetEnvIntWithDefault(vxrouter.EnvPrefix+"MAX_SELECT_TRIES", "", vxrouter.DefaultMaxSelectTries)
+	minFreeRatio   = vxrouter.GetEnvFloatWithDefault(vxrouter.EnvPrefix+"MIN_FREE_RATIO", "", vxrouter.DefaultMinFreeRatio)
+)
+
+// Utilization is the number of addresses of a subnet in use, out of those which may be selected
+type Utilization struct {
+	Used, Available int64
+	// Updated is when the utilization was last sampled
+	Updated time.Time
+}
+
+// Free returns the fraction of available addresses which are not used
+func (u Utilization) Free() float64 {
+	if u.Available <= 0 {
+		return 0
+	}
+	return float64(u.Available-u.Used) / float64(u.Available)
+}
+
+var utilizations = struct {
+	sync.Mutex
+	m map[string]Utilization
+}{m: make(map[string]Utilization)}
+
+// Utilizations returns the utilization of each subnet addresses were last selected from, by subnet
+func Utilizations() map[string]Utilization {
+	utilizations.Lock()
+	defer utilizations.Unlock()
+	ret := make(map[string]Utilization, len(utilizations.m))
+	for k, v := range utilizations.m {
+		ret[k] = v
+	}
+	return ret
+}
+
+// utilization samples and records the utilization of the subnet of opts.
+// ok is false for subnets too large to count, eg. ipv6.
+func utilization(opts *SelectOpts) (u Utilization, ok bool, err error) {
+	sn := opts.Subnet
+	size := SubnetSize(sn)
+	if !size.IsInt64() {
+		return u, false, nil
+	}
+	ex := ExcludedCount(sn, opts.ExcludeFirst, opts.ExcludeLast, opts.Gateway, opts.Exclude)
+	u.Available = size.Int64() - ex.Int64()
+
+	routes, err := HostRoutesIn(sn)
+	if err != nil {
+		return u, false, err
+	}
+	seen := make(map[string]bool)
+	for _, r := range routes {
+		if seen[r.IP.String()] || excluded(r.IP, opts) {
+			continue
+		}
+		seen[r.IP.String()] = true
+		u.Used++
+	}
+	if u.Used > u.Available {
+		u.Used = u.Available
+	}
+	u.Updated = time.Now()
+
+	utilizations.Lock()
+	utilizations.m[sn.String()] = u
+	utilizations.Unlock()
+	return u, true, nil
+}
+
+// excluded reports whether ip is never selected from the subnet of opts, so is not counted as available
+func excluded(ip net.IP, opts *SelectOpts) bool {
+	sn := opts.Subnet
+	i := IPToInt(ip)
+	first := IPToInt(sn.IP.Mask(sn.Mask))
+	if d := i.Sub(i, first); d.IsInt64() && d.Int64() < int64(opts.ExcludeFirst) {
+		return true
+	}
+	last := IPToInt(sn.IP.Mask(sn.Mask))
+	last.Add(last, SubnetSize(sn))
+	if d := last.Sub(last, IPToInt(ip)); d.IsInt64() && d.Int64() <= int64(opts.ExcludeLast) {
+		return true
+	}
+	return (opts.Gateway != nil && opts.Gateway.Equal(ip)) || inRanges(ip, opts.Exclude)
+}
@@ -3,28 +3,39 @@ package macvlan
 import (
 	"fmt"
 	"net"
+	"syscall"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
 )
 
 // Macvlan is a macvlan interface, for either a host or a container
 type Macvlan struct {
 	name string
 	log  *log.Entry
+	// h is the handle for the namespace the interface was last found in
+	h *netlink.Handle
 }
 
 func fromName(name string) *Macvlan {
 	log := log.WithField("Macvlan", name)
 	log.WithField("Func", "fromName()").Debug()
-	return &Macvlan{name, log}
+	return &Macvlan{name, log, gwns.Handle()}
 }
 
 func (m *Macvlan) nl() (*netlink.Macvlan, error) { // nolint: dupl
 	log := m.log.WithField("Func", "nl()")
 	log.Debug()
 
-	link, err := netlink.LinkByName(m.name)
+	// container macvlans are moved out of the gateway namespace, look for them in the root namespace too
+	m.h = gwns.Handle()
+	link, err := m.h.LinkByName(m.name)
+	if err != nil && gwns.Enabled() {
+		m.h = gwns.Root()
+		link, err = m.h.LinkByName(m.name)
+	}
 	if err != nil {
 		log.WithError(err).Debug("failed to get link by name")
 		return nil, err
@@ -55,7 +66,7 @@ func New(name string, parent int) (*Macvlan, error) {
 		},
 		Mode: netlink.MACVLAN_MODE_BRIDGE,
 	}
-	if err := netlink.LinkAdd(nl); err != nil {
+	if err := gwns.Handle().LinkAdd(nl); err != nil {
 		log.WithError(err).Debug("error adding link")
 
 		// Just in case add failed due to add succeeding from another thread
@@ -71,7 +82,7 @@ func New(name string, parent int) (*Macvlan, error) {
 		}
 	}
 
-	if err := netlink.LinkSetUp(nl); err != nil {
+	if err := m.h.LinkSetUp(nl); err != nil {
 		log.WithError(err).Debug("failed to bring up macvlan")
 		return nil, err
 	}
@@ -95,7 +106,7 @@ func FromName(name string) (*Macvlan, error) { // nolint: dupl
 
 // FromLinkIndex returns a Macvlan from an interface name
 func FromLinkIndex(li int) (*Macvlan, error) { // nolint: dupl
-	l, err := netlink.LinkByIndex(li)
+	l, err := gwns.Handle().LinkByIndex(li)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +128,8 @@ func FromLink(link netlink.Link) (*Macvlan, error) { // nolint: dupl
 	return m, nil
 }
 
-// AddAddress adds an ip address to a Macvlan interface
+// AddAddress adds an ip address to a Macvlan interface. IPv6 addresses skip duplicate address
+// detection, the gateway is an anycast address present on every host with containers on the network.
 func (m *Macvlan) AddAddress(addr *net.IPNet) error {
 	log := m.log.WithField("Func", "AddAddress()")
 	log.Debug()
@@ -127,7 +139,24 @@ func (m *Macvlan) AddAddress(addr *net.IPNet) error {
 		log.WithError(err).Debug()
 		return err
 	}
-	return netlink.AddrAdd(nl, &netlink.Addr{IPNet: addr})
+	a := &netlink.Addr{IPNet: addr}
+	if addr.IP.To4() == nil {
+		a.Flags = syscall.IFA_F_NODAD
+	}
+	return m.h.AddrAdd(nl, a)
+}
+
+// DelAddress deletes an ip address from a Macvlan interface
+func (m *Macvlan) DelAddress(addr *net.IPNet) error {
+	log := m.log.WithField("Func", "DelAddress()")
+	log.Debug()
+
+	nl, err := m.nl()
+	if err != nil {
+		log.WithError(err).Debug()
+		return err
+	}
+	return m.h.AddrDel(nl, &netlink.Addr{IPNet: addr})
 }
 
 // Delete deletes a Macvlan interface
@@ -149,7 +178,7 @@ func (m *Macvlan) Delete() error {
 	}
 
 	// delete the macvlan slave device
-	return netlink.LinkDel(nl)
+	return m.h.LinkDel(nl)
 }
 
 // GetAddresses returns IP Addresses on a Macvlan interface
@@ -163,7 +192,7 @@ func (m *Macvlan) GetAddresses() ([]*net.IPNet, error) {
 		return nil, err
 	}
 
-	addrs, err := netlink.AddrList(nl, 0)
+	addrs, err := m.h.AddrList(nl, 0)
 	if err != nil {
 		log.WithError(err).Debug()
 		return nil, err
@@ -220,6 +249,52 @@ func (m *Macvlan) GetIndex() int { // nolint: dupl
 	return nl.Attrs().Index
 }
 
+// SetMTU sets the mtu of the interface, if it differs
+func (m *Macvlan) SetMTU(mtu int) error {
+	log := m.log.WithField("Func", "SetMTU()").WithField("mtu", mtu)
+	log.Debug()
+
+	nl, err := m.nl()
+	if err != nil {
+		log.WithError(err).Debug()
+		return err
+	}
+	if mtu <= 0 || nl.Attrs().MTU == mtu {
+		return nil
+	}
+	return m.h.LinkSetMTU(nl, mtu)
+}
+
+// HardwareAddr returns the MAC of the interface
+func (m *Macvlan) HardwareAddr() (net.HardwareAddr, error) {
+	log := m.log.WithField("Func", "HardwareAddr()")
+	log.Debug()
+
+	nl, err := m.nl()
+	if err != nil {
+		log.WithError(err).Debug()
+		return nil, err
+	}
+	return nl.Attrs().HardwareAddr, nil
+}
+
+// MoveOut moves the interface out of the gateway namespace into the root namespace, where
+// docker moves container interfaces from. It does nothing without a gateway namespace.
+func (m *Macvlan) MoveOut() error {
+	log := m.log.WithField("Func", "MoveOut()")
+	log.Debug()
+
+	nl, err := m.nl()
+	if err != nil {
+		log.WithError(err).Debug()
+		return err
+	}
+	if m.h == gwns.Root() {
+		return nil
+	}
+	return gwns.MoveOut(nl)
+}
+
 // Name returns the name
 func (m *Macvlan) Name() string {
 	return m.name
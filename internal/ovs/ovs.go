@@ -0,0 +1,615 @@
+// Package ovs manages the Open vSwitch bridges of networks using the ovs datapath. Each network
+// has a bridge, named after it, with a vxlan port to every flood peer. The host and container
+// macvlans are created on the internal port of the bridge, so traffic between hosts passes the
+// OpenFlow tables of the bridge, where operators may add their own policy.
+package ovs
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/internal/macvlan"
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+const (
+	// DefaultPort is the destination port of the vxlan ports of Open vSwitch, the IANA port
+	DefaultPort = 4789
+	// cmdTimeout bounds each ovs-vsctl and ovs-appctl run
+	cmdTimeout = 10 * time.Second
+	// external ids of the bridges and tunnel interfaces of vxrouter
+	extVNI    = "vxrouter-vni"
+	extTunnel = "vxrouter-tunnel"
+	extBridge = "vxrouter-bridge"
+	extPeer   = "vxrouter-peer"
+	// minMajor and minMinor are the first version of ovs-vswitchd with fdb/add and fdb/del
+	minMajor = 2
+	minMinor = 17
+)
+
+// versionRe matches the version in the output of ovs-appctl version
+var versionRe = regexp.MustCompile(`\(Open vSwitch\) (\d+)\.(\d+)`)
+
+// tunnelOpts are the network options applied to the vxlan ports, by their Open vSwitch option
+var tunnelOpts = map[string]string{
+	"port":    "dst_port",
+	"srcaddr": "local_ip",
+	"tos":     "tos",
+	"ttl":     "ttl",
+	"udpcsum": "csum",
+}
+
+// Unsupported are the vxlan options of the kernel datapath Open vSwitch has no equivalent for
+var Unsupported = []string{"group", "learning", "vtepdev", "portlow", "porthigh", "proxy", "rsc", "l2miss", "l3miss", "noage", "gbp", "limit", "vxlantxqlen"}
+
+// run runs an Open vSwitch command and returns its output, replaced in tests
+var run = func(cmd string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cmdTimeout)
+	defer cancel()
+	c := exec.CommandContext(ctx, cmd, args...)
+	var stdout, stderr bytes.Buffer
+	c.Stdout, c.Stderr = &stdout, &stderr
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("%v %v: %v: %v", cmd, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// available reports whether Open vSwitch is installed, replaced in tests
+var available = func() bool {
+	_, err := exec.LookPath("ovs-vsctl")
+	return err == nil
+}
+
+func vsctl(args ...string) (string, error) {
+	return run("ovs-vsctl", append([]string{"--timeout=" + strconv.Itoa(int(cmdTimeout/time.Second))}, args...)...)
+}
+
+// Bridge is the Open vSwitch bridge of a network
+type Bridge struct {
+	name string
+	log  *log.Entry
+}
+
+func fromName(name string) *Bridge {
+	log := log.WithField("Bridge", name)
+	log.WithField("Func", "fromName()").Debug()
+	return &Bridge{name, log}
+}
+
+// record is a row of an ovs-vsctl --bare listing, a line per column
+type record []string
+
+// records parses the rows of ovs-vsctl --bare with n columns, separated by empty lines.
+// Empty columns are empty lines too, so rows are counted by their columns.
+func records(out string, n int) []record {
+	ret := []record{}
+	if strings.TrimSpace(out) == "" {
+		return ret
+	}
+	lines := strings.Split(strings.TrimRight(out, "\n"), "\n")
+	for i := 0; i+n <= len(lines); i += n + 1 {
+		ret = append(ret, lines[i:i+n])
+	}
+	return ret
+}
+
+// bareMap parses a map column of ovs-vsctl --bare, as key=value pairs separated by spaces.
+// Values which would not parse as strings, like numbers or those with commas, are quoted.
+func bareMap(s string) map[string]string {
+	ret := make(map[string]string)
+	for _, kv := range strings.Fields(s) {
+		if i := strings.Index(kv, "="); i > 0 {
+			ret[kv[:i]] = unquote(kv[i+1:])
+		}
+	}
+	return ret
+}
+
+func unquote(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// bridges returns the external ids of the bridges of vxrouter, by name
+func bridges() (map[string]map[string]string, error) {
+	out, err := vsctl("--bare", "--columns=name,external_ids", "list", "bridge")
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]map[string]string)
+	for _, r := range records(out, 2) {
+		ids := bareMap(r[1])
+		if ids[extVNI] != "" {
+			ret[r[0]] = ids
+		}
+	}
+	return ret, nil
+}
+
+// TunnelSpec returns the options of the vxlan ports of a network with opts, options of the
+// network without the namespace, falling back to the VXR_<key> environment variable
+func TunnelSpec(opts map[string]string) (map[string]string, error) {
+	for _, k := range Unsupported {
+		if opts[k] != "" {
+			return nil, fmt.Errorf("option %v is not supported by the ovs datapath", k)
+		}
+	}
+	vni, err := vxlan.ParseVxlanID(opts["vxlanid"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid vxlanid %q: %v", opts["vxlanid"], err)
+	}
+	spec := map[string]string{"key": strconv.Itoa(vni)}
+	for k, o := range tunnelOpts {
+		v := opts[k]
+		if v == "" {
+			v = os.Getenv(vxrouter.EnvPrefix + k)
+		}
+		if v == "" {
+			continue
+		}
+		switch k {
+		case "srcaddr":
+			if net.ParseIP(v) == nil {
+				return nil, fmt.Errorf("invalid srcaddr %q", v)
+			}
+		case "udpcsum":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid udpcsum %q: %v", v, err)
+			}
+			v = strconv.FormatBool(b)
+		case "tos":
+			if strings.EqualFold(v, "inherit") {
+				v = "inherit"
+				break
+			}
+			fallthrough
+		default:
+			if _, err := strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("invalid %v %q: %v", k, v, err)
+			}
+		}
+		spec[o] = v
+	}
+	if spec["dst_port"] == "" {
+		spec["dst_port"] = strconv.Itoa(DefaultPort)
+	}
+	return spec, nil
+}
+
+// formatSpec formats tunnel options as sorted key=value pairs separated by commas
+func formatSpec(spec map[string]string) string {
+	kvs := []string{}
+	for k, v := range spec {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+func parseSpec(s string) map[string]string {
+	ret := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			ret[kv[:i]] = kv[i+1:]
+		}
+	}
+	return ret
+}
+
+// New creates the bridge of a network, or gets it if it exists with the same tunnel options
+func New(name string, opts map[string]string) (*Bridge, error) {
+	b := fromName(name)
+	log := b.log.WithField("Func", "New()")
+	log.Debug()
+
+	spec, err := TunnelSpec(opts)
+	if err != nil {
+		return nil, err
+	}
+	brs, err := bridges()
+	if err != nil {
+		log.WithError(err).Debug("failed to list bridges")
+		return nil, err
+	}
+	if ids, ok := brs[name]; ok {
+		if ids[extTunnel] != formatSpec(spec) {
+			err = vxrerrors.Conflict("bridge %v already exists with other tunnel options %v", name, ids[extTunnel])
+			log.WithError(err).Debug()
+			return nil, err
+		}
+	} else if n := inUse(brs, spec); n != "" {
+		return nil, vxrerrors.Conflict("vxlanid %v is already in use by the bridge %v", spec["key"], n)
+	}
+
+	args := []string{
+		"--may-exist", "add-br", name,
+		"--", "br-set-external-id", name, extVNI, spec["key"],
+		"--", "br-set-external-id", name, extTunnel, formatSpec(spec),
+	}
+	if a := opts["ageing"]; a != "" {
+		args = append(args, "--", "set", "bridge", name, "other_config:mac-aging-time="+strconv.Quote(a))
+	}
+	if hw := opts["vxlanhardwareaddr"]; hw != "" {
+		if _, err = net.ParseMAC(hw); err != nil {
+			return nil, err
+		}
+		args = append(args, "--", "set", "bridge", name, "other_config:hwaddr="+strconv.Quote(hw))
+	}
+	if mtu := opts["vxlanmtu"]; mtu != "" {
+		if _, err = strconv.Atoi(mtu); err != nil {
+			return nil, fmt.Errorf("invalid vxlanmtu %q: %v", mtu, err)
+		}
+		args = append(args, "--", "set", "interface", name, "mtu_request="+mtu)
+	}
+	if _, err = vsctl(args...); err != nil {
+		log.WithError(err).Debug("failed to create bridge")
+		return nil, err
+	}
+
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		err = netlink.LinkSetUp(link)
+	}
+	if err != nil {
+		log.WithError(err).Debug("failed to bring up the internal port of the bridge")
+		return nil, err
+	}
+	return b, nil
+}
+
+// inUse returns the bridge with the vxlan id and destination port of spec, which Open vSwitch would
+// refuse the vxlan ports of another bridge with, or "" if there is none
+func inUse(brs map[string]map[string]string, spec map[string]string) string {
+	names := []string{}
+	for n := range brs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		o := parseSpec(brs[n][extTunnel])
+		if o["key"] == spec["key"] && o["dst_port"] == spec["dst_port"] {
+			return n
+		}
+	}
+	return ""
+}
+
+// InUse returns the bridge of another network using the vxlan id of a network with opts, or ""
+// if there is none, or Open vSwitch is not installed
+func InUse(name string, opts map[string]string) (string, error) {
+	if !available() {
+		return "", nil
+	}
+	spec, err := TunnelSpec(opts)
+	if err != nil {
+		return "", err
+	}
+	brs, err := bridges()
+	if err != nil {
+		return "", err
+	}
+	delete(brs, name)
+	return inUse(brs, spec), nil
+}
+
+// FromName gets the bridge of a network by name
+func FromName(name string) (*Bridge, error) {
+	b := fromName(name)
+	log := b.log.WithField("Func", "FromName()")
+	log.Debug()
+
+	if !available() {
+		return nil, fmt.Errorf("open vswitch is not installed")
+	}
+	brs, err := bridges()
+	if err != nil {
+		log.WithError(err).Debug()
+		return nil, err
+	}
+	if _, ok := brs[name]; !ok {
+		return nil, fmt.Errorf("%v is not a bridge of vxrouter", name)
+	}
+	return b, nil
+}
+
+// FromLinkIndex returns the bridge of the internal port with index li
+func FromLinkIndex(li int) (*Bridge, error) {
+	l, err := netlink.LinkByIndex(li)
+	if err != nil {
+		return nil, err
+	}
+	return FromLink(l)
+}
+
+// FromLink returns the bridge of an internal port link
+func FromLink(link netlink.Link) (*Bridge, error) {
+	if link.Type() != "openvswitch" {
+		return nil, fmt.Errorf("link is not an open vswitch port")
+	}
+	return FromName(link.Attrs().Name)
+}
+
+// CheckVersion checks that Open vSwitch is installed and the running ovs-vswitchd is at least
+// 2.17, the first with fdb/add and fdb/del to import neighbors
+func CheckVersion() error {
+	if !available() {
+		return fmt.Errorf("open vswitch is not installed")
+	}
+	out, err := run("ovs-appctl", "version")
+	if err != nil {
+		return err
+	}
+	m := versionRe.FindStringSubmatch(out)
+	if m == nil {
+		return fmt.Errorf("unknown open vswitch version %q", strings.TrimSpace(out))
+	}
+	major, _ := strconv.Atoi(m[1]) // nolint: errcheck
+	minor, _ := strconv.Atoi(m[2]) // nolint: errcheck
+	if major < minMajor || major == minMajor && minor < minMinor {
+		return fmt.Errorf("open vswitch %v.%v is older than %v.%v, the first with fdb/add", major, minor, minMajor, minMinor)
+	}
+	return nil
+}
+
+// Bridges returns the bridges of vxrouter, none if Open vSwitch is not installed
+func Bridges() ([]*Bridge, error) {
+	if !available() {
+		return nil, nil
+	}
+	brs, err := bridges()
+	if err != nil {
+		return nil, err
+	}
+	ret := []*Bridge{}
+	for n := range brs {
+		ret = append(ret, fromName(n))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret, nil
+}
+
+// Name returns the name
+func (b *Bridge) Name() string {
+	return b.name
+}
+
+// GetIndex returns the index of the internal port of the bridge
+func (b *Bridge) GetIndex() int {
+	log := b.log.WithField("Func", "GetIndex()")
+	log.Debug()
+
+	link, err := netlink.LinkByName(b.name)
+	if err != nil {
+		log.WithError(err).Debug()
+		return 0
+	}
+	return link.Attrs().Index
+}
+
+func (b *Bridge) spec() (map[string]string, error) {
+	out, err := vsctl("br-get-external-id", b.name, extTunnel)
+	if err != nil {
+		return nil, err
+	}
+	return parseSpec(unquote(strings.TrimSpace(out))), nil
+}
+
+// VTEP returns the vxlan id and the local tunnel endpoint address, nil if it is left to the routes of the host
+func (b *Bridge) VTEP() (int, net.IP, error) {
+	log := b.log.WithField("Func", "VTEP()")
+	log.Debug()
+
+	spec, err := b.spec()
+	if err != nil {
+		log.WithError(err).Debug()
+		return 0, nil, err
+	}
+	vni, err := strconv.Atoi(spec["key"])
+	if err != nil {
+		return 0, nil, fmt.Errorf("bridge %v has no vxlanid", b.name)
+	}
+	return vni, net.ParseIP(spec["local_ip"]), nil
+}
+
+// CreateMacvlan creates a macvlan on the internal port of the bridge
+func (b *Bridge) CreateMacvlan(name string) (*macvlan.Macvlan, error) {
+	log := b.log.WithField("Func", "CreateMacvlan()")
+	log.Debug()
+
+	link, err := netlink.LinkByName(b.name)
+	if err != nil {
+		log.WithError(err).Debug()
+		return nil, err
+	}
+	mvl, err := macvlan.New(name, link.Attrs().Index)
+	if err != nil {
+		return nil, err
+	}
+	// a macvlan inherits the mtu of its parent, unless it already existed
+	err = mvl.SetMTU(link.Attrs().MTU)
+	if err != nil {
+		log.WithError(err).Debug("failed to set macvlan mtu")
+		return nil, err
+	}
+	return mvl, nil
+}
+
+// DeleteMacvlan deletes a macvlan on the internal port of the bridge by name
+func (b *Bridge) DeleteMacvlan(name string) error {
+	log := b.log.WithField("Func", "DeleteMacvlan()")
+	log.Debug()
+
+	mvl, err := macvlan.FromName(name)
+	if err != nil {
+		log.WithError(err).Debug()
+		return err
+	}
+	if mvl.GetParentIndex() != b.GetIndex() {
+		return fmt.Errorf("macvlan is not on this bridge")
+	}
+	return mvl.Delete()
+}
+
+// GetSlaveDevices returns the links on the internal port of the bridge, the macvlans
+func (b *Bridge) GetSlaveDevices() ([]netlink.Link, error) {
+	log := b.log.WithField("Func", "GetSlaveDevices()")
+	log.Debug()
+
+	index := b.GetIndex()
+	if index == 0 {
+		return nil, fmt.Errorf("bridge %v has no internal port", b.name)
+	}
+	links, err := netlink.LinkList()
+	if err != nil {
+		log.WithError(err).Debug("failed to get all links")
+		return nil, err
+	}
+	ret := []netlink.Link{}
+	for _, link := range links {
+		if link.Attrs().ParentIndex == index && link.Attrs().Index != index {
+			ret = append(ret, link)
+		}
+	}
+	return ret, nil
+}
+
+// Delete deletes the bridge with its ports. The kernel deletes the macvlans on its internal port.
+func (b *Bridge) Delete() error {
+	log := b.log.WithField("Func", "Delete()")
+	log.Debug()
+
+	_, err := vsctl("--if-exists", "del-br", b.name)
+	return err
+}
+
+// portName returns the name of the vxlan port of a bridge to peer
+func portName(bridge string, peer net.IP) string {
+	h := fnv.New32a()
+	h.Write([]byte(bridge + " " + peer.String())) // nolint: errcheck
+	return fmt.Sprintf("vxt%08x", h.Sum32())
+}
+
+// SetPeers adds the vxlan ports of the bridge missing for peers, and removes those of peers which
+// left. The ports are protected, traffic from one peer is not forwarded to another, so flooded
+// traffic is only replicated by the host it came from.
+func (b *Bridge) SetPeers(peers []net.IP) error {
+	log := b.log.WithField("Func", "SetPeers()")
+	log.Debug()
+
+	spec, err := b.spec()
+	if err != nil {
+		return err
+	}
+	out, err := vsctl("--bare", "--columns=name", "find", "interface", "external_ids:"+extBridge+"="+strconv.Quote(b.name))
+	if err != nil {
+		return err
+	}
+	have := []string{}
+	for _, r := range records(out, 1) {
+		have = append(have, r[0])
+	}
+	sort.Strings(have)
+
+	args := []string{}
+	want := make(map[string]bool)
+	for _, p := range peers {
+		name := portName(b.name, p)
+		want[name] = true
+		if i := sort.SearchStrings(have, name); i < len(have) && have[i] == name {
+			continue
+		}
+		args = append(args, "--", "--may-exist", "add-port", b.name, name,
+			"--", "set", "interface", name, "type=vxlan", "options:remote_ip="+strconv.Quote(p.String()))
+		for _, kv := range strings.Split(formatSpec(spec), ",") {
+			kv := strings.SplitN(kv, "=", 2)
+			args = append(args, "options:"+kv[0]+"="+strconv.Quote(kv[1]))
+		}
+		args = append(args,
+			"external_ids:"+extBridge+"="+strconv.Quote(b.name), "external_ids:"+extPeer+"="+strconv.Quote(p.String()),
+			"--", "set", "port", name, "protected=true")
+		log.WithField("peer", p).Debug("adding flood peer")
+	}
+	for _, name := range have {
+		if !want[name] {
+			args = append(args, "--", "--if-exists", "del-port", b.name, name)
+			log.WithField("port", name).Debug("removing flood peer")
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	_, err = vsctl(args[1:]...)
+	return err
+}
+
+// Remotes returns the tunnel endpoint each MAC learned from a peer is behind
+func (b *Bridge) Remotes() (map[string]net.IP, error) {
+	out, err := vsctl("--bare", "--columns=ofport,external_ids", "find", "interface", "external_ids:"+extBridge+"="+strconv.Quote(b.name))
+	if err != nil {
+		return nil, err
+	}
+	peers := make(map[string]net.IP)
+	for _, r := range records(out, 2) {
+		if p := net.ParseIP(bareMap(r[1])[extPeer]); p != nil {
+			peers[strings.TrimSpace(r[0])] = p
+		}
+	}
+
+	out, err = run("ovs-appctl", "fdb/show", b.name)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]net.IP)
+	// port VLAN MAC Age
+	for _, l := range strings.Split(out, "\n") {
+		f := strings.Fields(l)
+		if len(f) < 3 {
+			continue
+		}
+		p, ok := peers[f[0]]
+		mac, err := net.ParseMAC(f[2])
+		if !ok || err != nil {
+			continue
+		}
+		ret[mac.String()] = p
+	}
+	return ret, nil
+}
+
+// AddRemote points mac at the vxlan port of the peer vtep, as if it was learned from it
+func (b *Bridge) AddRemote(mac net.HardwareAddr, vtep net.IP) error {
+	_, err := run("ovs-appctl", "fdb/add", b.name, portName(b.name, vtep), "0", mac.String())
+	return err
+}
+
+// DelRemote removes the entry of mac, if it is still behind the peer vtep
+func (b *Bridge) DelRemote(mac net.HardwareAddr, vtep net.IP) error {
+	rs, err := b.Remotes()
+	if err != nil {
+		return err
+	}
+	if p, ok := rs[mac.String()]; !ok || (vtep != nil && !p.Equal(vtep)) {
+		return nil
+	}
+	_, err = run("ovs-appctl", "fdb/del", b.name, "0", mac.String())
+	return err
+}
@@ -0,0 +1,335 @@
+package ovs
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeOVS replaces the Open vSwitch commands, recording them and answering
+// those starting with a key of out
+type fakeOVS struct {
+	out  map[string]string
+	cmds []string
+}
+
+func newFakeOVS(out map[string]string) (*fakeOVS, func()) {
+	f := &fakeOVS{out: out}
+	oldRun, oldAvailable := run, available
+	run = func(cmd string, args ...string) (string, error) {
+		c := strings.Join(append([]string{cmd}, args...), " ")
+		f.cmds = append(f.cmds, c)
+		for k, v := range f.out {
+			if strings.HasPrefix(c, k) {
+				return v, nil
+			}
+		}
+		return "", nil
+	}
+	available = func() bool { return true }
+	return f, func() { run, available = oldRun, oldAvailable }
+}
+
+const listBridges = "ovs-vsctl --timeout=10 --bare --columns=name,external_ids list bridge"
+
+func TestTunnelSpec(t *testing.T) {
+	tests := []struct {
+		name string
+		opts map[string]string
+		want map[string]string
+	}{
+		{"default port", map[string]string{"vxlanid": "10"}, map[string]string{"key": "10", "dst_port": "4789"}},
+		{"hex vxlanid", map[string]string{"vxlanid": "0x10"}, map[string]string{"key": "16", "dst_port": "4789"}},
+		{"options", map[string]string{"vxlanid": "10", "port": "4790", "srcaddr": "10.0.0.1", "ttl": "64", "tos": "Inherit", "udpcsum": "1"},
+			map[string]string{"key": "10", "dst_port": "4790", "local_ip": "10.0.0.1", "ttl": "64", "tos": "inherit", "csum": "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TunnelSpec(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTunnelSpecInvalid(t *testing.T) {
+	tests := []map[string]string{
+		{},
+		{"vxlanid": "16777216"},
+		{"vxlanid": "10", "group": "239.1.1.1"},
+		{"vxlanid": "10", "learning": "false"},
+		{"vxlanid": "10", "portlow": "4000", "porthigh": "5000"},
+		{"vxlanid": "10", "srcaddr": "host"},
+		{"vxlanid": "10", "udpcsum": "sometimes"},
+		{"vxlanid": "10", "ttl": "many"},
+	}
+	for _, tt := range tests {
+		if got, err := TunnelSpec(tt); err == nil {
+			t.Errorf("%v: got %v, want an error", tt, got)
+		}
+	}
+}
+
+func TestSpecRoundTrip(t *testing.T) {
+	spec := map[string]string{"key": "10", "dst_port": "4789", "local_ip": "fd00::1"}
+	s := formatSpec(spec)
+	if s != "dst_port=4789,key=10,local_ip=fd00::1" {
+		t.Errorf("formatted as %q, want sorted pairs", s)
+	}
+	if got := parseSpec(s); !reflect.DeepEqual(got, spec) {
+		t.Errorf("parsed as %v, want %v", got, spec)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	tests := []struct {
+		out  string
+		n    int
+		want []record
+	}{
+		{"", 1, []record{}},
+		{"\n", 2, []record{}},
+		{"a\n", 1, []record{{"a"}}},
+		{"a\n\nb\n", 1, []record{{"a"}, {"b"}}},
+		{"a\n\n\nb\nk=v\n", 2, []record{{"a", ""}, {"b", "k=v"}}},
+	}
+	for _, tt := range tests {
+		if got := records(tt.out, tt.n); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.out, got, tt.want)
+		}
+	}
+	want := map[string]string{"a": "1", "b": "x=1,y", "c": "plain"}
+	if got := bareMap(`a="1" b="x=1,y" c=plain`); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBridges(t *testing.T) {
+	_, restore := newFakeOVS(map[string]string{listBridges: "" +
+		"br0\n\n\n" +
+		"net1\nvxrouter-tunnel=\"dst_port=4789,key=10\" vxrouter-vni=\"10\"\n\n" +
+		"net2\nvxrouter-tunnel=\"dst_port=4790,key=10\" vxrouter-vni=\"10\"\n"})
+	defer restore()
+
+	brs, err := Bridges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, b := range brs {
+		names = append(names, b.Name())
+	}
+	if !reflect.DeepEqual(names, []string{"net1", "net2"}) {
+		t.Errorf("got bridges %v, want only those of vxrouter", names)
+	}
+
+	for _, tt := range []struct {
+		opts map[string]string
+		want string
+	}{
+		{map[string]string{"vxlanid": "10"}, "net1"},
+		{map[string]string{"vxlanid": "10", "port": "4790"}, "net2"},
+		{map[string]string{"vxlanid": "11"}, ""},
+	} {
+		got, err := InUse("net3", tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%v in use by %q, want %q", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestInUse(t *testing.T) {
+	brs := map[string]map[string]string{
+		"net1": {extTunnel: "dst_port=4789,key=10"},
+		"net2": {extTunnel: "dst_port=4790,key=10"},
+	}
+	tests := []struct {
+		spec map[string]string
+		want string
+	}{
+		{map[string]string{"key": "10", "dst_port": "4789"}, "net1"},
+		{map[string]string{"key": "10", "dst_port": "4790"}, "net2"},
+		{map[string]string{"key": "10", "dst_port": "4791"}, ""},
+		{map[string]string{"key": "11", "dst_port": "4789"}, ""},
+	}
+	for _, tt := range tests {
+		if got := inUse(brs, tt.spec); got != tt.want {
+			t.Errorf("%v in use by %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestNewConflict(t *testing.T) {
+	f, restore := newFakeOVS(map[string]string{listBridges: "" +
+		"net1\nvxrouter-tunnel=dst_port=4789,key=10 vxrouter-vni=10\n"})
+	defer restore()
+
+	if _, err := New("net1", map[string]string{"vxlanid": "10", "port": "4790"}); err == nil {
+		t.Error("created a bridge which exists with other tunnel options")
+	}
+	if _, err := New("net2", map[string]string{"vxlanid": "10"}); err == nil {
+		t.Error("created a bridge with the vxlanid of another")
+	}
+	for _, c := range f.cmds {
+		if c != listBridges {
+			t.Errorf("ran %q on a conflict", c)
+		}
+	}
+}
+
+func TestNewArgs(t *testing.T) {
+	f, restore := newFakeOVS(nil)
+	defer restore()
+
+	// the internal port does not exist, the bridge is not created in tests
+	New("net1", map[string]string{"vxlanid": "10", "ageing": "60", "vxlanmtu": "1450"}) // nolint: errcheck
+	want := "ovs-vsctl --timeout=10 --may-exist add-br net1" +
+		" -- br-set-external-id net1 vxrouter-vni 10" +
+		" -- br-set-external-id net1 vxrouter-tunnel dst_port=4789,key=10" +
+		" -- set bridge net1 other_config:mac-aging-time=\"60\"" +
+		" -- set interface net1 mtu_request=1450"
+	if len(f.cmds) != 2 || f.cmds[1] != want {
+		t.Errorf("ran %q, want %q", f.cmds, want)
+	}
+}
+
+func TestSetPeers(t *testing.T) {
+	p1, p2, p3 := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")
+	f, restore := newFakeOVS(map[string]string{
+		"ovs-vsctl --timeout=10 br-get-external-id net1 vxrouter-tunnel": "\"dst_port=4789,key=10\"\n",
+		"ovs-vsctl --timeout=10 --bare --columns=name find interface":    portName("net1", p1) + "\n\n" + portName("net1", p2) + "\n",
+	})
+	defer restore()
+
+	if err := (fromName("net1")).SetPeers([]net.IP{p1, p3}); err != nil {
+		t.Fatal(err)
+	}
+	set := f.cmds[len(f.cmds)-1]
+	n1, n2, n3 := portName("net1", p1), portName("net1", p2), portName("net1", p3)
+	if strings.Contains(set, "add-port net1 "+n1) {
+		t.Errorf("readded the port of a kept peer: %q", set)
+	}
+	add := "--may-exist add-port net1 " + n3 + " -- set interface " + n3 + " type=vxlan options:remote_ip=\"10.0.0.3\"" +
+		" options:dst_port=\"4789\" options:key=\"10\"" +
+		" external_ids:vxrouter-bridge=\"net1\" external_ids:vxrouter-peer=\"10.0.0.3\"" +
+		" -- set port " + n3 + " protected=true"
+	if !strings.Contains(set, add) {
+		t.Errorf("ran %q, want the port of the new peer %q", set, add)
+	}
+	if !strings.HasSuffix(set, "-- --if-exists del-port net1 "+n2) {
+		t.Errorf("ran %q, want the port of the peer which left removed", set)
+	}
+
+	f.cmds = nil
+	f.out["ovs-vsctl --timeout=10 --bare --columns=name find interface"] = ""
+	if err := (fromName("net1")).SetPeers(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.cmds) != 2 {
+		t.Errorf("ran %q, want nothing changed", f.cmds)
+	}
+
+	f.cmds = nil
+	f.out["ovs-vsctl --timeout=10 --bare --columns=name find interface"] = n1 + "\n\n" + n3 + "\n"
+	if err := (fromName("net1")).SetPeers([]net.IP{p1, p3}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.cmds) != 2 {
+		t.Errorf("ran %q, want nothing changed", f.cmds)
+	}
+}
+
+func TestPortName(t *testing.T) {
+	p := net.ParseIP("10.0.0.1")
+	n := portName("net1", p)
+	if len(n) > 15 {
+		t.Errorf("port name %v is longer than an interface name", n)
+	}
+	if n != portName("net1", net.ParseIP("10.0.0.1")) {
+		t.Error("port name is not stable")
+	}
+	if n == portName("net2", p) || n == portName("net1", net.ParseIP("10.0.0.2")) {
+		t.Error("port names of other bridges or peers collide")
+	}
+}
+
+func TestRemotes(t *testing.T) {
+	f, restore := newFakeOVS(map[string]string{
+		"ovs-vsctl --timeout=10 --bare --columns=ofport,external_ids find interface": "" +
+			"2\nvxrouter-bridge=net1 vxrouter-peer=\"10.0.0.2\"\n\n" +
+			"3\nvxrouter-bridge=net1 vxrouter-peer=\"fd00::3\"\n",
+		"ovs-appctl fdb/show net1": "" +
+			" port  VLAN  MAC                Age\n" +
+			"LOCAL     0  02:00:00:00:00:01    1\n" +
+			"    2     0  02:00:00:00:00:02    5\n" +
+			"    3     0  02:00:00:00:00:03    9\n",
+	})
+	defer restore()
+
+	b := fromName("net1")
+	rs, err := b.Remotes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]net.IP{
+		"02:00:00:00:00:02": net.ParseIP("10.0.0.2"),
+		"02:00:00:00:00:03": net.ParseIP("fd00::3"),
+	}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("got %v, want %v", rs, want)
+	}
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:02") // nolint: errcheck
+	f.cmds = nil
+	if err = b.DelRemote(mac, net.ParseIP("fd00::3")); err != nil {
+		t.Fatal(err)
+	}
+	if c := f.cmds[len(f.cmds)-1]; strings.HasPrefix(c, "ovs-appctl fdb/del") {
+		t.Errorf("ran %q, removed an entry behind another peer", c)
+	}
+	if err = b.DelRemote(mac, nil); err != nil {
+		t.Fatal(err)
+	}
+	if c := f.cmds[len(f.cmds)-1]; c != "ovs-appctl fdb/del net1 0 02:00:00:00:00:02" {
+		t.Errorf("ran %q, want the entry removed", c)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		out string
+		ok  bool
+	}{
+		{"ovs-vswitchd (Open vSwitch) 2.17.7\n", true},
+		{"ovs-vswitchd (Open vSwitch) 3.1.0\n", true},
+		{"ovs-vswitchd (Open vSwitch) 2.13.8\n", false},
+		{"ovs-vswitchd (Open vSwitch) 1.18.0\n", false},
+		{"ovs-vswitchd\n", false},
+	}
+	for _, tt := range tests {
+		f, restore := newFakeOVS(map[string]string{"ovs-appctl version": tt.out})
+		err := CheckVersion()
+		restore()
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: got %v, want ok %v", tt.out, err, tt.ok)
+		}
+		if len(f.cmds) != 1 || f.cmds[0] != "ovs-appctl version" {
+			t.Errorf("ran %q, want the version of ovs-vswitchd", f.cmds)
+		}
+	}
+
+	_, restore := newFakeOVS(nil)
+	defer restore()
+	available = func() bool { return false }
+	if err := CheckVersion(); err == nil {
+		t.Error("checked without open vswitch installed")
+	}
+}
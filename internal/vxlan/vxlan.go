@@ -11,11 +11,19 @@ import (
 	"github.com/vishvananda/netlink"
 
 	"github.com/TrilliumIT/vxrouter"
-	"github.com/TrilliumIT/vxrouter/macvlan"
+	"github.com/TrilliumIT/vxrouter/internal/gwns"
+	"github.com/TrilliumIT/vxrouter/internal/macvlan"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
 )
 
 const (
 	envPrefix = vxrouter.EnvPrefix
+	// MaxVxlanID is the largest valid vxlan id
+	MaxVxlanID = 16777215
+	// defaultPort is the destination port the kernel gives a vxlan created without one
+	defaultPort = 8472
+	// tosInherit is the type of service copying that of the inner packet
+	tosInherit = 1
 )
 
 // Vxlan is a vxlan interface
@@ -34,7 +42,7 @@ func (v *Vxlan) nl() (*netlink.Vxlan, error) { // nolint: dupl
 	log := v.log.WithField("Func", "nl()")
 	log.Debug()
 
-	link, err := netlink.LinkByName(v.name)
+	link, err := gwns.Handle().LinkByName(v.name)
 	if err != nil {
 		log.WithError(err).Debug("failed to get link by name")
 		return nil, err
@@ -69,15 +77,120 @@ func ParseVxlanID(v string) (int, error) {
 		vid = int(v64)
 	}
 
-	if vid < 0 || vid > 16777215 {
+	if vid < 0 || vid > MaxVxlanID {
 		err = fmt.Errorf("vxlanid is out of range")
 	}
 	return vid, err
 }
 
+// Owner is an existing vxlan interface on this host which a new vxlan collides with
+type Owner struct {
+	Name    string
+	VxlanID int
+	Port    int
+	Group   net.IP
+	// Namespace is root or gateway
+	Namespace string
+	// Foreign is set if the vxlan is not one of vxrouter's, it has no host macvlan
+	Foreign bool
+	// Reason is what collides, the vxlan id or the multicast group
+	Reason string
+}
+
+func (o *Owner) String() string {
+	s := fmt.Sprintf("%v in the %v namespace, with vxlanid %v, port %v", o.Name, o.Namespace, o.VxlanID, o.Port)
+	if o.Group != nil {
+		s += fmt.Sprintf(", group %v", o.Group)
+	}
+	if o.Foreign {
+		s += ", not created by vxrouter"
+	}
+	return s
+}
+
+// InUse returns the existing vxlan interface on this host, in the root or gateway namespace,
+// which a vxlan with the vxlan id vid and the options opts would collide with, or nil if there
+// is none. It is one with the same vxlan id and destination port, which the kernel would refuse
+// to create another with, or one of another system, eg. flannel or a manual script, joined to
+// the same multicast group with another vxlan id, which would leak its flooded traffic into the
+// overlay. vxrouter networks may share a group.
+func InUse(vid int, opts map[string]string) (*Owner, error) {
+	port := defaultPort
+	p := opts["port"]
+	if p == "" {
+		p = os.Getenv(envPrefix + "port")
+	}
+	if p != "" {
+		var err error
+		port, err = strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: %v", p, err)
+		}
+	}
+	var group net.IP
+	g := opts["group"]
+	if g == "" {
+		g = os.Getenv(envPrefix + "group")
+	}
+	if g != "" {
+		var err error
+		group, err = parseIP(g)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group %q: %v", g, err)
+		}
+	}
+
+	hs := []*netlink.Handle{gwns.Root()}
+	if gwns.Handle() != gwns.Root() {
+		hs = append(hs, gwns.Handle())
+	}
+	for i, h := range hs {
+		ns := "root"
+		if i > 0 {
+			ns = "gateway"
+		}
+		links, err := h.LinkList()
+		if err != nil {
+			return nil, err
+		}
+		ours := make(map[int]bool)
+		for _, link := range links {
+			if _, ok := link.(*netlink.Macvlan); ok && strings.HasPrefix(link.Attrs().Name, "hmvl_") {
+				ours[link.Attrs().ParentIndex] = true
+			}
+		}
+		for _, link := range links {
+			nl, ok := link.(*netlink.Vxlan)
+			if !ok {
+				continue
+			}
+			o := &Owner{Name: nl.Name, VxlanID: nl.VxlanId, Port: nl.Port, Group: nl.Group, Namespace: ns, Foreign: !ours[nl.Index]}
+			if o.Port == 0 {
+				o.Port = defaultPort
+			}
+			if len(o.Group) == 0 || !o.Group.IsMulticast() {
+				o.Group = nil
+			}
+			switch {
+			case nl.VxlanId == vid && o.Port == port:
+				o.Reason = fmt.Sprintf("vxlanid %v", vid)
+			case group != nil && o.Foreign && nl.VxlanId != vid && group.Equal(o.Group):
+				o.Reason = fmt.Sprintf("multicast group %v", group)
+			case nl.VxlanId == vid && o.Foreign:
+				log.WithField("vxlan", o.String()).Warn("vxlanid is also used by another system on another port")
+				continue
+			default:
+				continue
+			}
+			return o, nil
+		}
+	}
+	return nil, nil
+}
+
 func linkIndexByName(name string) (int, error) {
 	var i int
-	dev, err := netlink.LinkByName(name)
+	dev, err := gwns.Root().LinkByName(name)
 	if err == nil {
 		i = dev.Attrs().Index
 	}
@@ -87,7 +200,7 @@ func linkIndexByName(name string) (int, error) {
 
 func applyOpts(nl *netlink.Vxlan, opts map[string]string) (bool, error) {
 	var ok bool
-	keys := [...]string{"vxlanmtu", "vxlanhardwareaddr", "vxlantxqlen", "vxlanid", "vtepdev", "srcaddr", "group", "ttl", "tos", "learning", "proxy", "rsc", "l2miss", "l3miss", "noage", "gbp", "age", "limit", "port", "portlow", "porthigh", "vxlanhardwareaddr", "vxlanmtu"}
+	keys := [...]string{"vxlanmtu", "vxlanhardwareaddr", "vxlantxqlen", "vxlanid", "vtepdev", "srcaddr", "group", "ttl", "tos", "learning", "proxy", "rsc", "l2miss", "l3miss", "udpcsum", "noage", "gbp", "age", "ageing", "limit", "port", "portlow", "porthigh", "vxlanhardwareaddr", "vxlanmtu"}
 
 	for _, k := range keys {
 		if _, ok = opts[k]; !ok && os.Getenv(envPrefix+k) != "" {
@@ -125,7 +238,7 @@ func applyOpts(nl *netlink.Vxlan, opts map[string]string) (bool, error) {
 			n = nl.SrcAddr.String()
 		case "group":
 			o = nl.Group.String()
-			nl.Group = net.ParseIP(v)
+			nl.Group, err = parseIP(v)
 			n = nl.Group.String()
 		case "ttl":
 			o = strconv.Itoa(nl.TTL)
@@ -133,7 +246,11 @@ func applyOpts(nl *netlink.Vxlan, opts map[string]string) (bool, error) {
 			n = strconv.Itoa(nl.TTL)
 		case "tos":
 			o = strconv.Itoa(nl.TOS)
-			nl.TOS, err = strconv.Atoi(v)
+			if strings.EqualFold(v, "inherit") {
+				nl.TOS = tosInherit
+			} else {
+				nl.TOS, err = strconv.Atoi(v)
+			}
 			n = strconv.Itoa(nl.TOS)
 		case "learning":
 			o = strconv.FormatBool(nl.Learning)
@@ -155,6 +272,10 @@ func applyOpts(nl *netlink.Vxlan, opts map[string]string) (bool, error) {
 			o = strconv.FormatBool(nl.L3miss)
 			nl.L3miss, err = strconv.ParseBool(v)
 			n = strconv.FormatBool(nl.L3miss)
+		case "udpcsum":
+			o = strconv.FormatBool(nl.UDPCSum)
+			nl.UDPCSum, err = strconv.ParseBool(v)
+			n = strconv.FormatBool(nl.UDPCSum)
 		case "noage":
 			o = strconv.FormatBool(nl.NoAge)
 			nl.NoAge, err = strconv.ParseBool(v)
@@ -163,9 +284,11 @@ func applyOpts(nl *netlink.Vxlan, opts map[string]string) (bool, error) {
 			o = strconv.FormatBool(nl.GBP)
 			nl.GBP, err = strconv.ParseBool(v)
 			n = strconv.FormatBool(nl.GBP)
-		case "age":
+		case "age", "ageing":
 			o = strconv.Itoa(nl.Age)
 			nl.Age, err = strconv.Atoi(v)
+			// an ageing time of 0 is only sent, meaning never, with NoAge
+			nl.NoAge = nl.NoAge || (err == nil && nl.Age == 0)
 			n = strconv.Itoa(nl.Age)
 		case "limit":
 			o = strconv.Itoa(nl.Limit)
@@ -226,13 +349,15 @@ func newVxlan(vxlanName string, opts map[string]string, retry bool) (*Vxlan, err
 	}
 
 	if !new && changed {
-		err = fmt.Errorf("vxlan interface already exists with wrong attributes")
+		err = vxrerrors.Conflict("vxlan interface already exists with wrong attributes")
 		log.WithError(err).Debug()
 		return nil, err
 	}
 
 	if new {
-		err = netlink.LinkAdd(nl)
+		// the vxlan is created in the root namespace, its tunnel socket stays there
+		// on the underlay when it is moved into a gateway namespace
+		err = gwns.Root().LinkAdd(nl)
 		if err != nil {
 			if retry { // try again, in case another thread already brought it up
 				log.WithError(err).Debug("retrying")
@@ -241,6 +366,19 @@ func newVxlan(vxlanName string, opts map[string]string, retry bool) (*Vxlan, err
 			log.WithError(err).Debug("not retrying")
 			return nil, err
 		}
+		if gwns.Enabled() {
+			err = gwns.MoveIn(nl)
+			if err != nil {
+				log.WithError(err).Error("failed to move vxlan into gateway namespace")
+				gwns.Root().LinkDel(nl) // nolint: errcheck
+				return nil, err
+			}
+			nl, err = v.nl()
+			if err != nil {
+				log.WithError(err).Debug("failed to get vxlan in gateway namespace")
+				return nil, err
+			}
+		}
 	}
 
 	// Parse interface options
@@ -256,7 +394,7 @@ func newVxlan(vxlanName string, opts map[string]string, retry bool) (*Vxlan, err
 			if hardwareAddr.String() == nl.HardwareAddr.String() {
 				break
 			}
-			err = netlink.LinkSetHardwareAddr(nl, hardwareAddr)
+			err = gwns.Handle().LinkSetHardwareAddr(nl, hardwareAddr)
 		case "vxlanmtu":
 			var mtu int
 			mtu, err = strconv.Atoi(v)
@@ -266,7 +404,7 @@ func newVxlan(vxlanName string, opts map[string]string, retry bool) (*Vxlan, err
 			if mtu == nl.MTU {
 				break
 			}
-			err = netlink.LinkSetMTU(nl, mtu)
+			err = gwns.Handle().LinkSetMTU(nl, mtu)
 		}
 		if err != nil {
 			log.WithError(err).Debug()
@@ -275,7 +413,7 @@ func newVxlan(vxlanName string, opts map[string]string, retry bool) (*Vxlan, err
 	}
 
 	// bring interfaces up
-	err = netlink.LinkSetUp(nl)
+	err = gwns.Handle().LinkSetUp(nl)
 	if err != nil {
 		log.WithError(err).Debug("failed to bring up vxlan")
 		return nil, err
@@ -301,7 +439,7 @@ func FromName(name string) (*Vxlan, error) {
 
 // FromLinkIndex returns a Vxlan from an interface name
 func FromLinkIndex(li int) (*Vxlan, error) { // nolint: dupl
-	l, err := netlink.LinkByIndex(li)
+	l, err := gwns.Handle().LinkByIndex(li)
 	if err != nil {
 		return nil, err
 	}
@@ -334,7 +472,17 @@ func (v *Vxlan) CreateMacvlan(name string) (*macvlan.Macvlan, error) {
 		return nil, err
 	}
 
-	return macvlan.New(name, nl.LinkAttrs.Index)
+	mvl, err := macvlan.New(name, nl.LinkAttrs.Index)
+	if err != nil {
+		return nil, err
+	}
+	// a macvlan inherits the mtu of its parent, unless it already existed
+	err = mvl.SetMTU(nl.LinkAttrs.MTU)
+	if err != nil {
+		log.WithError(err).Debug("failed to set macvlan mtu")
+		return nil, err
+	}
+	return mvl, nil
 }
 
 // DeleteMacvlan deletes the slave macvlan interface by name
@@ -373,7 +521,7 @@ func (v *Vxlan) Delete() error {
 		return nil
 	}
 
-	return netlink.LinkDel(nl)
+	return gwns.Handle().LinkDel(nl)
 }
 
 // GetMacVlans returns all slave macvlan interfaces
@@ -412,7 +560,7 @@ func (v *Vxlan) GetSlaveDevices() ([]netlink.Link, error) {
 
 	r := []netlink.Link{}
 
-	allLinks, err := netlink.LinkList()
+	allLinks, err := gwns.Handle().LinkList()
 	if err != nil {
 		log.WithError(err).Debug("failed to get all links")
 		return r, err
@@ -427,7 +575,53 @@ func (v *Vxlan) GetSlaveDevices() ([]netlink.Link, error) {
 	return r, nil
 }
 
+// GetIndex returns the index of the interface
+func (v *Vxlan) GetIndex() int { // nolint: dupl
+	log := v.log.WithField("Func", "GetIndex()")
+	log.Debug()
+
+	nl, err := v.nl()
+	if err != nil {
+		log.WithError(err).Debug()
+		return 0
+	}
+	return nl.Attrs().Index
+}
+
 // Name returns the name
 func (v *Vxlan) Name() string {
 	return v.name
 }
+
+// VTEP returns the vxlan id and the local tunnel endpoint address, the source
+// address if set, else the first address of the vtep device
+func (v *Vxlan) VTEP() (int, net.IP, error) {
+	log := v.log.WithField("Func", "VTEP()")
+	log.Debug()
+
+	nl, err := v.nl()
+	if err != nil {
+		log.WithError(err).Debug()
+		return 0, nil, err
+	}
+	if nl.SrcAddr != nil && !nl.SrcAddr.IsUnspecified() {
+		return nl.VxlanId, nl.SrcAddr, nil
+	}
+	if nl.VtepDevIndex == 0 {
+		return nl.VxlanId, nil, nil
+	}
+	dev, err := gwns.Root().LinkByIndex(nl.VtepDevIndex)
+	if err != nil {
+		return nl.VxlanId, nil, err
+	}
+	addrs, err := gwns.Root().AddrList(dev, netlink.FAMILY_ALL)
+	if err != nil {
+		return nl.VxlanId, nil, err
+	}
+	for _, a := range addrs {
+		if a.IP.IsGlobalUnicast() {
+			return nl.VxlanId, a.IP, nil
+		}
+	}
+	return nl.VxlanId, nil, nil
+}
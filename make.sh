@@ -37,6 +37,21 @@ MAIN_VER=$(grep "\t*Version *= " const.go | sed 's/\t*Version *= //g' | sed 's/"
 check_prerequisites || exit 1
 check_versions || exit 1
 
+echo "Testing..."
+go test ./...
+
 echo "Building..."
 mkdir bin 2>/dev/null || true
-go build -o bin/vxrnet ./docker/vxrnet
+go build -o bin/vxrnet ./cmd/vxrnet
+
+# release binaries, edge gateways commonly run on arm
+for arch in ${ARCHES:-amd64 arm64 arm}; do
+	echo "Building linux/${arch}..."
+	# vet also type checks the tests, they run on the build host and, with
+	# binfmt emulation, eg. qemu-user-static, for the release architectures
+	CGO_ENABLED=0 GOOS=linux GOARCH=${arch} GOARM=7 go vet ./...
+	if [ -n "${TEST_ARCHES}" ] && [[ " ${TEST_ARCHES} " == *" ${arch} "* ]] ; then
+		CGO_ENABLED=0 GOOS=linux GOARCH=${arch} GOARM=7 go test ./...
+	fi
+	CGO_ENABLED=0 GOOS=linux GOARCH=${arch} GOARM=7 go build -o bin/vxrnet-linux-${arch} ./cmd/vxrnet
+done
@@ -0,0 +1,129 @@
+// Package allocator proposes the addresses tried when a container requests none.
+// Allocators only pick candidates; every candidate is still checked against the
+// exclusions, the routes of other hosts and duplicate address detection before
+// it is used. Custom allocators are compiled in by calling Register from an init function.
+package allocator
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/kvstore"
+)
+
+// Default is the allocator of networks which do not select one
+const Default = "random"
+
+// Allocator picks candidate addresses
+type Allocator interface {
+	// Candidate returns the next address to try in n, excluding the first xf and last xl addresses
+	Candidate(n *net.IPNet, xf, xl int) (net.IP, error)
+}
+
+// Claimer is implemented by allocators which lock a candidate before its route is installed.
+// Claim returns false if another host holds the address, Unclaim releases a candidate
+// claimed which is not used.
+type Claimer interface {
+	Claim(ctx context.Context, sn *net.IPNet, ip net.IP) (bool, error)
+	Unclaim(ctx context.Context, sn *net.IPNet, ip net.IP) error
+}
+
+// Peeker is implemented by allocators which can preview their next candidates without
+// changing what they return next, for planning an allocation without making it
+type Peeker interface {
+	// Peek returns the next count candidates Candidate would return
+	Peek(n *net.IPNet, xf, xl, count int) ([]net.IP, error)
+}
+
+// Reserver is implemented by allocators which reserve their candidates elsewhere, eg. in an
+// external IPAM. Reserve is used instead of Candidate, with the context of the address request,
+// and each reserved candidate which is not used is released.
+type Reserver interface {
+	// Reserve reserves and returns the next address to try in n, excluding the first xf and last xl addresses
+	Reserve(ctx context.Context, n *net.IPNet, xf, xl int) (net.IP, error)
+	// Release frees a candidate returned by Reserve
+	Release(ctx context.Context, ip net.IP) error
+	// Reserved reports whether ip was returned by Reserve and not released
+	Reserved(ip net.IP) bool
+}
+
+// ExternalIPAM reserves and releases addresses in an external IPAM system
+type ExternalIPAM interface {
+	// Next reserves and returns the next free address of the prefix of pool
+	Next(ctx context.Context, pool *net.IPNet) (net.IP, error)
+	// Release frees a reserved address
+	Release(ctx context.Context, ip net.IP) error
+}
+
+// Config is what an allocator is created with
+type Config struct {
+	// Options are the options of the network
+	Options map[string]string
+	// KV is the kv store of the plugin, nil if none is configured
+	KV kvstore.Locker
+	// Owner is the name locks are taken in, the hostname and the instance
+	Owner string
+	// KVTTL is how long locks in the kv store are held
+	KVTTL time.Duration
+	// ExternalIPAM is the external IPAM of the plugin, nil if none is configured
+	ExternalIPAM ExternalIPAM
+}
+
+// Factory creates an allocator
+type Factory func(cfg *Config) (Allocator, error)
+
+var registry = struct {
+	l sync.RWMutex
+	m map[string]Factory
+}{m: make(map[string]Factory)}
+
+// Register makes an allocator available by name, eg. for the allocation network option.
+// It panics if name is already registered.
+func Register(name string, f Factory) {
+	registry.l.Lock()
+	defer registry.l.Unlock()
+	name = strings.ToLower(name)
+	if _, ok := registry.m[name]; ok {
+		panic(fmt.Sprintf("allocator %v is already registered", name))
+	}
+	registry.m[name] = f
+}
+
+// Known reports an error if name is not a registered allocator
+func Known(name string) error {
+	registry.l.RLock()
+	defer registry.l.RUnlock()
+	if _, ok := registry.m[strings.ToLower(name)]; ok || name == "" {
+		return nil
+	}
+	return fmt.Errorf("unknown allocator %v, must be one of %v", name, strings.Join(names(), ", "))
+}
+
+// New creates the allocator registered as name, the default if name is empty
+func New(name string, cfg *Config) (Allocator, error) {
+	if name == "" {
+		name = Default
+	}
+	registry.l.RLock()
+	f, ok := registry.m[strings.ToLower(name)]
+	registry.l.RUnlock()
+	if !ok {
+		return nil, Known(name)
+	}
+	return f(cfg)
+}
+
+func names() []string {
+	ret := make([]string, 0, len(registry.m))
+	for n := range registry.m {
+		ret = append(ret, n)
+	}
+	sort.Strings(ret)
+	return ret
+}
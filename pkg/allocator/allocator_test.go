@@ -0,0 +1,304 @@
+package allocator
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/kvstore"
+)
+
+func cidr(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func ips(l []net.IP) []string {
+	ret := make([]string, 0, len(l))
+	for _, ip := range l {
+		ret = append(ret, ip.String())
+	}
+	return ret
+}
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *Config
+		want    interface{}
+		wantErr bool
+	}{
+		{"", nil, Random{}, false},
+		{"random", nil, Random{}, false},
+		{"Sequential", nil, Sequential{}, false},
+		{"lru", nil, Sequential{LRU: true}, false},
+		{"least-recently-used", nil, Sequential{LRU: true}, false},
+		{"kvstore", &Config{}, nil, true},
+		{"external", &Config{}, nil, true},
+		{"unknown", nil, nil, true},
+	}
+	for _, tt := range tests {
+		a, err := New(tt.name, tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("New(%q) error is %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && a != tt.want {
+			t.Errorf("New(%q) = %#v, want %#v", tt.name, a, tt.want)
+		}
+	}
+
+	if err := Known("LRU"); err != nil {
+		t.Errorf("lru is unknown: %v", err)
+	}
+	if err := Known("unknown"); err == nil {
+		t.Error("unknown allocator is known")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registering random twice did not panic")
+		}
+	}()
+	Register("Random", func(*Config) (Allocator, error) { return Random{}, nil })
+}
+
+func TestRandom(t *testing.T) {
+	tests := []struct {
+		sn          string
+		xf, xl      int
+		first, last string
+	}{
+		{"10.1.2.0/29", 1, 1, "10.1.2.1", "10.1.2.6"},
+		{"10.1.2.0/29", 3, 2, "10.1.2.3", "10.1.2.5"},
+		{"fd00::/125", 1, 0, "fd00::1", "fd00::7"},
+	}
+	for _, tt := range tests {
+		n := cidr(tt.sn)
+		first, last := net.ParseIP(tt.first), net.ParseIP(tt.last)
+		ps, _ := Random{}.Peek(n, tt.xf, tt.xl, 200)
+		for _, ip := range ps {
+			if !n.Contains(ip) || bytesLess(ip, first) || bytesLess(last, ip) {
+				t.Errorf("random candidate %v of %v is outside %v-%v", ip, tt.sn, tt.first, tt.last)
+				break
+			}
+		}
+	}
+}
+
+func bytesLess(a, b net.IP) bool {
+	a, b = a.To16(), b.To16()
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// resetPool forgets the cursor and released addresses of n, shared by all sequential allocators
+func resetPool(n *net.IPNet) {
+	poolStatesL.Lock()
+	defer poolStatesL.Unlock()
+	delete(poolStates, n.String())
+}
+
+func TestSequential(t *testing.T) {
+	tests := []struct {
+		sn     string
+		xf, xl int
+		want   []string
+	}{
+		{"10.1.3.0/29", 1, 1, []string{"10.1.3.1", "10.1.3.2", "10.1.3.3", "10.1.3.4", "10.1.3.5", "10.1.3.6", "10.1.3.1"}},
+		{"10.1.4.0/30", 0, 0, []string{"10.1.4.0", "10.1.4.1", "10.1.4.2", "10.1.4.3", "10.1.4.0"}},
+		{"fd00:4::/126", 2, 0, []string{"fd00:4::2", "fd00:4::3", "fd00:4::2"}},
+	}
+	for _, tt := range tests {
+		n := cidr(tt.sn)
+		resetPool(n)
+		peeked, _ := Sequential{}.Peek(n, tt.xf, tt.xl, len(tt.want))
+		var got []net.IP
+		for range tt.want {
+			ip, err := Sequential{}.Candidate(n, tt.xf, tt.xl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, ip)
+		}
+		for i := range tt.want {
+			if got[i].String() != tt.want[i] || peeked[i].String() != tt.want[i] {
+				t.Errorf("%v candidates %v, peeked %v, want %v", tt.sn, ips(got), ips(peeked), tt.want)
+				break
+			}
+		}
+	}
+
+	// a pool too small for its exclusions has no candidate
+	if ip, _ := (Sequential{}).Candidate(cidr("10.1.5.0/31"), 1, 1); ip != nil {
+		t.Errorf("candidate %v of a pool with no address left", ip)
+	}
+}
+
+func TestLRU(t *testing.T) {
+	n := cidr("10.1.6.0/30")
+	resetPool(n)
+	lru := Sequential{LRU: true}
+	next := func() string {
+		ip, err := lru.Candidate(n, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ip.String()
+	}
+
+	// released addresses are not reused before the pool is walked
+	for _, want := range []string{"10.1.6.0", "10.1.6.1"} {
+		if got := next(); got != want {
+			t.Errorf("candidate %v, want %v", got, want)
+		}
+	}
+	Released(net.ParseIP("10.1.6.1"))
+	Released(net.ParseIP("10.1.6.0"))
+	Released(net.ParseIP("10.1.7.1"))
+	poolStatesL.Lock()
+	ps := poolStates[n.String()]
+	if len(ps.released) != 2 {
+		t.Errorf("released addresses of %v are %v, want 2", n, ps.released)
+	}
+	now := time.Now()
+	ps.released["10.1.6.1"] = now.Add(-2 * time.Minute)
+	ps.released["10.1.6.0"] = now.Add(-time.Minute)
+	poolStatesL.Unlock()
+
+	for _, want := range []string{"10.1.6.2", "10.1.6.3", "10.1.6.0"} {
+		if got := next(); got != want {
+			t.Errorf("candidate %v walking the pool, want %v", got, want)
+		}
+	}
+	// walked, the addresses released longest ago come first, then the cursor
+	peeked, _ := lru.Peek(n, 0, 0, 3)
+	want := []string{"10.1.6.1", "10.1.6.0", "10.1.6.1"}
+	for i, w := range want {
+		if got := next(); got != w || peeked[i].String() != w {
+			t.Errorf("candidates after walking the pool %v, peeked %v, want %v", got, ips(peeked), want)
+		}
+	}
+}
+
+func TestExternal(t *testing.T) {
+	var l sync.Mutex
+	answer := "10.1.8.9"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("subnet") != "10.1.8.0/24" || q.Get("exclude_first") != "1" || q.Get("exclude_last") != "2" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		l.Lock()
+		defer l.Unlock()
+		json.NewEncoder(w).Encode(&externalResponse{Address: answer}) // nolint: errcheck
+	}))
+	defer ts.Close()
+
+	a, err := New("external", &Config{Options: map[string]string{URLOption: ts.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sn      string
+		answer  string
+		want    string
+		wantErr bool
+	}{
+		{"10.1.8.0/24", "10.1.8.9", "10.1.8.9", false},
+		{"10.1.8.0/24", "10.1.9.9", "", true},
+		{"10.1.8.0/24", "not an address", "", true},
+		{"10.1.9.0/24", "10.1.9.9", "", true},
+	}
+	for _, tt := range tests {
+		l.Lock()
+		answer = tt.answer
+		l.Unlock()
+		ip, err := a.Candidate(cidr(tt.sn), 1, 2)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && ip.String() != tt.want) {
+			t.Errorf("candidate of %v answered %q is %v (%v), want %v error %v", tt.sn, tt.answer, ip, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// memLocker is an in memory kv store
+type memLocker struct {
+	l    sync.Mutex
+	held map[string]string
+}
+
+func (m *memLocker) Lock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if o, ok := m.held[key]; ok && o != owner {
+		return false, nil
+	}
+	m.held[key] = owner
+	return true, nil
+}
+
+func (m *memLocker) Unlock(ctx context.Context, key, owner string) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if m.held[key] == owner {
+		delete(m.held, key)
+	}
+	return nil
+}
+
+func TestKVStore(t *testing.T) {
+	kv := &memLocker{held: make(map[string]string)}
+	newKV := func(owner string) Claimer {
+		a, err := New("kvstore", &Config{KV: kv, Owner: owner, KVTTL: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a.(Claimer)
+	}
+	h1, h2 := newKV("host1"), newKV("host2")
+	sn, ip := cidr("10.1.10.0/24"), net.ParseIP("10.1.10.5")
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		claim   Claimer
+		unclaim bool
+		want    bool
+	}{
+		{"first claim", h1, false, true},
+		{"claimed again by its owner", h1, false, true},
+		{"claimed by another host", h2, false, false},
+		{"unclaimed by another host", h2, true, false},
+		{"unclaimed by its owner", h1, true, true},
+		{"claimed once released", h2, false, true},
+	}
+	for _, tt := range tests {
+		if tt.unclaim {
+			if err := tt.claim.Unclaim(ctx, sn, ip); err != nil {
+				t.Fatal(err)
+			}
+			_, held := kv.held[kvstore.AddressKey(sn.String(), ip.String())]
+			if held == tt.want {
+				t.Errorf("%v: held %v after unclaim", tt.name, held)
+			}
+			continue
+		}
+		ok, err := tt.claim.Claim(ctx, sn, ip)
+		if err != nil || ok != tt.want {
+			t.Errorf("%v: claimed %v (%v), want %v", tt.name, ok, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package allocator
+
+import (
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TrilliumIT/iputil"
+)
+
+func init() {
+	Register("random", func(*Config) (Allocator, error) { return Random{}, nil })
+	Register("sequential", func(*Config) (Allocator, error) { return Sequential{}, nil })
+	Register("lru", func(*Config) (Allocator, error) { return Sequential{LRU: true}, nil })
+	Register("least-recently-used", func(*Config) (Allocator, error) { return Sequential{LRU: true}, nil })
+}
+
+// Random selects random addresses
+type Random struct{}
+
+// Candidate returns a random address of n
+func (Random) Candidate(n *net.IPNet, xf, xl int) (net.IP, error) {
+	return iputil.RandAddrWithExclude(n, xf, xl), nil
+}
+
+// Sequential walks the pool from a per pool cursor. With LRU, once the pool has
+// been walked, addresses released longest ago are selected first.
+type Sequential struct {
+	LRU bool
+}
+
+// poolState is the allocation state of a range of addresses
+type poolState struct {
+	cursor   *big.Int
+	wrapped  bool
+	released map[string]time.Time
+}
+
+var (
+	poolStates  = make(map[string]*poolState)
+	poolStatesL sync.Mutex
+)
+
+func getPoolState(key string) *poolState {
+	ps, ok := poolStates[key]
+	if !ok {
+		ps = &poolState{released: make(map[string]time.Time)}
+		poolStates[key] = ps
+	}
+	return ps
+}
+
+// Released records the release of ip, for the lru allocator
+func Released(ip net.IP) {
+	poolStatesL.Lock()
+	defer poolStatesL.Unlock()
+	for k, ps := range poolStates {
+		if _, n, err := net.ParseCIDR(k); err == nil && n.Contains(ip) {
+			ps.released[ip.String()] = time.Now()
+		}
+	}
+}
+
+// Peek returns count random addresses of n, as random candidates can not be previewed
+func (Random) Peek(n *net.IPNet, xf, xl, count int) ([]net.IP, error) {
+	ret := make([]net.IP, 0, count)
+	for i := 0; i < count; i++ {
+		ret = append(ret, iputil.RandAddrWithExclude(n, xf, xl))
+	}
+	return ret, nil
+}
+
+// Candidate returns the address after the cursor of n, or with LRU the address released longest ago
+func (s Sequential) Candidate(n *net.IPNet, xf, xl int) (net.IP, error) {
+	poolStatesL.Lock()
+	defer poolStatesL.Unlock()
+	return s.next(getPoolState(n.String()), n, xf, xl), nil
+}
+
+// Peek returns the next count candidates of n, walking a copy of its state
+func (s Sequential) Peek(n *net.IPNet, xf, xl, count int) ([]net.IP, error) {
+	poolStatesL.Lock()
+	ps := &poolState{released: make(map[string]time.Time)}
+	if cur, ok := poolStates[n.String()]; ok {
+		ps.wrapped = cur.wrapped
+		if cur.cursor != nil {
+			ps.cursor = new(big.Int).Set(cur.cursor)
+		}
+		for a, t := range cur.released {
+			ps.released[a] = t
+		}
+	}
+	poolStatesL.Unlock()
+
+	ret := make([]net.IP, 0, count)
+	for i := 0; i < count; i++ {
+		ret = append(ret, s.next(ps, n, xf, xl))
+	}
+	return ret, nil
+}
+
+// next returns the next candidate of n and advances ps
+func (s Sequential) next(ps *poolState, n *net.IPNet, xf, xl int) net.IP {
+	if s.LRU && ps.wrapped {
+		var oldest string
+		var ot time.Time
+		for a, t := range ps.released {
+			if oldest == "" || t.Before(ot) {
+				oldest, ot = a, t
+			}
+		}
+		if oldest != "" {
+			delete(ps.released, oldest)
+			return net.ParseIP(oldest)
+		}
+	}
+
+	base := n.IP.Mask(n.Mask)
+	if b4 := base.To4(); b4 != nil {
+		base = b4
+	}
+	ones, bits := n.Mask.Size()
+	start := new(big.Int).Add(new(big.Int).SetBytes(base), big.NewInt(int64(xf)))
+	end := new(big.Int).Add(new(big.Int).SetBytes(base), new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)))
+	end.Sub(end, big.NewInt(int64(xl+1)))
+	if start.Cmp(end) > 0 {
+		return iputil.RandAddrWithExclude(n, xf, xl)
+	}
+
+	if ps.cursor == nil || ps.cursor.Cmp(start) < 0 {
+		ps.cursor = new(big.Int).Set(start)
+	} else {
+		ps.cursor.Add(ps.cursor, big.NewInt(1))
+	}
+	if ps.cursor.Cmp(end) > 0 {
+		ps.cursor.Set(start)
+		ps.wrapped = true
+	}
+
+	b := ps.cursor.Bytes()
+	ip := make(net.IP, len(base))
+	copy(ip[len(ip)-len(b):], b)
+	return ip
+}
@@ -0,0 +1,76 @@
+package allocator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// URLOption is the network option with the url of the external allocator
+const URLOption = "allocatorurl"
+
+// externalTimeout bounds each request to an external allocator
+const externalTimeout = 2 * time.Second
+
+func init() {
+	Register("external", newExternal)
+}
+
+// External asks an http service for candidates, with a GET of its url with the subnet,
+// exclude_first and exclude_last query parameters. The service answers with a json
+// object with the address, eg. {"address": "10.1.2.3"}. It is asked again if the
+// address turns out to be in use.
+type External struct {
+	URL string
+	hc  *http.Client
+}
+
+type externalResponse struct {
+	Address string `json:"address"`
+}
+
+func newExternal(cfg *Config) (Allocator, error) {
+	var u string
+	if cfg != nil {
+		u = cfg.Options[URLOption]
+	}
+	if u == "" {
+		return nil, fmt.Errorf("the external allocator requires the %v option", URLOption)
+	}
+	if _, err := url.Parse(u); err != nil {
+		return nil, err
+	}
+	return &External{URL: u, hc: &http.Client{Timeout: externalTimeout}}, nil
+}
+
+// Candidate asks the service for an address of n
+func (e *External) Candidate(n *net.IPNet, xf, xl int) (net.IP, error) {
+	q := url.Values{}
+	q.Set("subnet", n.String())
+	q.Set("exclude_first", strconv.Itoa(xf))
+	q.Set("exclude_last", strconv.Itoa(xl))
+
+	resp, err := e.hc.Get(e.URL + "?" + q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("external allocator returned %v", resp.Status)
+	}
+
+	var r externalResponse
+	err = json.NewDecoder(resp.Body).Decode(&r)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(r.Address)
+	if ip == nil || !n.Contains(ip) {
+		return nil, fmt.Errorf("external allocator returned %q, which is not an address of %v", r.Address, n)
+	}
+	return ip, nil
+}
@@ -0,0 +1,42 @@
+package allocator
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/kvstore"
+)
+
+func init() {
+	Register("kvstore", newKVStore)
+}
+
+// KVStore selects random addresses, each locked in the kv store before its route is
+// installed. Unlike the other allocators with a kv store configured, a network using it
+// fails to allocate rather than falling back to routes only when there is no kv store.
+type KVStore struct {
+	Random
+	KV    kvstore.Locker
+	Owner string
+	TTL   time.Duration
+}
+
+func newKVStore(cfg *Config) (Allocator, error) {
+	if cfg == nil || cfg.KV == nil {
+		return nil, fmt.Errorf("the kvstore allocator requires a kv store")
+	}
+	return &KVStore{KV: cfg.KV, Owner: cfg.Owner, TTL: cfg.KVTTL}, nil
+}
+
+// Claim locks ip in the kv store
+func (k *KVStore) Claim(ctx context.Context, sn *net.IPNet, ip net.IP) (bool, error) {
+	return k.KV.Lock(ctx, kvstore.AddressKey(sn.String(), ip.String()), k.Owner, k.TTL)
+}
+
+// Unclaim releases the lock on ip in the kv store
+func (k *KVStore) Unclaim(ctx context.Context, sn *net.IPNet, ip net.IP) error {
+	return k.KV.Unlock(ctx, kvstore.AddressKey(sn.String(), ip.String()), k.Owner)
+}
@@ -0,0 +1,201 @@
+// Package bird exports the container routes of this host to BIRD, for shops which already
+// run it and advertise routes upstream from it. The routes are written to include files
+// of static protocols, one per address family, and BIRD is told to reconfigure through its
+// control socket. The include files are meant for BIRD 2 static protocols such as
+//
+//	protocol static vxrouter4 { ipv4; include "/etc/bird/vxrouter.conf"; }
+//	protocol static vxrouter6 { ipv6; include "/etc/bird/vxrouter6.conf"; }
+package bird
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// flushDelay batches the changes made within it into one reconfiguration
+	flushDelay = time.Second
+	// socketTimeout is how long BIRD may take to reconfigure
+	socketTimeout = 30 * time.Second
+)
+
+// Exporter writes routes to the include files of BIRD static protocols
+type Exporter struct {
+	path, path6 string
+	socket      string
+	l           sync.Mutex
+	routes      map[string]string
+	dirty       chan struct{}
+	done        chan struct{}
+	log         *log.Entry
+}
+
+// New exports routes to the include file path for IPv4, and the same file with a 6 before its
+// extension for IPv6, eg. vxrouter6.conf for vxrouter.conf, reconfiguring BIRD through its
+// control socket. The files are written right away, so BIRD can be started with them.
+func New(path, socket string) (*Exporter, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no bird include file")
+	}
+	ext := filepath.Ext(path)
+	e := &Exporter{
+		path:   path,
+		path6:  strings.TrimSuffix(path, ext) + "6" + ext,
+		socket: socket,
+		routes: make(map[string]string),
+		dirty:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		log:    log.WithField("bird", path),
+	}
+	if err := e.write(); err != nil {
+		return nil, err
+	}
+	go e.run()
+	return e, nil
+}
+
+// Export adds a route to dst through dev
+func (e *Exporter) Export(dst *net.IPNet, dev string) {
+	e.l.Lock()
+	e.routes[dst.String()] = dev
+	e.l.Unlock()
+	e.changed()
+}
+
+// Withdraw removes the route to dst
+func (e *Exporter) Withdraw(dst *net.IPNet) {
+	e.l.Lock()
+	delete(e.routes, dst.String())
+	e.l.Unlock()
+	e.changed()
+}
+
+// Close stops exporting routes. The include files are left, BIRD withdraws the routes
+// when it is reconfigured without the links they go through.
+func (e *Exporter) Close() {
+	if e == nil {
+		return
+	}
+	close(e.done)
+}
+
+func (e *Exporter) changed() {
+	select {
+	case e.dirty <- struct{}{}:
+	default:
+	}
+}
+
+func (e *Exporter) run() {
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-e.dirty:
+		}
+		select {
+		case <-e.done:
+			return
+		case <-time.After(flushDelay):
+		}
+		if err := e.write(); err != nil {
+			e.log.WithError(err).Error("failed to write bird include files")
+			continue
+		}
+		if err := e.configure(); err != nil {
+			e.log.WithError(err).Error("failed to reconfigure bird")
+			continue
+		}
+		e.log.Debug("reconfigured bird")
+	}
+}
+
+// write writes the routes of each family to its include file
+func (e *Exporter) write() error {
+	e.l.Lock()
+	var v4, v6 []string
+	for dst, dev := range e.routes {
+		if dev == "" {
+			// the link went away before the route was exported, it is withdrawn next
+			continue
+		}
+		r := fmt.Sprintf("route %v via %q;", dst, dev)
+		if ip, _, err := net.ParseCIDR(dst); err == nil && ip.To4() == nil {
+			v6 = append(v6, r)
+		} else {
+			v4 = append(v4, r)
+		}
+	}
+	e.l.Unlock()
+
+	if err := writeInclude(e.path, v4); err != nil {
+		return err
+	}
+	return writeInclude(e.path6, v6)
+}
+
+// writeInclude writes routes to a temporary file and renames it over path, so BIRD never reads a partial file
+func writeInclude(path string, routes []string) error {
+	sort.Strings(routes)
+	b := []byte("# container routes of this host, written by vxrouter\n")
+	for _, r := range routes {
+		b = append(b, r+"\n"...)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// configure asks BIRD to reload its configuration, and with it the include files
+func (e *Exporter) configure() error {
+	conn, err := net.DialTimeout("unix", e.socket, socketTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() // nolint: errcheck
+	if err = conn.SetDeadline(time.Now().Add(socketTimeout)); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	// the greeting, 0001 BIRD <version> ready.
+	if err = reply(r); err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintln(conn, "configure"); err != nil {
+		return err
+	}
+	return reply(r)
+}
+
+// reply reads a reply of the control socket, up to the line ending it. Replies with an
+// 8xxx or 9xxx code are errors.
+func reply(r *bufio.Reader) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		// continuation lines start with a space or the code followed by -, async messages with +
+		if len(line) < 5 || line[4] != ' ' || strings.Trim(line[:4], "0123456789") != "" {
+			continue
+		}
+		if line[0] == '8' || line[0] == '9' {
+			return fmt.Errorf("bird: %v", line[5:])
+		}
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+// Package bus distributes endpoint advertisements and withdrawals over a message bus,
+// for environments which standardize on one instead of BGP. Each host publishes the
+// address, MAC and tunnel endpoint of its containers as they join and leave, and
+// programs the forwarding and neighbor entries of those published by other hosts.
+package bus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+)
+
+// DefaultSubject is the subject messages are published and consumed on
+const DefaultSubject = "vxrouter.endpoints"
+
+// Events published
+const (
+	EventAdvertise = "advertise"
+	EventWithdraw  = "withdraw"
+)
+
+// Message is the json payload published when endpoints join or leave a host
+type Message struct {
+	Event     string        `json:"event"`
+	Host      string        `json:"host"`
+	Entries   []neigh.Entry `json:"entries"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// transport is the connection to a nats or kafka bus, delivering each message consumed
+type transport interface {
+	publish([]byte) error
+	close()
+}
+
+// Bus publishes and consumes messages on a subject
+type Bus struct {
+	subject string
+	t       transport
+	l       sync.RWMutex
+	subs    []func(*Message)
+}
+
+// New connects to the bus at rawurl, as nats://[user:pass@]host[:port] or tls:// for
+// nats over tls, or kafka://[user:pass@]broker[:port][,broker[:port]...] or kafka+tls://
+// for kafka, and publishes and consumes on subject, the topic on kafka. Kafka topics
+// must have partition 0, all messages go through it. The connection is retried in the
+// background, messages published while it is down are dropped.
+func New(rawurl, subject string) (*Bus, error) {
+	u, err := ParseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if subject == "" {
+		subject = DefaultSubject
+	}
+	b := &Bus{subject: subject}
+	switch strings.ToLower(u.Scheme) {
+	case "kafka", "kafka+tls":
+		b.t, err = dialKafka(u, subject, b.deliver)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		b.t = dialNATS(u, subject, b.deliver)
+	}
+	return b, nil
+}
+
+// ParseURL parses and checks the url of a bus
+func ParseURL(rawurl string) (*url.URL, error) {
+	rawurl, brokers := splitKafkaBrokers(rawurl)
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if brokers != "" {
+		u.Host = brokers
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "nats", "tls":
+	case "kafka", "kafka+tls":
+		if _, err = kafkaBrokers(u); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown bus scheme %q, must be nats, tls, kafka or kafka+tls", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("bus url %q has no host", rawurl)
+	}
+	return u, nil
+}
+
+// Publish publishes a message, timestamped now if it has no timestamp
+func (b *Bus) Publish(m *Message) {
+	if b == nil {
+		return
+	}
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+	log := log.WithField("event", m.Event).WithField("entries", len(m.Entries))
+	d, err := json.Marshal(m)
+	if err != nil {
+		log.WithError(err).Error("failed to encode bus message")
+		return
+	}
+	err = b.t.publish(d)
+	if err != nil {
+		log.WithError(err).Error("failed to publish bus message, dropping it")
+		return
+	}
+	log.Debug("published bus message")
+}
+
+// Subscribe calls f with each message consumed, including those published by this host
+func (b *Bus) Subscribe(f func(*Message)) {
+	if b == nil {
+		return
+	}
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.subs = append(b.subs, f)
+}
+
+// Close disconnects from the bus
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	b.t.close()
+}
+
+func (b *Bus) deliver(d []byte) {
+	m := &Message{}
+	err := json.Unmarshal(d, m)
+	if err != nil {
+		log.WithError(err).Warn("ignoring invalid bus message")
+		return
+	}
+	b.l.RLock()
+	defer b.l.RUnlock()
+	for _, f := range b.subs {
+		f(m)
+	}
+}
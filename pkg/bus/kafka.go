@@ -0,0 +1,144 @@
+package bus
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	kafkaDefaultPort = "9092"
+	// kafkaTimeout bounds dialing and each write
+	kafkaTimeout = 10 * time.Second
+	// kafkaMaxBytes is the largest message fetched
+	kafkaMaxBytes = 1024 * 1024
+	// kafkaPartition is the partition all messages are published to and consumed from,
+	// so every host consumes every message, in the order published
+	kafkaPartition = 0
+)
+
+// kafkaConn publishes to and consumes from one topic, reconnecting until closed
+type kafkaConn struct {
+	w      *kafka.Writer
+	r      *kafka.Reader
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// splitKafkaBrokers returns a kafka url with only its first broker, which url.Parse
+// accepts, and all its brokers. Other urls are returned as they are.
+func splitKafkaBrokers(rawurl string) (string, string) {
+	i := strings.Index(rawurl, "://")
+	if i < 0 || !strings.HasPrefix(strings.ToLower(rawurl[:i]), "kafka") {
+		return rawurl, ""
+	}
+	rest := rawurl[i+3:]
+	a := rest
+	if j := strings.IndexAny(rest, "/?#"); j >= 0 {
+		a = rest[:j]
+	}
+	brokers := a[strings.LastIndex(a, "@")+1:]
+	first := strings.Split(brokers, ",")[0]
+	return rawurl[:i+3] + a[:len(a)-len(brokers)] + first + rest[len(a):], brokers
+}
+
+// kafkaBrokers returns the brokers of a kafka url, as host[:port][,host[:port]...]
+func kafkaBrokers(u *url.URL) ([]string, error) {
+	var brokers []string
+	for _, b := range strings.Split(u.Host, ",") {
+		if b == "" {
+			return nil, fmt.Errorf("empty broker in %q", u.Host)
+		}
+		h, p, err := net.SplitHostPort(b)
+		if err != nil {
+			h, p = strings.TrimSuffix(strings.TrimPrefix(b, "["), "]"), kafkaDefaultPort
+		}
+		if h == "" {
+			return nil, fmt.Errorf("broker %q has no host", b)
+		}
+		brokers = append(brokers, net.JoinHostPort(h, p))
+	}
+	return brokers, nil
+}
+
+func dialKafka(u *url.URL, topic string, deliver func([]byte)) (*kafkaConn, error) {
+	brokers, err := kafkaBrokers(u)
+	if err != nil {
+		return nil, err
+	}
+	d := &kafka.Dialer{Timeout: kafkaTimeout, DualStack: true}
+	if strings.EqualFold(u.Scheme, "kafka+tls") {
+		// the server name is that of each broker dialed
+		d.TLS = &tls.Config{}
+	}
+	if u.User != nil {
+		p, _ := u.User.Password()
+		d.SASLMechanism = plain.Mechanism{Username: u.User.Username(), Password: p}
+	}
+
+	log := log.WithField("bus", u.Host)
+	r := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers,
+		Topic:       topic,
+		Partition:   kafkaPartition,
+		Dialer:      d,
+		MaxBytes:    kafkaMaxBytes,
+		MaxWait:     time.Second,
+		ErrorLogger: kafka.LoggerFunc(log.Warnf),
+	})
+	// only messages published from now on, like nats
+	if err = r.SetOffset(kafka.LastOffset); err != nil {
+		r.Close() // nolint: errcheck
+		return nil, err
+	}
+	w := kafka.NewWriter(kafka.WriterConfig{
+		Brokers:      brokers,
+		Topic:        topic,
+		Dialer:       d,
+		Balancer:     kafka.BalancerFunc(func(kafka.Message, ...int) int { return kafkaPartition }),
+		BatchTimeout: 10 * time.Millisecond,
+		WriteTimeout: kafkaTimeout,
+		// published in the background, messages are dropped if the brokers are down
+		Async:       true,
+		ErrorLogger: kafka.LoggerFunc(log.Errorf),
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	kc := &kafkaConn{w: w, r: r, cancel: cancel, done: make(chan struct{})}
+	go kc.run(ctx, deliver)
+	return kc, nil
+}
+
+func (kc *kafkaConn) run(ctx context.Context, deliver func([]byte)) {
+	defer close(kc.done)
+	for {
+		m, err := kc.r.ReadMessage(ctx)
+		if err != nil {
+			// the reader retries on its own, errors are from closing
+			return
+		}
+		deliver(m.Value)
+	}
+}
+
+func (kc *kafkaConn) publish(d []byte) error {
+	if len(d) > kafkaMaxBytes {
+		return fmt.Errorf("message of %v bytes is above the %v bytes consumed", len(d), kafkaMaxBytes)
+	}
+	return kc.w.WriteMessages(context.Background(), kafka.Message{Value: d})
+}
+
+func (kc *kafkaConn) close() {
+	kc.cancel()
+	kc.w.Close() // nolint: errcheck
+	kc.r.Close() // nolint: errcheck
+	<-kc.done
+}
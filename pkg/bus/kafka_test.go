@@ -0,0 +1,67 @@
+package bus
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKafkaBrokers(t *testing.T) {
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"kafka://broker", []string{"broker:9092"}},
+		{"kafka://broker:9093", []string{"broker:9093"}},
+		{"kafka://a:9093,b,c:9094", []string{"a:9093", "b:9092", "c:9094"}},
+		{"kafka+tls://user:pass@a,b", []string{"a:9092", "b:9092"}},
+		{"kafka://a:9093,b/", []string{"a:9093", "b:9092"}},
+		{"kafka://[fd00::1]:9093,[fd00::2]", []string{"[fd00::1]:9093", "[fd00::2]:9092"}},
+	}
+	for _, tt := range tests {
+		u, err := ParseURL(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := kafkaBrokers(u)
+		if err != nil || !reflect.DeepEqual(b, tt.want) {
+			t.Errorf("brokers of %v are %v (%v), want %v", tt.url, b, err, tt.want)
+		}
+	}
+	for _, u := range []string{"kafka://a,,b", "kafka://a,:9092", "kafka://", "kafka:///topic"} {
+		if _, err := ParseURL(u); err == nil {
+			t.Errorf("%v parsed", u)
+		}
+	}
+}
+
+func TestKafkaDown(t *testing.T) {
+	// a port nothing listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close() // nolint: errcheck
+
+	b, err := New("kafka://"+addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// dropped, without blocking
+		b.Publish(&Message{Event: EventAdvertise, Host: "test"})
+		if err := b.t.publish(make([]byte, kafkaMaxBytes+1)); err == nil {
+			t.Error("published a message above the bytes consumed")
+		}
+		b.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("publishing or closing blocked on the brokers")
+	}
+}
@@ -0,0 +1,241 @@
+package bus
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	natsDefaultPort = "4222"
+	// natsTimeout bounds dialing and each write
+	natsTimeout = 5 * time.Second
+	// natsMaxBackoff is the longest wait between reconnects
+	natsMaxBackoff = 30 * time.Second
+	// natsSid is the id of the single subscription of a connection
+	natsSid = "1"
+	// natsDefaultMaxPayload is the largest message of a server which announces no max_payload
+	natsDefaultMaxPayload = 1024 * 1024
+)
+
+// natsConn is a minimal client of the nats text protocol, publishing to and
+// subscribed on one subject, reconnecting until closed
+type natsConn struct {
+	u       *url.URL
+	subject string
+	deliver func([]byte)
+
+	l      sync.Mutex
+	c      net.Conn
+	w      *bufio.Writer
+	closed bool
+	// maxPayload is the largest message the server accepts and sends
+	maxPayload int
+}
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	MaxPayload  int  `json:"max_payload"`
+}
+
+func dialNATS(u *url.URL, subject string, deliver func([]byte)) *natsConn {
+	nc := &natsConn{u: u, subject: subject, deliver: deliver}
+	go nc.run()
+	return nc
+}
+
+func (nc *natsConn) run() {
+	log := log.WithField("bus", nc.u.Host)
+	wait := time.Second
+	for {
+		r, err := nc.connect()
+		if err == nil {
+			log.Info("connected to bus")
+			wait = time.Second
+			err = nc.read(r)
+		}
+		nc.l.Lock()
+		if nc.c != nil {
+			nc.c.Close() // nolint: errcheck
+			nc.c, nc.w = nil, nil
+		}
+		closed := nc.closed
+		nc.l.Unlock()
+		if closed {
+			return
+		}
+		log.WithError(err).WithField("retry", wait).Warn("disconnected from bus, reconnecting")
+		time.Sleep(wait)
+		if wait *= 2; wait > natsMaxBackoff {
+			wait = natsMaxBackoff
+		}
+	}
+}
+
+// connect dials the server, reads its info, and sends the connect and subscribe ops
+func (nc *natsConn) connect() (*bufio.Reader, error) {
+	addr := nc.u.Host
+	if nc.u.Port() == "" {
+		addr = net.JoinHostPort(nc.u.Hostname(), natsDefaultPort)
+	}
+	c, err := net.DialTimeout("tcp", addr, natsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(c)
+
+	c.SetReadDeadline(time.Now().Add(natsTimeout)) // nolint: errcheck
+	line, err := r.ReadString('\n')
+	if err != nil {
+		c.Close() // nolint: errcheck
+		return nil, err
+	}
+	c.SetReadDeadline(time.Time{}) // nolint: errcheck
+	if !strings.HasPrefix(line, "INFO ") {
+		c.Close() // nolint: errcheck
+		return nil, fmt.Errorf("expected INFO from the nats server, got %q", strings.TrimSpace(line))
+	}
+	var info natsInfo
+	if err = json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		c.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	if info.TLSRequired || strings.EqualFold(nc.u.Scheme, "tls") {
+		tc := tls.Client(c, &tls.Config{ServerName: nc.u.Hostname()})
+		c.SetDeadline(time.Now().Add(natsTimeout)) // nolint: errcheck
+		if err = tc.Handshake(); err != nil {
+			c.Close() // nolint: errcheck
+			return nil, err
+		}
+		c.SetDeadline(time.Time{}) // nolint: errcheck
+		c = tc
+		r = bufio.NewReader(c)
+	}
+
+	opts := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "vxrouter",
+		"lang":     "go",
+		"protocol": 0,
+	}
+	if ui := nc.u.User; ui != nil {
+		if p, ok := ui.Password(); ok {
+			opts["user"], opts["pass"] = ui.Username(), p
+		} else {
+			opts["auth_token"] = ui.Username()
+		}
+	}
+	co, err := json.Marshal(opts)
+	if err != nil {
+		c.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	nc.l.Lock()
+	defer nc.l.Unlock()
+	if nc.closed {
+		c.Close() // nolint: errcheck
+		return nil, fmt.Errorf("closed")
+	}
+	nc.c, nc.w = c, bufio.NewWriter(c)
+	nc.maxPayload = info.MaxPayload
+	if nc.maxPayload <= 0 {
+		nc.maxPayload = natsDefaultMaxPayload
+	}
+	err = nc.write("CONNECT " + string(co) + "\r\nSUB " + nc.subject + " " + natsSid + "\r\n")
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// read handles server ops until the connection fails
+func (nc *natsConn) read(r *bufio.Reader) error {
+	nc.l.Lock()
+	max := nc.maxPayload
+	nc.l.Unlock()
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		op := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch op {
+		case "PING":
+			nc.l.Lock()
+			err = nc.write("PONG\r\n")
+			nc.l.Unlock()
+			if err != nil {
+				return err
+			}
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			f := strings.Fields(line)
+			if len(f) < 4 {
+				return fmt.Errorf("invalid nats message %q", line)
+			}
+			var n int
+			n, err = strconv.Atoi(f[len(f)-1])
+			if err != nil {
+				return fmt.Errorf("invalid nats message %q: %v", line, err)
+			}
+			// the size is the server's word, a message above its own max_payload is a broken stream
+			if n < 0 || n > max {
+				return fmt.Errorf("invalid nats message %q: size is not between 0 and the max_payload %v", line, max)
+			}
+			d := make([]byte, n+2)
+			if _, err = io.ReadFull(r, d); err != nil {
+				return err
+			}
+			if string(d[n:]) != "\r\n" {
+				return fmt.Errorf("nats message %q is not terminated after %v bytes", line, n)
+			}
+			nc.deliver(d[:n])
+		case "-ERR":
+			log.WithField("bus", nc.u.Host).WithField("error", strings.TrimPrefix(line, "-ERR ")).Error("nats server returned an error")
+		}
+	}
+}
+
+// write writes and flushes ops to the connection. Caller must hold the lock.
+func (nc *natsConn) write(s string) error {
+	if nc.c == nil {
+		return fmt.Errorf("not connected to %v", nc.u.Host)
+	}
+	nc.c.SetWriteDeadline(time.Now().Add(natsTimeout)) // nolint: errcheck
+	if _, err := nc.w.WriteString(s); err != nil {
+		return err
+	}
+	return nc.w.Flush()
+}
+
+func (nc *natsConn) publish(d []byte) error {
+	nc.l.Lock()
+	defer nc.l.Unlock()
+	if nc.c != nil && len(d) > nc.maxPayload {
+		return fmt.Errorf("message of %v bytes is above the max_payload %v of %v", len(d), nc.maxPayload, nc.u.Host)
+	}
+	return nc.write("PUB " + nc.subject + " " + strconv.Itoa(len(d)) + "\r\n" + string(d) + "\r\n")
+}
+
+func (nc *natsConn) close() {
+	nc.l.Lock()
+	defer nc.l.Unlock()
+	nc.closed = true
+	if nc.c != nil {
+		nc.c.Close() // nolint: errcheck
+	}
+}
@@ -0,0 +1,161 @@
+package control
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// Client is a client for the control api of another host
+type Client struct {
+	base  string
+	token string
+	hc    *http.Client
+}
+
+// NewClient creates a client for the control api listening on addr, host:port for
+// http or https://host:port for https
+func NewClient(addr, token string, timeout time.Duration) *Client {
+	base := addr
+	if !strings.HasPrefix(addr, "https://") && !strings.HasPrefix(addr, "http://") {
+		base = "http://" + addr
+	}
+	return &Client{
+		base:  strings.TrimSuffix(base, "/"),
+		token: token,
+		hc:    &http.Client{Timeout: timeout},
+	}
+}
+
+// SetRootCAs verifies the certificate of an https control api against pool
+// instead of the system roots
+func (c *Client) SetRootCAs(pool *x509.CertPool) {
+	c.hc.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+	}
+}
+
+func (c *Client) get(path string, v interface{}) error {
+	return c.do(http.MethodGet, path, v)
+}
+
+func (c *Client) post(path string, v interface{}) error {
+	return c.do(http.MethodPost, path, v)
+}
+
+func (c *Client) do(method, path string, v interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return vxrerrors.NotFound("control api %v returned %v", path, resp.Status)
+	case http.StatusConflict:
+		return vxrerrors.Conflict("control api %v returned %v", path, resp.Status)
+	case http.StatusInsufficientStorage:
+		return vxrerrors.Exhausted("control api %v returned %v", path, resp.Status)
+	case http.StatusGatewayTimeout:
+		return vxrerrors.Timeout("control api %v returned %v", path, resp.Status)
+	case http.StatusServiceUnavailable:
+		return vxrerrors.Unavailable("control api %v returned %v", path, resp.Status)
+	default:
+		return fmt.Errorf("control api %v returned %v", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// State fetches the state of the remote host
+func (c *Client) State() (*core.State, error) {
+	s := &core.State{}
+	return s, c.get(statePath, s)
+}
+
+// Status fetches the pool capacity of the networks of the remote host
+func (c *Client) Status() ([]*core.PoolStatus, error) {
+	st := []*core.PoolStatus{}
+	err := c.get(statusPath, &st)
+	return st, err
+}
+
+// Neighbors fetches the known neighbors of the remote host
+func (c *Client) Neighbors() ([]neigh.Entry, error) {
+	ns := []neigh.Entry{}
+	err := c.get(neighPath, &ns)
+	return ns, err
+}
+
+// Pools fetches the address utilization of the pools of the remote host
+func (c *Client) Pools() ([]*core.PoolUtilization, error) {
+	pu := []*core.PoolUtilization{}
+	err := c.get(poolsPath, &pu)
+	return pu, err
+}
+
+// Freeze freezes a pool on the remote host, returning the network driver using it
+func (c *Client) Freeze(pool string) (string, error) {
+	var d string
+	err := c.post(freezePath+"?pool="+url.QueryEscape(pool), &d)
+	return d, err
+}
+
+// Unfreeze unfreezes a pool on the remote host, returning the network driver using it
+func (c *Client) Unfreeze(pool string) (string, error) {
+	var d string
+	err := c.post(unfreezePath+"?pool="+url.QueryEscape(pool), &d)
+	return d, err
+}
+
+// Verify fetches the consistency reports of the remote host, repairing the inconsistencies found if repair is set
+func (c *Client) Verify(repair bool) ([]*core.VerifyReport, error) {
+	reps := []*core.VerifyReport{}
+	var err error
+	if repair {
+		err = c.post(verifyPath, &reps)
+	} else {
+		err = c.get(verifyPath, &reps)
+	}
+	return reps, err
+}
+
+// Plan fetches what allocating an address on a network of the remote host would do,
+// the requested address addr if not empty
+func (c *Client) Plan(network, addr string) (*core.Plan, error) {
+	q := url.Values{}
+	q.Set("network", network)
+	if addr != "" {
+		q.Set("address", addr)
+	}
+	p := &core.Plan{}
+	err := c.get(planPath+"?"+q.Encode(), p)
+	return p, err
+}
+
+// Convergence fetches the route propagation reports of the remote host, by network driver name
+func (c *Client) Convergence() (map[string]*core.ConvergenceReport, error) {
+	cv := make(map[string]*core.ConvergenceReport)
+	err := c.get(convPath, &cv)
+	return cv, err
+}
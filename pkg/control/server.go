@@ -0,0 +1,402 @@
+package control
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+const (
+	statePath    = "/state"
+	topologyPath = "/topology"
+	statusPath   = "/status"
+	budgetPath   = "/budget"
+	flushPath    = "/cache/flush"
+	neighPath    = "/neighbors"
+	freezePath   = "/pools/freeze"
+	unfreezePath = "/pools/unfreeze"
+	poolsPath    = "/pools"
+	verifyPath   = "/verify"
+	planPath     = "/plan"
+	convPath     = "/convergence"
+)
+
+// Server serves the control api
+type Server struct {
+	cores []*core.Core
+	token string
+	srv   *http.Server
+	log   *log.Entry
+	// readOnly refuses requests which change the driver state
+	readOnly bool
+}
+
+// NewServer creates a new control api server for the driver instances of cores.
+// If token is not empty, requests must carry it as a bearer token.
+func NewServer(token string, cores ...*core.Core) *Server {
+	s := &Server{
+		cores: cores,
+		token: token,
+		log:   log.WithField("server", "control"),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(statePath, s.auth(s.state))
+	mux.HandleFunc(topologyPath, s.auth(s.topology))
+	mux.HandleFunc(statusPath, s.auth(s.status))
+	mux.HandleFunc(budgetPath, s.auth(s.budget))
+	mux.HandleFunc(flushPath, s.auth(s.flush))
+	mux.HandleFunc(neighPath, s.auth(s.neighbors))
+	mux.HandleFunc(freezePath, s.auth(s.freeze))
+	mux.HandleFunc(unfreezePath, s.auth(s.freeze))
+	mux.HandleFunc(poolsPath, s.auth(s.pools))
+	mux.HandleFunc(verifyPath, s.auth(s.verify))
+	mux.HandleFunc(planPath, s.auth(s.plan))
+	mux.HandleFunc(convPath, s.auth(s.convergence))
+	s.srv = &http.Server{Handler: mux}
+
+	return s
+}
+
+// SetReadOnly refuses requests which change the driver state, such as freezing a pool
+func (s *Server) SetReadOnly() {
+	s.readOnly = true
+}
+
+// Serve serves the control api on l
+func (s *Server) Serve(l net.Listener) error {
+	return s.srv.Serve(l)
+}
+
+// ServeTLS serves the control api over https on l, with the certificate and key in certFile and keyFile
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	return s.srv.ServeTLS(l, certFile, keyFile)
+}
+
+// Shutdown gracefully shuts down the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) auth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+			s.log.WithField("remote", r.RemoteAddr).WithField("path", r.URL.Path).Warn("unauthorized control request")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) state(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("state()")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := &core.State{Networks: []types.NetworkResource{}, Allocations: []string{}}
+	for _, c := range s.cores {
+		cst, err := c.State()
+		if err != nil {
+			s.log.WithError(err).Error("failed to get state")
+			httpError(w, err)
+			return
+		}
+		st.Networks = append(st.Networks, cst.Networks...)
+		st.Allocations = append(st.Allocations, cst.Allocations...)
+	}
+
+	writeJSON(w, st)
+}
+
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("status()")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := []*core.PoolStatus{}
+	for _, c := range s.cores {
+		cst, err := c.Status()
+		if err != nil {
+			s.log.WithError(err).Error("failed to get status")
+			httpError(w, err)
+			return
+		}
+		st = append(st, cst...)
+	}
+
+	writeJSON(w, st)
+}
+
+// pools serves the address utilization of the pools of all driver instances
+func (s *Server) pools(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("pools()")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pu := []*core.PoolUtilization{}
+	for _, c := range s.cores {
+		cpu, err := c.Utilization()
+		if err != nil {
+			s.log.WithError(err).Error("failed to get pool utilization")
+			httpError(w, err)
+			return
+		}
+		pu = append(pu, cpu...)
+	}
+
+	writeJSON(w, pu)
+}
+
+// budget serves the response budget histograms of each driver instance, by network driver name
+func (s *Server) budget(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("budget()")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b := make(map[string]map[string]core.BudgetHistogram)
+	for _, c := range s.cores {
+		b[c.NetworkDriverName()] = c.Budget()
+	}
+
+	writeJSON(w, b)
+}
+
+// convergence serves the route propagation reports of each driver instance, by network driver name
+func (s *Server) convergence(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("convergence()")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cv := make(map[string]*core.ConvergenceReport)
+	for _, c := range s.cores {
+		cv[c.NetworkDriverName()] = c.Convergence()
+	}
+
+	writeJSON(w, cv)
+}
+
+// flush flushes the network caches and serves the number of networks re-enumerated, by network driver name
+func (s *Server) flush(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("flush()")
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := make(map[string]int)
+	for _, c := range s.cores {
+		cn, err := c.FlushCache()
+		if err != nil {
+			s.log.WithError(err).Error("failed to re-enumerate networks")
+			httpError(w, err)
+			return
+		}
+		n[c.NetworkDriverName()] = cn
+	}
+
+	writeJSON(w, n)
+}
+
+// neighbors serves the known neighbors of all networks on GET, and imports neighbors on POST,
+// serving the number imported by network driver name
+func (s *Server) neighbors(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("neighbors()")
+	switch r.Method {
+	case http.MethodGet:
+		ns := []neigh.Entry{}
+		for _, c := range s.cores {
+			cns, err := c.Neighbors()
+			if err != nil {
+				s.log.WithError(err).Error("failed to get neighbors")
+				httpError(w, err)
+				return
+			}
+			ns = append(ns, cns...)
+		}
+		writeJSON(w, ns)
+	case http.MethodPost:
+		ns := []neigh.Entry{}
+		err := json.NewDecoder(r.Body).Decode(&ns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := make(map[string]int)
+		for _, c := range s.cores {
+			cn, err := c.ImportNeighbors(ns)
+			if err != nil {
+				s.log.WithError(err).Error("failed to import neighbors")
+				httpError(w, err)
+				return
+			}
+			n[c.NetworkDriverName()] = cn
+		}
+		writeJSON(w, n)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// freeze freezes or unfreezes the pool given by the pool query parameter, on the driver
+// instance using it, and serves the network driver name of that instance
+func (s *Server) freeze(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).WithField("path", r.URL.Path).Debug("freeze()")
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.readOnly {
+		http.Error(w, "the control api is read only", http.StatusForbidden)
+		return
+	}
+	pool := r.URL.Query().Get("pool")
+	if _, _, err := net.ParseCIDR(pool); err != nil {
+		http.Error(w, "invalid pool: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, c := range s.cores {
+		var err error
+		if r.URL.Path == freezePath {
+			err = c.Freeze(pool)
+		} else {
+			err = c.Unfreeze(pool)
+		}
+		if errors.Is(err, vxrerrors.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			s.log.WithField("pool", pool).WithError(err).Error("failed to change pool freeze")
+			httpError(w, err)
+			return
+		}
+		writeJSON(w, c.NetworkDriverName())
+		return
+	}
+	httpError(w, vxrerrors.NotFound("pool %v is not used or not frozen by any driver instance", pool))
+}
+
+// verify serves the consistency reports of all driver instances on GET, and repairs the
+// inconsistencies found on POST
+func (s *Server) verify(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).WithField("method", r.Method).Debug("verify()")
+	repair := false
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if s.readOnly {
+			http.Error(w, "the control api is read only", http.StatusForbidden)
+			return
+		}
+		repair = true
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reps := []*core.VerifyReport{}
+	for _, c := range s.cores {
+		rep, err := c.Verify(repair)
+		if err != nil {
+			s.log.WithError(err).Error("failed to verify")
+			httpError(w, err)
+			return
+		}
+		reps = append(reps, rep)
+	}
+
+	writeJSON(w, reps)
+}
+
+// plan serves what allocating an address on the network given by the network query
+// parameter would do, the address query parameter if set, on the driver instance of
+// the network. Nothing is changed, so it is served by a read only api too.
+func (s *Server) plan(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("plan()")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	network, addr := r.URL.Query().Get("network"), r.URL.Query().Get("address")
+	if network == "" {
+		http.Error(w, "missing network", http.StatusBadRequest)
+		return
+	}
+	if addr != "" && net.ParseIP(addr) == nil {
+		http.Error(w, "invalid address "+addr, http.StatusBadRequest)
+		return
+	}
+
+	for _, c := range s.cores {
+		p, err := c.Plan(network, addr)
+		if errors.Is(err, vxrerrors.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			s.log.WithField("network", network).WithError(err).Error("failed to plan")
+			httpError(w, err)
+			return
+		}
+		writeJSON(w, p)
+		return
+	}
+	httpError(w, vxrerrors.NotFound("network %v is not used by any driver instance", network))
+}
+
+func (s *Server) topology(w http.ResponseWriter, r *http.Request) {
+	s.log.WithField("remote", r.RemoteAddr).Debug("topology()")
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, host.LLDPNeighbors())
+}
+
+// httpError writes err with a status code for its vxrerrors category
+func httpError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, vxrerrors.ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, vxrerrors.ErrConflict):
+		code = http.StatusConflict
+	case errors.Is(err, vxrerrors.ErrExhausted):
+		code = http.StatusInsufficientStorage
+	case errors.Is(err, vxrerrors.ErrTimeout):
+		code = http.StatusGatewayTimeout
+	case errors.Is(err, vxrerrors.ErrUnavailable):
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), code)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.WithError(err).Error("failed to encode response")
+	}
+}
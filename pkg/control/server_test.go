@@ -0,0 +1,66 @@
+package control
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+)
+
+func TestAuth(t *testing.T) {
+	s := &Server{token: "secret", log: NewServer("").log}
+	h := s.auth(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer secre", http.StatusUnauthorized},
+		{"Bearer secrets", http.StatusUnauthorized},
+		{"secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, statePath, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tt.want {
+			t.Errorf("Authorization %q returned %v, want %v", tt.header, w.Code, tt.want)
+		}
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != statePath || r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, &core.State{Allocations: []string{"10.1.2.5"}})
+	}))
+	defer ts.Close()
+
+	// not trusted by the system roots
+	c := NewClient(ts.URL, "secret", time.Second)
+	if _, err := c.State(); err == nil {
+		t.Error("state fetched from an untrusted server")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	c.SetRootCAs(pool)
+	st, err := c.State()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Allocations) != 1 || st.Allocations[0] != "10.1.2.5" {
+		t.Errorf("allocations are %v, want [10.1.2.5]", st.Allocations)
+	}
+}
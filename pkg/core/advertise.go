@@ -0,0 +1,182 @@
+package core
+
+import (
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/bus"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+)
+
+// adverts tracks local endpoints for the message bus, the hardware vtep and bgp, from their creation,
+// when their addresses are known, to their deletion, when what was advertised for them is withdrawn
+type adverts struct {
+	l       sync.Mutex
+	pending map[string]*pendingAdvert
+	sent    map[string][]neigh.Entry
+}
+
+// pendingAdvert is an endpoint created but not joined yet
+type pendingAdvert struct {
+	ips []net.IP
+	mac net.HardwareAddr
+}
+
+func newAdverts() *adverts {
+	return &adverts{
+		pending: make(map[string]*pendingAdvert),
+		sent:    make(map[string][]neigh.Entry),
+	}
+}
+
+// ExpectEndpoint records the addresses, in cidr notation, and the MAC, if docker sets one,
+// of an endpoint being created, to advertise on the bus once it joins
+func (c *Core) ExpectEndpoint(endpointid string, addrs []string, mac string) {
+	if !c.advertising() {
+		return
+	}
+	pa := &pendingAdvert{}
+	for _, a := range addrs {
+		if ip, _, err := net.ParseCIDR(a); err == nil {
+			pa.ips = append(pa.ips, ip)
+		}
+	}
+	if mac != "" {
+		hw, err := net.ParseMAC(mac)
+		if err != nil {
+			log.WithField("mac", mac).WithError(err).Warn("ignoring invalid endpoint mac")
+		}
+		pa.mac = hw
+	}
+	if len(pa.ips) == 0 {
+		return
+	}
+	c.adverts.l.Lock()
+	defer c.adverts.l.Unlock()
+	c.adverts.pending[endpointid] = pa
+}
+
+// advertising reports whether local endpoints are advertised, on the bus, to a hardware vtep or over bgp
+func (c *Core) advertising() bool {
+	return c.bus != nil || c.hwvtep != nil || c.bgp != nil
+}
+
+// advertise publishes the entries of an endpoint joined on the container macvlan mvlName,
+// binds them in the hardware vtep and advertises them over bgp
+func (c *Core) advertise(hi *host.Interface, endpointid, mvlName string) {
+	if !c.advertising() {
+		return
+	}
+	c.adverts.l.Lock()
+	pa, ok := c.adverts.pending[endpointid]
+	delete(c.adverts.pending, endpointid)
+	c.adverts.l.Unlock()
+	if !ok {
+		return
+	}
+
+	es, err := hi.LocalEntries(mvlName, pa.mac, pa.ips)
+	if err != nil {
+		log.WithField("endpoint", endpointid).WithError(err).Error("failed to get entries to advertise")
+		return
+	}
+	c.adverts.l.Lock()
+	c.adverts.sent[endpointid] = es
+	c.adverts.l.Unlock()
+	c.bus.Publish(&bus.Message{Event: bus.EventAdvertise, Host: c.hostname, Entries: es})
+	c.hwvtep.Bind(es)
+	c.bgp.AdvertiseEntries(es)
+}
+
+// withdraw publishes the withdrawal of what was advertised for an endpoint
+func (c *Core) withdraw(endpointid string) {
+	if !c.advertising() {
+		return
+	}
+	c.adverts.l.Lock()
+	es, ok := c.adverts.sent[endpointid]
+	delete(c.adverts.sent, endpointid)
+	delete(c.adverts.pending, endpointid)
+	c.adverts.l.Unlock()
+	if !ok {
+		return
+	}
+	c.bus.Publish(&bus.Message{Event: bus.EventWithdraw, Host: c.hostname, Entries: es})
+	c.hwvtep.Unbind(es)
+	c.bgp.WithdrawEntries(es)
+}
+
+// consume programs the entries advertised by other hosts, and removes those withdrawn
+func (c *Core) consume(m *bus.Message) {
+	if m.Host == c.hostname {
+		return
+	}
+	log := log.WithField("func", "consume()").WithField("event", m.Event).WithField("host", m.Host)
+	switch m.Event {
+	case bus.EventAdvertise:
+		n, err := c.ImportNeighbors(m.Entries)
+		if err != nil {
+			log.WithError(err).Error("failed to import advertised entries")
+			return
+		}
+		log.WithField("imported", n).Debug()
+	case bus.EventWithdraw:
+		err := c.ForgetNeighbors(m.Entries)
+		if err != nil {
+			log.WithError(err).Error("failed to remove withdrawn entries")
+		}
+	default:
+		log.Debug("ignoring unknown bus event")
+	}
+}
+
+// consumeEVPN programs the entries of the evpn routes other hosts advertised, and removes those
+// withdrawn. The routes carry the vxlan id, the entries are programmed in each network with it.
+func (c *Core) consumeEVPN(advertised, withdrawn []neigh.Entry) {
+	log := log.WithField("func", "consumeEVPN()")
+
+	nrs, err := c.knownNetworks()
+	if err != nil {
+		log.WithError(err).Error("failed to get networks")
+		return
+	}
+	byVNI := make(map[int][]string)
+	for _, nr := range nrs {
+		nopts, err := c.netOptions(nr)
+		if err != nil {
+			continue
+		}
+		if vni, err := vxlan.ParseVxlanID(nopts.String(options.VxlanID)); err == nil {
+			byVNI[vni] = append(byVNI[vni], nr.Name)
+		}
+	}
+	inNetworks := func(es []neigh.Entry) []neigh.Entry {
+		ret := []neigh.Entry{}
+		for _, e := range es {
+			for _, n := range byVNI[e.VNI] {
+				e.Network = n
+				ret = append(ret, e)
+			}
+		}
+		return ret
+	}
+
+	if es := inNetworks(withdrawn); len(es) > 0 {
+		if err = c.ForgetNeighbors(es); err != nil {
+			log.WithError(err).Error("failed to remove withdrawn entries")
+		}
+	}
+	if es := inNetworks(advertised); len(es) > 0 {
+		n, err := c.ImportNeighbors(es)
+		if err != nil {
+			log.WithError(err).Error("failed to import advertised entries")
+			return
+		}
+		log.WithField("imported", n).Debug()
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	// breakerFailures is how many docker calls in a row may fail before the breaker opens
+	breakerFailures    = 5
+	breakerCooldownMin = 5 * time.Second
+	breakerCooldownMax = time.Minute
+)
+
+// breaker stops calling docker while it is failing, so a throttled or overloaded daemon
+// fails calls immediately instead of each of them waiting out dockerTimeout. Once the
+// cooldown has passed a single call is let through, closing the breaker if it succeeds
+// or reopening it for twice as long if it does not.
+type breaker struct {
+	l         sync.Mutex
+	failures  int
+	open      bool
+	openUntil time.Time
+	cooldown  time.Duration
+	trial     bool
+}
+
+func newBreaker() *breaker {
+	return &breaker{cooldown: breakerCooldownMin}
+}
+
+// allow returns whether docker may be called
+func (b *breaker) allow() bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// isOpen returns whether docker calls are failing fast
+func (b *breaker) isOpen() bool {
+	b.l.Lock()
+	defer b.l.Unlock()
+	return b.open
+}
+
+// success records a call docker answered, closing the breaker
+func (b *breaker) success() {
+	b.l.Lock()
+	defer b.l.Unlock()
+	if b.open {
+		log.Info("docker is responding again, closing circuit breaker")
+	}
+	b.failures, b.open, b.trial, b.cooldown = 0, false, false, breakerCooldownMin
+}
+
+// failure records a failed call, opening the breaker after breakerFailures in a row
+func (b *breaker) failure(err error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.failures++
+	switch {
+	case b.trial:
+		b.trial = false
+		b.cooldown *= 2
+		if b.cooldown > breakerCooldownMax {
+			b.cooldown = breakerCooldownMax
+		}
+	case !b.open && b.failures >= breakerFailures:
+		b.open = true
+	default:
+		return
+	}
+	b.openUntil = time.Now().Add(b.cooldown)
+	log.WithError(err).WithField("failures", b.failures).WithField("retry", b.cooldown).
+		Warn("docker is failing, opening circuit breaker")
+}
+
+// dockerFailing returns whether err is docker failing, as opposed to refusing a request.
+// Errors of the daemon carry no status code in this client, so any daemon error but a
+// missing object is taken as the daemon failing, as the plugin only makes valid requests.
+func dockerFailing(err error) bool {
+	if client.IsErrConnectionFailed(err) || err == context.DeadlineExceeded {
+		return true
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	if client.IsErrNotFound(err) {
+		return false
+	}
+	s := err.Error()
+	return strings.Contains(s, "Error response from daemon") || strings.Contains(s, "deadline exceeded")
+}
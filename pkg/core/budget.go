@@ -0,0 +1,98 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// budgetWarn is the fraction of the response budget above which a call is logged
+const budgetWarn = 0.8
+
+// budgetBuckets are the upper bounds of the histogram buckets, as fractions of the response budget
+var budgetBuckets = [...]float64{0.1, 0.25, 0.5, 0.8, 1}
+
+// BudgetHistogram is a cumulative histogram of the response budget used by calls to a driver method
+type BudgetHistogram struct {
+	Count int `json:"count"`
+	// Buckets counts the calls using at most each fraction of the budget, and +Inf
+	Buckets map[string]int `json:"buckets"`
+	Max     time.Duration  `json:"max_ns"`
+}
+
+type budget struct {
+	l     sync.Mutex
+	calls map[string]*BudgetHistogram
+}
+
+func newBudget() *budget {
+	return &budget{calls: make(map[string]*BudgetHistogram)}
+}
+
+// Deadline returns a context which expires when the response budget of a driver call
+// starting now is spent, for calls which wait, eg. on route propagation
+func (c *Core) Deadline() (context.Context, context.CancelFunc) {
+	if c.respTime <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.respTime)
+}
+
+// Track records the time since start of a driver call against the response budget.
+// Intended to be deferred at the start of the call.
+func (c *Core) Track(call string, start time.Time) {
+	if c.respTime <= 0 {
+		return
+	}
+	d := time.Since(start)
+	used := float64(d) / float64(c.respTime)
+
+	if used > budgetWarn {
+		log.WithField("call", call).
+			WithField("driver", c.NetworkDriverName()).
+			WithField("duration", d).
+			WithField("budget", c.respTime).
+			Warnf("call used %.0f%% of the response budget", used*100)
+	}
+
+	c.budget.l.Lock()
+	defer c.budget.l.Unlock()
+	h, ok := c.budget.calls[call]
+	if !ok {
+		h = &BudgetHistogram{Buckets: make(map[string]int)}
+		for _, b := range budgetBuckets {
+			h.Buckets[fmt.Sprint(b)] = 0
+		}
+		h.Buckets["+Inf"] = 0
+		c.budget.calls[call] = h
+	}
+	h.Count++
+	for _, b := range budgetBuckets {
+		if used <= b {
+			h.Buckets[fmt.Sprint(b)]++
+		}
+	}
+	h.Buckets["+Inf"]++
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// Budget returns the response budget histograms of the driver calls, by call name
+func (c *Core) Budget() map[string]BudgetHistogram {
+	c.budget.l.Lock()
+	defer c.budget.l.Unlock()
+	ret := make(map[string]BudgetHistogram, len(c.budget.calls))
+	for k, h := range c.budget.calls {
+		hc := *h
+		hc.Buckets = make(map[string]int, len(h.Buckets))
+		for b, n := range h.Buckets {
+			hc.Buckets[b] = n
+		}
+		ret[k] = hc
+	}
+	return ret
+}
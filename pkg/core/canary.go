@@ -0,0 +1,68 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+)
+
+const (
+	// canaryTries is the number of selected addresses checked before giving up
+	canaryTries = 3
+	// canaryHold is how long an address failing the canary check is kept from being selected again
+	canaryHold = time.Minute
+)
+
+// canary checks that an endpoint with the just selected address ip reaches the gateway,
+// catching broken segments before docker hands the address to a container. A selected
+// address failing the check has its route replaced with a blackhole for canaryHold and
+// another is selected. A requested address failing it is an error.
+func (c *Core) canary(hi *host.Interface, nr *types.NetworkResource, ip *net.IPNet, reqAddr net.IP, opts *host.SelectOpts) (*net.IPNet, error) {
+	gm, err := c.gatewayMode(nr)
+	if err != nil || gm == GatewayNone || opts.Gateway == nil {
+		// nothing to reach
+		return ip, nil
+	}
+	log := log.WithField("func", "canary()").WithField("network", nr.Name).WithField("gateway", opts.Gateway)
+
+	// failed addresses reserved in an external IPAM are held until the check ends, so they are not offered again
+	var failed []net.IP
+	defer func() {
+		for _, f := range failed {
+			host.ReleaseCandidate(opts.Allocator, f)
+		}
+	}()
+
+	for i := 1; ; i++ {
+		err = hi.Canary(ip, opts.Gateway)
+		if err == nil {
+			return ip, nil
+		}
+		log.WithField("ip", ip.IP).WithField("try", i).WithError(err).Warn("canary check failed, rolling back address")
+
+		if derr := hi.DelRoute(ip.IP); derr != nil {
+			log.WithField("ip", ip.IP).WithError(derr).Error("failed to delete route of address failing the canary check")
+		}
+		failed = append(failed, ip.IP)
+		if reqAddr != nil {
+			return nil, fmt.Errorf("requested address %v failed the canary check: %v", ip.IP, err)
+		}
+		if qerr := host.Quarantine(ip.IP, canaryHold); qerr != nil {
+			log.WithField("ip", ip.IP).WithError(qerr).Warn("failed to hold address failing the canary check")
+		}
+		if i >= canaryTries {
+			return nil, fmt.Errorf("%v selected addresses failed the canary check, last: %v", i, err)
+		}
+
+		opts.Preferred = nil
+		ip, err = hi.SelectAddress(nil, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"net"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// composeServiceFor returns the compose project/service of the container
+// being started on network nrID, if it can be identified. IPAM requests do
+// not carry the container, so this looks for newly created compose
+// containers on the network still waiting for an address. If they do not
+// all belong to the same service, no service is returned.
+func (c *Core) composeServiceFor(nrID string, sn *net.IPNet) string {
+	log := log.WithField("func", "composeServiceFor()").WithField("net_id", nrID)
+	log.Debug()
+
+	flts := filters.NewArgs()
+	flts.Add("label", composeServiceLabel)
+	flts.Add("network", nrID)
+	flts.Add("status", "created")
+	flts.Add("status", "restarting")
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	dc, err := c.docker()
+	if err != nil {
+		return ""
+	}
+	ctrs, err := dc.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: flts})
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Warn("failed to list compose containers")
+		return ""
+	}
+
+	svc := ""
+	for _, ctr := range ctrs {
+		if ctr.NetworkSettings == nil {
+			continue
+		}
+		waiting := false
+		for _, es := range ctr.NetworkSettings.Networks {
+			if es.NetworkID == nrID && waitingForAddress(es, sn) {
+				waiting = true
+			}
+		}
+		if !waiting {
+			continue
+		}
+		s := ctr.Labels[composeProjectLabel] + "/" + ctr.Labels[composeServiceLabel]
+		if svc != "" && svc != s {
+			log.Debug("containers from multiple compose services are starting, not pinning")
+			return ""
+		}
+		svc = s
+	}
+
+	return svc
+}
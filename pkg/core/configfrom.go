@@ -0,0 +1,104 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+)
+
+// configNetDriver is the driver docker reports for config-only networks
+const configNetDriver = "null"
+
+// configRef is the config-only network fields of a network inspect, which
+// docker added after the version of the client types used here
+type configRef struct {
+	ConfigOnly bool
+	ConfigFrom struct {
+		Network string
+	}
+}
+
+// inspectNetwork inspects a network. A network created with --config-from only reports
+// its own, mostly empty, configuration, so the options and ipam config of its
+// config-only network are merged into it.
+func (c *Core) inspectNetwork(ctx context.Context, dc *client.Client, id string) (types.NetworkResource, error) {
+	nr, raw, err := dc.NetworkInspectWithRaw(ctx, id)
+	c.dockerErr(err)
+	if err != nil {
+		return nr, err
+	}
+	var ref configRef
+	if json.Unmarshal(raw, &ref) != nil || ref.ConfigFrom.Network == "" {
+		return nr, nil
+	}
+
+	log := log.WithField("network", nr.Name).WithField("config_from", ref.ConfigFrom.Network)
+	cnr, craw, err := dc.NetworkInspectWithRaw(ctx, ref.ConfigFrom.Network)
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Error("failed to inspect config network")
+		return nr, fmt.Errorf("failed to inspect config network %v of %v: %v", ref.ConfigFrom.Network, nr.Name, err)
+	}
+	var cref configRef
+	if err = json.Unmarshal(craw, &cref); err != nil {
+		return nr, err
+	}
+	if !cref.ConfigOnly {
+		return nr, fmt.Errorf("network %v is configured from %v, which is not a config-only network", nr.Name, cnr.Name)
+	}
+
+	err = inheritConfig(&nr, &cnr)
+	if err != nil {
+		log.WithError(err).Error("invalid configuration from config network")
+		return nr, err
+	}
+	log.Debug("inherited configuration from config network")
+	return nr, nil
+}
+
+// inheritConfig merges the options and ipam config of the config-only network cnr into nr.
+// An option set on both must have the same value, and the merged options must be valid.
+func inheritConfig(nr, cnr *types.NetworkResource) error {
+	var err error
+	nr.Options, err = inheritOpts(nr.Options, cnr.Options)
+	if err != nil {
+		return err
+	}
+	nr.IPAM.Options, err = inheritOpts(nr.IPAM.Options, cnr.IPAM.Options)
+	if err != nil {
+		return err
+	}
+
+	if nr.IPAM.Driver == "" || nr.IPAM.Driver == "default" {
+		nr.IPAM.Driver = cnr.IPAM.Driver
+	} else if cnr.IPAM.Driver != "" && cnr.IPAM.Driver != nr.IPAM.Driver {
+		return fmt.Errorf("ipam driver %v conflicts with %v of config network %v", nr.IPAM.Driver, cnr.IPAM.Driver, cnr.Name)
+	}
+	if len(nr.IPAM.Config) == 0 {
+		nr.IPAM.Config = cnr.IPAM.Config
+	}
+	nr.EnableIPv6 = nr.EnableIPv6 || cnr.EnableIPv6
+
+	_, err = options.Parse(nr.IPAM.Options, nr.Options)
+	return err
+}
+
+func inheritOpts(own, from map[string]string) (map[string]string, error) {
+	ret := make(map[string]string, len(own)+len(from))
+	for k, v := range from {
+		ret[k] = v
+	}
+	for k, v := range own {
+		if f, ok := from[k]; ok && f != v {
+			return nil, fmt.Errorf("option %v=%v conflicts with %v=%v of the config network", k, v, k, f)
+		}
+		ret[k] = v
+	}
+	return ret, nil
+}
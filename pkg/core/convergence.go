@@ -0,0 +1,177 @@
+package core
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// convergenceKeep is how many of the latest allocations convergence is reported for
+	convergenceKeep = 256
+	// clockSlack is how far before an allocation a host may report learning its route, by
+	// its clock, for the report to be counted. Earlier reports are of a previous allocation.
+	clockSlack = time.Second
+)
+
+// convergenceBuckets are the upper bounds of the histogram buckets of route propagation delays
+var convergenceBuckets = [...]time.Duration{
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+}
+
+// AllocationConvergence is the propagation of the route of an allocation to the other hosts
+type AllocationConvergence struct {
+	Address   string    `json:"address"`
+	Allocated time.Time `json:"allocated"`
+	// Peers are the hosts which learned the route, with how long after the allocation.
+	// Hosts report when they learned it by their own clocks.
+	Peers map[string]time.Duration `json:"peers_ns"`
+	// Pending are the live hosts which have not reported the route yet
+	Pending []string `json:"pending"`
+	// Converged is how long until the last live host learned the route, 0 while some are pending
+	Converged time.Duration `json:"converged_ns"`
+}
+
+// ConvergenceReport is the propagation of the routes of the latest allocations
+type ConvergenceReport struct {
+	Allocations []*AllocationConvergence `json:"allocations"`
+	// Count is the number of delays reported by hosts since start
+	Count int `json:"count"`
+	// Buckets counts the delays of at most each duration, and +Inf, since start
+	Buckets map[string]int `json:"buckets"`
+	// Percentiles are the times to converge of the converged allocations reported, by percentile
+	Percentiles map[string]time.Duration `json:"percentiles_ns"`
+}
+
+type convergence struct {
+	l      sync.Mutex
+	allocs map[string]*AllocationConvergence
+	// order are the addresses of allocs, oldest first
+	order   []string
+	count   int
+	buckets map[string]int
+	peers   func() []string
+}
+
+func newConvergence(peers func() []string) *convergence {
+	cv := &convergence{allocs: make(map[string]*AllocationConvergence), buckets: make(map[string]int), peers: peers}
+	for _, b := range convergenceBuckets {
+		cv.buckets[b.String()] = 0
+	}
+	cv.buckets["+Inf"] = 0
+	return cv
+}
+
+// allocatedRoute starts tracking the propagation of the route to ip
+func (c *Core) allocatedRoute(ip net.IP) {
+	if c.convergence.peers == nil {
+		return
+	}
+	cv := c.convergence
+	k := ip.String()
+	cv.l.Lock()
+	defer cv.l.Unlock()
+	if _, ok := cv.allocs[k]; ok {
+		for i, a := range cv.order {
+			if a == k {
+				cv.order = append(cv.order[:i], cv.order[i+1:]...)
+				break
+			}
+		}
+	}
+	cv.allocs[k] = &AllocationConvergence{Address: k, Allocated: time.Now(), Peers: make(map[string]time.Duration)}
+	cv.order = append(cv.order, k)
+	if len(cv.order) > convergenceKeep {
+		delete(cv.allocs, cv.order[0])
+		cv.order = cv.order[1:]
+	}
+}
+
+// RouteLearned records that the host peer learned the host route dst at learned, by its clock,
+// for the convergence of the allocation of its address, if it is tracked
+func (c *Core) RouteLearned(peer, dst string, learned time.Time) {
+	ip, _, err := net.ParseCIDR(dst)
+	if err != nil {
+		return
+	}
+	cv := c.convergence
+	cv.l.Lock()
+	defer cv.l.Unlock()
+	a, ok := cv.allocs[ip.String()]
+	if !ok || learned.Before(a.Allocated.Add(-clockSlack)) {
+		return
+	}
+	if _, ok = a.Peers[peer]; ok {
+		return
+	}
+	d := learned.Sub(a.Allocated)
+	if d < 0 {
+		d = 0
+	}
+	a.Peers[peer] = d
+
+	cv.count++
+	for _, b := range convergenceBuckets {
+		if d <= b {
+			cv.buckets[b.String()]++
+		}
+	}
+	cv.buckets["+Inf"]++
+}
+
+// Convergence returns the propagation of the routes of the latest allocations of this
+// driver to the other live hosts, as reported through gossip
+func (c *Core) Convergence() *ConvergenceReport {
+	cv := c.convergence
+	var live []string
+	if cv.peers != nil {
+		live = cv.peers()
+	}
+
+	cv.l.Lock()
+	defer cv.l.Unlock()
+	r := &ConvergenceReport{
+		Allocations: make([]*AllocationConvergence, 0, len(cv.order)),
+		Count:       cv.count,
+		Buckets:     make(map[string]int, len(cv.buckets)),
+		Percentiles: make(map[string]time.Duration),
+	}
+	for b, n := range cv.buckets {
+		r.Buckets[b] = n
+	}
+
+	converged := []time.Duration{}
+	for _, k := range cv.order {
+		a := cv.allocs[k]
+		ac := &AllocationConvergence{Address: a.Address, Allocated: a.Allocated, Peers: make(map[string]time.Duration, len(a.Peers)), Pending: []string{}}
+		for p, d := range a.Peers {
+			ac.Peers[p] = d
+			if d > ac.Converged {
+				ac.Converged = d
+			}
+		}
+		for _, p := range live {
+			if _, ok := a.Peers[p]; !ok {
+				ac.Pending = append(ac.Pending, p)
+			}
+		}
+		sort.Strings(ac.Pending)
+		if len(ac.Pending) > 0 || len(ac.Peers) == 0 {
+			ac.Converged = 0
+		} else {
+			converged = append(converged, ac.Converged)
+		}
+		r.Allocations = append(r.Allocations, ac)
+	}
+
+	sort.Slice(converged, func(i, j int) bool { return converged[i] < converged[j] })
+	if len(converged) > 0 {
+		for _, p := range []int{50, 90, 99} {
+			r.Percentiles["p"+strconv.Itoa(p)] = converged[(len(converged)-1)*p/100]
+		}
+	}
+	return r
+}
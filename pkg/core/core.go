@@ -0,0 +1,785 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+	"github.com/TrilliumIT/vxrouter/pkg/bus"
+	"github.com/TrilliumIT/vxrouter/pkg/hwvtep"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrbgp"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/alloclog"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/extipam"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/kvstore"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/store"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/webhook"
+)
+
+const (
+	envPrefix     = vxrouter.EnvPrefix
+	dockerTimeout = 5 * time.Second
+)
+
+// Core is a wrapper for docker client type things
+type Core struct {
+	dc          *client.Client
+	dcL         sync.Mutex
+	breaker     *breaker
+	networkName string
+	ipamName    string
+	defaults    map[string]string
+	propTime    time.Duration
+	respTime    time.Duration
+	quarantine  time.Duration
+	slowCall    time.Duration
+	slow        slowCalls
+	stormGrace  time.Duration
+	storm       *storm
+	kv          kvstore.Locker
+	kvTTL       time.Duration
+	getNr       chan *getNr
+	delNr       chan string
+	putNr       chan *types.NetworkResource
+	flushNr     chan struct{}
+	listNr      chan chan<- []*types.NetworkResource
+	reserved    *reservations
+	pending     *pendingNetworks
+	pools       *poolRegistry
+	hostname    string
+	budget      *budget
+	leases      *store.Store
+	frozen      *frozenPools
+	allocLog    *alloclog.Log
+	webhook     *webhook.Hook
+	extIPAM     extipam.Client
+	extReserved *addrSet
+	bus         *bus.Bus
+	hwvtep      *hwvtep.VTEP
+	bgp         *vxrbgp.BGP
+	adverts     *adverts
+	convergence *convergence
+
+	// restored are the addresses with routes restored from the leases, kept without a
+	// container until restoreGrace passes
+	restored     *reservations
+	restoreGrace time.Duration
+}
+
+// Options configures a Core
+type Options struct {
+	// NetworkDriverName and IpamDriverName are the names the drivers are served as
+	NetworkDriverName, IpamDriverName string
+	// Defaults are network options applied to networks which do not set them
+	Defaults map[string]string
+	// PropTime is how long to wait for external route propagation
+	PropTime time.Duration
+	// RespTime is the maximum time to spend on a docker request
+	RespTime time.Duration
+	// Quarantine is how long to blackhole released addresses, 0 to disable
+	Quarantine time.Duration
+	// Leases records allocations, if not nil
+	Leases *store.Store
+	// RestoreGrace is how long the routes restored from Leases are kept without a
+	// container, for the containers started by docker after the driver
+	RestoreGrace time.Duration
+	// SlowCall is how long a driver call may run before a diagnostic snapshot is logged, 0 to disable
+	SlowCall time.Duration
+	// StormGrace is how long the route of an address released by a container in a restart loop
+	// is kept for its next request, 0 to disable
+	StormGrace time.Duration
+	// KV, if set, is locked for each address before its route is installed
+	KV kvstore.Locker
+	// KVTTL is how long a kv lock on an address is held
+	KVTTL time.Duration
+	// AllocLog records address requests, assignments and releases, if not nil
+	AllocLog *alloclog.Log
+	// Webhook is posted address assignments and releases, if not nil
+	Webhook *webhook.Hook
+	// ExternalIPAM, if set, is released the addresses it reserved through the extipam allocator
+	ExternalIPAM extipam.Client
+	// Bus, if set, is published the endpoints joining and leaving this host, and
+	// consumed for those of other hosts
+	Bus *bus.Bus
+	// HWVTEP, if set, is programmed with the endpoints joining and leaving this host
+	HWVTEP *hwvtep.VTEP
+	// BGP, if set, is advertised the endpoints joining and leaving this host as evpn routes,
+	// and imports those of other hosts
+	BGP *vxrbgp.BGP
+	// Peers, if set, returns the other live hosts, the propagation of the routes of
+	// allocations to which is reported in Convergence
+	Peers func() []string
+}
+
+// DefaultOptions returns the options the plugin uses when none are set
+func DefaultOptions() Options {
+	return Options{
+		NetworkDriverName: vxrouter.NetworkDriver,
+		IpamDriverName:    vxrouter.IpamDriver,
+		PropTime:          100 * time.Millisecond,
+		RespTime:          10 * time.Second,
+		RestoreGrace:      5 * time.Minute,
+	}
+}
+
+// New creates a new client for the network and ipam drivers configured by opts
+// The docker client is connected lazily, docker does not need to be running yet.
+func New(opts Options) (*Core, error) {
+	if opts.NetworkDriverName == "" || opts.IpamDriverName == "" || opts.NetworkDriverName == opts.IpamDriverName {
+		return nil, fmt.Errorf("network and ipam drivers must have different, non-empty names")
+	}
+
+	hn, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Core{
+		breaker:     newBreaker(),
+		networkName: opts.NetworkDriverName,
+		ipamName:    opts.IpamDriverName,
+		defaults:    opts.Defaults,
+		propTime:    opts.PropTime,
+		respTime:    opts.RespTime,
+		quarantine:  opts.Quarantine,
+		slowCall:    opts.SlowCall,
+		stormGrace:  opts.StormGrace,
+		storm:       newStorm(),
+		kv:          opts.KV,
+		kvTTL:       opts.KVTTL,
+		getNr:       make(chan *getNr),
+		delNr:       make(chan string),
+		putNr:       make(chan *types.NetworkResource),
+		flushNr:     make(chan struct{}),
+		listNr:      make(chan chan<- []*types.NetworkResource),
+		reserved:    newReservations(),
+		pending:     newPendingNetworks(),
+		pools:       newPoolRegistry(),
+		hostname:    hn,
+		budget:      newBudget(),
+		leases:      opts.Leases,
+		allocLog:    opts.AllocLog,
+		webhook:     opts.Webhook,
+		extIPAM:     opts.ExternalIPAM,
+		extReserved: newAddrSet(),
+		bus:         opts.Bus,
+		hwvtep:      opts.HWVTEP,
+		bgp:         opts.BGP,
+		adverts:     newAdverts(),
+		convergence: newConvergence(opts.Peers),
+
+		restored:     newReservations(),
+		restoreGrace: opts.RestoreGrace,
+	}
+	if opts.Leases != nil {
+		c.frozen = newFrozenPools(opts.Leases.Frozen())
+	} else {
+		c.frozen = newFrozenPools(nil)
+	}
+
+	opts.Bus.Subscribe(c.consume)
+	opts.BGP.Subscribe(c.consumeEVPN)
+
+	go nrCacheLoop(c.getNr, c.delNr, c.putNr, c.flushNr, c.listNr)
+	return c, nil
+}
+
+// lockOwner is the owner of the kv store locks taken by this instance. The instances
+// on a host serve different drivers, and must not take each other's locks as their own.
+func (c *Core) lockOwner() string {
+	return c.hostname + "/" + c.networkName
+}
+
+// NetworkDriverName returns the name of the network driver served with this core
+func (c *Core) NetworkDriverName() string {
+	return c.networkName
+}
+
+// IpamDriverName returns the name of the ipam driver served with this core
+func (c *Core) IpamDriverName() string {
+	return c.ipamName
+}
+
+// netOptions returns the parsed options of a network, with the instance defaults
+// for any options not set on the network. Driver options take precedence over ipam options.
+// The vxlanid of a network created without one is derived from its pools.
+func (c *Core) netOptions(nr *types.NetworkResource) (options.Options, error) {
+	nopts, err := options.Parse(c.defaults, nr.IPAM.Options, nr.Options)
+	if err != nil {
+		return nil, err
+	}
+	deriveVNI(nopts, nr)
+	return nopts, nil
+}
+
+// NetworkOption returns the value of a network option, or the instance default
+func (c *Core) NetworkOption(netid, key string) (string, error) {
+	nr, err := c.getNetworkResourceByID(netid)
+	if err != nil {
+		return "", err
+	}
+	nopts, err := c.netOptions(nr)
+	if err != nil {
+		return "", err
+	}
+	return nopts.String(key), nil
+}
+
+// getNetworkResourceByID gets a network resource by ID (checks cache first)
+func (c *Core) getNetworkResourceByID(id string) (*types.NetworkResource, error) {
+	log := log.WithField("net_id", id)
+	log.Debug("GetNetworkResourceByID()")
+
+	nr := c.getNrFromCache(id)
+	if nr != nil {
+		return nr, nil
+	}
+
+	//netid wasn't in cache, fetch from docker inspect
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	dc, err := c.docker()
+	if err != nil {
+		return nil, err
+	}
+	nnr, err := c.inspectNetwork(ctx, dc, id)
+	if err != nil {
+		log.WithError(err).Error("failed to inspect network")
+		return nil, err
+	}
+	nr = &nnr
+
+	c.putNrInCache(nr)
+
+	return nr, nil
+}
+
+// getNetworkResourceByPool gets a network resource by it's subnet
+func (c *Core) getNetworkResourceByPool(pool string) (*types.NetworkResource, error) {
+	log := log.WithField("pool", pool)
+	log.Debug("getNetworkResourceByPool")
+
+	nr := c.getNrFromCache(pool)
+	if nr != nil {
+		return nr, nil
+	}
+
+	flts := filters.NewArgs()
+	flts.Add("driver", c.NetworkDriverName())
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	dc, err := c.docker()
+	if err != nil {
+		return nil, err
+	}
+	nl, err := dc.NetworkList(ctx, types.NetworkListOptions{Filters: flts})
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Error("failed to list networks")
+		return nil, err
+	}
+
+	for _, n := range nl {
+		nr, err = c.getNetworkResourceByID(n.ID)
+		if err != nil {
+			continue
+		}
+		for _, tp := range poolsFromNR(nr) {
+			if tp == pool {
+				return nr, nil
+			}
+		}
+	}
+
+	return nil, vxrerrors.NotFound("network resource not found for pool %v", pool)
+}
+
+// Uncache uncaches the network resources
+func (c *Core) Uncache(poolid string) {
+	pool := poolFromID(poolid)
+	c.delNrInCache(pool)
+}
+
+func (c *Core) connectIfNotConnected(addr, nrID string) (bool, error) {
+	ip := net.ParseIP(addr)
+	numRoutes, err := host.VxroutesTo(ip)
+	if err != nil {
+		return false, err
+	}
+	if numRoutes > 0 {
+		return false, nil
+	}
+	nr, err := c.getNetworkResourceByID(nrID)
+	if err != nil {
+		return false, err
+	}
+	sn, err := subnetOf(nr, ip)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := c.Deadline()
+	defer cancel()
+	_, err = c.connectAndGetAddress(ctx, ip, sn, nil, nr, nil)
+	return true, err
+}
+
+// ConnectAndGetAddress connects the host to the network for the
+// passed in pool, and returns either an available random or the
+// requested address if it's available. A timeout error is returned
+// if ctx expires first. mac, if known, is the MAC of the endpoint the
+// address is for, addresses derived from it are preferred.
+func (c *Core) ConnectAndGetAddress(ctx context.Context, addr, poolid string, mac net.HardwareAddr) (*net.IPNet, error) {
+	log := log.WithField("addr", addr)
+	log = log.WithField("poolid", poolid)
+	log.Debug("ConnectAndGetAddress()")
+
+	pool := poolFromID(poolid)
+	nr, err := c.getNetworkResourceByPool(pool)
+	if err != nil {
+		log.WithError(err).Error("failed to get network resource")
+		return nil, err
+	}
+
+	_, sn, err := net.ParseCIDR(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(addr)
+
+	// a container in a restart loop gets its parked address back, its route is still in place
+	if pip := c.unpark(ip, sn); pip != nil {
+		if n, err := host.VxroutesTo(pip); err == nil && n > 0 {
+			log.WithField("ip", pip).Debug("reusing parked address")
+			return &net.IPNet{IP: pip, Mask: sn.Mask}, nil
+		}
+	}
+
+	// a frozen pool only serves addresses explicitly requested, eg. by a restarting container
+	if ip == nil {
+		if t, ok := c.frozenSince(sn); ok {
+			return nil, vxrerrors.Conflict("pool %v is frozen since %v", sn, t.Format(time.RFC3339))
+		}
+	}
+
+	rng := subPoolFromID(poolid)
+
+	// a restarted or re-created container gets the address last held by its name or hostname
+	if ip == nil {
+		if sip := c.stickyAddressFor(nr, sn); sip != nil {
+			a, err := c.connectAndGetAddress(ctx, sip, sn, rng, nr, nil)
+			if err == nil {
+				return a, nil
+			}
+			log.WithField("sticky", sip).WithError(err).Warn("failed to reuse sticky address, selecting another")
+		}
+	}
+
+	return c.connectAndGetAddress(ctx, ip, sn, rng, nr, mac)
+}
+
+// connectAndGetAddress selects an address in sn, one of the subnets of a network.
+// If rng is not nil, unrequested addresses are selected only from within it.
+// If mac is not nil, the address derived from it is preferred by the macalloc option.
+func (c *Core) connectAndGetAddress(ctx context.Context, addr net.IP, sn, rng *net.IPNet, nr *types.NetworkResource, mac net.HardwareAddr) (*net.IPNet, error) {
+	if nr.IPAM.Driver != c.IpamDriverName() || nr.Driver != c.NetworkDriverName() {
+		log.WithField("ipam-driver", nr.IPAM.Driver).WithField("network-driver", nr.Driver).Debug("not a vxrnet, refusing to connectAndGetAddress")
+		return nil, nil
+	}
+	gws, err := c.hostGateways(nr)
+	if err != nil {
+		log.WithError(err).Error("failed to get gateway")
+		return nil, err
+	}
+
+	nopts, err := c.netOptions(nr)
+	if err != nil {
+		return nil, err
+	}
+	alloc, err := allocator.New(nopts.String(options.Allocation), &allocator.Config{
+		Options:      nopts,
+		KV:           c.kv,
+		Owner:        c.lockOwner(),
+		KVTTL:        c.kvTTL,
+		ExternalIPAM: c.extIPAM,
+	})
+	if err != nil {
+		return nil, err
+	}
+	opts := &host.SelectOpts{
+		Allocator: alloc,
+		Subnet:    sn,
+		PropTime:  c.propTime,
+		RespTime:  c.respTime,
+		Context:   ctx,
+		//exclude network and (normal) broadcast addresses by default
+		ExcludeFirst: nopts.Int(options.ExcludeFirst),
+		ExcludeLast:  nopts.Int(options.ExcludeLast),
+		Reserved:     c.unavailable,
+		Range:        rng,
+		DAD:          nopts.String(options.DAD) == "on",
+		Metric:       nopts.Int(options.Metric),
+	}
+	if addr == nil && mac != nil {
+		opts.Preferred = macAddress(nopts.String(options.MacAlloc), sn, mac)
+	}
+	opts.Conflicts, err = host.ConflictPolicyFor(nopts.String(options.Conflict))
+	if err != nil {
+		return nil, err
+	}
+	opts.Evicted = func(cf *host.Conflict) {
+		c.evicted(cf, sn)
+	}
+	// an address claimed but not selected is released, also once the request is done
+	if cl, ok := alloc.(allocator.Claimer); ok {
+		opts.Claim = func(ip net.IP) (bool, error) {
+			return cl.Claim(ctx, sn, ip)
+		}
+		opts.Unclaim = func(ip net.IP) {
+			if err := cl.Unclaim(context.Background(), sn, ip); err != nil {
+				log.WithField("ip", ip).WithError(err).Warn("failed to release address lock")
+			}
+		}
+	} else if c.kv != nil {
+		opts.Claim = func(ip net.IP) (bool, error) {
+			return c.kv.Lock(ctx, kvstore.AddressKey(sn.String(), ip.String()), c.lockOwner(), c.kvTTL)
+		}
+		opts.Unclaim = func(ip net.IP) {
+			if err := c.kv.Unlock(context.Background(), kvstore.AddressKey(sn.String(), ip.String()), c.lockOwner()); err != nil {
+				log.WithField("ip", ip).WithError(err).Warn("failed to release address lock")
+			}
+		}
+	}
+	opts.Exclude, err = host.ParseRanges(nopts.String(options.Exclude))
+	if err != nil {
+		return nil, err
+	}
+	// the gateway is in use even when it is provided outside of the plugin
+	if ngw, err := gatewayIn(nr, sn); err == nil {
+		opts.Gateway = ngw.IP
+	}
+
+	// with prefix delegation, addresses within the prefixes delegated to containers are in use too
+	delegate := nopts.Int(options.Delegate)
+	if sn.IP.To4() != nil {
+		delegate = 0
+	}
+	if delegate > 0 {
+		opts.Reserved = notDelegated(sn, opts.Reserved)
+	}
+
+	// in host block mode, allocate only from this host's block, advertised as a single summary route
+	hb := nopts.Int(options.HostBlock)
+	if hb > 0 {
+		opts.Block = host.SubBlock(sn, hb, c.hostname)
+		opts.BlockOnly = opts.Block != nil
+		if opts.Block == nil {
+			log.WithField("hostblock", hb).Warn("host block is not smaller than the subnet, ignoring")
+		}
+	}
+
+	// keep containers of a compose service adjacent by allocating from a sub-block per service
+	cb := nopts.Int(options.ComposeBlock)
+	if addr == nil && cb > 0 && !opts.BlockOnly {
+		if svc := c.composeServiceFor(nr.ID, sn); svc != "" {
+			opts.Block = host.SubBlock(sn, cb, svc)
+			log.WithField("service", svc).WithField("block", opts.Block).Debug("allocating from compose service block")
+		}
+	}
+
+	// looking up the network may have used up the budget
+	if ctx.Err() != nil {
+		return nil, vxrerrors.Timeout("response deadline expired before selecting an address")
+	}
+
+	hi, err := host.GetOrCreateInterface(nr.Name, gws, nopts)
+	if err != nil {
+		log.WithError(err).Error("failed to get or create host interface")
+		return nil, err
+	}
+
+	if opts.BlockOnly {
+		opts.Block, err = hi.ClaimHostBlock(sn, hb, c.hostname)
+		if err != nil {
+			return nil, err
+		}
+		if addr != nil && !opts.Block.Contains(addr) {
+			log.WithField("addr", addr).WithField("block", opts.Block).Warn("requested address is outside of the host block and will not be covered by its summary route")
+		}
+	}
+
+	// docker is authoritative for explicitly requested addresses, eg. a container
+	// restarting with its previous address
+	var prevPrefix *net.IPNet
+	if addr != nil {
+		prevPrefix = c.leasedPrefix(addr)
+		c.unlease(addr)
+	}
+
+	ip, err := hi.SelectAddress(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	if nopts.String(options.Canary) == "on" {
+		ip, err = c.canary(hi, nr, ip, addr, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ext := reservedExternally(alloc, ip.IP)
+	c.lease(ip.IP, sn.String(), nopts.Duration(options.LeaseTTL), ext)
+	if delegate > 0 {
+		err = c.delegatePrefix(hi, sn, ip.IP, delegate, prevPrefix, opts)
+		if err != nil {
+			c.unlease(ip.IP)
+			if derr := hi.DelRoute(ip.IP); derr != nil {
+				log.WithField("ip", ip.IP).WithError(derr).Error("failed to delete route")
+			}
+			host.ReleaseCandidate(alloc, ip.IP)
+			return nil, err
+		}
+	}
+	if ext {
+		c.extReserved.add(ip.IP)
+	}
+	c.allocated(ip.IP)
+	c.allocatedRoute(ip.IP)
+	return ip, nil
+}
+
+// macAddress returns the address of sn derived from an endpoint mac in the macalloc mode,
+// or nil if the mode derives none for the subnet. eui64 derives ipv6 addresses from the
+// modified EUI-64 identifier of mac, hash also derives ipv4 addresses, and those of ipv6
+// subnets longer than /64, from a hash of it.
+func macAddress(mode string, sn *net.IPNet, mac net.HardwareAddr) net.IP {
+	if mode == "off" || len(mac) == 0 {
+		return nil
+	}
+	if ip := host.EUI64Address(sn, mac); ip != nil {
+		return ip
+	}
+	if mode == "hash" {
+		return host.HashAddress(sn, mac)
+	}
+	return nil
+}
+
+// GetGatewaysByNetID returns the gateway of each address family of a network
+func (c *Core) GetGatewaysByNetID(netid string) ([]*net.IPNet, error) {
+	log := log.WithField("netid", netid)
+	log.Debug("GetGatewaysByNetID()")
+
+	nr, err := c.getNetworkResourceByID(netid)
+	if err != nil {
+		log.WithError(err).WithField("NetworkID", netid).Error("failed to get network resource")
+		return nil, err
+	}
+	return GatewaysFromNR(nr)
+}
+
+// CreateContainerInterface creates the macvlan to be put into a container namespace
+// returns the name of the interface
+func (c *Core) CreateContainerInterface(netid, endpointid string) (string, error) {
+	log := log.WithField("netid", netid)
+	log = log.WithField("endpointid", endpointid)
+	log.Debug("CreateContainerInterface()")
+
+	nr, err := c.getNetworkResourceByID(netid)
+	if err != nil {
+		log.WithError(err).WithField("netid", netid).Error("failed to get network resource")
+		return "", err
+	}
+
+	gws, err := c.hostGateways(nr)
+	if err != nil {
+		log.WithError(err).Error("failed to get gateway")
+		return "", err
+	}
+
+	nopts, err := c.netOptions(nr)
+	if err != nil {
+		return "", err
+	}
+
+	hi, err := host.GetOrCreateInterface(nr.Name, gws, nopts)
+	if err != nil {
+		return "", err
+	}
+
+	mvlName := "cmvl_" + endpointid[:7]
+	err = hi.CreateMacvlan(mvlName)
+	if err != nil {
+		log.WithError(err).Error("failed to create macvlan for container")
+		return "", err
+	}
+	c.advertise(hi, endpointid, mvlName)
+
+	return mvlName, nil
+}
+
+// DeleteContainerInterface deletes the container interface, removes the route
+// and deletes the host interface if it's the last container
+func (c *Core) DeleteContainerInterface(netid, endpointid string) error {
+	log := log.WithField("netid", netid)
+	log = log.WithField("endpointid", endpointid)
+	log.Debug("CreateContainerInterface()")
+
+	c.withdraw(endpointid)
+
+	nr, err := c.getNetworkResourceByID(netid)
+	if err != nil {
+		log.WithError(err).WithField("NetworkID", netid).Error("failed to get network resource")
+		return err
+	}
+
+	hi, err := host.GetInterface(nr.Name)
+	if err != nil {
+		return err
+	}
+
+	mvlName := "cmvl_" + endpointid[:7]
+	err = hi.DeleteMacvlan(mvlName)
+	if err != nil {
+		log.WithError(err).Error("failed to delete macvlan for container")
+		return err
+	}
+
+	return nil
+}
+
+// DeleteRoute deletes a route, the neighbor and forwarding entries of the address,
+// and attempts to delete the host interface. Releasing an address with no route is not an error.
+func (c *Core) DeleteRoute(address string) error {
+	log := log.WithField("address", address)
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", address)
+	}
+
+	if n, err := host.VxroutesTo(ip); err == nil && n == 0 {
+		log.Debug("no route to released address, nothing to clean up")
+		return nil
+	}
+
+	if c.park(ip, func() {
+		if err := c.releaseAddress(ip); err != nil {
+			log.WithError(err).Error("failed to release parked address")
+		}
+	}) {
+		return nil
+	}
+
+	return c.releaseAddress(ip)
+}
+
+// releaseAddress deletes the route, neighbor entries and lease of a released address
+func (c *Core) releaseAddress(ip net.IP) error {
+	log := log.WithField("address", ip)
+
+	hi, err := c.deleteRoute(ip)
+	if err != nil {
+		return err
+	}
+
+	if ip.To4() == nil {
+		if err = hi.DelDelegatedPrefixes(ip); err != nil {
+			log.WithError(err).Warn("failed to delete prefixes delegated to released address")
+		}
+	}
+	ext := c.extReserved.take(ip) || (c.leases != nil && c.leases.External(ip.String()))
+	c.unlease(ip)
+	allocator.Released(ip)
+	if ext {
+		c.releaseExternal(ip)
+	}
+
+	if err = hi.DelNeigh(ip); err != nil {
+		log.WithError(err).Warn("failed to delete neighbor entries of released address")
+	}
+
+	if c.quarantine > 0 {
+		if err = host.Quarantine(ip, c.quarantine); err != nil {
+			log.WithError(err).Warn("failed to quarantine released address")
+		}
+	}
+
+	go func() {
+		// the gateway is released first, so it is not left claimed if the interface can not be deleted
+		if err := hi.ReleaseGateways(); err != nil {
+			log.WithError(err).Error("failed to release gateway")
+		}
+		if err := hi.Delete(); err != nil {
+			log.WithError(err).Error("error while deleting host interface")
+		}
+	}()
+
+	return nil
+}
+
+// addrSet is a set of addresses
+type addrSet struct {
+	l sync.Mutex
+	m map[string]struct{}
+}
+
+func newAddrSet() *addrSet {
+	return &addrSet{m: make(map[string]struct{})}
+}
+
+func (s *addrSet) add(ip net.IP) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.m[ip.String()] = struct{}{}
+}
+
+// take removes ip, returning whether it was in the set
+func (s *addrSet) take(ip net.IP) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, ok := s.m[ip.String()]
+	delete(s.m, ip.String())
+	return ok
+}
+
+// reservedExternally reports whether ip was reserved by alloc in the external IPAM
+func reservedExternally(alloc allocator.Allocator, ip net.IP) bool {
+	r, ok := alloc.(allocator.Reserver)
+	return ok && r.Reserved(ip)
+}
+
+// releaseExternal releases ip in the external IPAM, if there is one. Only addresses
+// reserved there by the extipam allocator are released.
+func (c *Core) releaseExternal(ip net.IP) {
+	if c.extIPAM == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.respTime)
+	defer cancel()
+	err := c.extIPAM.Release(ctx, ip)
+	if err != nil {
+		log.WithField("address", ip).WithError(err).Error("failed to release address in external ipam")
+	}
+}
+
+// deleteRoute only deletes the route, passing back host.interface
+// so that the caller can decide if it wants to call hi.Delete()
+func (c *Core) deleteRoute(addr net.IP) (*host.Interface, error) {
+	hi, err := host.GetInterfaceFromDestinationAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return hi, hi.DelRoute(addr)
+}
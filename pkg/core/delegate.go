@@ -0,0 +1,53 @@
+package core
+
+import (
+	"net"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+)
+
+// delegatePrefix delegates a /plen of sn to the container address ip, recording it in its lease.
+// prev is the prefix last delegated to ip, which is preferred.
+func (c *Core) delegatePrefix(hi *host.Interface, sn *net.IPNet, ip net.IP, plen int, prev *net.IPNet, opts *host.SelectOpts) error {
+	pfx, err := hi.DelegatePrefix(sn, plen, ip, prev, opts)
+	if err != nil {
+		log.WithField("ip", ip).WithField("delegate", plen).WithError(err).Error("failed to delegate prefix")
+		return err
+	}
+	if c.leases == nil {
+		return nil
+	}
+	err = c.leases.SetPrefix(ip.String(), pfx.String())
+	if err != nil {
+		log.WithField("ip", ip).WithField("prefix", pfx).WithError(err).Error("failed to store delegated prefix")
+	}
+	return nil
+}
+
+// leasedPrefix returns the prefix delegated to ip in the lease store, if any
+func (c *Core) leasedPrefix(ip net.IP) *net.IPNet {
+	if c.leases == nil {
+		return nil
+	}
+	_, pfx, err := net.ParseCIDR(c.leases.Prefix(ip.String()))
+	if err != nil {
+		return nil
+	}
+	return pfx
+}
+
+// notDelegated extends reserved to addresses within prefixes delegated from sn
+func notDelegated(sn *net.IPNet, reserved func(net.IP) bool) func(net.IP) bool {
+	return func(ip net.IP) bool {
+		if reserved != nil && reserved(ip) {
+			return true
+		}
+		d, err := host.Delegated(sn, ip)
+		if err != nil {
+			log.WithField("ip", ip).WithError(err).Warn("failed to check for delegated prefixes")
+		}
+		return d
+	}
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"time"
+
+	"github.com/docker/docker/client"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+const (
+	dockerRetryMin = 500 * time.Millisecond
+	dockerRetryMax = 30 * time.Second
+)
+
+// docker returns the docker client, creating it if it does not exist yet
+// or was dropped after a connection failure. It fails while the circuit
+// breaker is open.
+func (c *Core) docker() (*client.Client, error) {
+	if !c.breaker.allow() {
+		return nil, vxrerrors.Unavailable("docker is failing, not calling it until the circuit breaker closes")
+	}
+	c.dcL.Lock()
+	defer c.dcL.Unlock()
+	if c.dc != nil {
+		return c.dc, nil
+	}
+
+	dc, err := client.NewEnvClient()
+	if err != nil {
+		log.WithError(err).Error("failed to create docker client")
+		c.breaker.failure(err)
+		return nil, err
+	}
+	c.dc = dc
+	return dc, nil
+}
+
+// dockerErr records the result of a docker call with the circuit breaker,
+// and drops the docker client if err is a connection failure, so the next
+// call re-establishes it
+func (c *Core) dockerErr(err error) {
+	if err == nil || !dockerFailing(err) {
+		c.breaker.success()
+		return
+	}
+	c.breaker.failure(err)
+	if !client.IsErrConnectionFailed(err) {
+		return
+	}
+	log.WithError(err).Warn("lost connection to docker, reconnecting on next call")
+
+	c.dcL.Lock()
+	defer c.dcL.Unlock()
+	if c.dc != nil {
+		c.dc.Close() // nolint: errcheck
+		c.dc = nil
+	}
+}
+
+// WaitForDocker blocks until the docker daemon responds, retrying with
+// exponential backoff. It returns false if done is closed first.
+func (c *Core) WaitForDocker(done <-chan struct{}) bool {
+	log := log.WithField("func", "WaitForDocker()")
+	log.Debug()
+
+	wait := dockerRetryMin
+	for {
+		dc, err := c.docker()
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+			_, err = dc.Ping(ctx)
+			cancel()
+			c.dockerErr(err)
+		}
+		if err == nil {
+			return true
+		}
+		log.WithError(err).WithField("retry", wait).Info("docker is not available yet")
+
+		select {
+		case <-done:
+			return false
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > dockerRetryMax {
+			wait = dockerRetryMax
+		}
+	}
+}
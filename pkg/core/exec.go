@@ -0,0 +1,107 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// ExecResult is the output of a command run in a container
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Exec runs cmd in a running container and waits for it to exit.
+// A non-zero exit code is not an error, it is returned in the result.
+func (c *Core) Exec(ctx context.Context, containerID string, cmd ...string) (*ExecResult, error) {
+	log := log.WithField("container", containerID).WithField("cmd", cmd)
+	log.Debug("Exec()")
+
+	dc, err := c.docker()
+	if err != nil {
+		return nil, err
+	}
+
+	ec := types.ExecConfig{Cmd: cmd, AttachStdout: true, AttachStderr: true}
+	id, err := dc.ContainerExecCreate(ctx, containerID, ec)
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Error("failed to create exec")
+		return nil, err
+	}
+
+	hr, err := dc.ContainerExecAttach(ctx, id.ID, ec)
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Error("failed to attach to exec")
+		return nil, err
+	}
+	defer hr.Close()
+
+	// the attached stream ends when the command exits
+	var stdout, stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(&stdout, &stderr, hr.Reader)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ei, err := dc.ContainerExecInspect(ctx, id.ID)
+	c.dockerErr(err)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ExecResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: ei.ExitCode}, nil
+}
+
+// ContainerAddrs returns the output of ip addr in a container
+func (c *Core) ContainerAddrs(ctx context.Context, containerID string) (string, error) {
+	return c.execOK(ctx, containerID, "ip", "addr", "show")
+}
+
+// ContainerRoutes returns the output of ip route for both address families in a container
+func (c *Core) ContainerRoutes(ctx context.Context, containerID string) (string, error) {
+	v4, err := c.execOK(ctx, containerID, "ip", "-4", "route", "show")
+	if err != nil {
+		return "", err
+	}
+	v6, err := c.execOK(ctx, containerID, "ip", "-6", "route", "show")
+	if err != nil {
+		return "", err
+	}
+	return v4 + v6, nil
+}
+
+// ContainerPing pings addr count times from a container, and returns the output of ping.
+// It fails if no reply was received.
+func (c *Core) ContainerPing(ctx context.Context, containerID, addr string, count int) (string, error) {
+	return c.execOK(ctx, containerID, "ping", "-c", strconv.Itoa(count), "-W", "1", addr)
+}
+
+// execOK runs cmd in a container, failing on a non-zero exit code
+func (c *Core) execOK(ctx context.Context, containerID string, cmd ...string) (string, error) {
+	r, err := c.Exec(ctx, containerID, cmd...)
+	if err != nil {
+		return "", err
+	}
+	if r.ExitCode != 0 {
+		return r.Stdout, fmt.Errorf("%v exited %v: %v", cmd, r.ExitCode, r.Stderr)
+	}
+	return r.Stdout, nil
+}
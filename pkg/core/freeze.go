@@ -0,0 +1,102 @@
+package core
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// frozenPools are the pools no new addresses are allocated from, with when they were frozen
+type frozenPools struct {
+	l sync.Mutex
+	m map[string]time.Time
+}
+
+func newFrozenPools(m map[string]time.Time) *frozenPools {
+	if m == nil {
+		m = make(map[string]time.Time)
+	}
+	return &frozenPools{m: m}
+}
+
+// Freeze stops selecting new addresses from pool, a subnet of a network of this driver,
+// eg. while it is renumbered. Allocated addresses keep their routes, and explicitly requested
+// addresses, such as a restarting container's, are still served. The freeze is kept in
+// the lease store, if there is one.
+func (c *Core) Freeze(pool string) error {
+	sn, err := c.ownPool(pool)
+	if err != nil {
+		return err
+	}
+
+	c.frozen.l.Lock()
+	defer c.frozen.l.Unlock()
+	if _, ok := c.frozen.m[sn.String()]; ok {
+		return nil
+	}
+	t := time.Now()
+	if c.leases != nil {
+		err = c.leases.Freeze(sn.String(), t)
+		if err != nil {
+			return err
+		}
+	}
+	c.frozen.m[sn.String()] = t
+	log.WithField("pool", sn.String()).Warn("pool frozen, no new addresses will be allocated from it")
+	return nil
+}
+
+// Unfreeze resumes selecting new addresses from a frozen pool
+func (c *Core) Unfreeze(pool string) error {
+	_, sn, err := net.ParseCIDR(pool)
+	if err != nil {
+		return err
+	}
+
+	c.frozen.l.Lock()
+	defer c.frozen.l.Unlock()
+	if _, ok := c.frozen.m[sn.String()]; !ok {
+		return vxrerrors.NotFound("pool %v is not frozen", sn)
+	}
+	if c.leases != nil {
+		err = c.leases.Unfreeze(sn.String())
+		if err != nil {
+			return err
+		}
+	}
+	delete(c.frozen.m, sn.String())
+	log.WithField("pool", sn.String()).Info("pool unfrozen")
+	return nil
+}
+
+// frozenSince returns when sn was frozen, and if it is
+func (c *Core) frozenSince(sn *net.IPNet) (time.Time, bool) {
+	c.frozen.l.Lock()
+	defer c.frozen.l.Unlock()
+	t, ok := c.frozen.m[sn.String()]
+	return t, ok
+}
+
+// ownPool returns the subnet of pool, if it is a subnet of a network of this driver
+func (c *Core) ownPool(pool string) (*net.IPNet, error) {
+	_, sn, err := net.ParseCIDR(pool)
+	if err != nil {
+		return nil, err
+	}
+	nrs, err := c.networks()
+	if err != nil {
+		return nil, err
+	}
+	for _, nr := range nrs {
+		for _, p := range poolsFromNR(nr) {
+			if _, psn, err := net.ParseCIDR(p); err == nil && psn.String() == sn.String() {
+				return sn, nil
+			}
+		}
+	}
+	return nil, vxrerrors.NotFound("pool %v is not used by a network of %v", sn, c.NetworkDriverName())
+}
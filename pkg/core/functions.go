@@ -0,0 +1,134 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// waitingForAddress reports whether the endpoint es of a starting container has no address
+// yet in the address family of sn. Networks may be IPv6 only, the IPv4 address is not enough.
+func waitingForAddress(es *network.EndpointSettings, sn *net.IPNet) bool {
+	if es == nil {
+		return false
+	}
+	if sn.IP.To4() == nil {
+		return es.GlobalIPv6Address == ""
+	}
+	return es.IPAddress == ""
+}
+
+// poolsFromNR returns the pools of all address families of a network
+func poolsFromNR(nr *types.NetworkResource) []string {
+	ret := []string{}
+	for _, c := range nr.IPAM.Config {
+		if c.Subnet != "" {
+			ret = append(ret, c.Subnet)
+		}
+	}
+	return ret
+}
+
+// subnetOf returns the subnet of a network containing ip
+func subnetOf(nr *types.NetworkResource, ip net.IP) (*net.IPNet, error) {
+	for _, p := range poolsFromNR(nr) {
+		_, sn, err := net.ParseCIDR(p)
+		if err == nil && sn.Contains(ip) {
+			return sn, nil
+		}
+	}
+	return nil, vxrerrors.NotFound("no pool of network %v contains %v", nr.Name, ip)
+}
+
+// subPoolSep separates the pool from the sub-pool (--ip-range) in a pool id
+const subPoolSep = "@"
+
+// poolFromID strips the ipam driver name and sub-pool from a pool id
+func poolFromID(poolid string) string {
+	p := poolid[strings.Index(poolid, "/")+1:]
+	if i := strings.Index(p, subPoolSep); i >= 0 {
+		p = p[:i]
+	}
+	return p
+}
+
+// subPoolFromID returns the sub-pool of a pool id, or nil if it has none
+func subPoolFromID(poolid string) *net.IPNet {
+	i := strings.Index(poolid, subPoolSep)
+	if i < 0 {
+		return nil
+	}
+	_, sn, err := net.ParseCIDR(poolid[i+1:])
+	if err != nil {
+		return nil
+	}
+	return sn
+}
+
+// IPNetFromReqInfo returns an an IPNet from an ipam request
+func IPNetFromReqInfo(poolid, reqAddr string) (*net.IPNet, error) {
+	_, n, err := net.ParseCIDR(poolFromID(poolid))
+	if err != nil {
+		return nil, err
+	}
+	n.IP = net.ParseIP(reqAddr)
+	if n.IP == nil {
+		return nil, fmt.Errorf("invalid requested address")
+	}
+	return n, nil
+}
+
+// GatewayFromNR loops over the IPAMConfig array, combine gw and sn into a cidr
+// On a dual-stack network this is the gateway of the first configured family.
+func GatewayFromNR(nr *types.NetworkResource) (*net.IPNet, error) {
+	gws, err := GatewaysFromNR(nr)
+	if err != nil {
+		return nil, err
+	}
+	return gws[0], nil
+}
+
+// GatewaysFromNR returns the gateway of each address family of a network, as a cidr
+func GatewaysFromNR(nr *types.NetworkResource) ([]*net.IPNet, error) {
+	ret := []*net.IPNet{}
+	for _, ic := range nr.IPAM.Config {
+		gws := ic.Gateway
+		sns := ic.Subnet
+		if gws != "" && sns != "" {
+			gw := net.ParseIP(gws)
+			if gw == nil {
+				err := fmt.Errorf("failed to parse gateway from ipam config")
+				return nil, err
+			}
+			_, sn, err := net.ParseCIDR(sns)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, &net.IPNet{IP: gw, Mask: sn.Mask})
+		}
+	}
+
+	if len(ret) == 0 {
+		return nil, vxrerrors.NotFound("no gateway with subnet found in ipam config")
+	}
+	return ret, nil
+}
+
+// gatewayIn returns the gateway of a network within sn
+func gatewayIn(nr *types.NetworkResource, sn *net.IPNet) (*net.IPNet, error) {
+	gws, err := GatewaysFromNR(nr)
+	if err != nil {
+		return nil, err
+	}
+	for _, gw := range gws {
+		if sn.Contains(gw.IP) {
+			return gw, nil
+		}
+	}
+	return nil, vxrerrors.NotFound("no gateway of network %v is in %v", nr.Name, sn)
+}
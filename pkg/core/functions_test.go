@@ -0,0 +1,98 @@
+package core
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+// v6Only is an IPv6 only network, as docker inspects it
+var v6Only = &types.NetworkResource{
+	ID:   "v6only",
+	Name: "v6only",
+	IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "fd00:1::/64", Gateway: "fd00:1::1"}}},
+}
+
+func TestWaitingForAddress(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("10.1.2.0/24")
+	_, v6, _ := net.ParseCIDR("fd00:1::/64")
+	tests := []struct {
+		name string
+		es   *network.EndpointSettings
+		sn   *net.IPNet
+		want bool
+	}{
+		{"no endpoint", nil, v6, false},
+		{"ipv6 only, no address", &network.EndpointSettings{}, v6, true},
+		{"ipv6 only, addressed", &network.EndpointSettings{GlobalIPv6Address: "fd00:1::5"}, v6, false},
+		{"dual stack, ipv4 addressed first", &network.EndpointSettings{IPAddress: "10.1.2.5"}, v6, true},
+		{"ipv4, no address", &network.EndpointSettings{GlobalIPv6Address: "fd00:1::5"}, v4, true},
+		{"ipv4, addressed", &network.EndpointSettings{IPAddress: "10.1.2.5"}, v4, false},
+	}
+	for _, tt := range tests {
+		if w := waitingForAddress(tt.es, tt.sn); w != tt.want {
+			t.Errorf("%v: waiting is %v, want %v", tt.name, w, tt.want)
+		}
+	}
+}
+
+func TestGatewaysIPv6Only(t *testing.T) {
+	gws, err := GatewaysFromNR(v6Only)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gws) != 1 || gws[0].String() != "fd00:1::1/64" {
+		t.Errorf("gateways are %v, want fd00:1::1/64", gws)
+	}
+
+	_, sn, _ := net.ParseCIDR("fd00:1::/64")
+	if gw, err := gatewayIn(v6Only, sn); err != nil || gw.String() != "fd00:1::1/64" {
+		t.Errorf("gateway in %v is %v (%v), want fd00:1::1/64", sn, gw, err)
+	}
+	_, v4, _ := net.ParseCIDR("10.1.2.0/24")
+	if gw, err := gatewayIn(v6Only, v4); err == nil {
+		t.Errorf("gateway in %v is %v, want none", v4, gw)
+	}
+
+	if sn, err := subnetOf(v6Only, net.ParseIP("fd00:1::5")); err != nil || sn.String() != "fd00:1::/64" {
+		t.Errorf("subnet of fd00:1::5 is %v (%v)", sn, err)
+	}
+	if sn, err := subnetOf(v6Only, net.ParseIP("10.1.2.5")); err == nil {
+		t.Errorf("subnet of 10.1.2.5 is %v, want none", sn)
+	}
+}
+
+func TestGetGatewaysByNetIDIPv6Only(t *testing.T) {
+	c, err := New(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cached, so docker is not asked
+	c.putNrInCache(v6Only)
+
+	gws, err := c.GetGatewaysByNetID(v6Only.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gws) != 1 || gws[0].IP.To4() != nil || gws[0].String() != "fd00:1::1/64" {
+		t.Errorf("gateways are %v, want only fd00:1::1/64", gws)
+	}
+}
+
+func TestLockOwner(t *testing.T) {
+	c1, err := New(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := DefaultOptions()
+	o.NetworkDriverName, o.IpamDriverName = "vxrNet2", "vxrIpam2"
+	c2, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1.lockOwner() == c2.lockOwner() {
+		t.Errorf("instances on a host both lock as %v", c1.lockOwner())
+	}
+}
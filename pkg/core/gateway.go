@@ -0,0 +1,69 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+)
+
+// GatewayMode is how the gateway of a network is provided
+type GatewayMode string
+
+const (
+	// GatewayPlugin adds the gateway address to the host macvlan on each host (default)
+	GatewayPlugin GatewayMode = "plugin"
+	// GatewayExternal uses the network gateway as a next-hop provided outside of the plugin, eg. by the TOR switch
+	GatewayExternal GatewayMode = "external"
+	// GatewayNone provides no gateway to containers, the network is layer 2 only
+	GatewayNone GatewayMode = "none"
+)
+
+// ParseGatewayMode parses a gateway mode, empty is the default plugin mode
+func ParseGatewayMode(s string) (GatewayMode, error) {
+	switch m := GatewayMode(strings.ToLower(s)); m {
+	case "":
+		return GatewayPlugin, nil
+	case GatewayPlugin, GatewayExternal, GatewayNone:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid %v %q, must be one of plugin, external or none", options.GatewayMode, s)
+}
+
+func (c *Core) gatewayMode(nr *types.NetworkResource) (GatewayMode, error) {
+	nopts, err := c.netOptions(nr)
+	if err != nil {
+		return "", err
+	}
+	return ParseGatewayMode(nopts.String(options.GatewayMode))
+}
+
+// hostGateways returns the addresses to add to the host interface, one per address family.
+// It is empty unless the gateway is provided by the plugin.
+func (c *Core) hostGateways(nr *types.NetworkResource) ([]*net.IPNet, error) {
+	gws, err := GatewaysFromNR(nr)
+	if err != nil {
+		return nil, err
+	}
+
+	gm, err := c.gatewayMode(nr)
+	if err != nil {
+		return nil, err
+	}
+	if gm != GatewayPlugin {
+		return nil, nil
+	}
+	return gws, nil
+}
+
+// GatewayModeByNetID returns the gateway mode of a network
+func (c *Core) GatewayModeByNetID(netid string) (GatewayMode, error) {
+	nr, err := c.getNetworkResourceByID(netid)
+	if err != nil {
+		return "", err
+	}
+	return c.gatewayMode(nr)
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+)
+
+// TeardownIdle deletes the host interfaces of networks which have no endpoints on this
+// host and have passed no traffic for at least idle, freeing their vxlan.
+// It should be called periodically, traffic is sampled on each call.
+func (c *Core) TeardownIdle(idle time.Duration) {
+	log := log.WithField("func", "TeardownIdle()")
+	log.Debug()
+
+	es, err := c.getContainerIPsAndSubnets()
+	if err != nil {
+		log.WithError(err).Error("failed to get container IPs")
+		return
+	}
+	used := make(map[string]bool)
+	for _, netid := range es {
+		used[netid] = true
+	}
+
+	nrs, err := c.networks()
+	if err != nil {
+		return
+	}
+	for _, nr := range nrs {
+		if used[nr.ID] {
+			continue
+		}
+		hi, err := host.GetInterface(nr.Name)
+		if err != nil {
+			continue
+		}
+		log := log.WithField("network", nr.Name)
+		d, err := hi.Idle()
+		if err != nil {
+			log.WithError(err).Debug("failed to get interface traffic")
+			continue
+		}
+		if d < idle {
+			continue
+		}
+		// Delete leaves the interface alone if anything still routes through it
+		err = hi.Delete()
+		if err != nil {
+			log.WithError(err).Error("failed to delete idle host interface")
+			continue
+		}
+		if _, err = host.GetInterface(nr.Name); err != nil {
+			log.WithField("idle", d.Round(time.Second)).Info("deleted idle host interface")
+		}
+	}
+}
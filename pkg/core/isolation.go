@@ -0,0 +1,46 @@
+package core
+
+import (
+	"net"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+)
+
+// IsolateEndpoint isolates an endpoint joining a network which shares its vxlan id from the
+// other networks on the vxlan, in the container namespace at sandboxKey
+func (c *Core) IsolateEndpoint(netid, sandboxKey string) error {
+	log := log.WithField("netid", netid)
+	log.Debug("IsolateEndpoint()")
+
+	nr, err := c.getNetworkResourceByID(netid)
+	if err != nil {
+		log.WithError(err).Error("failed to get network resource")
+		return err
+	}
+	nopts, err := c.netOptions(nr)
+	if err != nil {
+		return err
+	}
+	if nopts.String(options.ShareVNI) != "on" {
+		return nil
+	}
+
+	sns := []*net.IPNet{}
+	for _, p := range poolsFromNR(nr) {
+		_, sn, err := net.ParseCIDR(p)
+		if err != nil {
+			return err
+		}
+		sns = append(sns, sn)
+	}
+
+	hi, err := host.GetInterface(nr.Name)
+	if err != nil {
+		log.WithError(err).Error("failed to get host interface")
+		return err
+	}
+	return hi.Isolate(sandboxKey, sns)
+}
@@ -0,0 +1,293 @@
+package core
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/alloclog"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/store"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/webhook"
+)
+
+// lease records an allocated address in the lease store. A lease with a ttl is
+// reclaimed soon after it expires without its container, see ExpireLeases.
+// external is set for addresses reserved in the external IPAM.
+func (c *Core) lease(ip net.IP, pool string, ttl time.Duration, external bool) {
+	if c.leases == nil {
+		return
+	}
+	l := &store.Lease{Address: ip.String(), Pool: pool, Created: time.Now(), External: external}
+	if ttl > 0 {
+		exp := l.Created.Add(ttl)
+		l.TTL, l.Expires = ttl, &exp
+	}
+	err := c.leases.Put(l)
+	if err != nil {
+		log.WithField("ip", ip).WithError(err).Error("failed to store lease")
+	}
+}
+
+// unlease removes the lease on an address from the lease store
+func (c *Core) unlease(ip net.IP) {
+	if c.leases == nil {
+		return
+	}
+	err := c.leases.Delete(ip.String())
+	if err != nil {
+		log.WithField("ip", ip).WithError(err).Error("failed to delete lease")
+	}
+}
+
+// unavailable reports addresses which must not be randomly selected, those
+// reserved from a seed host and those leased but without a route yet
+func (c *Core) unavailable(ip net.IP) bool {
+	return c.reserved.has(ip) || (c.leases != nil && c.leases.Has(ip.String()))
+}
+
+// LeaseEndpoint records the endpoint an address was assigned to
+func (c *Core) LeaseEndpoint(address, endpointID string) {
+	if address == "" {
+		return
+	}
+	ip, sn, err := net.ParseCIDR(address)
+	if err != nil {
+		return
+	}
+	c.LogAllocation(&alloclog.Entry{Op: alloclog.OpAssign, Pool: sn.String(), Address: ip.String(), Endpoint: endpointID}, time.Now(), nil)
+	if c.leases == nil {
+		return
+	}
+	err = c.leases.SetEndpoint(ip.String(), endpointID)
+	if err != nil {
+		log.WithField("ip", ip).WithError(err).Error("failed to store lease endpoint")
+	}
+}
+
+// LeasedEndpoint returns the endpoint address, with or without a mask, was assigned to, if known
+func (c *Core) LeasedEndpoint(address string) string {
+	if c.leases == nil {
+		return ""
+	}
+	if ip, _, err := net.ParseCIDR(address); err == nil {
+		address = ip.String()
+	}
+	return c.leases.Endpoint(address)
+}
+
+// LogAllocation writes an allocation event to the allocation log, if there is one,
+// with the latency since start and the error of the operation. Successful assignments
+// and releases are also posted to the webhook, if there is one.
+func (c *Core) LogAllocation(e *alloclog.Entry, start time.Time, err error) {
+	if err == nil {
+		c.notify(e)
+	}
+	if c.allocLog == nil {
+		return
+	}
+	e.Driver = c.IpamDriverName()
+	e.Latency = float64(time.Since(start)) / float64(time.Millisecond)
+	if err != nil {
+		e.Error = err.Error()
+	}
+	werr := c.allocLog.Write(e)
+	if werr != nil {
+		log.WithField("op", e.Op).WithField("address", e.Address).WithError(werr).Error("failed to write allocation log")
+	}
+}
+
+// RestoreLeases re-adds the host routes of leases in pools of this driver,
+// so addresses allocated before a restart are not handed out again.
+// Reconcile keeps the restored routes for the restore grace, the containers
+// they belong to may be started by docker after the driver.
+// Leases which conflict with a route from another host are dropped.
+func (c *Core) RestoreLeases() {
+	if c.leases == nil {
+		return
+	}
+	log := log.WithField("func", "RestoreLeases()")
+	log.Debug()
+
+	for _, l := range c.leases.List() {
+		log := log.WithField("ip", l.Address).WithField("pool", l.Pool)
+		ip := net.ParseIP(l.Address)
+		if ip == nil {
+			log.Warn("dropping invalid lease")
+			c.leases.Delete(l.Address) // nolint: errcheck
+			continue
+		}
+
+		nr, err := c.getNetworkResourceByPool(l.Pool)
+		if err != nil || nr == nil || nr.Driver != c.NetworkDriverName() {
+			continue
+		}
+
+		gws, err := c.hostGateways(nr)
+		if err != nil {
+			log.WithError(err).Error("failed to get gateway")
+			continue
+		}
+		nopts, err := c.netOptions(nr)
+		if err != nil {
+			log.WithError(err).Error("invalid network options")
+			continue
+		}
+		hi, err := host.GetOrCreateInterface(nr.Name, gws, nopts)
+		if err != nil {
+			log.WithError(err).Error("failed to get or create host interface")
+			continue
+		}
+
+		restored, err := hi.RestoreRoute(ip, nopts.Int(options.Metric))
+		if err != nil {
+			log.WithError(err).Error("failed to restore route")
+			continue
+		}
+		if !restored {
+			if n, _ := host.VxroutesTo(ip); n == 0 { // nolint: errcheck
+				log.Warn("leased address is routed elsewhere, dropping lease")
+				c.unlease(ip)
+			}
+			continue
+		}
+		log.Debug("restored route for lease")
+		c.restored.add(ip, time.Now().Add(c.restoreGrace))
+
+		if _, pfx, err := net.ParseCIDR(l.Prefix); err == nil {
+			if _, err = hi.RestorePrefix(pfx, ip); err != nil {
+				log.WithField("prefix", pfx).WithError(err).Error("failed to restore delegated prefix route")
+			}
+		}
+	}
+}
+
+// ReclaimLeases drops leases older than ttl whose address is neither used by a
+// container nor routed, so the address can be issued again. Leases are left for
+// addresses docker still knows, eg. a container which is being created.
+// Leases are shared by all instances, only one of them needs to reclaim them.
+func (c *Core) ReclaimLeases(ttl time.Duration) {
+	if c.leases == nil {
+		return
+	}
+	log := log.WithField("func", "ReclaimLeases()")
+	log.Debug()
+
+	es, err := c.getContainerIPsAndSubnets()
+	if err != nil {
+		log.WithError(err).Error("failed to get container IPs")
+		return
+	}
+
+	for _, l := range c.leases.List() {
+		if time.Since(l.Created) < ttl {
+			continue
+		}
+		if _, ok := es[l.Address]; ok {
+			continue
+		}
+		ip := net.ParseIP(l.Address)
+		if ip == nil {
+			continue
+		}
+		n, err := host.VxroutesTo(ip)
+		if err != nil || n > 0 {
+			continue
+		}
+		log.WithField("ip", l.Address).WithField("pool", l.Pool).WithField("endpoint", l.EndpointID).
+			Info("reclaiming stale lease")
+		c.unlease(ip)
+	}
+}
+
+// ExpireLeases checks the leases with a ttl which expired. Those whose container is
+// still running are renewed for another ttl, the others are reclaimed right away,
+// with the route of the address if it is still on this host, instead of waiting
+// for ReclaimLeases. Leases are shared by all instances, only one of them needs
+// to expire them.
+func (c *Core) ExpireLeases() {
+	if c.leases == nil {
+		return
+	}
+	log := log.WithField("func", "ExpireLeases()")
+
+	now := time.Now()
+	var expired []store.Lease
+	for _, l := range c.leases.List() {
+		if l.Expires != nil && now.After(*l.Expires) {
+			expired = append(expired, l)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	log.Debug()
+
+	es, err := c.getContainerIPsAndSubnets()
+	if err != nil {
+		log.WithError(err).Error("failed to get container IPs")
+		return
+	}
+
+	for _, l := range expired {
+		log := log.WithField("ip", l.Address).WithField("pool", l.Pool).WithField("endpoint", l.EndpointID)
+		ip := net.ParseIP(l.Address)
+		if ip == nil {
+			continue
+		}
+		if _, ok := es[l.Address]; ok {
+			if err = c.leases.Renew(l.Address); err != nil {
+				log.WithError(err).Error("failed to renew lease")
+			}
+			continue
+		}
+		if n, err := host.VxroutesTo(ip); err != nil || n == 0 {
+			log.Info("reclaiming expired lease")
+			c.unlease(ip)
+			continue
+		}
+		log.Info("reclaiming expired lease of a vanished container")
+		if err = c.releaseAddress(ip); err != nil {
+			log.WithError(err).Error("failed to release address of expired lease")
+		}
+	}
+}
+
+// notify posts an assignment or release to the webhook
+func (c *Core) notify(e *alloclog.Entry) {
+	if c.webhook == nil || e.Address == "" {
+		return
+	}
+	we := &webhook.Event{Address: e.Address, Endpoint: e.Endpoint, Host: c.hostname, Timestamp: e.Time}
+	switch e.Op {
+	case alloclog.OpAssign:
+		we.Event = webhook.EventAllocate
+	case alloclog.OpRelease:
+		we.Event = webhook.EventRelease
+	default:
+		return
+	}
+	// releases carry the pool id, assignments the pool
+	if _, _, err := net.ParseCIDR(e.Pool); err == nil {
+		we.Pool = e.Pool
+	} else if strings.Contains(e.Pool, "/") {
+		we.Pool = poolFromID(e.Pool)
+	}
+	c.webhook.Send(we)
+}
+
+// evicted logs and posts to the webhook an address taken over from another node
+func (c *Core) evicted(cf *host.Conflict, sn *net.IPNet) {
+	log.WithField("ip", cf.IP).WithField("pool", sn).WithField("reason", string(cf.Kind)).
+		Warn("evicted address from another node")
+	c.webhook.Send(&webhook.Event{
+		Event:   webhook.EventEvict,
+		Address: cf.IP.String(),
+		Pool:    sn.String(),
+		Reason:  string(cf.Kind),
+		Host:    c.hostname,
+	})
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+type getNr struct {
+	s  string
+	rc chan<- *types.NetworkResource
+}
+
+func nrCacheLoop(getNr <-chan *getNr, delNr <-chan string, putNr <-chan *types.NetworkResource, flushNr <-chan struct{},
+	listNr <-chan chan<- []*types.NetworkResource) {
+	nrCache := make(map[string]*types.NetworkResource)
+	for {
+		select {
+		case rc := <-listNr:
+			nrs := []*types.NetworkResource{}
+			for k, nr := range nrCache {
+				if k == nr.ID {
+					nrs = append(nrs, nr)
+				}
+			}
+			rc <- nrs
+		case <-flushNr:
+			nrCache = make(map[string]*types.NetworkResource)
+		case rc := <-getNr:
+			rc.rc <- nrCache[rc.s]
+		case dn := <-delNr:
+			nr := nrCache[dn]
+			if nr == nil {
+				break
+			}
+			delete(nrCache, nr.ID)
+			for _, pool := range poolsFromNR(nr) {
+				delete(nrCache, pool)
+			}
+		case nr := <-putNr:
+			nrCache[nr.ID] = nr
+			pools := poolsFromNR(nr)
+			if len(pools) == 0 {
+				log.Debug("failed to get pool from network resource, not caching")
+			}
+			for _, pool := range pools {
+				nrCache[pool] = nr
+			}
+		}
+	}
+}
+
+func (c *Core) getNrFromCache(s string) *types.NetworkResource {
+	rc := make(chan *types.NetworkResource)
+	c.getNr <- &getNr{s, rc}
+	return <-rc
+}
+
+func (c *Core) putNrInCache(nr *types.NetworkResource) {
+	c.putNr <- nr
+}
+
+func (c *Core) delNrInCache(s string) {
+	c.delNr <- s
+}
+
+func (c *Core) listNrInCache() []*types.NetworkResource {
+	rc := make(chan []*types.NetworkResource)
+	c.listNr <- rc
+	return <-rc
+}
+
+// FlushCache drops all cached network resources and re-enumerates the networks
+// from docker, returning how many were found. The cache is kept while the docker
+// circuit breaker is open, as it could not be refilled.
+func (c *Core) FlushCache() (int, error) {
+	if c.breaker.isOpen() {
+		return 0, vxrerrors.Unavailable("docker is failing, not flushing the network cache")
+	}
+	log.WithField("driver", c.NetworkDriverName()).Info("flushing network cache")
+	c.flushNr <- struct{}{}
+
+	nrs, err := c.networks()
+	if err != nil {
+		return 0, err
+	}
+	return len(nrs), nil
+}
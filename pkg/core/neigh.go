@@ -0,0 +1,102 @@
+package core
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+)
+
+// Neighbors returns the known neighbors of the host interfaces of all networks of this driver,
+// for another host to warm up its forwarding tables with
+func (c *Core) Neighbors() ([]neigh.Entry, error) {
+	log := log.WithField("func", "Neighbors()")
+	log.Debug()
+
+	nrs, err := c.knownNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []neigh.Entry{}
+	for _, nr := range nrs {
+		hi, err := host.GetInterface(nr.Name)
+		if err != nil {
+			continue
+		}
+		ns, err := hi.Neighbors()
+		if err != nil {
+			log.WithField("network", nr.Name).WithError(err).Warn("failed to list neighbors")
+			continue
+		}
+		ret = append(ret, ns...)
+	}
+	return ret, nil
+}
+
+// ImportNeighbors programs neighbors exported by another host into the host interfaces
+// of this driver's networks, and returns the number imported. Entries for networks
+// without a host interface here are skipped, they will be learned once it is used.
+func (c *Core) ImportNeighbors(entries []neigh.Entry) (int, error) {
+	log := log.WithField("func", "ImportNeighbors()")
+	log.WithField("entries", len(entries)).Debug()
+
+	nrs, err := c.knownNetworks()
+	if err != nil {
+		return 0, err
+	}
+	ours := make(map[string]bool)
+	for _, nr := range nrs {
+		ours[nr.Name] = true
+	}
+
+	n := 0
+	for _, e := range entries {
+		if !ours[e.Network] {
+			continue
+		}
+		hi, err := host.GetInterface(e.Network)
+		if err != nil {
+			continue
+		}
+		ok, err := hi.ImportNeighbor(e)
+		if err != nil {
+			log.WithField("ip", e.IP).WithError(err).Warn("failed to import neighbor")
+			continue
+		}
+		if ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ForgetNeighbors removes neighbors withdrawn by another host from the host interfaces
+// of this driver's networks
+func (c *Core) ForgetNeighbors(entries []neigh.Entry) error {
+	log := log.WithField("func", "ForgetNeighbors()")
+	log.WithField("entries", len(entries)).Debug()
+
+	nrs, err := c.knownNetworks()
+	if err != nil {
+		return err
+	}
+	ours := make(map[string]bool)
+	for _, nr := range nrs {
+		ours[nr.Name] = true
+	}
+
+	for _, e := range entries {
+		if !ours[e.Network] {
+			continue
+		}
+		hi, err := host.GetInterface(e.Network)
+		if err != nil {
+			continue
+		}
+		if err = hi.ForgetNeighbor(e); err != nil {
+			log.WithField("ip", e.IP).WithError(err).Warn("failed to forget neighbor")
+		}
+	}
+	return nil
+}
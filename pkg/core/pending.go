@@ -0,0 +1,111 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// pendingGrace is how long a network created by the driver may take to show up in docker
+// before it is considered to have failed and its reservation is rolled back
+const pendingGrace = time.Minute
+
+// pendingNetwork is a network accepted by CreateNetwork which docker has not yet finished creating
+type pendingNetwork struct {
+	vni     int
+	shared  bool
+	pools   []string
+	created time.Time
+}
+
+// pendingNetworks holds the vnis of networks being created, so concurrent creates can not claim the same vni
+type pendingNetworks struct {
+	l sync.Mutex
+	m map[string]*pendingNetwork
+}
+
+func newPendingNetworks() *pendingNetworks {
+	return &pendingNetworks{m: make(map[string]*pendingNetwork)}
+}
+
+// ReserveNetwork records the vni and pools of a network being created.
+// It fails if another network being created has already reserved the vni, unless both share it.
+func (c *Core) ReserveNetwork(netid string, vni int, shared bool, pools []string) error {
+	c.pending.l.Lock()
+	defer c.pending.l.Unlock()
+	for id, p := range c.pending.m {
+		if id != netid && p.vni == vni && !(shared && p.shared) {
+			return vxrerrors.Conflict("vxlanid %v is being used by network %v, which is still being created", vni, id)
+		}
+	}
+	c.pending.m[netid] = &pendingNetwork{vni: vni, shared: shared, pools: pools, created: time.Now()}
+	return nil
+}
+
+// CachedNetworkName returns the name of a network if it is cached, without asking docker,
+// which may be busy creating it
+func (c *Core) CachedNetworkName(netid string) string {
+	if nr := c.getNrFromCache(netid); nr != nil {
+		return nr.Name
+	}
+	return ""
+}
+
+// ReleaseNetwork rolls back everything recorded locally for a network,
+// either because it was deleted or because docker failed to create it
+func (c *Core) ReleaseNetwork(netid string) {
+	c.pending.l.Lock()
+	p := c.pending.m[netid]
+	delete(c.pending.m, netid)
+	c.pending.l.Unlock()
+
+	c.delNrInCache(netid)
+	if p == nil {
+		return
+	}
+	for _, pool := range p.pools {
+		c.delNrInCache(pool)
+	}
+}
+
+// expirePending commits pending networks which docker now knows about, and rolls back
+// those which have not shown up within the grace period
+func (c *Core) expirePending() {
+	c.pending.l.Lock()
+	n := len(c.pending.m)
+	c.pending.l.Unlock()
+	if n == 0 {
+		return
+	}
+
+	nrs, err := c.networks()
+	if err != nil {
+		log.WithError(err).Error("failed to list networks to check pending creates")
+		return
+	}
+	known := make(map[string]bool, len(nrs))
+	for _, nr := range nrs {
+		known[nr.ID] = true
+	}
+
+	expired := []string{}
+	c.pending.l.Lock()
+	for id, p := range c.pending.m {
+		if known[id] {
+			delete(c.pending.m, id)
+			continue
+		}
+		if time.Since(p.created) > pendingGrace {
+			expired = append(expired, id)
+		}
+	}
+	c.pending.l.Unlock()
+
+	for _, id := range expired {
+		log.WithField("net_id", id).Warn("network was never created by docker, rolling back its reservation")
+		c.ReleaseNetwork(id)
+	}
+}
@@ -0,0 +1,220 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// planTries is how many candidates are checked for an unrequested address
+const planTries = 64
+
+// Plan is what allocating an address on a network would do on this host
+type Plan struct {
+	Driver    string `json:"driver"`
+	Network   string `json:"network"`
+	NetworkID string `json:"network_id"`
+	// Interfaces are the host interfaces which would be created
+	Interfaces []string `json:"interfaces"`
+	// Gateways are the gateways which would be added to the host interface
+	Gateways  []string          `json:"gateways"`
+	Addresses []*PlannedAddress `json:"addresses"`
+}
+
+// PlannedAddress is the address which would be allocated in a subnet of the network
+type PlannedAddress struct {
+	Subnet    string `json:"subnet"`
+	Address   string `json:"address,omitempty"`
+	Requested bool   `json:"requested,omitempty"`
+	// Exact is unset if the allocator picks randomly, Address is then only an example
+	Exact bool `json:"exact"`
+	// Routes are the routes which would be added
+	Routes []string `json:"routes"`
+	// Error is why no address would be allocated
+	Error string `json:"error,omitempty"`
+}
+
+// Plan reports the address which would be allocated on the network with the name or id
+// network, the requested address addr if not empty, and the host interfaces and routes
+// which would be created, without changing anything. Claims in the kv store, duplicate
+// address detection and canaries are not previewed.
+func (c *Core) Plan(network, addr string) (*Plan, error) {
+	log := log.WithField("func", "Plan()").WithField("network", network).WithField("addr", addr)
+	log.Debug()
+
+	var req net.IP
+	if addr != "" {
+		if req = net.ParseIP(addr); req == nil {
+			return nil, fmt.Errorf("invalid address %q", addr)
+		}
+	}
+
+	nrs, err := c.knownNetworks()
+	if err != nil {
+		return nil, err
+	}
+	var nr *types.NetworkResource
+	for _, n := range nrs {
+		if n.ID == network || n.Name == network {
+			nr = n
+			break
+		}
+	}
+	if nr == nil {
+		return nil, vxrerrors.NotFound("network %v not found", network)
+	}
+	nopts, err := c.netOptions(nr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{Driver: c.NetworkDriverName(), Network: nr.Name, NetworkID: nr.ID, Gateways: []string{}, Addresses: []*PlannedAddress{}}
+	p.Interfaces, err = host.PlanInterfaces(nr.Name, nopts)
+	if err != nil {
+		return nil, err
+	}
+	gws, err := c.hostGateways(nr)
+	if err != nil {
+		return nil, err
+	}
+	hi, err := host.GetInterface(nr.Name)
+	for _, gw := range gws {
+		if err != nil || !hi.HasGateway(gw) {
+			p.Gateways = append(p.Gateways, gw.String())
+		}
+	}
+
+	matched := false
+	for _, pool := range poolsFromNR(nr) {
+		_, sn, err := net.ParseCIDR(pool)
+		if err != nil {
+			return nil, err
+		}
+		if req != nil && !sn.Contains(req) {
+			continue
+		}
+		matched = true
+		pa := &PlannedAddress{Subnet: sn.String(), Requested: req != nil, Routes: []string{}}
+		if err = c.planAddress(pa, nr, nopts, sn, req); err != nil {
+			pa.Error = err.Error()
+		}
+		p.Addresses = append(p.Addresses, pa)
+	}
+	if !matched {
+		return nil, fmt.Errorf("address %v is not in a subnet of network %v", addr, nr.Name)
+	}
+	return p, nil
+}
+
+// planAddress fills in the address and routes of pa, as connectAndGetAddress would select them
+func (c *Core) planAddress(pa *PlannedAddress, nr *types.NetworkResource, nopts options.Options, sn *net.IPNet, req net.IP) error {
+	alloc, err := allocator.New(nopts.String(options.Allocation), &allocator.Config{
+		Options:      nopts,
+		KV:           c.kv,
+		Owner:        c.lockOwner(),
+		KVTTL:        c.kvTTL,
+		ExternalIPAM: c.extIPAM,
+	})
+	if err != nil {
+		return err
+	}
+	opts := &host.SelectOpts{
+		Allocator:    alloc,
+		Subnet:       sn,
+		ExcludeFirst: nopts.Int(options.ExcludeFirst),
+		ExcludeLast:  nopts.Int(options.ExcludeLast),
+		Reserved:     c.unavailable,
+	}
+	opts.Exclude, err = host.ParseRanges(nopts.String(options.Exclude))
+	if err != nil {
+		return err
+	}
+	if ngw, err := gatewayIn(nr, sn); err == nil {
+		opts.Gateway = ngw.IP
+	}
+	delegate := nopts.Int(options.Delegate)
+	if sn.IP.To4() != nil {
+		delegate = 0
+	}
+	if delegate > 0 {
+		opts.Reserved = notDelegated(sn, opts.Reserved)
+	}
+	if hb := nopts.Int(options.HostBlock); hb > 0 {
+		opts.Block = host.SubBlock(sn, hb, c.hostname)
+		opts.BlockOnly = opts.Block != nil
+		// the block already claimed may have been probed past the one selected
+		if opts.BlockOnly {
+			if claimed, err := host.SummaryBlockIn(sn, hb, 0); err == nil && claimed != nil {
+				opts.Block = claimed
+			}
+		}
+	}
+
+	mvl := "hmvl_" + nr.Name
+	if opts.BlockOnly {
+		ok, err := host.RouteExists(opts.Block)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			pa.Routes = append(pa.Routes, opts.Block.String()+" dev "+mvl)
+		}
+	}
+
+	if req != nil {
+		// requested addresses are leased by docker, the lease is dropped before selecting it
+		opts.Reserved = c.reserved.has
+		pa.Exact = true
+		why, err := host.CheckAddress(req, true, opts)
+		if err != nil {
+			return err
+		}
+		if why != "" {
+			return vxrerrors.Conflict("requested address %v is %v", req, why)
+		}
+		pa.Address = req.String()
+		pa.Routes = append(pa.Routes, hostRoute(req)+" dev "+mvl)
+		return nil
+	}
+
+	if t, ok := c.frozenSince(sn); ok {
+		return vxrerrors.Conflict("pool %v is frozen since %v", sn, t.Format(time.RFC3339))
+	}
+	_, random := alloc.(allocator.Random)
+	_, kv := alloc.(*allocator.KVStore)
+	pa.Exact = !random && !kv
+	cands, err := host.PeekAddresses(opts, planTries)
+	if err != nil {
+		return err
+	}
+	for _, ip := range cands {
+		why, err := host.CheckAddress(ip, false, opts)
+		if err != nil {
+			return err
+		}
+		if why != "" {
+			continue
+		}
+		pa.Address = ip.String()
+		pa.Routes = append(pa.Routes, hostRoute(ip)+" dev "+mvl)
+		return nil
+	}
+	return vxrerrors.Exhausted("no free address found in %v after %v candidates", sn, planTries)
+}
+
+// hostRoute returns the host route to ip
+func hostRoute(ip net.IP) string {
+	bits := 128
+	if ip.To4() != nil {
+		bits = 32
+	}
+	return (&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}).String()
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// Address spaces of the ipam driver. Pools are checked for overlap across both,
+// as all networks share the routing table of the host.
+const (
+	LocalAddressSpace  = "vxrLocal"
+	GlobalAddressSpace = "vxrGlobal"
+)
+
+// poolEntry is a pool handed out by RequestPool
+type poolEntry struct {
+	space   string
+	pool    *net.IPNet
+	subPool *net.IPNet
+	// gateway is the address given by the gateway offset option, if set
+	gateway net.IP
+	// refs is the number of requests for the pool not yet released
+	refs int
+}
+
+// id returns the pool id of the entry
+func (e *poolEntry) id() string {
+	id := e.space + "/" + e.pool.String()
+	if e.subPool != nil {
+		id += subPoolSep + e.subPool.String()
+	}
+	return id
+}
+
+// poolRegistry holds the pools in use, by pool id, space/pool[@subpool]. It is loaded from docker's
+// networks on first use, as docker does not replay RequestPool after a restart.
+type poolRegistry struct {
+	l      sync.Mutex
+	loaded bool
+	m      map[string]*poolEntry
+}
+
+func newPoolRegistry() *poolRegistry {
+	return &poolRegistry{m: make(map[string]*poolEntry)}
+}
+
+// RequestPool registers pool, restricted to subPool if it is not empty, in an address space
+// and returns its pool id. It fails if the pool overlaps a pool already in use, unless it
+// is the same pool, which is handed out again until it is released as often.
+// opts are the ipam options of the pool.
+func (c *Core) RequestPool(space, pool, subPool string, opts map[string]string) (string, error) {
+	switch space {
+	case "":
+		space = LocalAddressSpace
+	case LocalAddressSpace, GlobalAddressSpace:
+	default:
+		return "", fmt.Errorf("unknown address space %v, must be %v or %v", space, LocalAddressSpace, GlobalAddressSpace)
+	}
+
+	_, pn, err := net.ParseCIDR(pool)
+	if err != nil {
+		return "", err
+	}
+	e := &poolEntry{space: space, pool: pn, refs: 1}
+	if subPool != "" {
+		_, e.subPool, err = net.ParseCIDR(subPool)
+		if err != nil {
+			return "", err
+		}
+		pOnes, _ := pn.Mask.Size()
+		sOnes, _ := e.subPool.Mask.Size()
+		if !pn.Contains(e.subPool.IP) || sOnes < pOnes {
+			return "", fmt.Errorf("ip range %v is not within pool %v", subPool, pool)
+		}
+	}
+	o, err := options.Parse(c.defaults, opts)
+	if err != nil {
+		return "", err
+	}
+	e.gateway, err = gatewayAtOffset(pn, o)
+	if err != nil {
+		return "", err
+	}
+
+	c.pools.l.Lock()
+	defer c.pools.l.Unlock()
+	if !c.pools.loaded {
+		err = c.loadPools()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// the same pool is requested again eg. by swarm when the network is created locally
+	id := e.id()
+	if o, ok := c.pools.m[id]; ok {
+		o.refs++
+		return id, nil
+	}
+	for oid, o := range c.pools.m {
+		if o.pool.Contains(pn.IP) || pn.Contains(o.pool.IP) {
+			return "", vxrerrors.Conflict("pool %v overlaps pool %v in use as %v", pn, o.pool, oid)
+		}
+	}
+	c.pools.m[id] = e
+	return id, nil
+}
+
+// ReleasePool removes a pool from the registry and the network resource cache,
+// once it is released as often as it was requested
+func (c *Core) ReleasePool(poolid string) {
+	c.pools.l.Lock()
+	if e, ok := c.pools.m[poolid]; ok {
+		e.refs--
+		if e.refs > 0 {
+			c.pools.l.Unlock()
+			return
+		}
+		delete(c.pools.m, poolid)
+	} else {
+		// ids loaded from docker, or issued before address spaces, are only known by their pool
+		pool := poolFromID(poolid)
+		for id, e := range c.pools.m {
+			if e.pool.String() == pool {
+				delete(c.pools.m, id)
+			}
+		}
+	}
+	c.pools.l.Unlock()
+
+	c.Uncache(poolid)
+}
+
+// PoolGateway returns the gateway of a pool set by the gateway offset option,
+// or nil if it is not set
+func (c *Core) PoolGateway(poolid string) (*net.IPNet, error) {
+	c.pools.l.Lock()
+	defer c.pools.l.Unlock()
+	if !c.pools.loaded {
+		if err := c.loadPools(); err != nil {
+			return nil, err
+		}
+	}
+
+	e, ok := c.pools.m[poolid]
+	if !ok {
+		pool := poolFromID(poolid)
+		for _, o := range c.pools.m {
+			if o.pool.String() == pool {
+				e, ok = o, true
+				break
+			}
+		}
+	}
+	if !ok || e.gateway == nil {
+		return nil, nil
+	}
+	return &net.IPNet{IP: e.gateway, Mask: e.pool.Mask}, nil
+}
+
+// gatewayAtOffset returns the address at the gateway offset of pool, or nil if the option is not set
+func gatewayAtOffset(pool *net.IPNet, opts options.Options) (net.IP, error) {
+	if opts.String(options.GatewayOffset) == "" {
+		return nil, nil
+	}
+	off := opts.Int(options.GatewayOffset)
+	gw := host.NthAddress(pool, off)
+	if gw == nil {
+		return nil, fmt.Errorf("gateway offset %v is outside of pool %v", off, pool)
+	}
+	return gw, nil
+}
+
+// loadPools registers the pools of docker's networks using this ipam driver.
+// Caller must hold the registry lock.
+func (c *Core) loadPools() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	dc, err := c.docker()
+	if err != nil {
+		return err
+	}
+	nl, err := dc.NetworkList(ctx, types.NetworkListOptions{})
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Error("failed to list networks")
+		return err
+	}
+
+	for _, nr := range nl {
+		// config-only networks never request their pools, networks created from them
+		// are listed without the config they inherit
+		if nr.Driver == configNetDriver {
+			continue
+		}
+		if nr.Driver == c.NetworkDriverName() && len(nr.IPAM.Config) == 0 {
+			inr, err := c.inspectNetwork(ctx, dc, nr.ID)
+			if err != nil {
+				log.WithField("network", nr.Name).WithError(err).Warn("failed to inspect network")
+				continue
+			}
+			nr = inr
+		}
+		if nr.IPAM.Driver != c.IpamDriverName() {
+			continue
+		}
+		opts, err := options.Parse(c.defaults, nr.IPAM.Options)
+		if err != nil {
+			log.WithField("network", nr.Name).WithError(err).Warn("invalid ipam options")
+		}
+		space := LocalAddressSpace
+		if nr.Scope == "swarm" {
+			space = GlobalAddressSpace
+		}
+		for _, ic := range nr.IPAM.Config {
+			_, pn, err := net.ParseCIDR(ic.Subnet)
+			if err != nil {
+				continue
+			}
+			e := &poolEntry{space: space, pool: pn, refs: 1}
+			if ic.IPRange != "" {
+				if _, e.subPool, err = net.ParseCIDR(ic.IPRange); err != nil {
+					e.subPool = nil
+				}
+			}
+			e.gateway, _ = gatewayAtOffset(pn, opts) // nolint: errcheck
+			c.pools.m[e.id()] = e
+		}
+	}
+	c.pools.loaded = true
+	return nil
+}
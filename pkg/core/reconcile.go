@@ -7,7 +7,7 @@ import (
 	"net"
 	"sync"
 
-	"github.com/TrilliumIT/vxrouter/host"
+	"github.com/TrilliumIT/vxrouter/internal/host"
 	"github.com/docker/docker/api/types"
 )
 
@@ -15,6 +15,8 @@ import (
 func (c *Core) Reconcile() {
 	log := log.WithField("func", "Reconcile()")
 
+	c.expirePending()
+
 	// This is possibly racy, if a container starts up after containers are listed
 	// I might delete it's routes
 	// To compensate for this, I compare es before and after the run, if it's changed, run again immediately
@@ -48,6 +50,14 @@ func (c *Core) Reconcile() {
 		if _, ok := es[n.IP.String()]; ok {
 			continue
 		}
+		// parked addresses of containers in a restart loop keep their route until they are released
+		if c.isParked(n.IP) {
+			continue
+		}
+		// restored leases wait for their containers to be started
+		if c.restored.has(n.IP) {
+			continue
+		}
 		// This MUST only delete the route, not call hi.Delete(), because if the race condition triggered
 		// and another container started up, and I just deleted it's route, hi.Delete() will delete the vxlan
 		// interface that is the master of the slave container interface. There will be no way to recover except by
@@ -60,6 +70,12 @@ func (c *Core) Reconcile() {
 			log.WithError(err).Error("error deleting orphaned route")
 			continue
 		}
+		if n.IP.To4() == nil {
+			if err = hi.DelDelegatedPrefixes(n.IP); err != nil {
+				log.WithError(err).Warn("failed to delete prefixes delegated to orphaned route")
+			}
+		}
+		c.unlease(n.IP)
 		orphanedInts[hi.Name()] = hi
 	}
 
@@ -81,6 +97,9 @@ func (c *Core) Reconcile() {
 		hiDelWg.Add(1)
 		go func(hi *host.Interface) {
 			defer hiDelWg.Done()
+			if err := hi.ReleaseGateways(); err != nil {
+				log.WithError(err).Error("failed to release gateway")
+			}
 			if err = hi.Delete(); err != nil {
 				log.WithError(err).Error("error while deleting host interface")
 			}
@@ -88,6 +107,8 @@ func (c *Core) Reconcile() {
 	}
 
 	hiDelWg.Wait()
+
+	c.learnSticky()
 }
 
 func ipListsEqual(m map[string]string, m2 map[string]string) bool {
@@ -108,7 +129,12 @@ func (c *Core) getContainerIPsAndSubnets() (map[string]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
 	defer cancel()
 
-	ctrs, err := c.dc.ContainerList(ctx, types.ContainerListOptions{})
+	dc, err := c.docker()
+	if err != nil {
+		return nil, err
+	}
+	ctrs, err := dc.ContainerList(ctx, types.ContainerListOptions{})
+	c.dockerErr(err)
 	if err != nil {
 		return nil, err
 	}
@@ -118,17 +144,21 @@ func (c *Core) getContainerIPsAndSubnets() (map[string]string, error) {
 		for _, es := range ctr.NetworkSettings.Networks {
 			// This is necessary because docker is stupid, this could be
 			// "10.1.141.01" for example
-			ip := net.ParseIP(es.IPAddress)
-			if ip != nil {
-				ret[ip.String()] = es.NetworkID
+			for _, a := range []string{es.IPAddress, es.GlobalIPv6Address} {
+				ip := net.ParseIP(a)
+				if ip != nil {
+					ret[ip.String()] = es.NetworkID
+				}
 			}
 
 			if es.IPAMConfig == nil {
 				continue
 			}
-			ip = net.ParseIP(es.IPAMConfig.IPv4Address)
-			if ip != nil {
-				ret[ip.String()] = es.NetworkID
+			for _, a := range []string{es.IPAMConfig.IPv4Address, es.IPAMConfig.IPv6Address} {
+				ip := net.ParseIP(a)
+				if ip != nil {
+					ret[ip.String()] = es.NetworkID
+				}
 			}
 		}
 	}
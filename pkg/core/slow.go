@@ -0,0 +1,73 @@
+package core
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+)
+
+// slowRouteCountTimeout bounds counting routes for a diagnostic snapshot, netlink may be what is hung
+const slowRouteCountTimeout = time.Second
+
+// slowCalls rate limits diagnostic snapshots, a hang usually stalls many calls at once
+type slowCalls struct {
+	l    sync.Mutex
+	last time.Time
+}
+
+// Watch starts timing a driver call, and returns a func to call when it completes,
+// which records it in the response budget. If the call is still running after the
+// slow call threshold, a diagnostic snapshot is logged.
+// Intended to be used as defer c.Watch("call")()
+func (c *Core) Watch(call string) func() {
+	start := time.Now()
+	var t *time.Timer
+	if c.slowCall > 0 {
+		t = time.AfterFunc(c.slowCall, func() { c.logSlowCall(call, start) })
+	}
+	return func() {
+		if t != nil {
+			t.Stop()
+		}
+		c.Track(call, start)
+	}
+}
+
+func (c *Core) logSlowCall(call string, start time.Time) {
+	log := log.WithField("call", call).
+		WithField("driver", c.NetworkDriverName()).
+		WithField("duration", time.Since(start).Round(time.Millisecond)).
+		WithField("netlink_pending", host.PendingNetlinkOps())
+
+	c.slow.l.Lock()
+	if time.Since(c.slow.last) < c.slowCall {
+		c.slow.l.Unlock()
+		log.Warn("call is slow, diagnostics were logged recently")
+		return
+	}
+	c.slow.last = time.Now()
+	c.slow.l.Unlock()
+
+	rc := make(chan int, 1)
+	go func() {
+		n, err := host.RouteCount()
+		if err != nil {
+			n = -1
+		}
+		rc <- n
+	}()
+	select {
+	case n := <-rc:
+		log = log.WithField("routes", n)
+	case <-time.After(slowRouteCountTimeout):
+		log = log.WithField("routes", "timed out")
+	}
+
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	log.WithField("goroutines", string(buf)).Warn("call exceeded the slow call threshold")
+}
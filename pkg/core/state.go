@@ -0,0 +1,165 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// State is the network and allocation state of a host, as shared with
+// other hosts joining the cluster
+type State struct {
+	Networks    []types.NetworkResource `json:"networks"`
+	Allocations []string                `json:"allocations"`
+}
+
+// reservations holds addresses learned from a seed host which should not be
+// handed out until they either show up as routes or expire
+type reservations struct {
+	l sync.Mutex
+	m map[string]time.Time
+}
+
+func newReservations() *reservations {
+	return &reservations{m: make(map[string]time.Time)}
+}
+
+func (r *reservations) add(ip net.IP, exp time.Time) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.m[ip.String()] = exp
+}
+
+func (r *reservations) has(ip net.IP) bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+	exp, ok := r.m[ip.String()]
+	if !ok {
+		return false
+	}
+	if time.Now().After(exp) {
+		delete(r.m, ip.String())
+		return false
+	}
+	return true
+}
+
+// countIn returns the number of unexpired reservations within sn
+func (r *reservations) countIn(sn *net.IPNet) int {
+	r.l.Lock()
+	defer r.l.Unlock()
+	n := 0
+	for a, exp := range r.m {
+		if time.Now().Before(exp) && sn.Contains(net.ParseIP(a)) {
+			n++
+		}
+	}
+	return n
+}
+
+// State returns all vxrouter networks known to docker and all host routes
+// (local and learned from other hosts) within their subnets
+func (c *Core) State() (*State, error) {
+	log := log.WithField("func", "State()")
+	log.Debug()
+
+	nrs, err := c.knownNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &State{Networks: []types.NetworkResource{}, Allocations: []string{}}
+	for _, nr := range nrs {
+		s.Networks = append(s.Networks, *nr)
+
+		for _, pool := range poolsFromNR(nr) {
+			var sn *net.IPNet
+			_, sn, err = net.ParseCIDR(pool)
+			if err != nil {
+				continue
+			}
+			var routes []*net.IPNet
+			routes, err = host.HostRoutesIn(sn)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range routes {
+				s.Allocations = append(s.Allocations, r.IP.String())
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// networks returns all networks of this network driver
+func (c *Core) networks() ([]*types.NetworkResource, error) {
+	flts := filters.NewArgs()
+	flts.Add("driver", c.NetworkDriverName())
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	dc, err := c.docker()
+	if err != nil {
+		return nil, err
+	}
+	nl, err := dc.NetworkList(ctx, types.NetworkListOptions{Filters: flts})
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Error("failed to list networks")
+		return nil, err
+	}
+
+	ret := []*types.NetworkResource{}
+	for _, n := range nl {
+		var nr *types.NetworkResource
+		nr, err = c.getNetworkResourceByID(n.ID)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, nr)
+	}
+	return ret, nil
+}
+
+// knownNetworks returns all networks of this network driver, or the cached ones
+// while the docker circuit breaker is open. It is only for callers which do not
+// act on networks missing from the result, as the cache may be incomplete.
+func (c *Core) knownNetworks() ([]*types.NetworkResource, error) {
+	nrs, err := c.networks()
+	if errors.Is(err, vxrerrors.ErrUnavailable) {
+		log.WithError(err).Debug("serving cached networks")
+		return c.listNrInCache(), nil
+	}
+	return nrs, err
+}
+
+// Bootstrap reserves the allocations from a seed host's state for ttl so they
+// are not handed out before the routes to them have propagated to this host.
+// The seed's networks are not cached, the ids of local scope networks differ
+// between hosts, and a cached one could be found by its pool in place of the
+// local network.
+func (c *Core) Bootstrap(s *State, ttl time.Duration) error {
+	log := log.WithField("func", "Bootstrap()")
+	log.WithField("networks", len(s.Networks)).WithField("allocations", len(s.Allocations)).Debug()
+
+	exp := time.Now().Add(ttl)
+	for _, a := range s.Allocations {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			return fmt.Errorf("invalid allocation %v in seed state", a)
+		}
+		c.reserved.add(ip, exp)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestBootstrap(t *testing.T) {
+	c, err := New(DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a local scope network of the seed host, its id is not the one of the local network
+	seedNet := types.NetworkResource{
+		ID:     "seednet",
+		Name:   "net1",
+		Driver: c.NetworkDriverName(),
+		IPAM:   network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.1.2.0/24"}}},
+	}
+	err = c.Bootstrap(&State{Networks: []types.NetworkResource{seedNet}, Allocations: []string{"10.1.2.5"}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nr := c.getNrFromCache("10.1.2.0/24"); nr != nil {
+		t.Errorf("seed network %v is cached", nr.ID)
+	}
+	if nr := c.getNrFromCache(seedNet.ID); nr != nil {
+		t.Errorf("seed network %v is cached", nr.ID)
+	}
+	if !c.reserved.has(net.ParseIP("10.1.2.5")) {
+		t.Error("seed allocation 10.1.2.5 is not reserved")
+	}
+
+	if err = c.Bootstrap(&State{Allocations: []string{"10.1.2"}}, time.Minute); err == nil {
+		t.Error("invalid allocation accepted")
+	}
+}
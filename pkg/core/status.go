@@ -0,0 +1,127 @@
+package core
+
+import (
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+)
+
+// PoolStatus is the address capacity of a network's pool.
+// Address counts are decimal strings, as v6 pools easily exceed 64 bits.
+type PoolStatus struct {
+	Network string `json:"network"`
+	Pool    string `json:"pool"`
+	Family  string `json:"family"`
+	// VxlanID is the vxlan id of the network, derived from its pools if it was created without one
+	VxlanID int `json:"vxlanid"`
+	// Size is the total number of addresses in the pool, 2^SizeBits
+	Size     string `json:"size"`
+	SizeBits int    `json:"size_bits"`
+	// Excluded addresses are never selected, the excluded first and last addresses, the gateway and exclusion ranges
+	Excluded string `json:"excluded"`
+	// Reserved addresses are allocated on a seed host, waiting for their routes to propagate
+	Reserved int `json:"reserved"`
+	// Allocated addresses have a host route, on this or another host
+	Allocated   int     `json:"allocated"`
+	Free        string  `json:"free"`
+	Utilization float64 `json:"utilization"`
+	// Conflicts are network managers managing the host interface of the network, or its underlay
+	Conflicts []string `json:"conflicts,omitempty"`
+	// FrozenSince is when the pool was frozen, no new addresses are allocated from a frozen pool
+	FrozenSince *time.Time `json:"frozen_since,omitempty"`
+}
+
+// Status returns the pool capacity of all networks of this driver, one entry per address family
+func (c *Core) Status() ([]*PoolStatus, error) {
+	log := log.WithField("func", "Status()")
+	log.Debug()
+
+	nrs, err := c.knownNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []*PoolStatus{}
+	for _, nr := range nrs {
+		for _, pool := range poolsFromNR(nr) {
+			var ps *PoolStatus
+			ps, err = c.poolStatus(nr, pool)
+			if err != nil {
+				log.WithField("network", nr.Name).WithField("pool", pool).WithError(err).Debug("skipping pool")
+				continue
+			}
+			ret = append(ret, ps)
+		}
+	}
+	return ret, nil
+}
+
+func (c *Core) poolStatus(nr *types.NetworkResource, pool string) (*PoolStatus, error) {
+	_, sn, err := net.ParseCIDR(pool)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := sn.Mask.Size()
+	ps := &PoolStatus{
+		Network:  nr.Name,
+		Pool:     sn.String(),
+		Family:   "ipv4",
+		SizeBits: bits - ones,
+	}
+	if sn.IP.To4() == nil {
+		ps.Family = "ipv6"
+	}
+
+	size := host.SubnetSize(sn)
+
+	nopts, err := c.netOptions(nr)
+	if err != nil {
+		return nil, err
+	}
+	ps.VxlanID = nopts.Int(options.VxlanID)
+	var gwIP net.IP
+	if gw, err := gatewayIn(nr, sn); err == nil {
+		gwIP = gw.IP
+	}
+	exclude, err := host.ParseRanges(nopts.String(options.Exclude))
+	if err != nil {
+		return nil, err
+	}
+	excluded := host.ExcludedCount(sn, nopts.Int(options.ExcludeFirst), nopts.Int(options.ExcludeLast), gwIP, exclude)
+
+	routes, err := host.HostRoutesIn(sn)
+	if err != nil {
+		return nil, err
+	}
+	ps.Allocated = len(routes)
+	ps.Reserved = c.reserved.countIn(sn)
+
+	usable := new(big.Int).Sub(size, excluded)
+	free := new(big.Int).Sub(usable, big.NewInt(int64(ps.Allocated+ps.Reserved)))
+	if free.Sign() < 0 {
+		free.SetInt64(0)
+	}
+
+	if hi, err := host.GetInterface(nr.Name); err == nil {
+		ps.Conflicts = hi.ManagerConflicts()
+	}
+	if t, ok := c.frozenSince(sn); ok {
+		ps.FrozenSince = &t
+	}
+
+	ps.Size = size.String()
+	ps.Excluded = excluded.String()
+	ps.Free = free.String()
+	if usable.Sign() > 0 {
+		ps.Utilization, _ = new(big.Float).Quo(new(big.Float).SetInt64(int64(ps.Allocated+ps.Reserved)), new(big.Float).SetInt(usable)).Float64()
+	}
+
+	return ps, nil
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+)
+
+// stickyMode returns the sticky option of a network, off if it is not valid
+func (c *Core) stickyMode(nr *types.NetworkResource) string {
+	if c.leases == nil {
+		return "off"
+	}
+	nopts, err := c.netOptions(nr)
+	if err != nil {
+		return "off"
+	}
+	return nopts.String(options.Sticky)
+}
+
+// stickyKey returns the key a container's address is remembered by
+func (c *Core) stickyKey(ctx context.Context, mode, ctrID, name string) string {
+	if mode != "hostname" {
+		return "name:" + strings.TrimPrefix(name, "/")
+	}
+	dc, err := c.docker()
+	if err != nil {
+		return ""
+	}
+	cj, err := dc.ContainerInspect(ctx, ctrID)
+	c.dockerErr(err)
+	if err != nil || cj.Config == nil || cj.Config.Hostname == "" {
+		return ""
+	}
+	return "hostname:" + cj.Config.Hostname
+}
+
+// stickyAddressFor returns the address last held by the container being started
+// on network nr in subnet sn, if it can be identified and the address is free.
+// IPAM requests do not carry the container, so like composeServiceFor this looks
+// for containers on the network still waiting for an address, and only returns
+// an address if exactly one of them has a free one.
+func (c *Core) stickyAddressFor(nr *types.NetworkResource, sn *net.IPNet) net.IP {
+	mode := c.stickyMode(nr)
+	if mode == "off" {
+		return nil
+	}
+	log := log.WithField("func", "stickyAddressFor()").WithField("net_id", nr.ID)
+	log.Debug()
+
+	flts := filters.NewArgs()
+	flts.Add("network", nr.ID)
+	flts.Add("status", "created")
+	flts.Add("status", "restarting")
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	dc, err := c.docker()
+	if err != nil {
+		return nil
+	}
+	ctrs, err := dc.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: flts})
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Warn("failed to list starting containers")
+		return nil
+	}
+
+	var ret net.IP
+	for _, ctr := range ctrs {
+		if ctr.NetworkSettings == nil || len(ctr.Names) == 0 {
+			continue
+		}
+		waiting := false
+		for _, es := range ctr.NetworkSettings.Networks {
+			if es.NetworkID == nr.ID && waitingForAddress(es, sn) {
+				waiting = true
+			}
+		}
+		if !waiting {
+			continue
+		}
+		k := c.stickyKey(ctx, mode, ctr.ID, ctr.Names[0])
+		if k == "" {
+			continue
+		}
+		ip := net.ParseIP(c.leases.Sticky(sn.String(), k))
+		if ip == nil || !sn.Contains(ip) || c.unavailable(ip) {
+			continue
+		}
+		if n, err := host.VxroutesTo(ip); err != nil || n > 0 {
+			continue
+		}
+		if ret != nil {
+			log.Debug("multiple containers with sticky addresses are starting, not reusing")
+			return nil
+		}
+		log.WithField("key", k).WithField("ip", ip).Debug("reusing sticky address")
+		ret = ip
+	}
+
+	return ret
+}
+
+// learnSticky remembers the addresses of running containers on networks with sticky addresses
+func (c *Core) learnSticky() {
+	if c.leases == nil {
+		return
+	}
+	log := log.WithField("func", "learnSticky()")
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	dc, err := c.docker()
+	if err != nil {
+		return
+	}
+	ctrs, err := dc.ContainerList(ctx, types.ContainerListOptions{})
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Warn("failed to list containers")
+		return
+	}
+
+	for _, ctr := range ctrs {
+		if ctr.NetworkSettings == nil || len(ctr.Names) == 0 {
+			continue
+		}
+		for _, es := range ctr.NetworkSettings.Networks {
+			nr, err := c.getNetworkResourceByID(es.NetworkID)
+			if err != nil || nr.Driver != c.NetworkDriverName() {
+				continue
+			}
+			mode := c.stickyMode(nr)
+			if mode == "off" {
+				continue
+			}
+			k := c.stickyKey(ctx, mode, ctr.ID, ctr.Names[0])
+			if k == "" {
+				continue
+			}
+			for _, a := range []string{es.IPAddress, es.GlobalIPv6Address} {
+				ip := net.ParseIP(a)
+				if ip == nil {
+					continue
+				}
+				sn, err := subnetOf(nr, ip)
+				if err != nil {
+					continue
+				}
+				if err = c.leases.SetSticky(sn.String(), k, ip.String()); err != nil {
+					log.WithError(err).Error("failed to store sticky address")
+				}
+			}
+		}
+	}
+}
+
+// LearnSticky remembers the address of the container of an endpoint, if its network has sticky addresses.
+// It is called as the endpoint leaves, while docker still lists it on the network.
+func (c *Core) LearnSticky(netid, endpointid string) {
+	if c.leases == nil {
+		return
+	}
+	log := log.WithField("func", "LearnSticky()").WithField("net_id", netid)
+
+	nr, err := c.getNetworkResourceByID(netid)
+	if err != nil {
+		return
+	}
+	mode := c.stickyMode(nr)
+	if mode == "off" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	dc, err := c.docker()
+	if err != nil {
+		return
+	}
+	// the cached network resource does not list containers
+	nnr, err := dc.NetworkInspect(ctx, netid)
+	c.dockerErr(err)
+	if err != nil {
+		log.WithError(err).Warn("failed to inspect network")
+		return
+	}
+	for ctrID, er := range nnr.Containers {
+		if er.EndpointID != endpointid {
+			continue
+		}
+		k := c.stickyKey(ctx, mode, ctrID, er.Name)
+		if k == "" {
+			return
+		}
+		for _, a := range []string{er.IPv4Address, er.IPv6Address} {
+			ip, sn, err := net.ParseCIDR(a)
+			if err != nil {
+				continue
+			}
+			if err = c.leases.SetSticky(sn.String(), k, ip.String()); err != nil {
+				log.WithError(err).Error("failed to store sticky address")
+			}
+		}
+	}
+}
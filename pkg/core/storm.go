@@ -0,0 +1,114 @@
+package core
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// stormCycles is the number of consecutive short lived allocations of an address
+// after which it is considered to belong to a crash looping container
+const stormCycles = 3
+
+// storm tracks how long addresses live, to detect containers in a restart loop.
+// The addresses of looping containers are parked on release, keeping their
+// route, and handed back on the next request instead of selecting a new one.
+type storm struct {
+	l         sync.Mutex
+	allocated map[string]time.Time
+	cycles    map[string]int
+	parked    map[string]*parkedAddr
+}
+
+type parkedAddr struct {
+	ip    net.IP
+	timer *time.Timer
+}
+
+func newStorm() *storm {
+	return &storm{
+		allocated: make(map[string]time.Time),
+		cycles:    make(map[string]int),
+		parked:    make(map[string]*parkedAddr),
+	}
+}
+
+// allocated records the allocation of ip
+func (c *Core) allocated(ip net.IP) {
+	if c.stormGrace <= 0 {
+		return
+	}
+	c.storm.l.Lock()
+	defer c.storm.l.Unlock()
+	c.storm.allocated[ip.String()] = time.Now()
+}
+
+// park parks ip instead of releasing it if it was allocated less than the grace
+// period ago for too many consecutive allocations. release is called if it is not
+// requested again within the grace period.
+func (c *Core) park(ip net.IP, release func()) bool {
+	if c.stormGrace <= 0 {
+		return false
+	}
+	k := ip.String()
+
+	c.storm.l.Lock()
+	defer c.storm.l.Unlock()
+
+	at, ok := c.storm.allocated[k]
+	delete(c.storm.allocated, k)
+	if !ok || time.Since(at) > c.stormGrace {
+		delete(c.storm.cycles, k)
+		return false
+	}
+	c.storm.cycles[k]++
+	if c.storm.cycles[k] < stormCycles {
+		return false
+	}
+
+	log.WithField("ip", k).WithField("cycles", c.storm.cycles[k]).
+		Warn("address is being allocated and released in a loop, keeping its route for the next request")
+	c.storm.parked[k] = &parkedAddr{
+		ip: ip,
+		timer: time.AfterFunc(c.stormGrace, func() {
+			c.storm.l.Lock()
+			_, still := c.storm.parked[k]
+			delete(c.storm.parked, k)
+			delete(c.storm.cycles, k)
+			c.storm.l.Unlock()
+			if still {
+				release()
+			}
+		}),
+	}
+	return true
+}
+
+// unpark returns a parked address in sn, addr if it is not nil, or nil if there is none
+func (c *Core) unpark(addr net.IP, sn *net.IPNet) net.IP {
+	if c.stormGrace <= 0 {
+		return nil
+	}
+	c.storm.l.Lock()
+	defer c.storm.l.Unlock()
+	for k, p := range c.storm.parked {
+		if !sn.Contains(p.ip) || (addr != nil && !addr.Equal(p.ip)) {
+			continue
+		}
+		p.timer.Stop()
+		delete(c.storm.parked, k)
+		c.storm.allocated[k] = time.Now()
+		return p.ip
+	}
+	return nil
+}
+
+// isParked reports whether ip is parked, its route is intentionally without a container
+func (c *Core) isParked(ip net.IP) bool {
+	c.storm.l.Lock()
+	defer c.storm.l.Unlock()
+	_, ok := c.storm.parked[ip.String()]
+	return ok
+}
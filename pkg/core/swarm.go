@@ -0,0 +1,90 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+)
+
+// DiscoverSwarmPeers sets the flood peers of the vxlans of this host to the addresses of the
+// other ready nodes of the docker swarm. Only managers can list the nodes, on a worker or
+// outside of a swarm the flood peers are left alone.
+func (c *Core) DiscoverSwarmPeers() error {
+	log := log.WithField("func", "DiscoverSwarmPeers()")
+	log.Debug()
+
+	dc, err := c.docker()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	info, err := dc.Info(ctx)
+	c.dockerErr(err)
+	if err != nil {
+		return err
+	}
+	if info.Swarm.LocalNodeState != swarm.LocalNodeStateActive {
+		log.Debug("not in a swarm, not discovering peers")
+		return nil
+	}
+	if !info.Swarm.ControlAvailable {
+		log.Debug("not a swarm manager, nodes can not be listed")
+		return nil
+	}
+
+	nodes, err := dc.NodeList(ctx, types.NodeListOptions{})
+	c.dockerErr(err)
+	if err != nil {
+		return err
+	}
+	peers := []net.IP{}
+	for _, n := range nodes {
+		if n.ID == info.Swarm.NodeID || n.Status.State != swarm.NodeStateReady {
+			continue
+		}
+		ip := net.ParseIP(n.Status.Addr)
+		if ip == nil || ip.IsUnspecified() || n.Status.Addr == info.Swarm.NodeAddr {
+			continue
+		}
+		peers = append(peers, ip)
+	}
+	return host.SetFloodPeers(peers)
+}
+
+// swarmFlags are the swarm fields of a network inspect, Ingress was added after the
+// version of the client types used here
+type swarmFlags struct {
+	Attachable bool
+	Ingress    bool
+}
+
+// SwarmFlags returns whether the network with the id may be attached to by standalone
+// containers, and whether it is the ingress network of the swarm routing mesh. Docker
+// does not pass them to the driver, they are inspected.
+func (c *Core) SwarmFlags(id string) (bool, bool, error) {
+	dc, err := c.docker()
+	if err != nil {
+		return false, false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	_, raw, err := dc.NetworkInspectWithRaw(ctx, id)
+	c.dockerErr(err)
+	if err != nil {
+		return false, false, err
+	}
+	var sf swarmFlags
+	if err = json.Unmarshal(raw, &sf); err != nil {
+		return false, false, err
+	}
+	return sf.Attachable, sf.Ingress, nil
+}
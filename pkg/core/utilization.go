@@ -0,0 +1,97 @@
+package core
+
+import (
+	"math/big"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+)
+
+// PoolUtilization is the address usage of a network's pool, counting both the host
+// routes of the pool and the addresses leased on this host which have no route yet.
+// Address counts which may exceed 64 bits in v6 pools are decimal strings.
+type PoolUtilization struct {
+	Network string `json:"network"`
+	Pool    string `json:"pool"`
+	Total   string `json:"total"`
+	// Allocated addresses have a host route, or are leased on this host
+	Allocated int `json:"allocated"`
+	// Leased addresses are in the lease store of this host
+	Leased      int     `json:"leased"`
+	Excluded    string  `json:"excluded"`
+	PercentFree float64 `json:"percent_free"`
+}
+
+// Utilization returns the address usage of all pools of networks of this driver
+func (c *Core) Utilization() ([]*PoolUtilization, error) {
+	log := log.WithField("func", "Utilization()")
+	log.Debug()
+
+	sts, err := c.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*PoolUtilization, 0, len(sts))
+	for _, ps := range sts {
+		var pu *PoolUtilization
+		pu, err = c.poolUtilization(ps)
+		if err != nil {
+			log.WithField("pool", ps.Pool).WithError(err).Debug("skipping pool")
+			continue
+		}
+		ret = append(ret, pu)
+	}
+	return ret, nil
+}
+
+func (c *Core) poolUtilization(ps *PoolStatus) (*PoolUtilization, error) {
+	_, sn, err := net.ParseCIDR(ps.Pool)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := host.HostRoutesIn(sn)
+	if err != nil {
+		return nil, err
+	}
+	allocated := make(map[string]struct{}, len(routes))
+	for _, r := range routes {
+		allocated[r.IP.String()] = struct{}{}
+	}
+
+	pu := &PoolUtilization{
+		Network:  ps.Network,
+		Pool:     ps.Pool,
+		Total:    ps.Size,
+		Excluded: ps.Excluded,
+	}
+	if c.leases != nil {
+		for _, l := range c.leases.List() {
+			ip := net.ParseIP(l.Address)
+			if ip == nil || !sn.Contains(ip) {
+				continue
+			}
+			pu.Leased++
+			allocated[ip.String()] = struct{}{}
+		}
+	}
+	pu.Allocated = len(allocated)
+
+	size, _ := new(big.Int).SetString(ps.Size, 10)
+	excluded, _ := new(big.Int).SetString(ps.Excluded, 10)
+	if size == nil || excluded == nil {
+		return pu, nil
+	}
+	usable := new(big.Int).Sub(size, excluded)
+	if usable.Sign() <= 0 {
+		return pu, nil
+	}
+	free := new(big.Int).Sub(usable, big.NewInt(int64(pu.Allocated+ps.Reserved)))
+	if free.Sign() < 0 {
+		free.SetInt64(0)
+	}
+	pu.PercentFree, _ = new(big.Float).Quo(new(big.Float).SetInt(new(big.Int).Mul(free, big.NewInt(100))), new(big.Float).SetInt(usable)).Float64()
+	return pu, nil
+}
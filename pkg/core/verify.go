@@ -0,0 +1,201 @@
+package core
+
+import (
+	"net"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+// Categories of the findings of Verify
+const (
+	// FindingMissingRoute is a docker endpoint on this host without a route
+	FindingMissingRoute = "missing_route"
+	// FindingOrphanRoute is a route to an address no docker endpoint uses
+	FindingOrphanRoute = "orphan_route"
+	// FindingOrphanLease is a lease on an address neither used by a docker endpoint nor routed
+	FindingOrphanLease = "orphan_lease"
+	// FindingOrphanInterface is a host interface of a network without docker endpoints on this host
+	FindingOrphanInterface = "orphan_interface"
+)
+
+// verifyGrace is how old a lease must be to be reported, younger ones may be of endpoints being created
+const verifyGrace = time.Minute
+
+// Finding is an inconsistency between the docker endpoints, kernel routes, lease store and host interfaces
+type Finding struct {
+	Category string `json:"category"`
+	Network  string `json:"network,omitempty"`
+	Address  string `json:"address,omitempty"`
+	// Repaired is set once the inconsistency is repaired, Error if repairing it failed
+	Repaired bool   `json:"repaired,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VerifyReport is the findings of a consistency check of a driver instance
+type VerifyReport struct {
+	Driver   string     `json:"driver"`
+	Time     time.Time  `json:"time"`
+	Findings []*Finding `json:"findings"`
+}
+
+// Verify cross-checks the docker endpoints, kernel routes, lease store and host interfaces of the
+// networks of this driver, and repairs the inconsistencies found if repair is set. Nothing is repaired
+// if docker endpoints change during the check, as they may be racing it, an error is returned instead.
+func (c *Core) Verify(repair bool) (*VerifyReport, error) {
+	log := log.WithField("func", "Verify()").WithField("repair", repair)
+	log.Debug()
+
+	es, err := c.getContainerIPsAndSubnets()
+	if err != nil {
+		return nil, err
+	}
+	nrs, err := c.networks()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*types.NetworkResource, len(nrs))
+	byName := make(map[string]bool, len(nrs))
+	for _, nr := range nrs {
+		byID[nr.ID] = nr
+		byName[nr.Name] = true
+	}
+
+	rep := &VerifyReport{Driver: c.NetworkDriverName(), Time: time.Now(), Findings: []*Finding{}}
+	add := func(cat, network, addr string) {
+		rep.Findings = append(rep.Findings, &Finding{Category: cat, Network: network, Address: addr})
+	}
+
+	used := make(map[string]bool)
+	for ip, netid := range es {
+		nr, ok := byID[netid]
+		if !ok {
+			continue
+		}
+		used[netid] = true
+		if n, err := host.VxroutesTo(net.ParseIP(ip)); err == nil && n == 0 {
+			add(FindingMissingRoute, nr.Name, ip)
+		}
+	}
+
+	routes, err := host.AllVxRoutes()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		if ones, bits := r.Mask.Size(); ones != bits {
+			continue
+		}
+		if _, ok := es[r.IP.String()]; ok || c.isParked(r.IP) {
+			continue
+		}
+		hi, err := host.GetInterfaceFromDestinationAddress(r.IP)
+		if err != nil || !byName[hi.Name()] {
+			continue
+		}
+		add(FindingOrphanRoute, hi.Name(), r.IP.String())
+	}
+
+	if c.leases != nil {
+		for _, l := range c.leases.List() {
+			if time.Since(l.Created) < verifyGrace {
+				continue
+			}
+			if _, ok := es[l.Address]; ok {
+				continue
+			}
+			nr, err := c.getNetworkResourceByPool(l.Pool)
+			if err != nil || nr == nil || nr.Driver != c.NetworkDriverName() {
+				continue
+			}
+			ip := net.ParseIP(l.Address)
+			if ip == nil {
+				add(FindingOrphanLease, nr.Name, l.Address)
+				continue
+			}
+			if n, err := host.VxroutesTo(ip); err == nil && n == 0 {
+				add(FindingOrphanLease, nr.Name, l.Address)
+			}
+		}
+	}
+
+	for _, nr := range nrs {
+		if used[nr.ID] {
+			continue
+		}
+		if _, err := host.GetInterface(nr.Name); err == nil {
+			add(FindingOrphanInterface, nr.Name, "")
+		}
+	}
+
+	if !repair || len(rep.Findings) == 0 {
+		return rep, nil
+	}
+
+	es2, err := c.getContainerIPsAndSubnets()
+	if err != nil {
+		return rep, err
+	}
+	if !ipListsEqual(es, es2) {
+		return rep, vxrerrors.Conflict("docker endpoints changed during the check, not repairing")
+	}
+
+	// routes are repaired before interfaces, which are only deleted once nothing routes through them
+	for _, cat := range []string{FindingMissingRoute, FindingOrphanRoute, FindingOrphanLease, FindingOrphanInterface} {
+		for _, f := range rep.Findings {
+			if f.Category != cat {
+				continue
+			}
+			err = c.repair(f, es)
+			if err != nil {
+				log.WithField("category", f.Category).WithField("network", f.Network).WithField("address", f.Address).
+					WithError(err).Error("failed to repair")
+				f.Error = err.Error()
+				continue
+			}
+			f.Repaired = true
+		}
+	}
+	return rep, nil
+}
+
+// repair repairs a finding of Verify, es are the docker endpoints by address
+func (c *Core) repair(f *Finding, es map[string]string) error {
+	ip := net.ParseIP(f.Address)
+	switch f.Category {
+	case FindingMissingRoute:
+		_, err := c.connectIfNotConnected(f.Address, es[f.Address])
+		return err
+	case FindingOrphanRoute:
+		hi, err := c.deleteRoute(ip)
+		if err != nil {
+			return err
+		}
+		if ip.To4() == nil {
+			if err = hi.DelDelegatedPrefixes(ip); err != nil {
+				log.WithField("address", f.Address).WithError(err).Warn("failed to delete prefixes delegated to orphaned route")
+			}
+		}
+		c.unlease(ip)
+	case FindingOrphanLease:
+		if ip == nil {
+			return c.leases.Delete(f.Address)
+		}
+		c.unlease(ip)
+	case FindingOrphanInterface:
+		hi, err := host.GetInterface(f.Network)
+		if err != nil {
+			return err
+		}
+		if err = hi.ReleaseGateways(); err != nil {
+			return err
+		}
+		// Delete leaves the interface alone if anything still routes through it
+		return hi.Delete()
+	}
+	return nil
+}
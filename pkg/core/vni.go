@@ -0,0 +1,39 @@
+package core
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+)
+
+// DeriveVNI returns the vxlan id of a network created without one, a hash of its pools
+// into the vxlan id space, so all hosts agree on it without configuration. It is never 0.
+func DeriveVNI(pools []string) int {
+	sns := make([]string, 0, len(pools))
+	for _, p := range pools {
+		if _, sn, err := net.ParseCIDR(p); err == nil {
+			sns = append(sns, sn.String())
+		}
+	}
+	sort.Strings(sns)
+	h := fnv.New32a()
+	h.Write([]byte(strings.Join(sns, ","))) // nolint: errcheck
+	return int(h.Sum32()%vxlan.MaxVxlanID) + 1
+}
+
+// deriveVNI sets the vxlanid option of a network without one to that derived from its pools
+func deriveVNI(nopts options.Options, nr *types.NetworkResource) {
+	if nopts.String(options.VxlanID) != "" {
+		return
+	}
+	if pools := poolsFromNR(nr); len(pools) > 0 {
+		nopts[options.VxlanID] = strconv.Itoa(DeriveVNI(pools))
+	}
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+)
+
+func TestDeriveVNI(t *testing.T) {
+	base := DeriveVNI([]string{"10.1.2.0/24", "fd00:1::/64"})
+	tests := []struct {
+		name  string
+		pools []string
+		same  bool
+	}{
+		{"same pools", []string{"10.1.2.0/24", "fd00:1::/64"}, true},
+		{"other order", []string{"fd00:1::/64", "10.1.2.0/24"}, true},
+		{"host bits set", []string{"10.1.2.7/24", "fd00:1::1/64"}, true},
+		{"invalid pool ignored", []string{"10.1.2.0/24", "bogus", "fd00:1::/64"}, true},
+		{"ipv4 only", []string{"10.1.2.0/24"}, false},
+		{"ipv6 only", []string{"fd00:1::/64"}, false},
+		{"other prefix length", []string{"10.1.2.0/25", "fd00:1::/64"}, false},
+		{"other subnet", []string{"10.1.3.0/24", "fd00:1::/64"}, false},
+	}
+	for _, tt := range tests {
+		if got := DeriveVNI(tt.pools); (got == base) != tt.same {
+			t.Errorf("%v: vxlanid %v, of %v %v, want the same %v", tt.name, got, tt.pools, base, tt.same)
+		}
+	}
+}
+
+func TestDeriveVNIRange(t *testing.T) {
+	// every /24 of a /12, within the vxlan id space and hashed apart
+	seen := make(map[int]string)
+	collisions := 0
+	for i := 0; i < 4096; i++ {
+		p := fmt.Sprintf("10.%v.%v.0/24", 16+i>>8, i&0xff)
+		vni := DeriveVNI([]string{p})
+		if vni < 1 || vni > vxlan.MaxVxlanID {
+			t.Fatalf("vxlanid %v of %v is outside 1-%v", vni, p, vxlan.MaxVxlanID)
+		}
+		if o, ok := seen[vni]; ok {
+			t.Logf("%v and %v derive vxlanid %v", o, p, vni)
+			collisions++
+		}
+		seen[vni] = p
+	}
+	if collisions > 0 {
+		t.Errorf("%v collisions", collisions)
+	}
+
+	if vni := DeriveVNI(nil); vni < 1 || vni > vxlan.MaxVxlanID {
+		t.Errorf("vxlanid %v without pools is outside 1-%v", vni, vxlan.MaxVxlanID)
+	}
+}
+
+func TestDeriveVNIOption(t *testing.T) {
+	nr := &types.NetworkResource{IPAM: network.IPAM{Config: []network.IPAMConfig{{Subnet: "10.1.2.0/24"}}}}
+	want := fmt.Sprint(DeriveVNI([]string{"10.1.2.0/24"}))
+	tests := []struct {
+		name string
+		opts options.Options
+		nr   *types.NetworkResource
+		want string
+	}{
+		{"derived", options.Options{}, nr, want},
+		{"set", options.Options{options.VxlanID: "42"}, nr, "42"},
+		{"no pools", options.Options{}, &types.NetworkResource{}, ""},
+	}
+	for _, tt := range tests {
+		deriveVNI(tt.opts, tt.nr)
+		if got := tt.opts[options.VxlanID]; got != tt.want {
+			t.Errorf("%v: vxlanid %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Package frr advertises the container routes of this host over BGP through FRRouting, so
+// the physical network learns container reachability. Each route is added to, or removed
+// from, the networks of the bgp router of FRR with vtysh. Routes are only advertised while
+// they are in the RIB, FRR must run in the namespace of the container routes.
+package frr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// queueLen is how many changes may wait to be applied before new ones are dropped
+	queueLen = 256
+	// vtyshTimeout is how long a vtysh call may take
+	vtyshTimeout = 10 * time.Second
+	// maxASN is the largest 4 byte as number
+	maxASN = 1<<32 - 1
+)
+
+// FRR advertises routes through the bgp router of FRR. Changes are queued and applied in
+// order in the background.
+type FRR struct {
+	vtysh string
+	asn   int64
+	q     chan *change
+	done  chan struct{}
+	log   *log.Entry
+}
+
+// change advertises or withdraws a route
+type change struct {
+	export bool
+	dst    *net.IPNet
+}
+
+// New advertises routes with the vtysh binary, in the bgp router of FRR with the as number asn
+func New(vtysh string, asn int64) (*FRR, error) {
+	if err := Check(vtysh, asn); err != nil {
+		return nil, err
+	}
+	path, err := exec.LookPath(vtysh)
+	if err != nil {
+		return nil, err
+	}
+	f := &FRR{
+		vtysh: path,
+		asn:   asn,
+		q:     make(chan *change, queueLen),
+		done:  make(chan struct{}),
+		log:   log.WithField("frr", asn),
+	}
+	go f.run()
+	return f, nil
+}
+
+// Check checks the as number and that the vtysh binary is found
+func Check(vtysh string, asn int64) error {
+	if asn < 1 || asn > maxASN {
+		return fmt.Errorf("invalid as number %v", asn)
+	}
+	_, err := exec.LookPath(vtysh)
+	return err
+}
+
+// Export queues the advertisement of dst. FRR finds the link in the RIB, dev is not used.
+func (f *FRR) Export(dst *net.IPNet, dev string) {
+	f.enqueue(&change{export: true, dst: dst})
+}
+
+// Withdraw queues the withdrawal of dst
+func (f *FRR) Withdraw(dst *net.IPNet) {
+	f.enqueue(&change{dst: dst})
+}
+
+// Close stops advertising routes, dropping queued changes. Advertised routes are withdrawn
+// by FRR as the kernel routes go away.
+func (f *FRR) Close() {
+	if f == nil {
+		return
+	}
+	close(f.done)
+}
+
+func (f *FRR) enqueue(c *change) {
+	if f == nil {
+		return
+	}
+	select {
+	case f.q <- c:
+	default:
+		f.log.WithField("dst", c.dst).Warn("frr queue is full, dropping route change")
+	}
+}
+
+func (f *FRR) run() {
+	for {
+		select {
+		case <-f.done:
+			return
+		case c := <-f.q:
+			log := f.log.WithField("dst", c.dst).WithField("export", c.export)
+			if err := f.apply(c); err != nil {
+				log.WithError(err).Error("failed to program frr")
+				continue
+			}
+			log.Debug("programmed frr")
+		}
+	}
+}
+
+// apply adds or removes the network statement of the route in the address family of its destination
+func (f *FRR) apply(c *change) error {
+	af := "ipv4 unicast"
+	if c.dst.IP.To4() == nil {
+		af = "ipv6 unicast"
+	}
+	stmt := "network " + c.dst.String()
+	if !c.export {
+		stmt = "no " + stmt
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vtyshTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, f.vtysh,
+		"-c", "configure terminal",
+		"-c", fmt.Sprintf("router bgp %v", f.asn),
+		"-c", "address-family "+af,
+		"-c", stmt,
+	).CombinedOutput()
+	out = bytes.TrimSpace(out)
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	// vtysh exits successfully on rejected commands, and only prints output for those
+	if len(out) > 0 {
+		return fmt.Errorf("%s", out)
+	}
+	return nil
+}
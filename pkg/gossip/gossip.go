@@ -0,0 +1,405 @@
+// Package gossip lets the hosts running the plugin discover each other without multicast or
+// swarm. Each host heartbeats the tunnel endpoint of each vxlan id it serves, and the members
+// it knows, over udp to every member it knows, so a new host only needs to reach one of them.
+// The vxlans of each host then flood unknown and broadcast traffic to the hosts serving their
+// vxlan id. Hosts also report the host routes they learned recently, so the time routes
+// take to propagate can be measured.
+package gossip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+)
+
+const (
+	// DefaultPort is the udp port gossip is exchanged on
+	DefaultPort = 7947
+	// deadAfter is how many intervals a member may be silent before it is dropped
+	deadAfter = 3
+	// maxPacket is the largest message, the whole state of a host must fit in one datagram
+	maxPacket = 65507
+	// routeWindow is how long a learned host route is reported in heartbeats
+	routeWindow = time.Minute
+	// maxRoutes is how many learned host routes are reported at most, the latest ones
+	maxRoutes = 1024
+)
+
+// Options configures gossip
+type Options struct {
+	// Bind is the udp address listened on, as host:port
+	Bind string
+	// Advertise is the address other hosts reach this one on, Bind if empty
+	Advertise string
+	// Join are the addresses of members to contact first, kept even while they do not answer
+	Join []string
+	// Key, if set, authenticates messages with a hmac, the same key must be set on all hosts
+	Key string
+	// Interval is how often the state of this host is sent to the members
+	Interval time.Duration
+}
+
+// Member is another host running the plugin
+type Member struct {
+	Host string `json:"host"`
+	Addr string `json:"addr"`
+	// VNIs are the tunnel endpoints of the vxlan ids the host serves
+	VNIs map[int]string `json:"vnis"`
+	Seen time.Time      `json:"seen"`
+}
+
+// message is the heartbeat of a host
+type message struct {
+	Host    string         `json:"host"`
+	Addr    string         `json:"addr"`
+	VNIs    map[int]string `json:"vnis"`
+	Members []string       `json:"members"`
+	// Routes are the host routes the host learned within routeWindow, with when, in unix nanoseconds
+	Routes map[string]int64 `json:"routes,omitempty"`
+}
+
+// Gossip exchanges the state of this host with the other members
+type Gossip struct {
+	opts     Options
+	hostname string
+	conn     *net.UDPConn
+	l        sync.Mutex
+	members  map[string]*Member
+	// known are the addresses heartbeats are sent to, with when they were learned or last heard from
+	known map[string]time.Time
+	seeds map[string]bool
+	// routes are the host routes learned by this host, with when
+	routes  map[string]time.Time
+	onRoute func(host, dst string, learned time.Time)
+	done    chan struct{}
+	log     *log.Entry
+}
+
+// New listens for gossip and starts heartbeating the state of this host
+func New(opts Options) (*Gossip, error) {
+	if err := CheckOptions(opts); err != nil {
+		return nil, err
+	}
+	if opts.Advertise == "" {
+		opts.Advertise = opts.Bind
+	}
+	hn, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	ua, err := net.ResolveUDPAddr("udp", opts.Bind)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", ua)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gossip{
+		opts:     opts,
+		hostname: hn,
+		conn:     conn,
+		members:  make(map[string]*Member),
+		known:    make(map[string]time.Time),
+		seeds:    make(map[string]bool),
+		routes:   make(map[string]time.Time),
+		done:     make(chan struct{}),
+		log:      log.WithField("gossip", opts.Advertise),
+	}
+	for _, j := range opts.Join {
+		if j != opts.Advertise {
+			g.seeds[j] = true
+			g.known[j] = time.Now()
+		}
+	}
+	go g.receive()
+	go g.heartbeat()
+	go func() {
+		if err := host.WatchLearnedRoutes(g.learned, g.done); err != nil {
+			g.log.WithError(err).Error("stopped reporting learned routes")
+		}
+	}()
+	return g, nil
+}
+
+// CheckOptions checks the addresses of gossip options
+func CheckOptions(opts Options) error {
+	if _, _, err := net.SplitHostPort(opts.Bind); err != nil {
+		return fmt.Errorf("invalid gossip bind address %q: %v", opts.Bind, err)
+	}
+	if opts.Advertise == "" {
+		if h, _, _ := net.SplitHostPort(opts.Bind); h == "" || net.ParseIP(h).IsUnspecified() { // nolint: errcheck
+			return fmt.Errorf("gossip bind address %q has no host, set an advertise address", opts.Bind)
+		}
+	} else if _, _, err := net.SplitHostPort(opts.Advertise); err != nil {
+		return fmt.Errorf("invalid gossip advertise address %q: %v", opts.Advertise, err)
+	}
+	for _, j := range opts.Join {
+		if _, _, err := net.SplitHostPort(j); err != nil {
+			return fmt.Errorf("invalid gossip join address %q: %v", j, err)
+		}
+	}
+	if opts.Interval <= 0 {
+		return fmt.Errorf("gossip interval must be positive")
+	}
+	return nil
+}
+
+// Members returns the live members
+func (g *Gossip) Members() []Member {
+	if g == nil {
+		return nil
+	}
+	g.l.Lock()
+	defer g.l.Unlock()
+	ret := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		ret = append(ret, *m)
+	}
+	return ret
+}
+
+// OnRoute sets f to be called with the host routes the members report they learned, and
+// when, by their clocks, for every heartbeat within which they are reported
+func (g *Gossip) OnRoute(f func(host, dst string, learned time.Time)) {
+	if g == nil {
+		return
+	}
+	g.l.Lock()
+	defer g.l.Unlock()
+	g.onRoute = f
+}
+
+// learned records a host route learned by this host, to report it
+func (g *Gossip) learned(dst *net.IPNet) {
+	g.l.Lock()
+	defer g.l.Unlock()
+	g.routes[dst.String()] = time.Now()
+}
+
+// recentRoutes returns the latest maxRoutes host routes learned within routeWindow,
+// dropping older ones. Caller must hold g.l.
+func (g *Gossip) recentRoutes() map[string]int64 {
+	type learned struct {
+		dst string
+		t   time.Time
+	}
+	ls := make([]learned, 0, len(g.routes))
+	for dst, t := range g.routes {
+		if time.Since(t) > routeWindow {
+			delete(g.routes, dst)
+			continue
+		}
+		ls = append(ls, learned{dst, t})
+	}
+	sort.Slice(ls, func(i, j int) bool { return ls[i].t.After(ls[j].t) })
+	if len(ls) > maxRoutes {
+		ls = ls[:maxRoutes]
+	}
+	ret := make(map[string]int64, len(ls))
+	for _, l := range ls {
+		ret[l.dst] = l.t.UnixNano()
+	}
+	return ret
+}
+
+// Close stops gossiping. The flood entries of the vxlans are left as they are.
+func (g *Gossip) Close() {
+	if g == nil {
+		return
+	}
+	close(g.done)
+	g.conn.Close() // nolint: errcheck
+}
+
+func (g *Gossip) heartbeat() {
+	t := time.NewTicker(g.opts.Interval)
+	defer t.Stop()
+	for {
+		g.expire()
+		g.send()
+		g.program()
+		select {
+		case <-g.done:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// send sends the state of this host to every known address
+func (g *Gossip) send() {
+	vteps, err := host.LocalVTEPs()
+	if err != nil {
+		g.log.WithError(err).Error("failed to get local tunnel endpoints")
+		return
+	}
+	m := &message{Host: g.hostname, Addr: g.opts.Advertise, VNIs: make(map[int]string, len(vteps)), Members: []string{}}
+	for vni, ip := range vteps {
+		m.VNIs[vni] = ip.String()
+	}
+
+	g.l.Lock()
+	for _, mb := range g.members {
+		m.Members = append(m.Members, mb.Addr)
+	}
+	m.Routes = g.recentRoutes()
+	addrs := make([]string, 0, len(g.known))
+	for a := range g.known {
+		addrs = append(addrs, a)
+	}
+	g.l.Unlock()
+
+	d, err := json.Marshal(m)
+	if err != nil {
+		g.log.WithError(err).Error("failed to encode gossip")
+		return
+	}
+	d = g.sign(d)
+	if len(d) > maxPacket {
+		g.log.WithField("size", len(d)).Error("gossip state does not fit in a datagram, not sending")
+		return
+	}
+	for _, a := range addrs {
+		ua, err := net.ResolveUDPAddr("udp", a)
+		if err != nil {
+			g.log.WithField("member", a).WithError(err).Debug("failed to resolve member")
+			continue
+		}
+		if _, err = g.conn.WriteToUDP(d, ua); err != nil {
+			g.log.WithField("member", a).WithError(err).Debug("failed to send gossip")
+		}
+	}
+}
+
+func (g *Gossip) receive() {
+	buf := make([]byte, maxPacket)
+	for {
+		n, from, err := g.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-g.done:
+				return
+			default:
+			}
+			g.log.WithError(err).Error("failed to read gossip")
+			time.Sleep(g.opts.Interval)
+			continue
+		}
+		d, ok := g.verify(buf[:n])
+		if !ok {
+			g.log.WithField("from", from).Warn("dropping unauthenticated gossip")
+			continue
+		}
+		m := &message{}
+		if err = json.Unmarshal(d, m); err != nil {
+			g.log.WithField("from", from).WithError(err).Warn("dropping invalid gossip")
+			continue
+		}
+		if m.Host == g.hostname || m.Addr == "" {
+			continue
+		}
+		g.merge(m)
+		g.report(m)
+	}
+}
+
+// merge records the state of a member, and learns the members it knows
+func (g *Gossip) merge(m *message) {
+	now := time.Now()
+	g.l.Lock()
+	defer g.l.Unlock()
+	if _, ok := g.members[m.Host]; !ok {
+		g.log.WithField("host", m.Host).WithField("addr", m.Addr).Info("member joined")
+	}
+	g.members[m.Host] = &Member{Host: m.Host, Addr: m.Addr, VNIs: m.VNIs, Seen: now}
+	g.known[m.Addr] = now
+	for _, a := range m.Members {
+		// learned addresses are only refreshed by hearing from them, so dead ones expire
+		if _, ok := g.known[a]; !ok && a != g.opts.Advertise {
+			g.known[a] = now
+		}
+	}
+}
+
+// report passes the host routes a member learned to the route callback, if there is one
+func (g *Gossip) report(m *message) {
+	g.l.Lock()
+	f := g.onRoute
+	g.l.Unlock()
+	if f == nil {
+		return
+	}
+	for dst, t := range m.Routes {
+		f(m.Host, dst, time.Unix(0, t))
+	}
+}
+
+// expire drops the members and addresses not heard from for deadAfter intervals
+func (g *Gossip) expire() {
+	dead := time.Duration(deadAfter) * g.opts.Interval
+	g.l.Lock()
+	defer g.l.Unlock()
+	for h, m := range g.members {
+		if time.Since(m.Seen) > dead {
+			g.log.WithField("host", h).WithField("addr", m.Addr).Info("member left")
+			delete(g.members, h)
+		}
+	}
+	for a, t := range g.known {
+		if !g.seeds[a] && time.Since(t) > dead {
+			delete(g.known, a)
+		}
+	}
+}
+
+// program floods the vxlans of this host to the members serving their vxlan id
+func (g *Gossip) program() {
+	byVNI := make(map[int][]net.IP)
+	g.l.Lock()
+	for _, m := range g.members {
+		for vni, vtep := range m.VNIs {
+			if ip := net.ParseIP(vtep); ip != nil {
+				byVNI[vni] = append(byVNI[vni], ip)
+			}
+		}
+	}
+	g.l.Unlock()
+	if err := host.SetVNIFloodPeers(byVNI); err != nil {
+		g.log.WithError(err).Error("failed to update flood peers")
+	}
+}
+
+// sign prefixes d with its hmac, if there is a key
+func (g *Gossip) sign(d []byte) []byte {
+	if g.opts.Key == "" {
+		return d
+	}
+	mac := hmac.New(sha256.New, []byte(g.opts.Key))
+	mac.Write(d) // nolint: errcheck
+	return append(mac.Sum(nil), d...)
+}
+
+// verify checks and strips the hmac of d, if there is a key
+func (g *Gossip) verify(d []byte) ([]byte, bool) {
+	if g.opts.Key == "" {
+		return d, true
+	}
+	if len(d) < sha256.Size {
+		return nil, false
+	}
+	mac := hmac.New(sha256.New, []byte(g.opts.Key))
+	mac.Write(d[sha256.Size:]) // nolint: errcheck
+	return d[sha256.Size:], hmac.Equal(mac.Sum(nil), d[:sha256.Size])
+}
@@ -0,0 +1,212 @@
+// Package hwvtep programs the endpoints of this host into a hardware VTEP through its
+// OVSDB hardware_vtep database, so bare-metal servers behind a switch VTEP share the
+// vxlan ids of the containers. Each endpoint is bound as a remote MAC of the logical
+// switch with its vxlan id, located at the tunnel endpoint of this host.
+package hwvtep
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+)
+
+// queueLen is how many bindings may wait to be programmed before new ones are dropped
+const queueLen = 256
+
+// encapsulation is the only encapsulation of the hardware_vtep schema
+const encapsulation = "vxlan_over_ipv4"
+
+// VTEP programs a hardware VTEP. Bindings are queued and programmed in order in the background.
+type VTEP struct {
+	network, addr string
+	q             chan *change
+	done          chan struct{}
+	log           *log.Entry
+}
+
+// change binds or unbinds entries
+type change struct {
+	bind    bool
+	entries []neigh.Entry
+}
+
+// New programs the hardware VTEP with its OVSDB server at rawurl, as tcp:host:port or unix:path
+func New(rawurl string) (*VTEP, error) {
+	network, addr, err := ParseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	v := &VTEP{
+		network: network,
+		addr:    addr,
+		q:       make(chan *change, queueLen),
+		done:    make(chan struct{}),
+		log:     log.WithField("hwvtep", rawurl),
+	}
+	go v.run()
+	return v, nil
+}
+
+// ParseURL parses and checks the ovsdb url of a hardware VTEP into a network and address to dial
+func ParseURL(rawurl string) (string, string, error) {
+	kv := strings.SplitN(rawurl, ":", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return "", "", fmt.Errorf("invalid hardware vtep %q, must be tcp:host:port or unix:path", rawurl)
+	}
+	switch kv[0] {
+	case "tcp":
+		if _, _, err := net.SplitHostPort(kv[1]); err != nil {
+			return "", "", fmt.Errorf("invalid hardware vtep %q: %v", rawurl, err)
+		}
+	case "unix":
+	case "ssl":
+		return "", "", fmt.Errorf("ssl is not supported for the hardware vtep, only tcp or unix")
+	default:
+		return "", "", fmt.Errorf("unknown hardware vtep method %q, must be tcp or unix", kv[0])
+	}
+	return kv[0], kv[1], nil
+}
+
+// Bind queues the binding of local endpoints to this host in the hardware VTEP
+func (v *VTEP) Bind(es []neigh.Entry) {
+	v.enqueue(&change{bind: true, entries: es})
+}
+
+// Unbind queues the removal of the bindings of local endpoints, if they are still to this host
+func (v *VTEP) Unbind(es []neigh.Entry) {
+	v.enqueue(&change{entries: es})
+}
+
+// Close stops programming the hardware VTEP, dropping queued bindings
+func (v *VTEP) Close() {
+	if v == nil {
+		return
+	}
+	close(v.done)
+}
+
+func (v *VTEP) enqueue(c *change) {
+	if v == nil || len(c.entries) == 0 {
+		return
+	}
+	select {
+	case v.q <- c:
+	default:
+		v.log.WithField("entries", len(c.entries)).Warn("hardware vtep queue is full, dropping bindings")
+	}
+}
+
+func (v *VTEP) run() {
+	for {
+		select {
+		case <-v.done:
+			return
+		case c := <-v.q:
+			for _, e := range c.entries {
+				log := v.log.WithField("mac", e.MAC).WithField("vni", e.VNI).WithField("vtep", e.VTEP)
+				if ip := net.ParseIP(e.VTEP); ip == nil || ip.To4() == nil {
+					log.Debug("hardware vtep only supports ipv4 tunnel endpoints, skipping")
+					continue
+				}
+				var err error
+				if c.bind {
+					err = v.bind(e)
+				} else {
+					err = v.unbind(e)
+				}
+				if err != nil {
+					log.WithField("bind", c.bind).WithError(err).Error("failed to program hardware vtep")
+					continue
+				}
+				log.WithField("bind", c.bind).Debug("programmed hardware vtep")
+			}
+		}
+	}
+}
+
+// lookup returns the uuids of the logical switch of vni and of the locator of vtep, empty if they do not exist
+func (v *VTEP) lookup(vni int, vtep string) (string, string, error) {
+	res, err := v.transact(
+		op{"op": "select", "table": "Logical_Switch", "where": [][]interface{}{{"tunnel_key", "==", vni}}, "columns": []string{"_uuid"}},
+		op{"op": "select", "table": "Physical_Locator", "where": [][]interface{}{
+			{"dst_ip", "==", vtep}, {"encapsulation_type", "==", encapsulation},
+		}, "columns": []string{"_uuid"}},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	if len(res) != 2 {
+		return "", "", fmt.Errorf("unexpected ovsdb result")
+	}
+	var ls, pl string
+	if len(res[0].Rows) > 0 {
+		if ls, err = rowUUID(res[0].Rows[0]); err != nil {
+			return "", "", err
+		}
+	}
+	if len(res[1].Rows) > 0 {
+		if pl, err = rowUUID(res[1].Rows[0]); err != nil {
+			return "", "", err
+		}
+	}
+	return ls, pl, nil
+}
+
+// bind points the MAC of e at the tunnel endpoint of e, in the logical switch of its vni,
+// creating the switch and the locator if they do not exist
+func (v *VTEP) bind(e neigh.Entry) error {
+	ls, pl, err := v.lookup(e.VNI, e.VTEP)
+	if err != nil {
+		return err
+	}
+
+	ops := []op{}
+	var lsRef, plRef []string
+	if ls == "" {
+		ops = append(ops, op{"op": "insert", "table": "Logical_Switch", "uuid-name": "ls", "row": map[string]interface{}{
+			"name": fmt.Sprintf("vxr%v", e.VNI), "tunnel_key": e.VNI,
+		}})
+		lsRef = namedUUID("ls")
+	} else {
+		lsRef = uuid(ls)
+		// a MAC is bound to a single locator per switch
+		ops = append(ops, op{"op": "delete", "table": "Ucast_Macs_Remote", "where": [][]interface{}{
+			{"MAC", "==", e.MAC}, {"logical_switch", "==", lsRef},
+		}})
+	}
+	if pl == "" {
+		ops = append(ops, op{"op": "insert", "table": "Physical_Locator", "uuid-name": "pl", "row": map[string]interface{}{
+			"dst_ip": e.VTEP, "encapsulation_type": encapsulation,
+		}})
+		plRef = namedUUID("pl")
+	} else {
+		plRef = uuid(pl)
+	}
+	ops = append(ops, op{"op": "insert", "table": "Ucast_Macs_Remote", "row": map[string]interface{}{
+		"MAC": e.MAC, "ipaddr": e.IP, "logical_switch": lsRef, "locator": plRef,
+	}})
+
+	_, err = v.transact(ops...)
+	return err
+}
+
+// unbind removes the binding of the MAC of e, if it is still to the tunnel endpoint of e.
+// The locator is garbage collected by the database once unreferenced, the logical switch is kept.
+func (v *VTEP) unbind(e neigh.Entry) error {
+	ls, pl, err := v.lookup(e.VNI, e.VTEP)
+	if err != nil || ls == "" || pl == "" {
+		return err
+	}
+	_, err = v.transact(op{"op": "delete", "table": "Ucast_Macs_Remote", "where": [][]interface{}{
+		{"MAC", "==", e.MAC}, {"logical_switch", "==", uuid(ls)}, {"locator", "==", uuid(pl)},
+	}})
+	return err
+}
+
+func (v *VTEP) transact(ops ...op) ([]opResult, error) {
+	return transact(v.network, v.addr, ops...)
+}
@@ -0,0 +1,116 @@
+package hwvtep
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// database is the name of the hardware_vtep schema database
+const database = "hardware_vtep"
+
+// rpcTimeout is how long a transaction may take, including connecting
+const rpcTimeout = 10 * time.Second
+
+// op is an ovsdb operation, rfc 7047 section 5.2
+type op map[string]interface{}
+
+// opResult is the result of an operation, rows of a select, or an error
+type opResult struct {
+	Rows    []map[string]json.RawMessage `json:"rows"`
+	Error   string                       `json:"error"`
+	Details string                       `json:"details"`
+}
+
+type rpcRequest struct {
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+	ID     interface{}   `json:"id"`
+}
+
+type rpcResponse struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  interface{}     `json:"error"`
+	ID     json.RawMessage `json:"id"`
+}
+
+// uuid is a reference to a row by its uuid
+func uuid(id string) []string {
+	return []string{"uuid", id}
+}
+
+// namedUUID is a reference to a row inserted earlier in the transaction, by its uuid-name
+func namedUUID(name string) []string {
+	return []string{"named-uuid", name}
+}
+
+// rowUUID returns the uuid of a selected row
+func rowUUID(row map[string]json.RawMessage) (string, error) {
+	var u []string
+	err := json.Unmarshal(row["_uuid"], &u)
+	if err != nil || len(u) != 2 || u[0] != "uuid" {
+		return "", fmt.Errorf("invalid _uuid %s", row["_uuid"])
+	}
+	return u[1], nil
+}
+
+// transact runs ops as one transaction on the database at network, addr
+func transact(network, addr string, ops ...op) ([]opResult, error) {
+	conn, err := net.DialTimeout(network, addr, rpcTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close() // nolint: errcheck
+	err = conn.SetDeadline(time.Now().Add(rpcTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	params := []interface{}{database}
+	for _, o := range ops {
+		params = append(params, o)
+	}
+	enc := json.NewEncoder(conn)
+	err = enc.Encode(&rpcRequest{Method: "transact", Params: params, ID: 0})
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(conn)
+	for {
+		var resp rpcResponse
+		err = dec.Decode(&resp)
+		if err != nil {
+			return nil, err
+		}
+		// the server may probe the connection before answering
+		if resp.Method == "echo" {
+			err = enc.Encode(map[string]interface{}{"result": resp.Params, "error": nil, "id": resp.ID})
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.Method != "" {
+			continue
+		}
+		if resp.Error != nil {
+			return nil, fmt.Errorf("ovsdb error: %v", resp.Error)
+		}
+		var res []opResult
+		err = json.Unmarshal(resp.Result, &res)
+		if err != nil {
+			return nil, err
+		}
+		// a failed operation aborts the transaction, its error is in its result or appended
+		for _, r := range res {
+			if r.Error != "" {
+				return nil, fmt.Errorf("ovsdb transaction failed: %v: %v", r.Error, r.Details)
+			}
+		}
+		return res, nil
+	}
+}
@@ -0,0 +1,13 @@
+// Package neigh describes the endpoints vxrouter hosts learn about each other, as exchanged
+// with the control api, the message bus, hardware vteps and bgp evpn.
+package neigh
+
+// Entry is an endpoint reachable through a host interface: its address,
+// MAC, and the tunnel endpoint of the host it is on
+type Entry struct {
+	Network string `json:"network"`
+	VNI     int    `json:"vni"`
+	IP      string `json:"ip"`
+	MAC     string `json:"mac"`
+	VTEP    string `json:"vtep"`
+}
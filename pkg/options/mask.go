@@ -0,0 +1,66 @@
+package options
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// masked replaces the value of sensitive options when logging
+const masked = "********"
+
+// sensitive are substrings of option and label keys whose values are never logged
+var sensitive = []string{"password", "passwd", "secret", "token", "credential", "apikey", "api_key", "auth"}
+
+// Sensitive reports whether the value of an option or label key must not be logged
+func Sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitive {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mask returns v, eg. a docker plugin request, as json with the values of
+// sensitive options and labels masked, for debug logging
+func Mask(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("<unloggable %T: %v>", v, err)
+	}
+	var g interface{}
+	err = json.Unmarshal(b, &g)
+	if err != nil {
+		return fmt.Sprintf("<unloggable %T: %v>", v, err)
+	}
+	b, err = json.Marshal(mask(g))
+	if err != nil {
+		return fmt.Sprintf("<unloggable %T: %v>", v, err)
+	}
+	return string(b)
+}
+
+func mask(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, vv := range t {
+			if _, nested := vv.(map[string]interface{}); !nested && Sensitive(k) && vv != nil {
+				t[k] = masked
+				continue
+			}
+			t[k] = mask(vv)
+		}
+	case []interface{}:
+		for i, vv := range t {
+			t[i] = mask(vv)
+		}
+	case string:
+		// labels and options given as key=value lists
+		if kv := strings.SplitN(t, "=", 2); len(kv) == 2 && Sensitive(kv[0]) {
+			return kv[0] + "=" + masked
+		}
+	}
+	return v
+}
@@ -0,0 +1,56 @@
+package options
+
+import (
+	"strings"
+	"testing"
+
+	gphnet "github.com/docker/go-plugins-helpers/network"
+)
+
+func TestSensitive(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"vxlanid", false},
+		{"kvstore", false},
+		{"password", true},
+		{"KV_Password", true},
+		{"webhooktoken", true},
+		{Namespace + "webhook-secret", true},
+		{"Authorization", true},
+		{"apikey", true},
+	}
+	for _, tt := range tests {
+		if got := Sensitive(tt.key); got != tt.want {
+			t.Errorf("Sensitive(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"plain", map[string]string{"vxlanid": "42"}, `{"vxlanid":"42"}`},
+		{"option", map[string]string{"vxlanid": "42", "password": "hunter2"}, `{"password":"********","vxlanid":"42"}`},
+		{"empty value", map[string]string{"token": ""}, `{"token":"********"}`},
+		{"null value", map[string]interface{}{"token": nil}, `{"token":null}`},
+		{"list value", map[string]interface{}{"secret": []string{"a", "b"}}, `{"secret":"********"}`},
+		{"nested under a sensitive key", map[string]interface{}{"auth": map[string]string{"user": "u", "password": "p"}},
+			`{"auth":{"password":"********","user":"u"}}`},
+		{"key=value list", []string{"vxlanid=42", "webhook_token=abc=def", "label"}, `["vxlanid=42","webhook_token=********","label"]`},
+		{"unloggable", make(chan int), "<unloggable chan int"},
+		{"network request", &gphnet.CreateNetworkRequest{NetworkID: "n1", Options: map[string]interface{}{
+			"com.docker.network.generic": map[string]interface{}{"vxlanid": "42", "kvstorepassword": "p"},
+		}}, `"com.docker.network.generic":{"kvstorepassword":"********","vxlanid":"42"}`},
+	}
+	for _, tt := range tests {
+		got := Mask(tt.v)
+		if !strings.Contains(got, tt.want) || strings.Contains(got, "hunter2") {
+			t.Errorf("%v: Mask is %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package options
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/internal/ovs"
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+)
+
+// Namespace is the prefix of namespaced options, eg. com.trilliumit.vxrouter.vxlanid.
+// Options may also be given without it, the namespaced form takes precedence.
+const Namespace = "com.trilliumit.vxrouter."
+
+// genericKey is the docker key holding the driver options of a network or endpoint
+const genericKey = "com.docker.network.generic"
+
+// Known option keys
+const (
+	VxlanID         = "vxlanid"
+	GatewayMode     = "gatewaymode"
+	GatewayOffset   = "gatewayoffset"
+	Allocation      = "allocation"
+	AllocatorURL    = allocator.URLOption
+	ExcludeFirst    = "excludefirst"
+	ExcludeLast     = "excludelast"
+	Exclude         = "exclude"
+	HostBlock       = "hostblock"
+	ComposeBlock    = "composeblock"
+	Fabric          = "fabric"
+	FabricPolicy    = "fabricpolicy"
+	Sysctl          = "sysctl"
+	Sticky          = "sticky"
+	DAD             = "dad"
+	MacAlloc        = "macalloc"
+	Canary          = "canary"
+	Delegate        = "delegate"
+	Nested          = "nested"
+	MTU             = "mtu"
+	ShareVNI        = "sharevni"
+	Group           = "group"
+	Port            = "port"
+	PortLow         = "portlow"
+	PortHigh        = "porthigh"
+	Parent          = "parent"
+	Learning        = "learning"
+	TTL             = "ttl"
+	TOS             = "tos"
+	UDPCsum         = "udpcsum"
+	Ageing          = "ageing"
+	L3              = "l3"
+	Conflict        = "conflict"
+	LeaseTTL        = "leasettl"
+	GatewayConflict = "gatewayconflict"
+	Metric          = "metric"
+	Datapath        = "datapath"
+)
+
+// spec describes a known option
+type spec struct {
+	def      string
+	validate func(string) error
+}
+
+var specs = map[string]spec{
+	VxlanID:         {"", intRange(0, 16777215)},
+	GatewayMode:     {"plugin", oneOf("plugin", "external", "none")},
+	GatewayOffset:   {"", intRange(0, -1)},
+	Allocation:      {allocator.Default, allocator.Known},
+	AllocatorURL:    {"", nil},
+	ExcludeFirst:    {"1", intRange(0, -1)},
+	ExcludeLast:     {"1", intRange(0, -1)},
+	Exclude:         {"", ranges},
+	HostBlock:       {"0", intRange(0, -1)},
+	ComposeBlock:    {"0", intRange(0, -1)},
+	Fabric:          {"", nil},
+	FabricPolicy:    {"order", oneOf("order", "balance")},
+	Sysctl:          {"", nil},
+	Sticky:          {"off", oneOf("off", "name", "hostname")},
+	DAD:             {"on", oneOf("on", "off")},
+	MacAlloc:        {"eui64", oneOf("off", "eui64", "hash")},
+	Canary:          {"off", oneOf("off", "on")},
+	Delegate:        {"0", intRange(0, 128)},
+	Nested:          {"auto", oneOf("auto", "off")},
+	MTU:             {"", intRange(68, 65535)},
+	ShareVNI:        {"off", oneOf("off", "on")},
+	Group:           {"", ipAddr},
+	Port:            {"", intRange(1, 65535)},
+	PortLow:         {"", intRange(1, 65535)},
+	PortHigh:        {"", intRange(1, 65535)},
+	Parent:          {"", parent},
+	Learning:        {"", boolean},
+	TTL:             {"", intRange(0, 255)},
+	TOS:             {"", tos},
+	UDPCsum:         {"", boolean},
+	Ageing:          {"", intRange(0, -1)},
+	L3:              {"off", oneOf("off", "on")},
+	Conflict:        {"retry", oneOf("retry", "fail", "evict")},
+	LeaseTTL:        {"0", duration},
+	GatewayConflict: {"off", oneOf("off", "warn", "fail", "takeover")},
+	Metric:          {"0", intRange(0, -1)},
+	Datapath:        {"kernel", oneOf("kernel", "ovs")},
+}
+
+// Options are the options of a network or endpoint, keyed without the namespace
+type Options map[string]string
+
+// Parse merges layers of options, later layers taking precedence, and validates the known options.
+// Keys are lower cased, and namespaced keys override their bare form within a layer.
+func Parse(layers ...map[string]string) (Options, error) {
+	o := make(Options)
+	for _, l := range layers {
+		for k, v := range l {
+			k = strings.ToLower(k)
+			if !strings.HasPrefix(k, Namespace) {
+				o[k] = v
+			}
+		}
+		for k, v := range l {
+			k = strings.ToLower(k)
+			if strings.HasPrefix(k, Namespace) {
+				o[strings.TrimPrefix(k, Namespace)] = v
+			}
+		}
+	}
+
+	for k, v := range o {
+		s, ok := specs[k]
+		if !ok || s.validate == nil || v == "" {
+			continue
+		}
+		if err := s.validate(v); err != nil {
+			return nil, fmt.Errorf("invalid option %v: %v", k, err)
+		}
+	}
+	if err := checkPortRange(o); err != nil {
+		return nil, err
+	}
+	if learn, _ := strconv.ParseBool(o[Learning]); strings.EqualFold(o[L3], "on") && (o[Group] != "" || learn) { // nolint: errcheck
+		return nil, fmt.Errorf("option %v can not be set with %v or %v", L3, Group, Learning)
+	}
+	if o[Parent] != "" && o[Fabric] != "" {
+		return nil, fmt.Errorf("options %v and %v can not be set together", Parent, Fabric)
+	}
+	if err := checkDatapath(o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// checkDatapath rejects the options the ovs datapath can not honour: the vxlan
+// options it has no equivalent for, and those requiring a kernel vxlan
+func checkDatapath(o Options) error {
+	if !strings.EqualFold(o[Datapath], "ovs") {
+		return nil
+	}
+	for _, k := range ovs.Unsupported {
+		if o[k] != "" {
+			return fmt.Errorf("option %v can not be set with %v ovs", k, Datapath)
+		}
+	}
+	if strings.EqualFold(o[L3], "on") {
+		return fmt.Errorf("option %v can not be set with %v ovs", L3, Datapath)
+	}
+	if strings.EqualFold(o[ShareVNI], "on") {
+		return fmt.Errorf("option %v can not be set with %v ovs", ShareVNI, Datapath)
+	}
+	for _, k := range []string{Parent, Fabric} {
+		if o[k] != "" {
+			return fmt.Errorf("option %v can not be set with %v ovs", k, Datapath)
+		}
+	}
+	return nil
+}
+
+// FromGeneric returns the string options of a docker request, from the
+// generic options map if present, else the top level options
+func FromGeneric(m map[string]interface{}) map[string]string {
+	if g, ok := m[genericKey].(map[string]interface{}); ok {
+		m = g
+	}
+	ret := make(map[string]string)
+	for k, v := range m {
+		if s, ok := v.(string); ok {
+			ret[k] = s
+		}
+	}
+	return ret
+}
+
+// String returns an option, else the VXR_<key> environment variable, else the default
+func (o Options) String(key string) string {
+	if v := o[key]; v != "" {
+		return v
+	}
+	if v := os.Getenv(vxrouter.EnvPrefix + key); v != "" {
+		return v
+	}
+	return specs[key].def
+}
+
+// Int returns an integer option, as String
+func (o Options) Int(key string) int {
+	v := o.String(key)
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.WithField(key, v).WithError(err).Warn("failed to convert option to int, using default")
+		i, _ = strconv.Atoi(specs[key].def) // nolint: errcheck
+	}
+	return i
+}
+
+// Duration returns a duration option, as String
+func (o Options) Duration(key string) time.Duration {
+	v := o.String(key)
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.WithField(key, v).WithError(err).Warn("failed to convert option to duration, using default")
+		d, _ = time.ParseDuration(specs[key].def) // nolint: errcheck
+	}
+	return d
+}
+
+func intRange(min, max int) func(string) error {
+	return func(v string) error {
+		i, err := strconv.ParseInt(v, 0, 0)
+		if err != nil {
+			return err
+		}
+		if int(i) < min || (max >= min && int(i) > max) {
+			if max < min {
+				return fmt.Errorf("%v must be at least %v", v, min)
+			}
+			return fmt.Errorf("%v is not between %v and %v", v, min, max)
+		}
+		return nil
+	}
+}
+
+func ipAddr(v string) error {
+	if net.ParseIP(v) == nil {
+		return fmt.Errorf("%v is not an ip address", v)
+	}
+	return nil
+}
+
+// checkPortRange checks the source port range of the vxlan, which the kernel requires in full and in order
+func checkPortRange(o Options) error {
+	low, high := o[PortLow], o[PortHigh]
+	if low == "" && high == "" {
+		return nil
+	}
+	if low == "" || high == "" {
+		return fmt.Errorf("options %v and %v must be set together", PortLow, PortHigh)
+	}
+	l, _ := strconv.ParseInt(low, 0, 0)  // nolint: errcheck
+	h, _ := strconv.ParseInt(high, 0, 0) // nolint: errcheck
+	if l > h {
+		return fmt.Errorf("option %v %v is above %v %v", PortLow, low, PortHigh, high)
+	}
+	return nil
+}
+
+func duration(v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	if d < 0 {
+		return fmt.Errorf("%v is negative", v)
+	}
+	return nil
+}
+
+func boolean(v string) error {
+	_, err := strconv.ParseBool(v)
+	return err
+}
+
+// tos is a type of service, or inherit to copy it from the inner packet
+func tos(v string) error {
+	if strings.EqualFold(v, "inherit") {
+		return nil
+	}
+	return intRange(0, 255)(v)
+}
+
+func parent(v string) error {
+	_, _, err := host.ParseParent(v)
+	return err
+}
+
+func ranges(v string) error {
+	_, err := host.ParseRanges(v)
+	return err
+}
+
+func oneOf(vals ...string) func(string) error {
+	return func(v string) error {
+		for _, a := range vals {
+			if strings.EqualFold(v, a) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%v must be one of %v", v, strings.Join(vals, ", "))
+	}
+}
@@ -0,0 +1,175 @@
+package options
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TrilliumIT/vxrouter"
+)
+
+func TestParseLayers(t *testing.T) {
+	o, err := Parse(
+		map[string]string{"ExcludeFirst": "2", "dad": "off"},
+		map[string]string{"excludefirst": "3"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o[ExcludeFirst] != "3" {
+		t.Errorf("%v is %q, want the later layer 3", ExcludeFirst, o[ExcludeFirst])
+	}
+	if o[DAD] != "off" {
+		t.Errorf("%v is %q, want off from the first layer", DAD, o[DAD])
+	}
+}
+
+func TestParseNamespace(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers []map[string]string
+		want   string
+	}{
+		{"bare", []map[string]string{{"vxlanid": "10"}}, "10"},
+		{"namespaced", []map[string]string{{Namespace + "vxlanid": "11"}}, "11"},
+		{"namespaced over bare", []map[string]string{{"vxlanid": "10", Namespace + "vxlanid": "11"}}, "11"},
+		{"upper case namespaced over bare", []map[string]string{{"VxlanID": "10", "COM.TrilliumIT.vxrouter.VXLANID": "11"}}, "11"},
+		{"bare of a later layer over namespaced", []map[string]string{{Namespace + "vxlanid": "11"}, {"vxlanid": "12"}}, "12"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := Parse(tt.layers...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o[VxlanID] != tt.want {
+				t.Errorf("%v is %q, want %q", VxlanID, o[VxlanID], tt.want)
+			}
+			if _, ok := o[Namespace+VxlanID]; ok {
+				t.Errorf("namespaced key kept in %v", o)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []map[string]string{
+		{VxlanID: "16777216"},
+		{VxlanID: "ten"},
+		{GatewayMode: "bridge"},
+		{ExcludeFirst: "-1"},
+		{Allocation: "nosuchallocator"},
+		{Delegate: "129"},
+		{MTU: "67"},
+		{Group: "239.1.1"},
+		{Learning: "sometimes"},
+		{TOS: "256"},
+		{LeaseTTL: "-1m"},
+		{LeaseTTL: "1 day"},
+		{Conflict: "ignore"},
+		{Exclude: "10.1.2.3-"},
+		{Namespace + DAD: "maybe"},
+		{PortLow: "4000"},
+		{PortLow: "5000", PortHigh: "4000"},
+		{L3: "on", Group: "239.1.1.1"},
+		{L3: "on", Learning: "true"},
+		{Parent: "eth0", Fabric: "eth1"},
+		{Datapath: "bridge"},
+		{Datapath: "ovs", Group: "239.1.1.1"},
+		{Datapath: "ovs", Learning: "false"},
+		{Datapath: "ovs", PortLow: "4000", PortHigh: "5000"},
+		{Datapath: "ovs", L3: "on"},
+		{Datapath: "ovs", Parent: "eth0"},
+		{Datapath: "ovs", Fabric: "eth1"},
+	}
+	for _, tt := range tests {
+		if o, err := Parse(tt); err == nil {
+			t.Errorf("%v parsed as %v, want an error", tt, o)
+		}
+	}
+}
+
+func TestParseValid(t *testing.T) {
+	tests := []map[string]string{
+		{VxlanID: "0x10"},
+		{GatewayMode: "External"},
+		{TOS: "inherit"},
+		{TOS: "0x10"},
+		{LeaseTTL: "1h30m"},
+		{PortLow: "4000", PortHigh: "5000"},
+		{L3: "on"},
+		{L3: "off", Learning: "true"},
+		{VxlanID: ""},
+		{"unknownoption": "anything"},
+		{Datapath: "OVS", Port: "4790", TTL: "64", UDPCsum: "true"},
+		{Datapath: "kernel", Group: "239.1.1.1"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt); err != nil {
+			t.Errorf("%v: %v", tt, err)
+		}
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	o, err := Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := o.String(GatewayMode); v != "plugin" {
+		t.Errorf("%v defaults to %q, want plugin", GatewayMode, v)
+	}
+	if v := o.Int(ExcludeFirst); v != 1 {
+		t.Errorf("%v defaults to %v, want 1", ExcludeFirst, v)
+	}
+	if v := o.Duration(LeaseTTL); v != 0 {
+		t.Errorf("%v defaults to %v, want 0", LeaseTTL, v)
+	}
+	if v := o.String("unknownoption"); v != "" {
+		t.Errorf("unknown option defaults to %q", v)
+	}
+}
+
+func TestEnvironment(t *testing.T) {
+	env := vxrouter.EnvPrefix + ExcludeLast
+	if err := os.Setenv(env, "4"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv(env) // nolint: errcheck
+
+	if v := (Options{}).Int(ExcludeLast); v != 4 {
+		t.Errorf("%v is %v without the option, want 4 from %v", ExcludeLast, v, env)
+	}
+	if v := (Options{ExcludeLast: "2"}).Int(ExcludeLast); v != 2 {
+		t.Errorf("%v is %v with the option set, want 2", ExcludeLast, v)
+	}
+}
+
+func TestInvalidValuesUseDefault(t *testing.T) {
+	// values not validated by Parse, eg. from the environment, fall back to the default
+	o := Options{ExcludeFirst: "one", LeaseTTL: "soon"}
+	if v := o.Int(ExcludeFirst); v != 1 {
+		t.Errorf("invalid %v is %v, want the default 1", ExcludeFirst, v)
+	}
+	if v := o.Duration(LeaseTTL); v != 0 {
+		t.Errorf("invalid %v is %v, want the default 0", LeaseTTL, v)
+	}
+	if v := (Options{LeaseTTL: "90s"}).Duration(LeaseTTL); v != 90*time.Second {
+		t.Errorf("%v is %v, want 1m30s", LeaseTTL, v)
+	}
+}
+
+func TestFromGeneric(t *testing.T) {
+	m := map[string]interface{}{
+		genericKey: map[string]interface{}{"vxlanid": "10", "count": 3},
+		"other":    "x",
+	}
+	o := FromGeneric(m)
+	if len(o) != 1 || o["vxlanid"] != "10" {
+		t.Errorf("generic options are %v, want only vxlanid=10", o)
+	}
+	o = FromGeneric(map[string]interface{}{"vxlanid": "11"})
+	if o["vxlanid"] != "11" {
+		t.Errorf("top level options are %v, want vxlanid=11", o)
+	}
+}
@@ -0,0 +1,532 @@
+// Package vxrbgp is the bgp evpn control plane of vxrouter, through a gobgpd on the host.
+// The endpoints of this host are advertised as evpn type 2 (MAC/IP) routes and its
+// container routes as type 5 (prefix) routes, with the vxlan id as label and the tunnel
+// endpoint as next hop. The forwarding and neighbor entries of the type 2 routes of other
+// hosts are programmed, and their type 5 routes installed as routes through their tunnel
+// endpoints. gobgpd is driven with the gobgp cli and its rib polled.
+package vxrbgp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+)
+
+const (
+	// DefaultAPI is the grpc api address of gobgpd
+	DefaultAPI = "127.0.0.1:50051"
+	// DefaultInterval is how often the rib of gobgpd is polled
+	DefaultInterval = 5 * time.Second
+	// queueLen is how many changes may wait to be applied before new ones are dropped
+	queueLen = 256
+	// cliTimeout is how long a gobgp call may take
+	cliTimeout = 10 * time.Second
+	// maxASN is the largest 4 byte as number
+	maxASN = 1<<32 - 1
+	// maxVNI is the largest vxlan id, carried in the 24 bit label of evpn routes with vxlan encapsulation
+	maxVNI = 1<<24 - 1
+	// evpn route types
+	macIPRoute  = 2
+	prefixRoute = 5
+	// mpReach is the path attribute holding the next hop of evpn routes
+	mpReach = 14
+)
+
+// Config configures the bgp speaker
+type Config struct {
+	// Gobgp is the gobgp cli binary
+	Gobgp string
+	// API is the host:port of the grpc api of gobgpd
+	API string
+	// ASN is the local as number
+	ASN int64
+	// RouterID is the bgp router id, also the next hop of routes without a tunnel endpoint address
+	RouterID net.IP
+	// Peers are the bgp peers to add, none if they are configured in gobgpd
+	Peers []Peer
+	// Interval is how often the rib is polled for the routes of other hosts
+	Interval time.Duration
+}
+
+// Peer is a bgp neighbor, in the local as if ASN is 0
+type Peer struct {
+	Address net.IP
+	ASN     int64
+}
+
+// BGP advertises and imports evpn routes through gobgpd. Changes are queued and applied in
+// order in the background, where the rib is also polled.
+type BGP struct {
+	cfg  Config
+	api  []string
+	q    chan *change
+	done chan struct{}
+	// stopped is closed when run returns
+	stopped chan struct{}
+	log     *log.Entry
+
+	// configured is set once the global config and peers of gobgpd are set, only from run
+	configured bool
+	// imported are the type 2 routes of other hosts, by mac, ip and vni, only from run
+	imported map[string]neigh.Entry
+	// prefixes are the arguments of the type 5 routes exported, by prefix, only from run
+	prefixes map[string][]string
+
+	l    sync.RWMutex
+	subs []func(advertised, withdrawn []neigh.Entry)
+}
+
+// change adds or deletes evpn routes
+type change struct {
+	add     bool
+	entries []neigh.Entry
+	dst     *net.IPNet
+	dev     string
+}
+
+// run runs the gobgp cli and returns its output, replaced in tests
+var run = func(cmd string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, cmd, args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+// localVTEPs returns the local tunnel endpoints by vxlan id, replaced in tests
+var localVTEPs = host.LocalVTEPs
+
+// setImportedRoutes installs the prefixes of other hosts, replaced in tests
+var setImportedRoutes = host.SetImportedRoutes
+
+// ParsePeer parses a peer as address[;as=asn]
+func ParsePeer(s string) (Peer, error) {
+	kvs := strings.Split(s, ";")
+	p := Peer{Address: net.ParseIP(kvs[0])}
+	if p.Address == nil {
+		return p, fmt.Errorf("invalid bgp peer address %q", kvs[0])
+	}
+	for _, kv := range kvs[1:] {
+		i := strings.Index(kv, "=")
+		if i < 0 || kv[:i] != "as" {
+			return p, fmt.Errorf("invalid bgp peer option %q, only as=<asn>", kv)
+		}
+		asn, err := strconv.ParseInt(kv[i+1:], 10, 64)
+		if err != nil || asn < 1 || asn > maxASN {
+			return p, fmt.Errorf("invalid as number %q of bgp peer %v", kv[i+1:], p.Address)
+		}
+		p.ASN = asn
+	}
+	return p, nil
+}
+
+// Check checks the configuration and that the gobgp binary is found
+func Check(cfg Config) error {
+	if cfg.ASN < 1 || cfg.ASN > maxASN {
+		return fmt.Errorf("invalid as number %v", cfg.ASN)
+	}
+	if cfg.RouterID == nil || cfg.RouterID.To4() == nil {
+		return fmt.Errorf("invalid router id %v, it must be an ipv4 address", cfg.RouterID)
+	}
+	if _, _, err := net.SplitHostPort(cfg.API); err != nil {
+		return fmt.Errorf("invalid gobgpd api %q: %v", cfg.API, err)
+	}
+	_, err := exec.LookPath(cfg.Gobgp)
+	return err
+}
+
+// New advertises and imports evpn routes with the gobgp cli. gobgpd is configured with the
+// as number, router id and peers in the background, once it is up.
+func New(cfg Config) (*BGP, error) {
+	if err := Check(cfg); err != nil {
+		return nil, err
+	}
+	path, err := exec.LookPath(cfg.Gobgp)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Gobgp = path
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	b := newBGP(cfg)
+	go b.run()
+	return b, nil
+}
+
+func newBGP(cfg Config) *BGP {
+	h, p, _ := net.SplitHostPort(cfg.API) // nolint: errcheck
+	return &BGP{
+		cfg:      cfg,
+		api:      []string{"-u", h, "-p", p},
+		q:        make(chan *change, queueLen),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		log:      log.WithField("bgp", cfg.ASN),
+		imported: make(map[string]neigh.Entry),
+		prefixes: make(map[string][]string),
+	}
+}
+
+// AdvertiseEntries queues the type 2 routes of local endpoints
+func (b *BGP) AdvertiseEntries(es []neigh.Entry) {
+	if len(es) > 0 {
+		b.enqueue(&change{add: true, entries: es})
+	}
+}
+
+// WithdrawEntries queues the withdrawal of the type 2 routes of local endpoints
+func (b *BGP) WithdrawEntries(es []neigh.Entry) {
+	if len(es) > 0 {
+		b.enqueue(&change{entries: es})
+	}
+}
+
+// Export queues the type 5 route of dst, with the vxlan id of the link dev. Routes through
+// links which are not on a vxlan of this plugin are not advertised.
+func (b *BGP) Export(dst *net.IPNet, dev string) {
+	b.enqueue(&change{add: true, dst: dst, dev: dev})
+}
+
+// Withdraw queues the withdrawal of the type 5 route of dst
+func (b *BGP) Withdraw(dst *net.IPNet) {
+	b.enqueue(&change{dst: dst})
+}
+
+// Subscribe calls f with the type 2 routes of other hosts advertised and withdrawn since the last poll
+func (b *BGP) Subscribe(f func(advertised, withdrawn []neigh.Entry)) {
+	if b == nil {
+		return
+	}
+	b.l.Lock()
+	defer b.l.Unlock()
+	b.subs = append(b.subs, f)
+}
+
+// Close stops advertising and importing routes, dropping queued changes once a change or
+// poll in progress is done. The routes advertised are kept by gobgpd, those installed are
+// kept until the next start.
+func (b *BGP) Close() {
+	if b == nil {
+		return
+	}
+	close(b.done)
+	<-b.stopped
+}
+
+func (b *BGP) enqueue(c *change) {
+	if b == nil {
+		return
+	}
+	select {
+	case b.q <- c:
+	default:
+		b.log.WithField("dst", c.dst).WithField("entries", len(c.entries)).Warn("bgp queue is full, dropping route change")
+	}
+}
+
+func (b *BGP) run() {
+	defer close(b.stopped)
+	t := time.NewTicker(b.cfg.Interval)
+	defer t.Stop()
+	b.poll()
+	for {
+		select {
+		case <-b.done:
+			return
+		case c := <-b.q:
+			b.apply(c)
+		case <-t.C:
+			b.poll()
+		}
+	}
+}
+
+// gobgp runs a gobgp command against the api of gobgpd
+func (b *BGP) gobgp(args ...string) ([]byte, error) {
+	return run(b.cfg.Gobgp, append(append([]string{}, b.api...), args...)...)
+}
+
+// configure sets the as number and router id of gobgpd and adds the peers. gobgpd refuses
+// a global config once started, eg. from its config file, which is then kept.
+func (b *BGP) configure() error {
+	out, err := b.gobgp("global", "as", strconv.FormatInt(b.cfg.ASN, 10), "router-id", b.cfg.RouterID.String())
+	if err != nil {
+		if _, gerr := b.gobgp("global"); gerr != nil {
+			return err
+		}
+		b.log.WithError(err).Info("gobgpd is already started, keeping its global config")
+	}
+	b.log.WithField("out", string(bytes.TrimSpace(out))).Debug("configured gobgpd")
+	for _, p := range b.cfg.Peers {
+		asn := p.ASN
+		if asn == 0 {
+			asn = b.cfg.ASN
+		}
+		_, err = b.gobgp("neighbor", "add", p.Address.String(), "as", strconv.FormatInt(asn, 10), "family", "l2vpn-evpn")
+		if err != nil && !strings.Contains(err.Error(), "exist") {
+			return fmt.Errorf("failed to add peer %v: %v", p.Address, err)
+		}
+	}
+	return nil
+}
+
+// rd returns the route distinguisher of the routes of vni, unique per host with the router id.
+// A type 1 rd, router id:vni, only has 16 bits for the vni, larger vnis get a type 0 rd with
+// the low 2 bytes of the router id and the whole vni.
+func (b *BGP) rd(vni int) string {
+	if vni <= 0xffff {
+		return fmt.Sprintf("%v:%v", b.cfg.RouterID, vni)
+	}
+	id := b.cfg.RouterID.To4()
+	return fmt.Sprintf("%v:%v", int(id[2])<<8|int(id[3]), vni)
+}
+
+// rt returns the route target of vni, as:vni with the low 2 bytes of the as number, like FRR derives it
+func (b *BGP) rt(vni int) string {
+	return fmt.Sprintf("%v:%v", b.cfg.ASN&0xffff, vni)
+}
+
+// macIPArgs returns the arguments of the type 2 route of a local endpoint
+func (b *BGP) macIPArgs(e neigh.Entry) ([]string, error) {
+	if e.VNI < 1 || e.VNI > maxVNI {
+		return nil, fmt.Errorf("invalid vxlan id %v", e.VNI)
+	}
+	if _, err := net.ParseMAC(e.MAC); err != nil {
+		return nil, err
+	}
+	if net.ParseIP(e.IP) == nil || net.ParseIP(e.VTEP) == nil {
+		return nil, fmt.Errorf("invalid address %q or tunnel endpoint %q", e.IP, e.VTEP)
+	}
+	vni := strconv.Itoa(e.VNI)
+	return []string{"macadv", e.MAC, e.IP, "etag", "0", "label", vni, "rd", b.rd(e.VNI), "rt", b.rt(e.VNI),
+		"encap", "vxlan", "nexthop", e.VTEP}, nil
+}
+
+// prefixArgs returns the arguments of the type 5 route of dst, with the vxlan id and tunnel endpoint
+// of the datapath of dev, or the router id if the tunnel endpoint is left to the routes of the host
+func (b *BGP) prefixArgs(dst *net.IPNet, vni int, local net.IP) ([]string, error) {
+	if vni < 1 || vni > maxVNI {
+		return nil, fmt.Errorf("invalid vxlan id %v", vni)
+	}
+	if local == nil {
+		local = b.cfg.RouterID
+	}
+	return []string{"prefix", dst.String(), "etag", "0", "label", strconv.Itoa(vni), "rd", b.rd(vni), "rt", b.rt(vni),
+		"encap", "vxlan", "nexthop", local.String()}, nil
+}
+
+func (b *BGP) apply(c *change) {
+	if c.dst != nil {
+		b.applyPrefix(c)
+		return
+	}
+	op := "del"
+	if c.add {
+		op = "add"
+	}
+	for _, e := range c.entries {
+		log := b.log.WithField("mac", e.MAC).WithField("ip", e.IP).WithField("vni", e.VNI).WithField("add", c.add)
+		args, err := b.macIPArgs(e)
+		if err == nil {
+			_, err = b.gobgp(append([]string{"global", "rib", "-a", "evpn", op}, args...)...)
+		}
+		if err != nil {
+			log.WithError(err).Error("failed to program bgp")
+			continue
+		}
+		log.Debug("programmed bgp")
+	}
+}
+
+func (b *BGP) applyPrefix(c *change) {
+	k := c.dst.String()
+	log := b.log.WithField("dst", k).WithField("add", c.add)
+	args, ok := b.prefixes[k]
+	if !c.add {
+		if !ok {
+			return
+		}
+		delete(b.prefixes, k)
+		if _, err := b.gobgp(append([]string{"global", "rib", "-a", "evpn", "del"}, args...)...); err != nil {
+			log.WithError(err).Error("failed to program bgp")
+			return
+		}
+		log.Debug("programmed bgp")
+		return
+	}
+	if ok {
+		return
+	}
+	vni, local, err := host.LinkVTEP(c.dev)
+	if err != nil {
+		log.WithField("dev", c.dev).WithError(err).Debug("route is not through a vxlan, not advertising it")
+		return
+	}
+	args, err = b.prefixArgs(c.dst, vni, local)
+	if err == nil {
+		_, err = b.gobgp(append([]string{"global", "rib", "-a", "evpn", "add"}, args...)...)
+	}
+	if err != nil {
+		log.WithError(err).Error("failed to program bgp")
+		return
+	}
+	b.prefixes[k] = args
+	log.Debug("programmed bgp")
+}
+
+// ribPath is a path of the json rib of gobgp
+type ribPath struct {
+	NLRI struct {
+		Type  int `json:"type"`
+		Value struct {
+			MAC    string   `json:"mac"`
+			IP     string   `json:"ip"`
+			Labels []uint32 `json:"labels"`
+			Prefix string   `json:"prefix"`
+			Label  uint32   `json:"label"`
+		} `json:"value"`
+	} `json:"nlri"`
+	Attrs []struct {
+		Type    int    `json:"type"`
+		Nexthop string `json:"nexthop"`
+	} `json:"attrs"`
+	Best       bool   `json:"best"`
+	NeighborIP string `json:"neighbor-ip"`
+}
+
+func (p *ribPath) nexthop() net.IP {
+	for _, a := range p.Attrs {
+		if a.Type == mpReach {
+			return net.ParseIP(a.Nexthop)
+		}
+	}
+	return nil
+}
+
+// parseRIB returns the type 2 routes of other hosts as neighbor entries by mac, ip and vni, and the
+// next hops of their type 5 routes by prefix. Only best paths learned from peers, with a next hop
+// which is not a tunnel endpoint of this host, are returned.
+func parseRIB(out []byte, local func(net.IP) bool) (map[string]neigh.Entry, map[string]net.IP, error) {
+	rib := make(map[string][]ribPath)
+	if err := json.Unmarshal(out, &rib); err != nil {
+		return nil, nil, fmt.Errorf("invalid gobgp rib: %v", err)
+	}
+	entries := make(map[string]neigh.Entry)
+	prefixes := make(map[string]net.IP)
+	for _, paths := range rib {
+		for i := range paths {
+			p := &paths[i]
+			nh := p.nexthop()
+			if !p.Best || p.NeighborIP == "" || nh == nil || local(nh) {
+				continue
+			}
+			v := p.NLRI.Value
+			switch p.NLRI.Type {
+			case macIPRoute:
+				mac, err := net.ParseMAC(v.MAC)
+				ip := net.ParseIP(v.IP)
+				if err != nil || ip == nil || ip.IsUnspecified() || len(v.Labels) == 0 {
+					continue
+				}
+				e := neigh.Entry{VNI: int(v.Labels[0]), IP: ip.String(), MAC: mac.String(), VTEP: nh.String()}
+				entries[entryKey(e)] = e
+			case prefixRoute:
+				if _, dst, err := net.ParseCIDR(v.Prefix); err == nil {
+					prefixes[dst.String()] = nh
+				}
+			}
+		}
+	}
+	return entries, prefixes, nil
+}
+
+func entryKey(e neigh.Entry) string {
+	return fmt.Sprintf("%v %v %v", e.MAC, e.IP, e.VNI)
+}
+
+// diffEntries returns the entries advertised or moved to another tunnel endpoint, and those withdrawn
+func diffEntries(old, cur map[string]neigh.Entry) ([]neigh.Entry, []neigh.Entry) {
+	adv, wd := []neigh.Entry{}, []neigh.Entry{}
+	for k, e := range cur {
+		if o, ok := old[k]; !ok || o.VTEP != e.VTEP {
+			adv = append(adv, e)
+		}
+	}
+	for k, e := range old {
+		if _, ok := cur[k]; !ok {
+			wd = append(wd, e)
+		}
+	}
+	sort.Slice(adv, func(i, j int) bool { return entryKey(adv[i]) < entryKey(adv[j]) })
+	sort.Slice(wd, func(i, j int) bool { return entryKey(wd[i]) < entryKey(wd[j]) })
+	return adv, wd
+}
+
+// poll configures gobgpd until it succeeds, then imports the routes of other hosts from its rib
+func (b *BGP) poll() {
+	if !b.configured {
+		if err := b.configure(); err != nil {
+			b.log.WithError(err).Warn("failed to configure gobgpd, retrying")
+			return
+		}
+		b.configured = true
+	}
+
+	out, err := b.gobgp("global", "rib", "-a", "evpn", "-j")
+	if err != nil {
+		b.log.WithError(err).Error("failed to get the evpn routes of gobgpd")
+		return
+	}
+	vteps, err := localVTEPs()
+	if err != nil {
+		b.log.WithError(err).Error("failed to get the local tunnel endpoints")
+		return
+	}
+	local := func(ip net.IP) bool {
+		if ip.Equal(b.cfg.RouterID) {
+			return true
+		}
+		for _, v := range vteps {
+			if ip.Equal(v) {
+				return true
+			}
+		}
+		return false
+	}
+	entries, prefixes, err := parseRIB(out, local)
+	if err != nil {
+		b.log.WithError(err).Error()
+		return
+	}
+
+	if err = setImportedRoutes(prefixes); err != nil {
+		b.log.WithError(err).Error("failed to install the prefixes of other hosts")
+	}
+	adv, wd := diffEntries(b.imported, entries)
+	b.imported = entries
+	if len(adv) == 0 && len(wd) == 0 {
+		return
+	}
+	b.log.WithField("advertised", len(adv)).WithField("withdrawn", len(wd)).Debug("evpn routes changed")
+	b.l.RLock()
+	defer b.l.RUnlock()
+	for _, f := range b.subs {
+		f(adv, wd)
+	}
+}
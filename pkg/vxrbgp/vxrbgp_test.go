@@ -0,0 +1,411 @@
+package vxrbgp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TrilliumIT/vxrouter/pkg/neigh"
+)
+
+// fakeGobgp replaces the gobgp cli, recording the commands and answering those starting with a key of out
+type fakeGobgp struct {
+	out  map[string]string
+	fail map[string]bool
+	cmds []string
+}
+
+func newFakeGobgp() (*fakeGobgp, func()) {
+	f := &fakeGobgp{out: make(map[string]string), fail: make(map[string]bool)}
+	oldRun, oldVTEPs, oldImported := run, localVTEPs, setImportedRoutes
+	run = func(cmd string, args ...string) ([]byte, error) {
+		c := strings.Join(args, " ")
+		f.cmds = append(f.cmds, c)
+		for k := range f.fail {
+			if strings.HasPrefix(c, k) {
+				return nil, fmt.Errorf("exit status 1: failed")
+			}
+		}
+		for k, v := range f.out {
+			if strings.HasPrefix(c, k) {
+				return []byte(v), nil
+			}
+		}
+		return nil, nil
+	}
+	localVTEPs = func() (map[int]net.IP, error) {
+		return map[int]net.IP{10: net.ParseIP("10.0.0.1")}, nil
+	}
+	setImportedRoutes = func(map[string]net.IP) error { return nil }
+	return f, func() { run, localVTEPs, setImportedRoutes = oldRun, oldVTEPs, oldImported }
+}
+
+func testConfig() Config {
+	return Config{
+		Gobgp:    "gobgp",
+		API:      DefaultAPI,
+		ASN:      65000,
+		RouterID: net.ParseIP("10.0.0.1"),
+		Peers:    []Peer{{Address: net.ParseIP("10.0.0.2")}, {Address: net.ParseIP("fd00::3"), ASN: 65003}},
+		Interval: time.Second,
+	}
+}
+
+func TestParsePeer(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Peer
+	}{
+		{"10.0.0.2", Peer{Address: net.ParseIP("10.0.0.2")}},
+		{"fd00::3;as=65003", Peer{Address: net.ParseIP("fd00::3"), ASN: 65003}},
+		{"10.0.0.4;as=4200000000", Peer{Address: net.ParseIP("10.0.0.4"), ASN: 4200000000}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePeer(tt.s)
+		if err != nil {
+			t.Errorf("%v: %v", tt.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+	for _, s := range []string{"", "peer", "10.0.0.2;as=0", "10.0.0.2;as=4294967296", "10.0.0.2;asn=1", "10.0.0.2;as"} {
+		if p, err := ParsePeer(s); err == nil {
+			t.Errorf("%v parsed as %+v", s, p)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	cfg := testConfig()
+	cfg.Gobgp = "sh"
+	if err := Check(cfg); err != nil {
+		t.Error(err)
+	}
+	for _, mod := range []func(*Config){
+		func(c *Config) { c.ASN = 0 },
+		func(c *Config) { c.ASN = maxASN + 1 },
+		func(c *Config) { c.RouterID = nil },
+		func(c *Config) { c.RouterID = net.ParseIP("fd00::1") },
+		func(c *Config) { c.API = "localhost" },
+		func(c *Config) { c.Gobgp = "no-such-gobgp" },
+	} {
+		c := cfg
+		mod(&c)
+		if err := Check(c); err == nil {
+			t.Errorf("%+v checked", c)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	f, restore := newFakeGobgp()
+	defer restore()
+
+	b := newBGP(testConfig())
+	if err := b.configure(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-u 127.0.0.1 -p 50051 global as 65000 router-id 10.0.0.1",
+		"-u 127.0.0.1 -p 50051 neighbor add 10.0.0.2 as 65000 family l2vpn-evpn",
+		"-u 127.0.0.1 -p 50051 neighbor add fd00::3 as 65003 family l2vpn-evpn",
+	}
+	if !reflect.DeepEqual(f.cmds, want) {
+		t.Errorf("ran %q, want %q", f.cmds, want)
+	}
+
+	// a started gobgpd keeps its global config
+	f.cmds = nil
+	f.fail["-u 127.0.0.1 -p 50051 global as"] = true
+	if err := b.configure(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.cmds) != 4 || f.cmds[1] != "-u 127.0.0.1 -p 50051 global" {
+		t.Errorf("ran %q, want the global config checked and the peers added", f.cmds)
+	}
+
+	// gobgpd is down
+	f.fail["-u 127.0.0.1 -p 50051 global"] = true
+	if err := b.configure(); err == nil {
+		t.Error("configured a gobgpd which is down")
+	}
+}
+
+func TestApply(t *testing.T) {
+	f, restore := newFakeGobgp()
+	defer restore()
+
+	b := newBGP(testConfig())
+	e := neigh.Entry{Network: "net1", VNI: 10, IP: "10.1.0.5", MAC: "02:00:0a:01:00:05", VTEP: "10.0.0.1"}
+	b.apply(&change{add: true, entries: []neigh.Entry{e}})
+	b.apply(&change{entries: []neigh.Entry{e}})
+	route := " macadv 02:00:0a:01:00:05 10.1.0.5 etag 0 label 10 rd 10.0.0.1:10 rt 65000:10 encap vxlan nexthop 10.0.0.1"
+	want := []string{
+		"-u 127.0.0.1 -p 50051 global rib -a evpn add" + route,
+		"-u 127.0.0.1 -p 50051 global rib -a evpn del" + route,
+	}
+	if !reflect.DeepEqual(f.cmds, want) {
+		t.Errorf("ran %q, want %q", f.cmds, want)
+	}
+
+	f.cmds = nil
+	b.apply(&change{add: true, entries: []neigh.Entry{{VNI: maxVNI + 1, IP: "10.1.0.5", MAC: e.MAC, VTEP: e.VTEP}, {VNI: 10, IP: "10.1.0.5", MAC: "mac", VTEP: e.VTEP}}})
+	if len(f.cmds) != 0 {
+		t.Errorf("ran %q, advertised invalid entries", f.cmds)
+	}
+}
+
+func TestPrefixArgs(t *testing.T) {
+	cfg := testConfig()
+	cfg.ASN = 4200000001
+	b := newBGP(cfg)
+	_, dst, _ := net.ParseCIDR("fd00:1::5/128") // nolint: errcheck
+	args, err := b.prefixArgs(dst, 70000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a tunnel endpoint address the router id is the next hop, the rt has the low 2 bytes of the as,
+	// a vni above 16 bits has a type 0 rd with the low 2 bytes of the router id
+	want := "prefix fd00:1::5/128 etag 0 label 70000 rd 1:70000 rt 59905:70000 encap vxlan nexthop 10.0.0.1"
+	if got := strings.Join(args, " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if args, err = b.prefixArgs(dst, 10, net.ParseIP("10.0.0.9")); err != nil || args[len(args)-1] != "10.0.0.9" {
+		t.Errorf("got %q, %v, want the tunnel endpoint as next hop", args, err)
+	}
+	if _, err = b.prefixArgs(dst, maxVNI+1, nil); err == nil {
+		t.Error("advertised a vxlan id above 24 bits")
+	}
+}
+
+func TestRD(t *testing.T) {
+	b := newBGP(testConfig())
+	tests := []struct {
+		vni  int
+		want string
+	}{
+		{10, "10.0.0.1:10"},
+		{0xffff, "10.0.0.1:65535"},
+		{0x10000, "1:65536"},
+		{0x1000a, "1:65546"},
+		{maxVNI, "1:16777215"},
+	}
+	for _, tt := range tests {
+		if got := b.rd(tt.vni); got != tt.want {
+			t.Errorf("rd of vni %v is %v, want %v", tt.vni, got, tt.want)
+		}
+	}
+}
+
+func TestWithdrawPrefix(t *testing.T) {
+	f, restore := newFakeGobgp()
+	defer restore()
+
+	b := newBGP(testConfig())
+	_, dst, _ := net.ParseCIDR("10.1.0.5/32") // nolint: errcheck
+	b.apply(&change{dst: dst})
+	if len(f.cmds) != 0 {
+		t.Errorf("ran %q, withdrew a prefix never advertised", f.cmds)
+	}
+	b.prefixes[dst.String()] = []string{"prefix", "10.1.0.5/32", "etag", "0"}
+	b.apply(&change{dst: dst})
+	if len(f.cmds) != 1 || f.cmds[0] != "-u 127.0.0.1 -p 50051 global rib -a evpn del prefix 10.1.0.5/32 etag 0" {
+		t.Errorf("ran %q, want the advertised prefix withdrawn", f.cmds)
+	}
+	if _, ok := b.prefixes[dst.String()]; ok {
+		t.Error("withdrawn prefix is still advertised")
+	}
+}
+
+// rib is the json rib of gobgp global rib -a evpn -j, with a local route, a route of another
+// host, one looped back through a peer, a mac only route, a prefix route and a path not best
+const rib = `{
+ "[type:macadv][rd:10.0.0.1:10][etag:0][mac:02:00:0a:01:00:05][ip:10.1.0.5]": [
+  {"nlri":{"type":2,"value":{"rd":{"admin":"10.0.0.1","assigned":10},"esi":"single-homed","etag":0,"mac":"02:00:0a:01:00:05","ip":"10.1.0.5","labels":[10]}},
+   "age":1,"best":true,"attrs":[{"type":14,"nexthop":"10.0.0.1","afi":25,"safi":70,"value":[]}],"stale":false}
+ ],
+ "[type:macadv][rd:10.0.0.2:10][etag:0][mac:02:00:0a:01:00:06][ip:10.1.0.6]": [
+  {"nlri":{"type":2,"value":{"rd":{"admin":"10.0.0.2","assigned":10},"esi":"single-homed","etag":0,"mac":"02:00:0a:01:00:06","ip":"10.1.0.6","labels":[10]}},
+   "age":1,"best":true,"attrs":[{"type":1,"value":0},{"type":14,"nexthop":"10.0.0.2","afi":25,"safi":70,"value":[]}],"stale":false,"source-id":"10.0.0.2","neighbor-ip":"10.0.0.2"},
+  {"nlri":{"type":2,"value":{"rd":{"admin":"10.0.0.2","assigned":10},"esi":"single-homed","etag":0,"mac":"02:00:0a:01:00:06","ip":"10.1.0.6","labels":[10]}},
+   "age":1,"best":false,"attrs":[{"type":14,"nexthop":"10.0.0.4","afi":25,"safi":70,"value":[]}],"stale":false,"source-id":"10.0.0.4","neighbor-ip":"10.0.0.4"}
+ ],
+ "[type:macadv][rd:10.0.0.3:10][etag:0][mac:02:00:0a:01:00:07][ip:10.1.0.7]": [
+  {"nlri":{"type":2,"value":{"rd":{"admin":"10.0.0.3","assigned":10},"esi":"single-homed","etag":0,"mac":"02:00:0a:01:00:07","ip":"10.1.0.7","labels":[10]}},
+   "age":1,"best":true,"attrs":[{"type":14,"nexthop":"10.0.0.1","afi":25,"safi":70,"value":[]}],"stale":false,"source-id":"10.0.0.3","neighbor-ip":"10.0.0.3"}
+ ],
+ "[type:macadv][rd:10.0.0.2:10][etag:0][mac:02:00:0a:01:00:08][ip:<nil>]": [
+  {"nlri":{"type":2,"value":{"rd":{"admin":"10.0.0.2","assigned":10},"esi":"single-homed","etag":0,"mac":"02:00:0a:01:00:08","ip":"<nil>","labels":[10]}},
+   "age":1,"best":true,"attrs":[{"type":14,"nexthop":"10.0.0.2","afi":25,"safi":70,"value":[]}],"stale":false,"source-id":"10.0.0.2","neighbor-ip":"10.0.0.2"}
+ ],
+ "[type:Prefix][rd:10.0.0.2:10][etag:0][prefix:10.1.0.6/32]": [
+  {"nlri":{"type":5,"value":{"rd":{"admin":"10.0.0.2","assigned":10},"esi":"single-homed","etag":0,"prefix":"10.1.0.6/32","gateway":"0.0.0.0","label":10}},
+   "age":1,"best":true,"attrs":[{"type":14,"nexthop":"10.0.0.2","afi":25,"safi":70,"value":[]}],"stale":false,"source-id":"10.0.0.2","neighbor-ip":"10.0.0.2"}
+ ]
+}`
+
+func TestParseRIB(t *testing.T) {
+	local := func(ip net.IP) bool { return ip.Equal(net.ParseIP("10.0.0.1")) }
+	entries, prefixes, err := parseRIB([]byte(rib), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := neigh.Entry{VNI: 10, IP: "10.1.0.6", MAC: "02:00:0a:01:00:06", VTEP: "10.0.0.2"}
+	if want := map[string]neigh.Entry{entryKey(e): e}; !reflect.DeepEqual(entries, want) {
+		t.Errorf("got entries %+v, want %+v", entries, want)
+	}
+	if want := map[string]net.IP{"10.1.0.6/32": net.ParseIP("10.0.0.2")}; !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("got prefixes %v, want %v", prefixes, want)
+	}
+
+	if _, _, err = parseRIB([]byte("[]"), local); err == nil {
+		t.Error("parsed an invalid rib")
+	}
+	if entries, prefixes, err = parseRIB([]byte("{}"), local); err != nil || len(entries) != 0 || len(prefixes) != 0 {
+		t.Errorf("got %v, %v, %v from an empty rib", entries, prefixes, err)
+	}
+}
+
+func TestDiffEntries(t *testing.T) {
+	a := neigh.Entry{VNI: 10, IP: "10.1.0.6", MAC: "02:00:0a:01:00:06", VTEP: "10.0.0.2"}
+	moved := a
+	moved.VTEP = "10.0.0.3"
+	b := neigh.Entry{VNI: 10, IP: "10.1.0.7", MAC: "02:00:0a:01:00:07", VTEP: "10.0.0.2"}
+	m := func(es ...neigh.Entry) map[string]neigh.Entry {
+		ret := make(map[string]neigh.Entry)
+		for _, e := range es {
+			ret[entryKey(e)] = e
+		}
+		return ret
+	}
+	tests := []struct {
+		name     string
+		old, cur map[string]neigh.Entry
+		adv, wd  []neigh.Entry
+	}{
+		{"first", m(), m(a, b), []neigh.Entry{a, b}, []neigh.Entry{}},
+		{"unchanged", m(a, b), m(a, b), []neigh.Entry{}, []neigh.Entry{}},
+		{"withdrawn", m(a, b), m(a), []neigh.Entry{}, []neigh.Entry{b}},
+		{"moved", m(a), m(moved), []neigh.Entry{moved}, []neigh.Entry{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adv, wd := diffEntries(tt.old, tt.cur)
+			if !reflect.DeepEqual(adv, tt.adv) || !reflect.DeepEqual(wd, tt.wd) {
+				t.Errorf("got %+v, %+v, want %+v, %+v", adv, wd, tt.adv, tt.wd)
+			}
+		})
+	}
+}
+
+// fakeCLI is a gobgp cli standing in for gobgpd, logging its arguments and answering rib
+// queries with the rib file, once it is written
+const fakeCLI = `#!/bin/sh
+d=$(dirname "$0")
+echo "$*" >> "$d/cmds"
+case "$*" in
+*" -j") if [ -f "$d/rib" ]; then cat "$d/rib"; else echo "{}"; fi ;;
+esac
+`
+
+func TestFakeGobgpd(t *testing.T) {
+	oldVTEPs, oldImported := localVTEPs, setImportedRoutes
+	defer func() { localVTEPs, setImportedRoutes = oldVTEPs, oldImported }()
+	localVTEPs = func() (map[int]net.IP, error) { return map[int]net.IP{10: net.ParseIP("10.0.0.1")}, nil }
+	imported := make(chan map[string]net.IP, 16)
+	setImportedRoutes = func(p map[string]net.IP) error {
+		select {
+		case imported <- p:
+		default:
+		}
+		return nil
+	}
+
+	dir, err := ioutil.TempDir("", "gobgp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	cli := filepath.Join(dir, "gobgp")
+	if err = ioutil.WriteFile(cli, []byte(fakeCLI), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig()
+	cfg.Gobgp = cli
+	cfg.Interval = 20 * time.Millisecond
+	b, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	advertised := make(chan []neigh.Entry, 1)
+	b.Subscribe(func(adv, wd []neigh.Entry) {
+		select {
+		case advertised <- adv:
+		default:
+		}
+	})
+
+	e := neigh.Entry{VNI: 0x1000a, IP: "10.1.0.5", MAC: "02:00:0a:01:00:05", VTEP: "10.0.0.1"}
+	b.AdvertiseEntries([]neigh.Entry{e})
+	if err = ioutil.WriteFile(filepath.Join(dir, "rib"), []byte(rib), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	other := neigh.Entry{VNI: 10, IP: "10.1.0.6", MAC: "02:00:0a:01:00:06", VTEP: "10.0.0.2"}
+	select {
+	case adv := <-advertised:
+		if !reflect.DeepEqual(adv, []neigh.Entry{other}) {
+			t.Errorf("got %+v advertised by other hosts, want %+v", adv, other)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("routes of other hosts not imported")
+	}
+	for p := range imported {
+		if len(p) > 0 {
+			if !p["10.1.0.6/32"].Equal(net.ParseIP("10.0.0.2")) {
+				t.Errorf("got prefixes %v, want 10.1.0.6/32 through 10.0.0.2", p)
+			}
+			break
+		}
+	}
+
+	// the change is applied in the background, between polls
+	want := []string{
+		"-u 127.0.0.1 -p 50051 global as 65000 router-id 10.0.0.1",
+		"-u 127.0.0.1 -p 50051 neighbor add 10.0.0.2 as 65000 family l2vpn-evpn",
+		"-u 127.0.0.1 -p 50051 global rib -a evpn add macadv 02:00:0a:01:00:05 10.1.0.5 etag 0 label 65546 rd 1:65546 rt 65000:65546 encap vxlan nexthop 10.0.0.1",
+		"-u 127.0.0.1 -p 50051 global rib -a evpn -j",
+	}
+	var missing []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		out, err := ioutil.ReadFile(filepath.Join(dir, "cmds"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		missing = nil
+		ran := strings.Split(strings.TrimSpace(string(out)), "\n")
+		for _, c := range want {
+			found := false
+			for _, r := range ran {
+				found = found || r == c
+			}
+			if !found {
+				missing = append(missing, c)
+			}
+		}
+		if len(missing) == 0 {
+			return
+		}
+	}
+	t.Errorf("gobgp %q not run", missing)
+}
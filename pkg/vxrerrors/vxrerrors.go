@@ -0,0 +1,86 @@
+package vxrerrors
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error categories, match them with errors.Is
+var (
+	// ErrNotFound is a missing network, pool, interface or other resource
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is a resource in use elsewhere, eg. an address routed by another host
+	ErrConflict = errors.New("conflict")
+	// ErrExhausted is a pool or block with no free addresses
+	ErrExhausted = errors.New("exhausted")
+	// ErrTimeout is an operation which did not complete in time
+	ErrTimeout = errors.New("timeout")
+	// ErrKernelUnsupported is a feature the running kernel does not provide
+	ErrKernelUnsupported = errors.New("kernel unsupported")
+	// ErrUnavailable is a dependency, eg. docker, failing and not being called until it recovers
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// Error is an error in one of the categories above, optionally wrapping a cause
+type Error struct {
+	Kind error
+	Msg  string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Msg + ": " + e.Err.Error()
+	}
+	return e.Msg
+}
+
+// Is reports whether target is the category of e
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// Unwrap returns the cause of e
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New returns an error of kind with a formatted message
+func New(kind error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, a...)}
+}
+
+// Wrap returns an error of kind with a formatted message, wrapping err
+func Wrap(kind error, err error, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, a...), Err: err}
+}
+
+// NotFound returns an ErrNotFound error
+func NotFound(format string, a ...interface{}) error {
+	return New(ErrNotFound, format, a...)
+}
+
+// Conflict returns an ErrConflict error
+func Conflict(format string, a ...interface{}) error {
+	return New(ErrConflict, format, a...)
+}
+
+// Exhausted returns an ErrExhausted error
+func Exhausted(format string, a ...interface{}) error {
+	return New(ErrExhausted, format, a...)
+}
+
+// Timeout returns an ErrTimeout error
+func Timeout(format string, a ...interface{}) error {
+	return New(ErrTimeout, format, a...)
+}
+
+// KernelUnsupported returns an ErrKernelUnsupported error wrapping err
+func KernelUnsupported(err error, format string, a ...interface{}) error {
+	return Wrap(ErrKernelUnsupported, err, format, a...)
+}
+
+// Unavailable returns an ErrUnavailable error
+func Unavailable(format string, a ...interface{}) error {
+	return New(ErrUnavailable, format, a...)
+}
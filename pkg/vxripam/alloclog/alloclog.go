@@ -0,0 +1,132 @@
+// Package alloclog is an append-only log of address allocations, so the owner
+// of an address at any time can be reconstructed when debugging conflicts.
+package alloclog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// Journald is the destination which logs to the systemd journal instead of a file
+const Journald = "journald"
+
+// Operations logged
+const (
+	OpRequest = "request"
+	OpAssign  = "assign"
+	OpRelease = "release"
+)
+
+// Entry is an allocation event
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Op is request when an address is requested, assign when it is assigned to an endpoint,
+	// and release when it is released
+	Op     string `json:"op"`
+	Driver string `json:"driver"`
+	Pool   string `json:"pool,omitempty"`
+	// Requested is the address explicitly requested, if any
+	Requested string `json:"requested,omitempty"`
+	Address   string `json:"address,omitempty"`
+	MAC       string `json:"mac,omitempty"`
+	Endpoint  string `json:"endpoint,omitempty"`
+	// Error is empty on success
+	Error   string  `json:"error,omitempty"`
+	Latency float64 `json:"latency_ms"`
+}
+
+// Log is an allocation log, written to a json lines file or the systemd journal
+type Log struct {
+	l sync.Mutex
+	f *os.File
+}
+
+// Open opens the allocation log at dest, a file path appended to, or journald
+func Open(dest string) (*Log, error) {
+	if dest == Journald {
+		if !journal.Enabled() {
+			return nil, fmt.Errorf("the systemd journal is not available")
+		}
+		return &Log{}, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{f: f}, nil
+}
+
+// Write logs an entry, timestamped now if it has no time
+func (l *Log) Write(e *Entry) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	if l.f == nil {
+		return l.journal(e)
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.l.Lock()
+	defer l.l.Unlock()
+	_, err = l.f.Write(append(b, '\n'))
+	return err
+}
+
+// journal sends e to the journal, with its fields as journal fields
+func (l *Log) journal(e *Entry) error {
+	vars := map[string]string{
+		"VXR_ALLOC_OP":      e.Op,
+		"VXR_ALLOC_DRIVER":  e.Driver,
+		"VXR_ALLOC_LATENCY": strconv.FormatFloat(e.Latency, 'f', 3, 64),
+	}
+	add := func(k, v string) {
+		if v != "" {
+			vars["VXR_ALLOC_"+k] = v
+		}
+	}
+	add("POOL", e.Pool)
+	add("REQUESTED", e.Requested)
+	add("ADDRESS", e.Address)
+	add("MAC", e.MAC)
+	add("ENDPOINT", e.Endpoint)
+	add("ERROR", e.Error)
+
+	msg := []string{e.Op}
+	if e.Address != "" {
+		msg = append(msg, e.Address)
+	}
+	pri := journal.PriInfo
+	if e.Error != "" {
+		msg = append(msg, "failed: "+e.Error)
+		pri = journal.PriWarning
+	}
+	return journal.Send(strings.Join(msg, " "), pri, vars)
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
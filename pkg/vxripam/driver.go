@@ -0,0 +1,171 @@
+package vxripam
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	gphipam "github.com/docker/go-plugins-helpers/ipam"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+	"github.com/TrilliumIT/vxrouter/pkg/vxripam/alloclog"
+)
+
+const (
+	// DriverName is the default name of the driver
+	DriverName = vxrouter.IpamDriver
+
+	// macAddressKey is the request option docker passes the endpoint MAC in
+	macAddressKey = "com.docker.network.endpoint.macaddress"
+)
+
+// Driver is the driver ipam type
+type Driver struct {
+	core *core.Core
+	log  *log.Entry
+}
+
+var _ gphipam.Ipam = (*Driver)(nil)
+
+// NewDriver creates new ipam driver, named after the instance of core.
+// The Driver can be served with a go-plugins-helpers ipam handler,
+// or its methods called directly by programs embedding it.
+func NewDriver(core *core.Core) (*Driver, error) {
+	return &Driver{core, log.WithField("driver", core.IpamDriverName())}, nil
+}
+
+// GetCapabilities asks docker for the endpoint MAC in address requests, addresses may be derived from it
+func (d *Driver) GetCapabilities() (*gphipam.CapabilitiesResponse, error) {
+	d.log.Debug("GetCapabilities()")
+	return &gphipam.CapabilitiesResponse{RequiresMACAddress: true}, nil
+}
+
+// GetDefaultAddressSpaces returns the local and global address spaces
+func (d *Driver) GetDefaultAddressSpaces() (*gphipam.AddressSpacesResponse, error) {
+	d.log.Debug("GetDefaultAddressSpaces()")
+	return &gphipam.AddressSpacesResponse{
+		LocalDefaultAddressSpace:  core.LocalAddressSpace,
+		GlobalDefaultAddressSpace: core.GlobalAddressSpace,
+	}, nil
+}
+
+// RequestPool registers the requested pool, refusing pools which overlap one already in use
+func (d *Driver) RequestPool(r *gphipam.RequestPoolRequest) (*gphipam.RequestPoolResponse, error) {
+	d.log.WithField("r", options.Mask(r)).Debug("RequestPool()")
+	defer d.core.Watch("RequestPool")()
+
+	if r.Pool == "" {
+		// docker before 26 always requests an IPv4 pool, without one for IPv6 only networks
+		if !r.V6 {
+			return nil, fmt.Errorf("this driver does not support automatic address pools, give the network an IPv4 subnet, or create it with --ipv4=false for IPv6 only")
+		}
+		return nil, fmt.Errorf("this driver does not support automatic address pools")
+	}
+
+	if _, err := options.Parse(r.Options); err != nil {
+		return nil, err
+	}
+
+	pid, err := d.core.RequestPool(r.AddressSpace, r.Pool, r.SubPool, r.Options)
+	if err != nil {
+		d.log.WithError(err).Error("failed to request pool")
+		return nil, err
+	}
+
+	rpr := &gphipam.RequestPoolResponse{
+		PoolID: pid,
+		Pool:   r.Pool,
+	}
+
+	return rpr, nil
+}
+
+// ReleasePool releases the pool from the registry and clears the network resource cache from core
+func (d *Driver) ReleasePool(r *gphipam.ReleasePoolRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("ReleasePool()")
+	defer d.core.Watch("ReleasePool")()
+	d.core.ReleasePool(r.PoolID)
+	return nil
+}
+
+// RequestAddress calls the core function to connect and get an available address
+func (d *Driver) RequestAddress(r *gphipam.RequestAddressRequest) (*gphipam.RequestAddressResponse, error) {
+	d.log.WithField("r", options.Mask(r)).Debug("RequestAddress()")
+	defer d.core.Watch("RequestAddress")()
+
+	// Always respond with the gateway address if specified
+	// This is called on network create, and network create will fail if this returns an error
+	if r.Options["RequestAddressType"] == "com.docker.network.gateway" {
+		gw, err := d.core.PoolGateway(r.PoolID)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case gw != nil && r.Address != "" && !net.ParseIP(r.Address).Equal(gw.IP):
+			return nil, fmt.Errorf("gateway %v does not match the gateway offset of the pool, %v", r.Address, gw.IP)
+		case gw == nil && r.Address != "":
+			// an unknown pool or one without a gateway offset, the requested gateway is taken as is
+			gw, err = core.IPNetFromReqInfo(r.PoolID, r.Address)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if gw != nil {
+			return &gphipam.RequestAddressResponse{
+				Address: gw.String(),
+			}, nil
+		}
+	}
+
+	ctx, cancel := d.core.Deadline()
+	defer cancel()
+	var mac net.HardwareAddr
+	if m := r.Options[macAddressKey]; m != "" {
+		var err error
+		mac, err = net.ParseMAC(m)
+		if err != nil {
+			d.log.WithField("mac", m).WithError(err).Warn("ignoring invalid endpoint mac")
+		}
+	}
+	start := time.Now()
+	addr, err := d.core.ConnectAndGetAddress(ctx, r.Address, r.PoolID, mac)
+	e := &alloclog.Entry{Op: alloclog.OpRequest, Pool: r.PoolID, Requested: r.Address, MAC: mac.String()}
+	if addr != nil {
+		e.Address = addr.IP.String()
+	}
+	d.core.LogAllocation(e, start, err)
+	if err != nil {
+		log := log.WithField("r.Address", r.Address).WithField("r.PoolID", r.PoolID).WithError(err)
+		switch {
+		case errors.Is(err, vxrerrors.ErrExhausted), errors.Is(err, vxrerrors.ErrConflict):
+			log.Warn("failed to get address")
+		default:
+			log.Error("failed to get address")
+		}
+		return nil, err
+	}
+
+	rar := &gphipam.RequestAddressResponse{
+		Address: addr.String(),
+	}
+
+	return rar, nil
+}
+
+// ReleaseAddress deletes the route, neighbor and forwarding entries of the address,
+// and the host interface once nothing uses it
+func (d *Driver) ReleaseAddress(r *gphipam.ReleaseAddressRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("ReleaseAddress()")
+	defer d.core.Watch("ReleaseAddress")()
+
+	start := time.Now()
+	ep := d.core.LeasedEndpoint(r.Address)
+	err := d.core.DeleteRoute(r.Address)
+	d.core.LogAllocation(&alloclog.Entry{Op: alloclog.OpRelease, Pool: r.PoolID, Address: r.Address, Endpoint: ep}, start, err)
+	return err
+}
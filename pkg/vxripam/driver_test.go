@@ -0,0 +1,150 @@
+package vxripam
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	gphipam "github.com/docker/go-plugins-helpers/ipam"
+
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+)
+
+// fakeDocker serves the network list of the docker api, with networks, until the returned func is called
+func fakeDocker(t *testing.T, networks ...types.NetworkResource) func() {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/networks") {
+			http.NotFound(w, r)
+			return
+		}
+		if networks == nil {
+			networks = []types.NetworkResource{}
+		}
+		json.NewEncoder(w).Encode(networks) // nolint: errcheck
+	}))
+	host := os.Getenv("DOCKER_HOST")
+	if err := os.Setenv("DOCKER_HOST", "tcp://"+srv.Listener.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		srv.Close()
+		os.Setenv("DOCKER_HOST", host) // nolint: errcheck
+	}
+}
+
+func newDriver(t *testing.T) *Driver {
+	c, err := core.New(core.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDriver(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestRequestPoolAutomatic(t *testing.T) {
+	d := newDriver(t)
+	_, err := d.RequestPool(&gphipam.RequestPoolRequest{})
+	if err == nil || !strings.Contains(err.Error(), "--ipv4=false") {
+		t.Errorf("automatic ipv4 pool request returned %v, want how to create an ipv6 only network", err)
+	}
+	_, err = d.RequestPool(&gphipam.RequestPoolRequest{V6: true})
+	if err == nil || strings.Contains(err.Error(), "--ipv4=false") {
+		t.Errorf("automatic ipv6 pool request returned %v", err)
+	}
+}
+
+func TestRequestPoolIPv6Only(t *testing.T) {
+	defer fakeDocker(t, types.NetworkResource{
+		Name:   "existing",
+		Driver: "vxrNet",
+		IPAM:   network.IPAM{Driver: DriverName, Config: []network.IPAMConfig{{Subnet: "fd00:2::/64", Gateway: "fd00:2::1"}}},
+	})()
+	d := newDriver(t)
+
+	r, err := d.RequestPool(&gphipam.RequestPoolRequest{Pool: "fd00:1::/64", V6: true, Options: map[string]string{"gatewayoffset": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Pool != "fd00:1::/64" || !strings.HasSuffix(r.PoolID, "/fd00:1::/64") {
+		t.Errorf("pool %v id %v, want fd00:1::/64", r.Pool, r.PoolID)
+	}
+
+	// the gateway of the ipv6 pool is given by its offset
+	ar, err := d.RequestAddress(&gphipam.RequestAddressRequest{PoolID: r.PoolID, Options: map[string]string{"RequestAddressType": "com.docker.network.gateway"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Address != "fd00:1::1/64" {
+		t.Errorf("gateway is %v, want fd00:1::1/64", ar.Address)
+	}
+	_, err = d.RequestAddress(&gphipam.RequestAddressRequest{PoolID: r.PoolID, Address: "fd00:1::2", Options: map[string]string{"RequestAddressType": "com.docker.network.gateway"}})
+	if err == nil {
+		t.Error("gateway other than the offset accepted")
+	}
+
+	for _, p := range []string{"fd00:1::/48", "fd00:1::80/121", "fd00:2::/48", "fd00:2::80/121"} {
+		if _, err = d.RequestPool(&gphipam.RequestPoolRequest{Pool: p, V6: true}); err == nil {
+			t.Errorf("overlapping pool %v accepted", p)
+		}
+	}
+
+	// the same pools, eg. requested by swarm again when the network is created locally, get their ids
+	for _, p := range []string{"fd00:1::/64", "fd00:2::/64"} {
+		rr, err := d.RequestPool(&gphipam.RequestPoolRequest{Pool: p, V6: true})
+		if err != nil {
+			t.Errorf("pool %v requested again: %v", p, err)
+			continue
+		}
+		if want := "vxrLocal/" + p; rr.PoolID != want {
+			t.Errorf("pool %v requested again has id %v, want %v", p, rr.PoolID, want)
+		}
+	}
+	if _, err = d.RequestPool(&gphipam.RequestPoolRequest{Pool: "fd00:1::/64", SubPool: "fd00:1::80/121", V6: true}); err == nil {
+		t.Error("pool requested again with another sub-pool accepted")
+	}
+
+	// requested twice, the pool is in use until it is released twice
+	if err = d.ReleasePool(&gphipam.ReleasePoolRequest{PoolID: r.PoolID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.RequestPool(&gphipam.RequestPoolRequest{Pool: "fd00:1::/48", V6: true}); err == nil {
+		t.Error("overlapping pool accepted after the pool was released once of twice")
+	}
+	if err = d.ReleasePool(&gphipam.ReleasePoolRequest{PoolID: r.PoolID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.RequestPool(&gphipam.RequestPoolRequest{Pool: "fd00:1::/48", V6: true}); err != nil {
+		t.Errorf("pool overlapping a released pool refused: %v", err)
+	}
+}
+
+func TestRequestGatewayIPv6(t *testing.T) {
+	defer fakeDocker(t)()
+	d := newDriver(t)
+
+	r, err := d.RequestPool(&gphipam.RequestPoolRequest{Pool: "fd00:3::/64", SubPool: "fd00:3::100/120", V6: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a gateway offset, the gateway docker asks for is handed back in the pool
+	ar, err := d.RequestAddress(&gphipam.RequestAddressRequest{PoolID: r.PoolID, Address: "fd00:3::1", Options: map[string]string{"RequestAddressType": "com.docker.network.gateway"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ar.Address != "fd00:3::1/64" {
+		t.Errorf("gateway is %v, want fd00:3::1/64", ar.Address)
+	}
+
+	// an ipv4 pool next to it does not overlap
+	if _, err = d.RequestPool(&gphipam.RequestPoolRequest{Pool: "10.3.0.0/16"}); err != nil {
+		t.Errorf("ipv4 pool beside an ipv6 pool refused: %v", err)
+	}
+}
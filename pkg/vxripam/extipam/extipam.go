@@ -0,0 +1,212 @@
+// Package extipam defers address allocation to an external IPAM system, NetBox or phpIPAM,
+// through its REST api. The external system reserves the next free address of the prefix
+// mapped to a pool, and the address is released there when the container is torn down.
+// Routes and vxlan plumbing are still handled locally.
+package extipam
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+)
+
+// AllocatorName is the allocator which takes addresses from the external IPAM
+const AllocatorName = "extipam"
+
+// requestTimeout bounds each request to the external IPAM
+const requestTimeout = 10 * time.Second
+
+// Client reserves and releases addresses in an external IPAM
+type Client interface {
+	// Next reserves and returns the next free address of the prefix of pool
+	Next(ctx context.Context, pool *net.IPNet) (net.IP, error)
+	// Release frees a reserved address
+	Release(ctx context.Context, ip net.IP) error
+}
+
+// New creates a client for the IPAM kind, netbox or phpipam, at url, authenticated with token.
+// prefixes maps pools to the prefix (NetBox) or subnet (phpIPAM) ids they are reserved in,
+// pools not mapped are looked up by their cidr.
+func New(kind, url, token string, prefixes map[string]string) (Client, error) {
+	if url == "" {
+		return nil, fmt.Errorf("the external ipam requires a url")
+	}
+	p := &prefixIDs{m: make(map[string]string, len(prefixes))}
+	for k, v := range prefixes {
+		_, sn, err := net.ParseCIDR(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix mapping %v=%v: %v", k, v, err)
+		}
+		p.m[sn.String()] = v
+	}
+	hc := &http.Client{Timeout: requestTimeout}
+	url = strings.TrimRight(url, "/")
+
+	switch strings.ToLower(kind) {
+	case "netbox":
+		return &netbox{url: url, token: token, hc: hc, ids: p}, nil
+	case "phpipam":
+		return &phpipam{url: url, token: token, hc: hc, ids: p}, nil
+	}
+	return nil, fmt.Errorf("unknown external ipam %q, must be netbox or phpipam", kind)
+}
+
+// ParsePrefixes parses pool=id prefix mappings
+func ParsePrefixes(ss []string) (map[string]string, error) {
+	ret := make(map[string]string, len(ss))
+	for _, s := range ss {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid prefix mapping %q, must be pool=id", s)
+		}
+		if _, _, err := net.ParseCIDR(kv[0]); err != nil {
+			return nil, fmt.Errorf("invalid prefix mapping %q: %v", s, err)
+		}
+		ret[kv[0]] = kv[1]
+	}
+	return ret, nil
+}
+
+// Allocator takes the candidates of address selection from the external IPAM
+type Allocator struct {
+	Client Client
+
+	l        sync.Mutex
+	reserved map[string]struct{}
+}
+
+// Candidate is not used, address selection reserves candidates with Reserve
+func (a *Allocator) Candidate(n *net.IPNet, xf, xl int) (net.IP, error) {
+	return nil, fmt.Errorf("the %v allocator only reserves candidates", AllocatorName)
+}
+
+// Reserve reserves the next free address of n in the external IPAM. The IPAM offers its first
+// free address, excluded ones it offers are kept reserved so they are not offered again.
+func (a *Allocator) Reserve(ctx context.Context, n *net.IPNet, xf, xl int) (net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	for i := 0; i <= xf+xl; i++ {
+		ip, err := a.Client.Next(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		if !n.Contains(ip) {
+			if err = a.Client.Release(ctx, ip); err != nil {
+				log.WithField("ip", ip).WithError(err).Error("failed to release address in external ipam")
+			}
+			return nil, fmt.Errorf("external ipam offered %v, which is not an address of %v", ip, n)
+		}
+		if excluded(n, ip, xf, xl) {
+			log.WithField("ip", ip).WithField("subnet", n).Warn("external ipam offered an excluded address, keeping it reserved")
+			continue
+		}
+		a.l.Lock()
+		if a.reserved == nil {
+			a.reserved = make(map[string]struct{})
+		}
+		a.reserved[ip.String()] = struct{}{}
+		a.l.Unlock()
+		return ip, nil
+	}
+	return nil, fmt.Errorf("external ipam offered only excluded addresses of %v", n)
+}
+
+// Release frees a candidate which is not used in the external IPAM
+func (a *Allocator) Release(ctx context.Context, ip net.IP) error {
+	if !a.Reserved(ip) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	err := a.Client.Release(ctx, ip)
+	if err != nil {
+		return err
+	}
+	a.l.Lock()
+	delete(a.reserved, ip.String())
+	a.l.Unlock()
+	return nil
+}
+
+// Reserved reports whether ip was reserved by Reserve and not released
+func (a *Allocator) Reserved(ip net.IP) bool {
+	a.l.Lock()
+	defer a.l.Unlock()
+	_, ok := a.reserved[ip.String()]
+	return ok
+}
+
+// excluded reports whether ip is one of the first xf or last xl addresses of n
+func excluded(n *net.IPNet, ip net.IP, xf, xl int) bool {
+	base := n.IP.Mask(n.Mask)
+	if len(base) == net.IPv4len {
+		ip = ip.To4()
+	}
+	i := new(big.Int).Sub(new(big.Int).SetBytes(ip), new(big.Int).SetBytes(base))
+	ones, bits := n.Mask.Size()
+	left := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)), i)
+	return i.Cmp(big.NewInt(int64(xf))) < 0 || left.Cmp(big.NewInt(int64(xl))) <= 0
+}
+
+func init() {
+	allocator.Register(AllocatorName, func(cfg *allocator.Config) (allocator.Allocator, error) {
+		if cfg == nil || cfg.ExternalIPAM == nil {
+			return nil, fmt.Errorf("the %v allocator requires an external ipam", AllocatorName)
+		}
+		return &Allocator{Client: cfg.ExternalIPAM}, nil
+	})
+}
+
+// prefixIDs are the ids of the IPAM prefixes of pools, mapped or looked up
+type prefixIDs struct {
+	l sync.Mutex
+	m map[string]string
+}
+
+// get returns the id of the longest known prefix containing pool, else looks up that of pool with lookup
+func (p *prefixIDs) get(ctx context.Context, pool *net.IPNet, lookup func(context.Context, *net.IPNet) (string, error)) (string, error) {
+	ones, _ := pool.Mask.Size()
+	if id, ok := p.longest(pool.IP, ones); ok {
+		return id, nil
+	}
+
+	id, err := lookup(ctx, pool)
+	if err != nil {
+		return "", err
+	}
+	p.l.Lock()
+	p.m[pool.String()] = id
+	p.l.Unlock()
+	return id, nil
+}
+
+// containing returns the id of the longest known prefix containing ip
+func (p *prefixIDs) containing(ip net.IP) (string, bool) {
+	return p.longest(ip, 8*len(ip))
+}
+
+// longest returns the id of the longest known prefix containing ip, no longer than maxOnes
+func (p *prefixIDs) longest(ip net.IP, maxOnes int) (string, bool) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	id, best := "", -1
+	for k, v := range p.m {
+		_, sn, err := net.ParseCIDR(k)
+		if err != nil || !sn.Contains(ip) {
+			continue
+		}
+		if ones, _ := sn.Mask.Size(); ones <= maxOnes && ones > best {
+			id, best = v, ones
+		}
+	}
+	return id, best >= 0
+}
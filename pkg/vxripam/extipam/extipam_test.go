@@ -0,0 +1,177 @@
+package extipam
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/TrilliumIT/vxrouter/pkg/allocator"
+)
+
+// fakeIPAM offers its free addresses in order, like the first free address of an IPAM
+type fakeIPAM struct {
+	free     []string
+	reserved map[string]bool
+	released []string
+}
+
+func newFakeIPAM(free ...string) *fakeIPAM {
+	return &fakeIPAM{free: free, reserved: make(map[string]bool)}
+}
+
+func (f *fakeIPAM) Next(ctx context.Context, pool *net.IPNet) (net.IP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, a := range f.free {
+		if !f.reserved[a] {
+			f.reserved[a] = true
+			return net.ParseIP(a), nil
+		}
+	}
+	return nil, context.DeadlineExceeded
+}
+
+func (f *fakeIPAM) Release(ctx context.Context, ip net.IP) error {
+	delete(f.reserved, ip.String())
+	f.released = append(f.released, ip.String())
+	return nil
+}
+
+func newAllocator(t *testing.T, c Client) *Allocator {
+	a, err := allocator.New(AllocatorName, &allocator.Config{ExternalIPAM: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.(*Allocator)
+}
+
+func TestRequiresExternalIPAM(t *testing.T) {
+	if _, err := allocator.New(AllocatorName, &allocator.Config{}); err == nil {
+		t.Error("allocator created without an external ipam")
+	}
+}
+
+func TestReserveExclusions(t *testing.T) {
+	_, sn, _ := net.ParseCIDR("10.1.2.0/29")
+	tests := []struct {
+		name   string
+		free   []string
+		xf, xl int
+		want   string
+		held   []string
+	}{
+		{"network and gateway", []string{"10.1.2.0", "10.1.2.1", "10.1.2.2"}, 2, 1, "10.1.2.2", []string{"10.1.2.0", "10.1.2.1"}},
+		{"broadcast", []string{"10.1.2.7", "10.1.2.3"}, 1, 1, "10.1.2.3", []string{"10.1.2.7"}},
+		{"none excluded", []string{"10.1.2.0"}, 0, 0, "10.1.2.0", nil},
+		{"only excluded", []string{"10.1.2.0", "10.1.2.7"}, 1, 1, "", []string{"10.1.2.0", "10.1.2.7"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeIPAM(tt.free...)
+			a := newAllocator(t, f)
+			ip, err := a.Reserve(context.Background(), sn, tt.xf, tt.xl)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("reserved %v, want an error", ip)
+				}
+			} else if err != nil || ip.String() != tt.want {
+				t.Fatalf("reserved %v (%v), want %v", ip, err, tt.want)
+			}
+			for _, h := range tt.held {
+				if !f.reserved[h] || a.Reserved(net.ParseIP(h)) {
+					t.Errorf("excluded %v is not held in the ipam, or was handed out", h)
+				}
+			}
+			if len(f.released) > 0 {
+				t.Errorf("released %v", f.released)
+			}
+		})
+	}
+}
+
+func TestReserveOutsideSubnet(t *testing.T) {
+	_, block, _ := net.ParseCIDR("10.1.2.16/28")
+	f := newFakeIPAM("10.1.2.2")
+	a := newAllocator(t, f)
+	if ip, err := a.Reserve(context.Background(), block, 0, 0); err == nil {
+		t.Fatalf("reserved %v outside of %v", ip, block)
+	}
+	if len(f.released) != 1 || f.reserved["10.1.2.2"] {
+		t.Errorf("address outside of the block was not released, released %v", f.released)
+	}
+}
+
+func TestReserveContext(t *testing.T) {
+	_, sn, _ := net.ParseCIDR("10.1.2.0/24")
+	a := newAllocator(t, newFakeIPAM("10.1.2.5"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := a.Reserve(ctx, sn, 0, 0); err != context.Canceled {
+		t.Errorf("reserve with a canceled request returned %v", err)
+	}
+}
+
+func TestReleaseOnlyReserved(t *testing.T) {
+	_, sn, _ := net.ParseCIDR("10.1.2.0/24")
+	f := newFakeIPAM("10.1.2.5")
+	a := newAllocator(t, f)
+	ip, err := a.Reserve(context.Background(), sn, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Reserved(ip) {
+		t.Fatalf("%v is not reserved", ip)
+	}
+
+	// addresses the allocator did not reserve are left alone
+	if err = a.Release(context.Background(), net.ParseIP("10.1.2.9")); err != nil || len(f.released) != 0 {
+		t.Fatalf("released %v (%v), want nothing", f.released, err)
+	}
+	if err = a.Release(context.Background(), ip); err != nil {
+		t.Fatal(err)
+	}
+	if a.Reserved(ip) || len(f.released) != 1 || f.released[0] != ip.String() {
+		t.Errorf("released %v, want %v", f.released, ip)
+	}
+	// released once only
+	if err = a.Release(context.Background(), ip); err != nil || len(f.released) != 1 {
+		t.Errorf("released %v (%v) twice", ip, err)
+	}
+}
+
+func TestPrefixIDsLongest(t *testing.T) {
+	p := &prefixIDs{m: map[string]string{
+		"10.0.0.0/8":  "8",
+		"10.1.0.0/16": "16",
+		"fd00::/48":   "48",
+		"fd00::/64":   "64",
+	}}
+	notFound := func(context.Context, *net.IPNet) (string, error) { return "lookup", nil }
+	tests := []struct {
+		pool string
+		want string
+	}{
+		{"10.1.2.0/24", "16"},
+		{"10.1.0.0/16", "16"},
+		{"10.0.0.0/8", "8"},
+		{"10.2.0.0/16", "8"},
+		{"fd00::/64", "64"},
+		{"fd00:0:0:1::/64", "48"},
+		{"192.168.0.0/24", "lookup"},
+	}
+	for _, tt := range tests {
+		_, pool, _ := net.ParseCIDR(tt.pool)
+		id, err := p.get(context.Background(), pool, notFound)
+		if err != nil || id != tt.want {
+			t.Errorf("prefix of %v is %v (%v), want %v", tt.pool, id, err, tt.want)
+		}
+	}
+
+	for ip, want := range map[string]string{"10.1.2.3": "16", "10.9.0.1": "8", "fd00::1": "64"} {
+		if id, ok := p.containing(net.ParseIP(ip)); !ok || id != want {
+			t.Errorf("prefix containing %v is %v, want %v", ip, id, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package extipam
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/context"
+)
+
+// netbox reserves addresses as ip address objects created from the available ips of a prefix
+type netbox struct {
+	url   string
+	token string
+	hc    *http.Client
+	ids   *prefixIDs
+}
+
+// netboxDescription marks the ip addresses created by vxrouter, only those are released
+const netboxDescription = "vxrouter"
+
+type netboxObject struct {
+	ID          int    `json:"id"`
+	Address     string `json:"address"`
+	Description string `json:"description"`
+}
+
+type netboxList struct {
+	Results []netboxObject `json:"results"`
+}
+
+func (n *netbox) do(ctx context.Context, method, path string, body, resp interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, n.url+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Token "+n.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	hresp, err := n.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close() // nolint: errcheck
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return fmt.Errorf("netbox %v %v returned %v", method, path, hresp.Status)
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.NewDecoder(hresp.Body).Decode(resp)
+}
+
+func (n *netbox) lookup(ctx context.Context, pool *net.IPNet) (string, error) {
+	var l netboxList
+	err := n.do(ctx, http.MethodGet, "/api/ipam/prefixes/?prefix="+url.QueryEscape(pool.String()), nil, &l)
+	if err != nil {
+		return "", err
+	}
+	if len(l.Results) == 0 {
+		return "", fmt.Errorf("netbox has no prefix %v", pool)
+	}
+	return strconv.Itoa(l.Results[0].ID), nil
+}
+
+// Next creates an ip address from the available ips of the prefix of pool
+func (n *netbox) Next(ctx context.Context, pool *net.IPNet) (net.IP, error) {
+	id, err := n.ids.get(ctx, pool, n.lookup)
+	if err != nil {
+		return nil, err
+	}
+	var o netboxObject
+	err = n.do(ctx, http.MethodPost, "/api/ipam/prefixes/"+id+"/available-ips/", map[string]string{"description": netboxDescription}, &o)
+	if err != nil {
+		return nil, err
+	}
+	ip, _, err := net.ParseCIDR(o.Address)
+	if err != nil {
+		return nil, fmt.Errorf("netbox returned invalid address %q: %v", o.Address, err)
+	}
+	return ip, nil
+}
+
+// Release deletes the ip address objects of ip created by vxrouter
+func (n *netbox) Release(ctx context.Context, ip net.IP) error {
+	var l netboxList
+	err := n.do(ctx, http.MethodGet, "/api/ipam/ip-addresses/?address="+url.QueryEscape(ip.String()), nil, &l)
+	if err != nil {
+		return err
+	}
+	for _, o := range l.Results {
+		if o.Description != netboxDescription {
+			continue
+		}
+		err = n.do(ctx, http.MethodDelete, "/api/ipam/ip-addresses/"+strconv.Itoa(o.ID)+"/", nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
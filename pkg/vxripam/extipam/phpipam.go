@@ -0,0 +1,92 @@
+package extipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// phpipam reserves addresses with the first free address of a subnet. The url is that of
+// the api application, eg. https://ipam.example.com/api/vxrouter, and token an app token.
+type phpipam struct {
+	url   string
+	token string
+	hc    *http.Client
+	ids   *prefixIDs
+}
+
+type phpipamResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func (p *phpipam) do(ctx context.Context, method, path string, data interface{}) error {
+	req, err := http.NewRequest(method, p.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("token", p.token)
+	hresp, err := p.hc.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close() // nolint: errcheck
+
+	var r phpipamResponse
+	err = json.NewDecoder(hresp.Body).Decode(&r)
+	if err != nil {
+		return fmt.Errorf("phpipam %v %v returned %v", method, path, hresp.Status)
+	}
+	if !r.Success || hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return fmt.Errorf("phpipam %v %v returned %v: %v", method, path, hresp.Status, r.Message)
+	}
+	if data == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Data, data)
+}
+
+func (p *phpipam) lookup(ctx context.Context, pool *net.IPNet) (string, error) {
+	var subnets []struct {
+		ID string `json:"id"`
+	}
+	err := p.do(ctx, http.MethodGet, "/subnets/cidr/"+pool.String()+"/", &subnets)
+	if err != nil {
+		return "", err
+	}
+	if len(subnets) == 0 {
+		return "", fmt.Errorf("phpipam has no subnet %v", pool)
+	}
+	return subnets[0].ID, nil
+}
+
+// Next reserves the first free address of the subnet of pool
+func (p *phpipam) Next(ctx context.Context, pool *net.IPNet) (net.IP, error) {
+	id, err := p.ids.get(ctx, pool, p.lookup)
+	if err != nil {
+		return nil, err
+	}
+	var a string
+	err = p.do(ctx, http.MethodPost, "/addresses/first_free/"+id+"/", &a)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(a)
+	if ip == nil {
+		return nil, fmt.Errorf("phpipam returned invalid address %q", a)
+	}
+	return ip, nil
+}
+
+// Release deletes ip from the subnet it was reserved in
+func (p *phpipam) Release(ctx context.Context, ip net.IP) error {
+	id, ok := p.ids.containing(ip)
+	if !ok {
+		return fmt.Errorf("no phpipam subnet known for %v", ip)
+	}
+	return p.do(ctx, http.MethodDelete, "/addresses/"+ip.String()+"/"+id+"/", nil)
+}
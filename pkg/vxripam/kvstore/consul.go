@@ -0,0 +1,113 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// consulMinTTL is the shortest session ttl consul accepts
+const consulMinTTL = 10 * time.Second
+
+// consul takes locks as keys acquired by a session, which deletes them when it expires
+type consul struct {
+	addr   string
+	prefix string
+	hc     *http.Client
+}
+
+// errNotFound is returned by do for a key which does not exist
+var errNotFound = fmt.Errorf("not found")
+
+func (c *consul) do(ctx context.Context, method, path string, body []byte, resp interface{}) error {
+	hr, err := http.NewRequest(method, c.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	hresp, err := c.hc.Do(hr.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close() // nolint: errcheck
+	if hresp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if hresp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul %v returned %v", path, hresp.Status)
+	}
+	return json.NewDecoder(hresp.Body).Decode(resp)
+}
+
+func (c *consul) put(ctx context.Context, path string, body []byte, resp interface{}) error {
+	return c.do(ctx, http.MethodPut, path, body, resp)
+}
+
+// holder returns the value of key and the session holding it, empty if the key does not exist
+func (c *consul) holder(ctx context.Context, key string) (string, string, error) {
+	var kvs []struct {
+		Value   string `json:"Value"`
+		Session string `json:"Session"`
+	}
+	err := c.do(ctx, http.MethodGet, "/v1/kv/"+c.prefix+"/"+key, nil, &kvs)
+	if err == errNotFound || err == nil && len(kvs) == 0 {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	v, err := base64.StdEncoding.DecodeString(kvs[0].Value)
+	return string(v), kvs[0].Session, err
+}
+
+// Lock implements Locker
+func (c *consul) Lock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	if ttl < consulMinTTL {
+		ttl = consulMinTTL
+	}
+	sreq, err := json.Marshal(map[string]interface{}{
+		"Name":      "vxrouter " + owner,
+		"TTL":       ttl.String(),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	})
+	if err != nil {
+		return false, err
+	}
+	var session struct {
+		ID string `json:"ID"`
+	}
+	err = c.put(ctx, "/v1/session/create", sreq, &session)
+	if err != nil {
+		return false, err
+	}
+
+	var ok bool
+	err = c.put(ctx, "/v1/kv/"+c.prefix+"/"+key+"?acquire="+session.ID, []byte(owner), &ok)
+	if err != nil || !ok {
+		var destroyed bool
+		c.put(ctx, "/v1/session/destroy/"+session.ID, nil, &destroyed) // nolint: errcheck
+	}
+	if err != nil || ok {
+		return ok, err
+	}
+
+	// the lock may already be ours, held by an earlier session
+	v, _, err := c.holder(ctx, key)
+	return err == nil && v == owner, err
+}
+
+// Unlock implements Locker, destroying the session holding the key if owner holds it,
+// which deletes the key
+func (c *consul) Unlock(ctx context.Context, key, owner string) error {
+	v, session, err := c.holder(ctx, key)
+	if err != nil || v != owner || session == "" {
+		return err
+	}
+	var destroyed bool
+	return c.put(ctx, "/v1/session/destroy/"+session, nil, &destroyed)
+}
@@ -0,0 +1,116 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// etcd takes locks through the json gateway of the etcd v3 api, as a key
+// created in a transaction only if it does not exist, attached to a lease
+type etcd struct {
+	addr   string
+	prefix string
+	hc     *http.Client
+}
+
+func (e *etcd) post(ctx context.Context, path string, req, resp interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	hr, err := http.NewRequest(http.MethodPost, e.addr+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	hresp, err := e.hc.Do(hr.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close() // nolint: errcheck
+	if hresp.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %v returned %v", path, hresp.Status)
+	}
+	return json.NewDecoder(hresp.Body).Decode(resp)
+}
+
+// b64 encodes the keys and values of the json gateway
+func b64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// Lock implements Locker
+func (e *etcd) Lock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	k := b64(e.prefix + "/" + key)
+
+	secs := int64(ttl / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	var lease struct {
+		ID string `json:"ID"`
+	}
+	err := e.post(ctx, "/v3/lease/grant", map[string]interface{}{"TTL": secs}, &lease)
+	if err != nil {
+		return false, err
+	}
+
+	txn := map[string]interface{}{
+		"compare": []map[string]interface{}{{
+			"key": k, "target": "CREATE", "result": "EQUAL", "create_revision": "0",
+		}},
+		"success": []map[string]interface{}{{
+			"request_put": map[string]interface{}{"key": k, "value": b64(owner), "lease": lease.ID},
+		}},
+		"failure": []map[string]interface{}{{
+			"request_range": map[string]interface{}{"key": k},
+		}},
+	}
+	var resp struct {
+		Succeeded bool `json:"succeeded"`
+		Responses []struct {
+			ResponseRange struct {
+				Kvs []struct {
+					Value string `json:"value"`
+				} `json:"kvs"`
+			} `json:"response_range"`
+		} `json:"responses"`
+	}
+	err = e.post(ctx, "/v3/kv/txn", txn, &resp)
+	if err != nil {
+		return false, err
+	}
+	if resp.Succeeded {
+		return true, nil
+	}
+
+	// the lease is not needed, and the lock may already be ours
+	e.post(ctx, "/v3/lease/revoke", map[string]interface{}{"ID": lease.ID}, &struct{}{}) // nolint: errcheck
+	for _, r := range resp.Responses {
+		for _, kv := range r.ResponseRange.Kvs {
+			if kv.Value == b64(owner) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Unlock implements Locker, deleting the key in a transaction only if owner holds it
+func (e *etcd) Unlock(ctx context.Context, key, owner string) error {
+	k := b64(e.prefix + "/" + key)
+	txn := map[string]interface{}{
+		"compare": []map[string]interface{}{{
+			"key": k, "target": "VALUE", "result": "EQUAL", "value": b64(owner),
+		}},
+		"success": []map[string]interface{}{{
+			"request_delete_range": map[string]interface{}{"key": k},
+		}},
+	}
+	return e.post(ctx, "/v3/kv/txn", txn, &struct{}{})
+}
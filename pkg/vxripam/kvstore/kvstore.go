@@ -0,0 +1,60 @@
+// Package kvstore coordinates address allocation between hosts through etcd or consul.
+// Before a host installs the route of an address it selected, it takes a short lived lock
+// on the address, so two hosts never race for it within the route propagation window.
+package kvstore
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// requestTimeout bounds each request to the kv store, on top of the caller's context
+const requestTimeout = 5 * time.Second
+
+// Locker takes short lived locks in a kv store
+type Locker interface {
+	// Lock takes a lock on key held by owner, which expires after ttl.
+	// It returns false if the lock is held by another owner.
+	Lock(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Unlock releases the lock on key if it is held by owner, before it expires
+	Unlock(ctx context.Context, key, owner string) error
+}
+
+// New returns a Locker for the store at u, etcd://host:port[/prefix] or consul://host:port[/prefix].
+// Use etcds:// or consuls:// for https. Keys are placed under prefix, vxrouter by default.
+func New(u string) (Locker, error) {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if pu.Host == "" {
+		return nil, fmt.Errorf("kv store %q has no host", u)
+	}
+	prefix := strings.Trim(pu.Path, "/")
+	if prefix == "" {
+		prefix = "vxrouter"
+	}
+	hc := &http.Client{Timeout: requestTimeout}
+
+	switch pu.Scheme {
+	case "etcd":
+		return &etcd{"http://" + pu.Host, prefix, hc}, nil
+	case "etcds":
+		return &etcd{"https://" + pu.Host, prefix, hc}, nil
+	case "consul":
+		return &consul{"http://" + pu.Host, prefix, hc}, nil
+	case "consuls":
+		return &consul{"https://" + pu.Host, prefix, hc}, nil
+	}
+	return nil, fmt.Errorf("unknown kv store %q, must be etcd or consul", pu.Scheme)
+}
+
+// AddressKey returns the key of an address in a pool
+func AddressKey(pool, address string) string {
+	return "allocations/" + strings.Replace(pool, "/", "_", -1) + "/" + address
+}
@@ -0,0 +1,286 @@
+package kvstore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// fakeEtcd serves the lease and transaction requests of the etcd v3 json gateway used by etcd.Lock
+type fakeEtcd struct {
+	l       sync.Mutex
+	kvs     map[string]string
+	leases  int
+	revoked int
+}
+
+func (f *fakeEtcd) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	var req struct {
+		TTL     int64 `json:"TTL"`
+		Compare []struct {
+			Key    string `json:"key"`
+			Target string `json:"target"`
+			Value  string `json:"value"`
+		} `json:"compare"`
+		Success []struct {
+			RequestPut struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+				Lease string `json:"lease"`
+			} `json:"request_put"`
+		} `json:"success"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.URL.Path {
+	case "/v3/lease/grant":
+		if req.TTL < 1 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		f.leases++
+		json.NewEncoder(w).Encode(map[string]interface{}{"ID": "1", "TTL": req.TTL}) // nolint: errcheck
+	case "/v3/lease/revoke":
+		f.revoked++
+		w.Write([]byte("{}")) // nolint: errcheck
+	case "/v3/kv/txn":
+		k := req.Compare[0].Key
+		if req.Compare[0].Target == "VALUE" {
+			// unlock
+			if f.kvs[k] == req.Compare[0].Value {
+				delete(f.kvs, k)
+			}
+			w.Write([]byte("{}")) // nolint: errcheck
+			return
+		}
+		if v, ok := f.kvs[k]; ok {
+			json.NewEncoder(w).Encode(map[string]interface{}{ // nolint: errcheck
+				"responses": []interface{}{map[string]interface{}{
+					"response_range": map[string]interface{}{"kvs": []interface{}{map[string]interface{}{"key": k, "value": v}}},
+				}},
+			})
+			return
+		}
+		f.kvs[k] = req.Success[0].RequestPut.Value
+		json.NewEncoder(w).Encode(map[string]interface{}{"succeeded": true}) // nolint: errcheck
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeConsul serves the session and kv requests used by consul.Lock and Unlock
+type fakeConsul struct {
+	l         sync.Mutex
+	sessions  int
+	destroyed int
+	// held are the sessions holding keys, values their values
+	held   map[string]string
+	values map[string]string
+}
+
+func (f *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.l.Lock()
+	defer f.l.Unlock()
+	if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/kv/") {
+		k := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		s, ok := f.held[k]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]interface{}{ // nolint: errcheck
+			{"Key": k, "Value": base64.StdEncoding.EncodeToString([]byte(f.values[k])), "Session": s},
+		})
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		var req struct {
+			TTL string `json:"TTL"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if d, err := time.ParseDuration(req.TTL); err != nil || d < consulMinTTL {
+			http.Error(w, "invalid session ttl", http.StatusBadRequest)
+			return
+		}
+		f.sessions++
+		json.NewEncoder(w).Encode(map[string]string{"ID": strings.Repeat("s", f.sessions)}) // nolint: errcheck
+	case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		f.destroyed++
+		// the keys of the session are deleted with it
+		s := strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/")
+		for k, h := range f.held {
+			if h == s {
+				delete(f.held, k)
+				delete(f.values, k)
+			}
+		}
+		w.Write([]byte("true")) // nolint: errcheck
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		v, _ := ioutil.ReadAll(r.Body) // nolint: errcheck
+		k := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		s := r.URL.Query().Get("acquire")
+		if h, ok := f.held[k]; ok && h != s {
+			w.Write([]byte("false")) // nolint: errcheck
+			return
+		}
+		f.held[k], f.values[k] = s, string(v)
+		w.Write([]byte("true")) // nolint: errcheck
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newLocker(t *testing.T, scheme string, h http.Handler) (Locker, func()) {
+	ts := httptest.NewServer(h)
+	l, err := New(scheme + "://" + strings.TrimPrefix(ts.URL, "http://") + "/test")
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return l, ts.Close
+}
+
+func testLock(t *testing.T, l Locker) {
+	ctx := context.Background()
+	key := AddressKey("10.1.2.0/24", "10.1.2.5")
+	for _, tt := range []struct {
+		owner string
+		want  bool
+	}{
+		{"host1/vxrNet", true},
+		{"host2/vxrNet", false},
+		// another instance on the same host
+		{"host1/vxrNet2", false},
+	} {
+		ok, err := l.Lock(ctx, key, tt.owner, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tt.want {
+			t.Errorf("lock by %v is %v, want %v", tt.owner, ok, tt.want)
+		}
+	}
+	if ok, err := l.Lock(ctx, AddressKey("10.1.2.0/24", "10.1.2.6"), "host2/vxrNet", time.Second); err != nil || !ok {
+		t.Errorf("lock on a free key is %v (%v), want true", ok, err)
+	}
+	// the lock is retaken by its owner
+	if ok, err := l.Lock(ctx, key, "host1/vxrNet", time.Second); err != nil || !ok {
+		t.Errorf("lock retaken by its owner is %v (%v), want true", ok, err)
+	}
+}
+
+func testUnlock(t *testing.T, l Locker) {
+	ctx := context.Background()
+	key := AddressKey("10.1.2.0/24", "10.1.2.7")
+	if ok, err := l.Lock(ctx, key, "host1/vxrNet", time.Second); err != nil || !ok {
+		t.Fatalf("lock on a free key is %v (%v), want true", ok, err)
+	}
+	// only the owner releases the lock
+	if err := l.Unlock(ctx, key, "host2/vxrNet"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := l.Lock(ctx, key, "host2/vxrNet", time.Second); err != nil || ok {
+		t.Errorf("lock released by another owner is %v (%v), want false", ok, err)
+	}
+	if err := l.Unlock(ctx, key, "host1/vxrNet"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := l.Lock(ctx, key, "host2/vxrNet", time.Second); err != nil || !ok {
+		t.Errorf("lock released by its owner is %v (%v), want true", ok, err)
+	}
+	// a key which is not locked is released
+	if err := l.Unlock(ctx, AddressKey("10.1.2.0/24", "10.1.2.8"), "host1/vxrNet"); err != nil {
+		t.Errorf("release of a free key failed: %v", err)
+	}
+}
+
+func TestEtcdLock(t *testing.T) {
+	f := &fakeEtcd{kvs: make(map[string]string)}
+	l, done := newLocker(t, "etcd", f)
+	defer done()
+	testLock(t, l)
+
+	// the leases granted for locks not taken are revoked
+	if f.revoked != f.leases-2 {
+		t.Errorf("%v of %v leases revoked, want all but the 2 held", f.revoked, f.leases)
+	}
+	for k := range f.kvs {
+		dk, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || !strings.HasPrefix(string(dk), "test/allocations/10.1.2.0_24/") {
+			t.Errorf("key %s is not under the prefix", dk)
+		}
+	}
+}
+
+func TestConsulLock(t *testing.T) {
+	f := &fakeConsul{held: make(map[string]string), values: make(map[string]string)}
+	l, done := newLocker(t, "consul", f)
+	defer done()
+	testLock(t, l)
+
+	if f.destroyed != f.sessions-2 {
+		t.Errorf("%v of %v sessions destroyed, want all but the 2 holding locks", f.destroyed, f.sessions)
+	}
+	for k := range f.held {
+		if !strings.HasPrefix(k, "test/allocations/10.1.2.0_24/") {
+			t.Errorf("key %v is not under the prefix", k)
+		}
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	for _, tt := range []struct {
+		scheme string
+		h      http.Handler
+	}{
+		{"etcd", &fakeEtcd{kvs: make(map[string]string)}},
+		{"consul", &fakeConsul{held: make(map[string]string), values: make(map[string]string)}},
+	} {
+		t.Run(tt.scheme, func(t *testing.T) {
+			l, done := newLocker(t, tt.scheme, tt.h)
+			defer done()
+			testUnlock(t, l)
+		})
+	}
+}
+
+func TestLockError(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	for _, scheme := range []string{"etcd", "consul"} {
+		l, done := newLocker(t, scheme, h)
+		if ok, err := l.Lock(context.Background(), "k", "host1/vxrNet", time.Second); err == nil || ok {
+			t.Errorf("%v: lock on a failing store is %v (%v), want an error", scheme, ok, err)
+		}
+		done()
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, u := range []string{"redis://host:1", "etcd://", "etcd://:2379/%zz"} {
+		if _, err := New(u); err == nil {
+			t.Errorf("%v accepted", u)
+		}
+	}
+}
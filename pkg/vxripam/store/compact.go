@@ -0,0 +1,303 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// RepairReport is the outcome of Repair
+type RepairReport struct {
+	// Kept and Dropped are the entries copied to the repaired store and those which could not be read
+	Kept, Dropped int
+	// Reset is set if the store could not be opened at all and was replaced by an empty one
+	Reset bool
+	// Backup is where the store as it was before the repair is kept
+	Backup string
+}
+
+// corruptError is a panic of bolt on a damaged page
+type corruptError struct {
+	v interface{}
+}
+
+func (e corruptError) Error() string {
+	return fmt.Sprintf("corrupted store: %v", e.v)
+}
+
+// safely runs f, returning a panic of bolt on a damaged store as a corruptError
+func safely(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = corruptError{r}
+		}
+	}()
+	return f()
+}
+
+// releasedKey is the key of a released lease in the released bucket
+func releasedKey(l *Lease) []byte {
+	return []byte(l.Address + " " + l.Released.UTC().Format(time.RFC3339Nano))
+}
+
+// validEntry returns an error if the value of key k in bucket b cannot be loaded
+func validEntry(b, k, v []byte) error {
+	switch string(b) {
+	case string(leasesBucket), string(releasedBucket):
+		l := &Lease{}
+		if err := json.Unmarshal(v, l); err != nil {
+			return err
+		}
+		if net.ParseIP(l.Address) == nil {
+			return fmt.Errorf("invalid address %q", l.Address)
+		}
+		if string(b) == string(leasesBucket) && l.Address != string(k) {
+			return fmt.Errorf("lease on %v is stored as %q", l.Address, k)
+		}
+		if string(b) == string(releasedBucket) && l.Released == nil {
+			return fmt.Errorf("released lease on %v has no release time", l.Address)
+		}
+	case string(stickyBucket):
+		if net.ParseIP(string(v)) == nil {
+			return fmt.Errorf("invalid address %q", v)
+		}
+	case string(frozenBucket):
+		var t time.Time
+		return t.UnmarshalText(v)
+	}
+	return nil
+}
+
+// check returns the first invalid entry or damaged page of the store
+func check(tx *bolt.Tx) error {
+	for _, name := range buckets {
+		b := tx.Bucket(name)
+		if b == nil {
+			continue
+		}
+		err := b.ForEach(func(k, v []byte) error {
+			if err := validEntry(name, k, v); err != nil {
+				return fmt.Errorf("invalid %s entry %q: %v", name, k, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	for cerr := range tx.Check() {
+		if err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// rewrite copies the buckets of src, if any, to a new store at path, filling its pages.
+// When repairing, invalid entries are dropped, as are the rest of a bucket on a damaged
+// page. It returns the entries copied and dropped.
+func rewrite(path string, src *bolt.Tx, repair bool) (int, int, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return 0, 0, err
+	}
+	kept, dropped := 0, 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := createBuckets(tx); err != nil || src == nil {
+			return err
+		}
+		for _, name := range buckets {
+			sb := src.Bucket(name)
+			if sb == nil {
+				continue
+			}
+			b := tx.Bucket(name)
+			b.FillPercent = 1
+			err := safely(func() error {
+				return sb.ForEach(func(k, v []byte) error {
+					if repair && validEntry(name, k, v) != nil {
+						log.WithField("bucket", string(name)).WithField("key", string(k)).Warn("dropping invalid lease store entry")
+						dropped++
+						return nil
+					}
+					kept++
+					return b.Put(k, v)
+				})
+			})
+			if _, ok := err.(corruptError); ok && repair {
+				log.WithField("bucket", string(name)).WithError(err).Warn("dropping the rest of a damaged lease store bucket")
+				dropped++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return kept, dropped, err
+}
+
+// syncDir commits the renames in dir to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close() // nolint: errcheck
+	return d.Sync()
+}
+
+// Released returns the released leases kept as history, ordered by when they were released
+func (s *Store) Released() ([]Lease, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	var ret []Lease
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(releasedBucket).ForEach(func(k, v []byte) error {
+			l := Lease{}
+			if err := json.Unmarshal(v, &l); err != nil {
+				return err
+			}
+			ret = append(ret, l)
+			return nil
+		})
+	})
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Released.Before(*ret[j].Released) })
+	return ret, err
+}
+
+// prune deletes the released leases older than the retention, and those which cannot be
+// read. It returns how many were deleted. Caller must hold s.l.
+func (s *Store) prune(now time.Time) (int, error) {
+	var old [][]byte
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(releasedBucket)
+		err := b.ForEach(func(k, v []byte) error {
+			l := &Lease{}
+			if err := json.Unmarshal(v, l); err != nil || l.Released == nil || now.Sub(*l.Released) >= s.retention {
+				old = append(old, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range old {
+			if err = b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(old), nil
+}
+
+// Compact deletes the released leases older than the retention, then rewrites the store
+// to a new file renamed over it, giving the pages of deleted entries back to the file
+// system. It returns how many released leases were deleted.
+func (s *Store) Compact() (int, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	pruned, err := s.prune(time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	tmp := s.path + ".compact"
+	os.Remove(tmp) // nolint: errcheck
+	err = s.db.View(func(tx *bolt.Tx) error {
+		_, _, err := rewrite(tmp, tx, false)
+		return err
+	})
+	if err == nil {
+		err = s.db.Close()
+	}
+	if err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return pruned, err
+	}
+	rerr := os.Rename(tmp, s.path)
+	if rerr == nil {
+		rerr = syncDir(filepath.Dir(s.path))
+	} else {
+		os.Remove(tmp) // nolint: errcheck
+	}
+
+	// the store is reopened, compacted or not. If that fails the closed store fails every change.
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return pruned, err
+	}
+	s.db = db
+	return pruned, rerr
+}
+
+// Repair rewrites the store at path with the entries which can still be read, keeping
+// the store as it was with a .bak suffix. A store which cannot be opened at all is
+// replaced by an empty one. It fails with ErrInUse if the store is locked by another
+// process, eg. the running plugin.
+func Repair(path string) (*RepairReport, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &RepairReport{Backup: path + ".bak"}
+	tmp := path + ".repair"
+	os.Remove(tmp) // nolint: errcheck
+
+	var db *bolt.DB
+	if fi.Size() == 0 {
+		err = fmt.Errorf("empty file")
+	} else {
+		err = safely(func() (err error) {
+			db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+			return err
+		})
+	}
+	switch {
+	case err == bolt.ErrTimeout:
+		return nil, ErrInUse
+	case err != nil:
+		log.WithField("path", path).WithError(err).Warn("lease store cannot be opened, replacing it with an empty one")
+		r.Reset = true
+		_, _, err = rewrite(tmp, nil, true)
+	default:
+		err = db.View(func(tx *bolt.Tx) error {
+			var err error
+			r.Kept, r.Dropped, err = rewrite(tmp, tx, true)
+			return err
+		})
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return nil, err
+	}
+
+	os.Remove(r.Backup) // nolint: errcheck
+	if err = os.Rename(path, r.Backup); err != nil {
+		os.Remove(tmp) // nolint: errcheck
+		return nil, err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return r, syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRetention(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "leases.db")
+
+	s, err := Open(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }() // nolint: errcheck
+	for _, err = range []error{
+		s.Put(&Lease{Address: "10.1.2.5", Pool: "10.1.2.0/24", EndpointID: "ep1"}),
+		s.Put(&Lease{Address: "10.1.2.6", Pool: "10.1.2.0/24"}),
+		s.Delete("10.1.2.5"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the released lease is history, no longer a lease
+	if s.Has("10.1.2.5") {
+		t.Error("released address is still leased")
+	}
+	rs, err := s.Released()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rs) != 1 || rs[0].Address != "10.1.2.5" || rs[0].EndpointID != "ep1" || rs[0].Released == nil {
+		t.Fatalf("released leases are %+v, want 10.1.2.5 of ep1", rs)
+	}
+
+	// a lease released before the retention is dropped by the compaction
+	old := time.Now().Add(-2 * time.Hour)
+	ol := &Lease{Address: "10.1.2.7", Pool: "10.1.2.0/24", Released: &old}
+	v, _ := json.Marshal(ol) // nolint: errcheck
+	err = s.db.Update(func(tx *bolt.Tx) error { return tx.Bucket(releasedBucket).Put(releasedKey(ol), v) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("compaction dropped %v released leases, want 1", n)
+	}
+	if rs, err = s.Released(); err != nil || len(rs) != 1 || rs[0].Address != "10.1.2.5" {
+		t.Errorf("released leases are %+v, %v, want 10.1.2.5", rs, err)
+	}
+
+	// the compacted store is still written to and reopened
+	if err = s.Put(&Lease{Address: "10.1.2.8", Pool: "10.1.2.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = Open(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ls := s.List(); len(ls) != 2 || ls[0].Address != "10.1.2.6" || ls[1].Address != "10.1.2.8" {
+		t.Errorf("leases are %+v, want 10.1.2.6 and 10.1.2.8", ls)
+	}
+
+	// without a retention releases are not kept, and the history is dropped
+	if err = s.Delete("10.1.2.6"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if rs, err = s.Released(); err != nil || len(rs) != 0 {
+		t.Errorf("released leases are %+v, %v, want none", rs, err)
+	}
+}
+
+func TestCompactShrinks(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "leases.db")
+
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { s.Close() }() // nolint: errcheck
+	for i := 0; i < 2000; i++ {
+		a := "10.1." + strconv.Itoa(i/250) + "." + strconv.Itoa(i%250)
+		if err = s.Put(&Lease{Address: a, Pool: "10.1.0.0/16", EndpointID: "0123456789abcdef0123456789abcdef"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range s.List() {
+		if err = s.Delete(l.Address); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() >= before.Size() {
+		t.Errorf("compacted store is %v bytes, was %v", after.Size(), before.Size())
+	}
+}
+
+func TestRepair(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "leases.db")
+
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err = range []error{
+		s.Put(&Lease{Address: "10.1.2.5", Pool: "10.1.2.0/24"}),
+		s.SetSticky("10.1.2.0/24", "web", "10.1.2.5"),
+		s.Freeze("10.1.2.0/24", time.Now()),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a store in use is not repaired
+	if _, err = Repair(path); err != ErrInUse {
+		t.Errorf("repair of a store in use returned %v, want %v", err, ErrInUse)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(leasesBucket).Put([]byte("10.1.2.6"), []byte("{not json")); err != nil {
+			return err
+		}
+		return tx.Bucket(stickyBucket).Put([]byte("10.1.2.0/24 db"), []byte("not an address"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Check(path); err == nil {
+		t.Error("check of a store with invalid entries passed")
+	}
+	if _, err = Open(path, 0); err == nil {
+		t.Error("opened a store with invalid entries")
+	}
+	r, err := Repair(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (RepairReport{Kept: 3, Dropped: 2, Backup: path + ".bak"}); *r != want {
+		t.Errorf("repair returned %+v, want %+v", *r, want)
+	}
+	if err = Check(path); err != nil {
+		t.Errorf("check of the repaired store failed: %v", err)
+	}
+	if err = Check(r.Backup); err == nil {
+		t.Error("check of the store kept before the repair passed")
+	}
+	s, err = Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close() // nolint: errcheck
+	if ls := s.List(); len(ls) != 1 || ls[0].Address != "10.1.2.5" || s.Sticky("10.1.2.0/24", "web") != "10.1.2.5" || len(s.Frozen()) != 1 {
+		t.Errorf("repaired store has leases %+v, sticky %q, frozen %v", ls, s.Sticky("10.1.2.0/24", "web"), s.Frozen())
+	}
+}
+
+func TestRepairUnreadable(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "leases.db")
+	if err := ioutil.WriteFile(path, []byte("not a store"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Repair(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Reset {
+		t.Errorf("repair returned %+v, want the store reset", *r)
+	}
+	if b, err := ioutil.ReadFile(r.Backup); err != nil || string(b) != "not a store" {
+		t.Errorf("store before the repair is %q, %v", b, err)
+	}
+	if err = Check(path); err != nil {
+		t.Errorf("check of the reset store failed: %v", err)
+	}
+}
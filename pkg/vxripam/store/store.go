@@ -0,0 +1,379 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Lease is an address allocated from a pool
+type Lease struct {
+	Address    string    `json:"address"`
+	Pool       string    `json:"pool"`
+	EndpointID string    `json:"endpoint_id,omitempty"`
+	Created    time.Time `json:"created"`
+	// Prefix is the v6 prefix delegated to the address, if any
+	Prefix string `json:"prefix,omitempty"`
+	// TTL is how long the lease lasts without its container, 0 if it lasts until released
+	TTL time.Duration `json:"ttl,omitempty"`
+	// Expires is when the lease is next checked for its container, if it has a TTL
+	Expires *time.Time `json:"expires,omitempty"`
+	// External is set if the address was reserved in the external IPAM
+	External bool `json:"external,omitempty"`
+	// Released is when the lease was released, only set on the leases kept as history
+	Released *time.Time `json:"released,omitempty"`
+}
+
+var (
+	leasesBucket = []byte("leases")
+	stickyBucket = []byte("sticky")
+	frozenBucket = []byte("frozen")
+	// releasedBucket holds the released leases kept for the retention, by address and release time
+	releasedBucket = []byte("released")
+	// buckets are the buckets of a store
+	buckets = [][]byte{leasesBucket, stickyBucket, frozenBucket, releasedBucket}
+)
+
+// openTimeout is how long Open waits for the lock on a store held by another process
+const openTimeout = time.Second
+
+// ErrInUse is returned when the store is locked by another process, eg. the running plugin
+var ErrInUse = errors.New("lease store is in use by another process")
+
+// Store is a lease database kept in a bolt database.
+// Every change is committed to disk before it returns.
+type Store struct {
+	path   string
+	db     *bolt.DB
+	l      sync.Mutex
+	leases map[string]*Lease
+	// sticky are the addresses last held by a container name or hostname, by pool
+	// and key. They outlive leases.
+	sticky map[string]string
+	// frozen are the pools no new addresses are allocated from, with when they were frozen
+	frozen map[string]time.Time
+	// retention is how long released leases are kept as history, none are if it is 0
+	retention time.Duration
+}
+
+// Open loads the store at path, creating it if it does not exist. Released leases
+// are kept for retention, see Compact.
+func Open(path string, retention time.Duration) (*Store, error) {
+	s := &Store{path: path, leases: make(map[string]*Lease), sticky: make(map[string]string), frozen: make(map[string]time.Time), retention: retention}
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	s.db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err == bolt.ErrTimeout {
+		return nil, ErrInUse
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Update(createBuckets)
+	if err == nil {
+		err = s.db.View(s.load)
+	}
+	if err != nil {
+		s.db.Close() // nolint: errcheck
+		return nil, err
+	}
+	log.WithField("path", path).WithField("leases", len(s.leases)).Debug("opened lease store")
+
+	return s, nil
+}
+
+// Check checks the entries and pages of the store at path, without changing it. A store
+// locked by another process, eg. the running plugin, is taken to be valid.
+func Check(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err == bolt.ErrTimeout {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close() // nolint: errcheck
+	return safely(func() error { return db.View(check) })
+}
+
+// Close closes the store, releasing its lock
+func (s *Store) Close() error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.db.Close()
+}
+
+// load reads the store into memory
+func (s *Store) load(tx *bolt.Tx) error {
+	err := tx.Bucket(leasesBucket).ForEach(func(k, v []byte) error {
+		l := &Lease{}
+		if err := json.Unmarshal(v, l); err != nil {
+			return err
+		}
+		s.leases[l.Address] = l
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(stickyBucket).ForEach(func(k, v []byte) error {
+		s.sticky[string(k)] = string(v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(frozenBucket).ForEach(func(k, v []byte) error {
+		var t time.Time
+		if err := t.UnmarshalText(v); err != nil {
+			return err
+		}
+		s.frozen[string(k)] = t
+		return nil
+	})
+}
+
+// replace writes a changed lease to the store and memory. Caller must hold s.l.
+func (s *Store) replace(l *Lease) error {
+	err := s.putLease(l)
+	if err != nil {
+		return err
+	}
+	s.leases[l.Address] = l
+	return nil
+}
+
+// putLease writes the lease on address to the store. Caller must hold s.l.
+func (s *Store) putLease(l *Lease) error {
+	v, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(leasesBucket).Put([]byte(l.Address), v)
+	})
+}
+
+// createBuckets creates the buckets of a new store
+func createBuckets(tx *bolt.Tx) error {
+	for _, b := range buckets {
+		if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put records a lease, replacing any lease on the same address
+func (s *Store) Put(l *Lease) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.replace(l)
+}
+
+// SetEndpoint records the endpoint an address was assigned to
+func (s *Store) SetEndpoint(address, endpointID string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	l, ok := s.leases[address]
+	if !ok || l.EndpointID == endpointID {
+		return nil
+	}
+	nl := *l
+	nl.EndpointID = endpointID
+	return s.replace(&nl)
+}
+
+// SetPrefix records the prefix delegated to an address
+func (s *Store) SetPrefix(address, prefix string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	l, ok := s.leases[address]
+	if !ok || l.Prefix == prefix {
+		return nil
+	}
+	nl := *l
+	nl.Prefix = prefix
+	return s.replace(&nl)
+}
+
+// Renew extends the lease on address by its TTL from now
+func (s *Store) Renew(address string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	l, ok := s.leases[address]
+	if !ok || l.TTL <= 0 {
+		return nil
+	}
+	exp := time.Now().Add(l.TTL)
+	nl := *l
+	nl.Expires = &exp
+	return s.replace(&nl)
+}
+
+// Prefix returns the prefix delegated to address, or "" if there is none
+func (s *Store) Prefix(address string) string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if l, ok := s.leases[address]; ok {
+		return l.Prefix
+	}
+	return ""
+}
+
+// External returns true if address was reserved in the external IPAM
+func (s *Store) External(address string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	l, ok := s.leases[address]
+	return ok && l.External
+}
+
+// Delete removes the lease on an address, if there is one, keeping it as history for the retention
+func (s *Store) Delete(address string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	l, ok := s.leases[address]
+	if !ok {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if s.retention > 0 {
+			rl := *l
+			now := time.Now()
+			rl.Released = &now
+			v, err := json.Marshal(&rl)
+			if err != nil {
+				return err
+			}
+			if err = tx.Bucket(releasedBucket).Put(releasedKey(&rl), v); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(leasesBucket).Delete([]byte(address))
+	})
+	if err != nil {
+		return err
+	}
+	delete(s.leases, address)
+	return nil
+}
+
+// Has returns true if address is leased
+func (s *Store) Has(address string) bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	_, ok := s.leases[address]
+	return ok
+}
+
+// Endpoint returns the endpoint address is assigned to, or "" if it is not known
+func (s *Store) Endpoint(address string) string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if l, ok := s.leases[address]; ok {
+		return l.EndpointID
+	}
+	return ""
+}
+
+// List returns all leases, ordered by address
+func (s *Store) List() []Lease {
+	s.l.Lock()
+	defer s.l.Unlock()
+	ret := make([]Lease, 0, len(s.leases))
+	for _, l := range s.leases {
+		ret = append(ret, *l)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Address < ret[j].Address })
+	return ret
+}
+
+// SetSticky records the address last held by key in pool
+func (s *Store) SetSticky(pool, key, address string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	k := pool + " " + key
+	if s.sticky[k] == address {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(stickyBucket).Put([]byte(k), []byte(address))
+	})
+	if err != nil {
+		return err
+	}
+	s.sticky[k] = address
+	return nil
+}
+
+// Sticky returns the address last held by key in pool, or "" if there is none
+func (s *Store) Sticky(pool, key string) string {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.sticky[pool+" "+key]
+}
+
+// Freeze records pool as frozen since t, keeping an earlier time if it already is
+func (s *Store) Freeze(pool string, t time.Time) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.frozen[pool]; ok {
+		return nil
+	}
+	v, err := t.MarshalText()
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(frozenBucket).Put([]byte(pool), v)
+	})
+	if err != nil {
+		return err
+	}
+	s.frozen[pool] = t
+	return nil
+}
+
+// Unfreeze removes the freeze on pool, if there is one
+func (s *Store) Unfreeze(pool string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.frozen[pool]; !ok {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(frozenBucket).Delete([]byte(pool))
+	})
+	if err != nil {
+		return err
+	}
+	delete(s.frozen, pool)
+	return nil
+}
+
+// Frozen returns the frozen pools, with when they were frozen
+func (s *Store) Frozen() map[string]time.Time {
+	s.l.Lock()
+	defer s.l.Unlock()
+	ret := make(map[string]time.Time, len(s.frozen))
+	for k, v := range s.frozen {
+		ret[k] = v
+	}
+	return ret
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "leases.db")
+
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frozen := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, err = range []error{
+		s.Put(&Lease{Address: "10.1.2.5", Pool: "10.1.2.0/24", TTL: time.Minute}),
+		s.Put(&Lease{Address: "10.1.2.6", Pool: "10.1.2.0/24"}),
+		s.SetEndpoint("10.1.2.5", "ep1"),
+		s.SetPrefix("10.1.2.5", "fd00:1::/64"),
+		s.Renew("10.1.2.5"),
+		s.Delete("10.1.2.6"),
+		s.SetSticky("10.1.2.0/24", "web", "10.1.2.5"),
+		s.Freeze("10.1.2.0/24", frozen),
+		s.Freeze("10.1.3.0/24", frozen),
+		s.Unfreeze("10.1.3.0/24"),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close() // nolint: errcheck
+	ls := s.List()
+	if len(ls) != 1 {
+		t.Fatalf("leases are %v, want only 10.1.2.5", ls)
+	}
+	l := ls[0]
+	if l.Address != "10.1.2.5" || l.EndpointID != "ep1" || l.Prefix != "fd00:1::/64" || l.Expires == nil {
+		t.Errorf("lease is %+v", l)
+	}
+	if a := s.Sticky("10.1.2.0/24", "web"); a != "10.1.2.5" {
+		t.Errorf("sticky address is %q, want 10.1.2.5", a)
+	}
+	if f := s.Frozen(); len(f) != 1 || !f["10.1.2.0/24"].Equal(frozen) {
+		t.Errorf("frozen pools are %v, want 10.1.2.0/24 at %v", f, frozen)
+	}
+}
+
+func TestLocked(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "leases.db")
+
+	s, err := Open(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close() // nolint: errcheck
+	if s2, err := Open(path, 0); err == nil {
+		s2.Close() // nolint: errcheck
+		t.Error("opened a store locked by another")
+	}
+	if err = Check(path); err != nil {
+		t.Errorf("check of a locked store failed: %v", err)
+	}
+}
+
+func TestCheckInvalid(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	path := filepath.Join(dir, "leases.db")
+	if err := ioutil.WriteFile(path, []byte("not a store"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Check(path); err == nil {
+		t.Error("check of an invalid store passed")
+	}
+}
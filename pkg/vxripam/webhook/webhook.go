@@ -0,0 +1,156 @@
+// Package webhook posts address allocations and releases to an http endpoint,
+// eg. to keep an external IPAM or CMDB such as NetBox in sync.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// queueLen is how many events may wait for delivery before new ones are dropped
+	queueLen = 1024
+	// requestTimeout bounds each post
+	requestTimeout = 5 * time.Second
+	// firstBackoff is the wait before the first retry, doubled for each following one
+	firstBackoff = time.Second
+	maxBackoff   = time.Minute
+)
+
+// Events posted
+const (
+	EventAllocate = "allocate"
+	EventRelease  = "release"
+	// EventEvict is an address taken over from another node by the evict conflict policy
+	EventEvict = "evict"
+)
+
+// Event is the json payload posted for an allocation, release or eviction
+type Event struct {
+	Event   string `json:"event"`
+	Address string `json:"address"`
+	// Reason is what an evicted address was in use by
+	Reason    string    `json:"reason,omitempty"`
+	Pool      string    `json:"pool,omitempty"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Host      string    `json:"host"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Hook delivers events to a url in order, from a queue, so allocations are not slowed by it
+type Hook struct {
+	url    string
+	tries  int
+	hc     *http.Client
+	q      chan *Event
+	done   chan struct{}
+	l      sync.RWMutex
+	closed bool
+}
+
+// New starts delivering events to url, trying each up to tries times with exponential backoff
+func New(url string, tries int) *Hook {
+	if tries < 1 {
+		tries = 1
+	}
+	h := &Hook{
+		url:   url,
+		tries: tries,
+		hc:    &http.Client{Timeout: requestTimeout},
+		q:     make(chan *Event, queueLen),
+		done:  make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+// Send queues an event, timestamped now if it has no timestamp.
+// The event is dropped if the queue is full.
+func (h *Hook) Send(e *Event) {
+	if h == nil {
+		return
+	}
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	h.l.RLock()
+	defer h.l.RUnlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.q <- e:
+	default:
+		log.WithField("event", e.Event).WithField("address", e.Address).Error("webhook queue is full, dropping event")
+	}
+}
+
+// Close stops accepting events, and waits a while for the queued ones to be delivered
+func (h *Hook) Close() {
+	if h == nil {
+		return
+	}
+	h.l.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.q)
+	}
+	h.l.Unlock()
+	select {
+	case <-h.done:
+	case <-time.After(requestTimeout):
+		log.WithField("url", h.url).WithField("queued", len(h.q)).Warn("closing with webhook events undelivered")
+	}
+}
+
+func (h *Hook) run() {
+	defer close(h.done)
+	for e := range h.q {
+		h.deliver(e)
+	}
+}
+
+func (h *Hook) deliver(e *Event) {
+	log := log.WithField("event", e.Event).WithField("address", e.Address).WithField("url", h.url)
+	b, err := json.Marshal(e)
+	if err != nil {
+		log.WithError(err).Error("failed to encode webhook event")
+		return
+	}
+
+	wait := firstBackoff
+	for try := 1; ; try++ {
+		err = h.post(b)
+		if err == nil {
+			log.Debug("delivered webhook event")
+			return
+		}
+		if try >= h.tries {
+			log.WithError(err).WithField("tries", try).Error("failed to deliver webhook event, giving up")
+			return
+		}
+		log.WithError(err).WithField("try", try).Warn("failed to deliver webhook event, retrying")
+		time.Sleep(wait)
+		if wait *= 2; wait > maxBackoff {
+			wait = maxBackoff
+		}
+	}
+}
+
+func (h *Hook) post(b []byte) error {
+	resp, err := h.hc.Post(h.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint: errcheck
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package vxrnet
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	gphnet "github.com/docker/go-plugins-helpers/network"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/TrilliumIT/vxrouter"
+	"github.com/TrilliumIT/vxrouter/internal/host"
+	"github.com/TrilliumIT/vxrouter/internal/ovs"
+	"github.com/TrilliumIT/vxrouter/internal/vxlan"
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+	"github.com/TrilliumIT/vxrouter/pkg/options"
+	"github.com/TrilliumIT/vxrouter/pkg/vxrerrors"
+)
+
+const (
+	// DriverName is the default docker plugin name of the driver
+	DriverName = vxrouter.NetworkDriver
+)
+
+// Driver is a vxrouter network driver
+type Driver struct {
+	scope  string
+	vniMin int
+	vniMax int
+	macOUI net.HardwareAddr
+	core   *core.Core
+	log    *log.Entry
+}
+
+var _ gphnet.Driver = (*Driver)(nil)
+
+// Options configures a Driver
+type Options struct {
+	// Scope is the scope of the networks, local or global
+	Scope string
+	// VniMin and VniMax bound the vxlan ids networks may be created with
+	VniMin, VniMax int
+	// MacOUI is the prefix of the MACs of endpoints without one, nil to leave them to the kernel
+	MacOUI net.HardwareAddr
+}
+
+// DefaultOptions returns the options the plugin uses when none are set
+func DefaultOptions() Options {
+	return Options{Scope: "local", VniMax: vxlan.MaxVxlanID}
+}
+
+// NewDriver creates a new Driver, named after the instance of core.
+// The Driver can be served with a go-plugins-helpers network handler,
+// or its methods called directly by programs embedding it.
+func NewDriver(opts Options, core *core.Core) (*Driver, error) {
+	if opts.VniMin > opts.VniMax {
+		return nil, fmt.Errorf("vxlanid range %v-%v is empty", opts.VniMin, opts.VniMax)
+	}
+	d := &Driver{
+		opts.Scope,
+		opts.VniMin,
+		opts.VniMax,
+		opts.MacOUI,
+		core,
+		log.WithField("driver", core.NetworkDriverName()),
+	}
+	return d, nil
+}
+
+// GetCapabilities is called on driver initialization
+func (d *Driver) GetCapabilities() (*gphnet.CapabilitiesResponse, error) {
+	d.log.Debug("GetCapabilities()")
+	cap := &gphnet.CapabilitiesResponse{
+		Scope:             d.scope,
+		ConnectivityScope: "",
+	}
+	return cap, nil
+}
+
+// CreateNetwork is called on docker network create
+func (d *Driver) CreateNetwork(r *gphnet.CreateNetworkRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("CreateNetwork()")
+	defer d.core.Watch("CreateNetwork")()
+
+	opts, err := options.Parse(options.FromGeneric(r.Options))
+	if err != nil {
+		d.log.WithError(err).Error()
+		return err
+	}
+
+	// the flags only exist on swarm networks, which docker creates on a node once it knows them,
+	// so they can be inspected
+	if d.scope == "global" {
+		attachable, ingress, ferr := d.core.SwarmFlags(r.NetworkID)
+		switch {
+		case ferr != nil:
+			d.log.WithError(ferr).Debug("failed to inspect the swarm flags of the network")
+		case ingress:
+			err = fmt.Errorf("%v networks can not be the swarm ingress network, the routing mesh requires the overlay driver", d.core.NetworkDriverName())
+			d.log.WithError(err).Error()
+			return err
+		case attachable:
+			d.log.Info("standalone containers may attach to the swarm network")
+		}
+	}
+
+	if fs := opts[options.Fabric]; fs != "" {
+		err = host.CheckFabricOption(fs)
+		if err != nil {
+			d.log.WithError(err).Error()
+			return err
+		}
+	}
+
+	err = host.CheckMTU(opts)
+	if err != nil {
+		d.log.WithError(err).Error()
+		return err
+	}
+
+	hasGW := false
+	for _, v4 := range append(r.IPv4Data, r.IPv6Data...) {
+		if v4.Gateway != "" {
+			hasGW = true
+			break
+		}
+	}
+
+	// the subnet of the network is derived from the gateway, even when it is not used by containers
+	if !hasGW {
+		err = fmt.Errorf("gateway not found in IPAMData")
+		d.log.WithError(err).Error()
+		return err
+	}
+
+	pools := []string{}
+	for _, ipd := range append(r.IPv4Data, r.IPv6Data...) {
+		pools = append(pools, ipd.Pool)
+	}
+
+	// without a vxlanid, every host derives the same one from the pools of the network
+	vxlID := opts.String(options.VxlanID)
+	derived := vxlID == ""
+	if derived {
+		vxlID = strconv.Itoa(core.DeriveVNI(pools))
+		d.log.WithField("vxlanid", vxlID).Info("derived vxlanid from the network pools")
+	}
+
+	vid, err := vxlan.ParseVxlanID(vxlID)
+	if err != nil {
+		return err
+	}
+
+	if vid < d.vniMin || vid > d.vniMax {
+		err = fmt.Errorf("vxlanid %v is outside of the range %v-%v allowed by this driver", vid, d.vniMin, d.vniMax)
+		if derived {
+			err = fmt.Errorf("%v, set one with -o vxlanid=<%v-%v>", err, d.vniMin, d.vniMax)
+		}
+		d.log.WithError(err).Error()
+		return err
+	}
+
+	// the kernel would refuse a second vxlan with the id only once the network is first used.
+	// The vxlan of this network itself, if docker creates it again, is not a collision.
+	inUse, err := vxlan.InUse(vid, opts)
+	if err != nil {
+		d.log.WithError(err).Warn("failed to check for vxlan interfaces using the vxlanid")
+	}
+	// networks sharing the vxlanid share its vxlan, isolated from each other by their gateways
+	shared := opts.String(options.ShareVNI) == "on"
+	if shared && opts.String(options.GatewayMode) != string(core.GatewayPlugin) {
+		err = fmt.Errorf("%v requires %v plugin, isolation relies on the gateway of each network", options.ShareVNI, options.GatewayMode)
+		d.log.WithError(err).Error()
+		return err
+	}
+	if shared && inUse != nil && inUse.Name == host.SharedVxlanName(vid) {
+		inUse = nil
+	}
+	if inUse != nil && inUse.Name != d.core.CachedNetworkName(r.NetworkID) {
+		err = vxrerrors.Conflict("%v is already in use by the vxlan interface %v on this host", inUse.Reason, inUse)
+		d.log.WithError(err).Error()
+		return err
+	}
+	if opts.String(options.Datapath) == "ovs" {
+		bopts := map[string]string{}
+		for k, v := range opts {
+			bopts[k] = v
+		}
+		bopts[options.VxlanID] = strconv.Itoa(vid)
+		if err = host.CheckDatapath(bopts); err != nil {
+			d.log.WithError(err).Error()
+			return err
+		}
+		br, err := ovs.InUse(d.core.CachedNetworkName(r.NetworkID), bopts)
+		if err != nil {
+			d.log.WithError(err).Warn("failed to check for bridges using the vxlanid")
+		}
+		if br != "" {
+			err = vxrerrors.Conflict("vxlanid %v is already in use by the bridge %v on this host", vid, br)
+			d.log.WithError(err).Error()
+			return err
+		}
+	}
+
+	// if docker fails to create the network after this, it either calls DeleteNetwork
+	// or the reservation expires during reconcile
+	err = d.core.ReserveNetwork(r.NetworkID, vid, shared, pools)
+	if err != nil {
+		d.log.WithError(err).Error()
+		return err
+	}
+
+	return nil
+}
+
+// AllocateNetwork is never called
+func (d *Driver) AllocateNetwork(r *gphnet.AllocateNetworkRequest) (*gphnet.AllocateNetworkResponse, error) {
+	d.log.WithField("r", options.Mask(r)).Debug("AllocateNetwork()")
+	return &gphnet.AllocateNetworkResponse{}, nil
+}
+
+// DeleteNetwork is called on docker network rm
+func (d *Driver) DeleteNetwork(r *gphnet.DeleteNetworkRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("DeleteNetwork()")
+	defer d.core.Watch("DeleteNetwork")()
+
+	d.core.ReleaseNetwork(r.NetworkID)
+	return nil
+}
+
+// FreeNetwork is never called
+func (d *Driver) FreeNetwork(r *gphnet.FreeNetworkRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("FreeNetwork()")
+	return nil
+}
+
+// CreateEndpoint is called after IPAM has assigned an address, before Join is called
+func (d *Driver) CreateEndpoint(r *gphnet.CreateEndpointRequest) (*gphnet.CreateEndpointResponse, error) {
+	d.log.WithField("r", options.Mask(r)).Debug("CreateEndpoint()")
+	defer d.core.Watch("CreateEndpoint")()
+
+	if r.Interface == nil {
+		return &gphnet.CreateEndpointResponse{}, nil
+	}
+	d.core.LeaseEndpoint(r.Interface.Address, r.EndpointID)
+	d.core.LeaseEndpoint(r.Interface.AddressIPv6, r.EndpointID)
+	addrs := []string{r.Interface.Address, r.Interface.AddressIPv6}
+
+	// docker refuses a MAC from the driver if the endpoint already has one
+	if d.macOUI != nil && r.Interface.MacAddress == "" {
+		mac := endpointMAC(d.macOUI, r.Interface.Address, r.EndpointID)
+		d.core.ExpectEndpoint(r.EndpointID, addrs, mac.String())
+		return &gphnet.CreateEndpointResponse{
+			Interface: &gphnet.EndpointInterface{MacAddress: mac.String()},
+		}, nil
+	}
+
+	d.core.ExpectEndpoint(r.EndpointID, addrs, r.Interface.MacAddress)
+	return &gphnet.CreateEndpointResponse{}, nil
+}
+
+// DeleteEndpoint is called after Leave
+func (d *Driver) DeleteEndpoint(r *gphnet.DeleteEndpointRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("DeleteEndpoint()")
+	defer d.core.Watch("DeleteEndpoint")()
+
+	return d.core.DeleteContainerInterface(r.NetworkID, r.EndpointID)
+}
+
+// EndpointInfo is called on inspect... maybe?
+// It reports the vxlanid of the network, which may have been derived from its pools.
+func (d *Driver) EndpointInfo(r *gphnet.InfoRequest) (*gphnet.InfoResponse, error) {
+	d.log.WithField("r", options.Mask(r)).Debug("EndpointInfo()")
+
+	vid, err := d.core.NetworkOption(r.NetworkID, options.VxlanID)
+	if err != nil {
+		d.log.WithError(err).Error("failed to get vxlanid")
+		return &gphnet.InfoResponse{}, nil
+	}
+	return &gphnet.InfoResponse{Value: map[string]string{options.VxlanID: vid}}, nil
+}
+
+// Join is the last thing called before the nic is put into the container namespace
+func (d *Driver) Join(r *gphnet.JoinRequest) (*gphnet.JoinResponse, error) {
+	d.log.WithField("r", options.Mask(r)).Debug("Join()")
+	defer d.core.Watch("Join")()
+
+	err := d.setSysctls(r)
+	if err != nil {
+		d.log.WithError(err).Error("failed to set sysctls")
+		return nil, err
+	}
+
+	mvlName, err := d.core.CreateContainerInterface(r.NetworkID, r.EndpointID)
+	if err != nil {
+		d.log.WithError(err).Error("failed to create macvlan for container")
+		return nil, err
+	}
+
+	err = d.core.IsolateEndpoint(r.NetworkID, r.SandboxKey)
+	if err != nil {
+		d.log.WithError(err).Error("failed to isolate endpoint")
+		return nil, err
+	}
+
+	jr := &gphnet.JoinResponse{
+		InterfaceName: gphnet.InterfaceName{
+			SrcName:   mvlName,
+			DstPrefix: "eth",
+		},
+	}
+
+	gm, err := d.core.GatewayModeByNetID(r.NetworkID)
+	if err != nil {
+		d.log.WithError(err).Error("failed to get gateway mode")
+		return nil, err
+	}
+	if gm == core.GatewayNone {
+		return jr, nil
+	}
+
+	gws, err := d.core.GetGatewaysByNetID(r.NetworkID)
+	if err != nil {
+		d.log.WithError(err).Error("failed to get gateway")
+		return nil, err
+	}
+	setGateways(jr, gws)
+
+	return jr, nil
+}
+
+// setGateways sets the gateway of each address family of the network, an IPv6 only network has none for IPv4
+func setGateways(jr *gphnet.JoinResponse, gws []*net.IPNet) {
+	for _, gw := range gws {
+		if gw.IP.To4() != nil {
+			jr.Gateway = gw.IP.String()
+		} else {
+			jr.GatewayIPv6 = gw.IP.String()
+		}
+	}
+}
+
+// setSysctls sets the sysctls from the network sysctl option, overridden by
+// the endpoint sysctl option, in the container namespace
+func (d *Driver) setSysctls(r *gphnet.JoinRequest) error {
+	ns, err := d.core.NetworkOption(r.NetworkID, options.Sysctl)
+	if err != nil {
+		return err
+	}
+	sysctls, err := host.ParseSysctls(ns)
+	if err != nil {
+		return err
+	}
+
+	eopts, err := options.Parse(options.FromGeneric(r.Options))
+	if err != nil {
+		return err
+	}
+	esc, err := host.ParseSysctls(eopts[options.Sysctl])
+	if err != nil {
+		return err
+	}
+	for k, v := range esc {
+		sysctls[k] = v
+	}
+
+	return host.SetNsSysctls(r.SandboxKey, sysctls)
+}
+
+// Leave is the first thing called on container stop
+func (d *Driver) Leave(r *gphnet.LeaveRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("Leave()")
+	defer d.core.Watch("Leave")()
+	d.core.LearnSticky(r.NetworkID, r.EndpointID)
+	return nil
+}
+
+// DiscoverNew is not implemented by this driver
+func (d *Driver) DiscoverNew(r *gphnet.DiscoveryNotification) error {
+	d.log.WithField("r", options.Mask(r)).Debug("DiscoverNew()")
+	return nil
+}
+
+// DiscoverDelete is not implemented by this driver
+func (d *Driver) DiscoverDelete(r *gphnet.DiscoveryNotification) error {
+	d.log.WithField("r", options.Mask(r)).Debug("DiscoverDelete()")
+	return nil
+}
+
+// ProgramExternalConnectivity is not implemented by this driver
+func (d *Driver) ProgramExternalConnectivity(r *gphnet.ProgramExternalConnectivityRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("ProgramExternalConnectivity()")
+	return nil
+}
+
+// RevokeExternalConnectivity is not implemented by this driver
+func (d *Driver) RevokeExternalConnectivity(r *gphnet.RevokeExternalConnectivityRequest) error {
+	d.log.WithField("r", options.Mask(r)).Debug("RevokeExternalConnectivity()")
+
+	return nil
+}
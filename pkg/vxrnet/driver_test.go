@@ -0,0 +1,82 @@
+package vxrnet
+
+import (
+	"net"
+	"testing"
+
+	gphnet "github.com/docker/go-plugins-helpers/network"
+
+	"github.com/TrilliumIT/vxrouter/pkg/core"
+)
+
+func newDriver(t *testing.T) *Driver {
+	c, err := core.New(core.DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := DefaultOptions()
+	opts.VniMin, opts.VniMax = 1000, 1999
+	d, err := NewDriver(opts, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func v6Request(id, vni string) *gphnet.CreateNetworkRequest {
+	r := &gphnet.CreateNetworkRequest{
+		NetworkID: id,
+		IPv6Data:  []*gphnet.IPAMData{{AddressSpace: core.LocalAddressSpace, Pool: "fd00:1::/64", Gateway: "fd00:1::1/64"}},
+	}
+	if vni != "" {
+		r.Options = map[string]interface{}{"com.docker.network.generic": map[string]interface{}{"vxlanid": vni}}
+	}
+	return r
+}
+
+func TestCreateNetworkIPv6Only(t *testing.T) {
+	d := newDriver(t)
+	if err := d.CreateNetwork(v6Request("net1", "1500")); err != nil {
+		t.Fatal(err)
+	}
+	// the vxlanid is reserved for the network while docker creates it
+	if err := d.CreateNetwork(v6Request("net2", "1500")); err == nil {
+		t.Error("second ipv6 only network created with the same vxlanid")
+	}
+	if err := d.CreateNetwork(v6Request("net3", "2500")); err == nil {
+		t.Error("network created with a vxlanid outside of the range of the driver")
+	}
+
+	// the gateway is the only one of an ipv6 only network
+	r := v6Request("net4", "1501")
+	r.IPv6Data[0].Gateway = ""
+	if err := d.CreateNetwork(r); err == nil {
+		t.Error("ipv6 only network without a gateway created")
+	}
+}
+
+func TestCreateNetworkIPv6OnlyDerivedVNI(t *testing.T) {
+	d := newDriver(t)
+	d.vniMin, d.vniMax = 1, 16777215
+	if err := d.CreateNetwork(v6Request("net1", "")); err != nil {
+		t.Fatalf("vxlanid not derived from the ipv6 pool: %v", err)
+	}
+}
+
+func TestJoinGatewaysIPv6Only(t *testing.T) {
+	_, gw, _ := net.ParseCIDR("fd00:1::1/64")
+	gw.IP = net.ParseIP("fd00:1::1")
+
+	jr := &gphnet.JoinResponse{}
+	setGateways(jr, []*net.IPNet{gw})
+	if jr.Gateway != "" || jr.GatewayIPv6 != "fd00:1::1" {
+		t.Errorf("gateways are %q and %q, want only fd00:1::1", jr.Gateway, jr.GatewayIPv6)
+	}
+
+	v4 := &net.IPNet{IP: net.ParseIP("10.1.2.1"), Mask: net.CIDRMask(24, 32)}
+	jr = &gphnet.JoinResponse{}
+	setGateways(jr, []*net.IPNet{v4, gw})
+	if jr.Gateway != "10.1.2.1" || jr.GatewayIPv6 != "fd00:1::1" {
+		t.Errorf("dual stack gateways are %q and %q", jr.Gateway, jr.GatewayIPv6)
+	}
+}
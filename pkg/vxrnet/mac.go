@@ -0,0 +1,47 @@
+package vxrnet
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+)
+
+// ParseOUI parses the 3 byte OUI prefix of endpoint MACs, eg. 02:42:ac
+func ParseOUI(s string) (net.HardwareAddr, error) {
+	oui, err := net.ParseMAC(s + ":00:00:00")
+	if err != nil || len(oui) != 6 {
+		return nil, fmt.Errorf("invalid oui %q, expected 3 bytes such as 02:42:ac", s)
+	}
+	if oui[0]&1 != 0 {
+		return nil, fmt.Errorf("oui %v is a multicast prefix", s)
+	}
+	return oui[:3], nil
+}
+
+// endpointMAC returns a MAC in oui for an endpoint. It ends with the last 3 bytes of
+// its IPv4 address, unique within a /8, or else a hash of the endpoint id.
+func endpointMAC(oui net.HardwareAddr, address, endpointID string) net.HardwareAddr {
+	mac := make(net.HardwareAddr, 6)
+	copy(mac, oui)
+
+	if ip, _, err := net.ParseCIDR(address); err == nil && ip.To4() != nil {
+		copy(mac[3:], ip.To4()[1:])
+		return mac
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(endpointID))) // nolint: errcheck
+	s := h.Sum32()
+	mac[3], mac[4], mac[5] = byte(s>>16), byte(s>>8), byte(s)
+	return mac
+}
+
+// AddressMAC returns the MAC generated in oui for an endpoint with the IPv4 address ip,
+// or nil for an IPv6 one, whose MAC is a hash of an endpoint id
+func AddressMAC(oui net.HardwareAddr, ip net.IP) net.HardwareAddr {
+	if ip.To4() == nil {
+		return nil
+	}
+	return endpointMAC(oui, (&net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}).String(), "")
+}